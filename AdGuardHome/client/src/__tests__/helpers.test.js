@@ -3,6 +3,8 @@ import {
     countClientsStatistics,
     findAddressType,
     subnetMaskToBitMask,
+    isCakeThrottled,
+    normalizeCakeHistory,
 } from '../helpers/helpers';
 import { ADDRESS_TYPES } from '../helpers/constants';
 
@@ -458,3 +460,34 @@ describe('subnetMaskToBitMask', () => {
         ).toEqual(true);
     });
 });
+
+describe('normalizeCakeHistory', () => {
+    test('field', () => {
+        expect(normalizeCakeHistory([{ rtt: 100 }, { rtt: 200 }], 'rtt', 'rtt')).toStrictEqual([{
+            id: 'rtt',
+            data: [{ x: 0, y: 100 }, { x: 1, y: 200 }],
+        }]);
+    });
+});
+
+describe('isCakeThrottled', () => {
+    const params = {
+        maxUpload: 100000,
+        maxDownload: 100000,
+    };
+
+    test('at ceiling', () => {
+        expect(isCakeThrottled({
+            ...params,
+            bandwidthUpload: 100000 * 0.9,
+            bandwidthDownload: 100000 * 0.9,
+        })).toStrictEqual(false);
+    });
+    test('below ceiling', () => {
+        expect(isCakeThrottled({
+            ...params,
+            bandwidthUpload: 100000 * 0.9,
+            bandwidthDownload: 46000,
+        })).toStrictEqual(true);
+    });
+});
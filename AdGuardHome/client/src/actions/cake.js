@@ -0,0 +1,49 @@
+import { createAction } from 'redux-actions';
+
+import apiClient from '../api/Api';
+import { addErrorToast } from './toasts';
+
+export const getCakeStatusRequest = createAction('GET_CAKE_STATUS_REQUEST');
+export const getCakeStatusFailure = createAction('GET_CAKE_STATUS_FAILURE');
+export const getCakeStatusSuccess = createAction('GET_CAKE_STATUS_SUCCESS');
+
+/**
+ * Requests the full status of the CAKE controller.  The controller is optional,
+ * so the errors are only shown when showErrors is true, e.g. on its own page.
+ *
+ * @param {boolean} showErrors
+ */
+export const getCakeStatus = (showErrors = false) => async (dispatch) => {
+    dispatch(getCakeStatusRequest());
+    try {
+        const status = await apiClient.getCakeStatus();
+        dispatch(getCakeStatusSuccess(status));
+    } catch (error) {
+        if (showErrors) {
+            dispatch(addErrorToast({ error }));
+        }
+        dispatch(getCakeStatusFailure());
+    }
+};
+
+export const cakeStatusUpdate = createAction('CAKE_STATUS_UPDATE');
+
+/**
+ * Subscribes to the status updates of the CAKE controller sent as server-sent
+ * events.  The browser reconnects automatically when the stream is closed.
+ *
+ * @returns {function} The function that closes the subscription.
+ */
+export const subscribeCakeEvents = () => (dispatch) => {
+    const source = new EventSource(apiClient.getCakeEventsUrl());
+
+    source.addEventListener('status', (event) => {
+        try {
+            dispatch(cakeStatusUpdate(JSON.parse(event.data)));
+        } catch (error) {
+            // Skip the malformed updates, the next one will arrive shortly.
+        }
+    });
+
+    return () => source.close();
+};
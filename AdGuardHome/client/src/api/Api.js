@@ -523,6 +523,20 @@ class Api {
         return this.makeRequest(path, method, parameters);
     }
 
+    // CAKE
+    CAKE_STATUS = { path: 'cake/status', method: 'GET' };
+
+    CAKE_EVENTS = { path: 'cake/events', method: 'GET' };
+
+    getCakeStatus() {
+        const { path, method } = this.CAKE_STATUS;
+        return this.makeRequest(path, method);
+    }
+
+    getCakeEventsUrl() {
+        return `${this.baseUrl}/${this.CAKE_EVENTS.path}`;
+    }
+
     // Settings for statistics
     GET_STATS = { path: 'stats', method: 'GET' };
 
@@ -532,6 +546,8 @@ class Api {
 
     STATS_RESET = { path: 'stats_reset', method: 'POST' };
 
+    GET_CLIENT_STATS = { path: 'stats/clients', method: 'GET' };
+
     getStats() {
         const { path, method } = this.GET_STATS;
         return this.makeRequest(path, method);
@@ -555,6 +571,11 @@ class Api {
         return this.makeRequest(path, method);
     }
 
+    getClientStats(id) {
+        const { path, method } = this.GET_CLIENT_STATS;
+        return this.makeRequest(`${path}/${encodeURIComponent(id)}`, method);
+    }
+
     // Query log
     GET_QUERY_LOG = { path: 'querylog', method: 'GET' };
 
@@ -564,6 +585,8 @@ class Api {
 
     QUERY_LOG_CLEAR = { path: 'querylog_clear', method: 'POST' };
 
+    QUERY_LOG_EXPORT = { path: 'querylog/export', method: 'GET' };
+
     getQueryLog(params) {
         const { path, method } = this.GET_QUERY_LOG;
         // eslint-disable-next-line no-param-reassign
@@ -572,6 +595,11 @@ class Api {
         return this.makeRequest(url, method);
     }
 
+    getQueryLogExportUrl(params) {
+        const url = getPathWithQueryString(this.QUERY_LOG_EXPORT.path, params);
+        return `${this.baseUrl}/${url}`;
+    }
+
     getQueryLogConfig() {
         const { path, method } = this.GET_QUERY_LOG_CONFIG;
         return this.makeRequest(path, method);
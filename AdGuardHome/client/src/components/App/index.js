@@ -43,6 +43,7 @@ import DnsRewrites from '../../containers/DnsRewrites';
 import CustomRules from '../../containers/CustomRules';
 import Services from '../Filters/Services';
 import Logs from '../Logs';
+import Cake from '../Cake';
 import ProtectionTimer from '../ProtectionTimer';
 
 const ROUTES = [
@@ -55,6 +56,10 @@ const ROUTES = [
         path: [`${MENU_URLS.logs}${getLogsUrlParams(':search?', ':response_status?')}`, MENU_URLS.logs],
         component: Logs,
     },
+    {
+        path: MENU_URLS.cake,
+        component: Cake,
+    },
     {
         path: MENU_URLS.guide,
         component: SetupGuide,
@@ -0,0 +1,52 @@
+import React from 'react';
+import PropTypes from 'prop-types';
+import dateFormat from 'date-fns/format';
+
+import { STATUS_COLORS } from '../../helpers/constants';
+import { normalizeCakeHistory } from '../../helpers/helpers';
+import Card from '../ui/Card';
+import Line from '../ui/Line';
+
+/**
+ * ChartCard shows the latest value of a field of the CAKE history along with
+ * its chart in the style of the statistics cards.
+ */
+const ChartCard = ({
+    history, field, value, title, color, format,
+}) => {
+    const lineData = normalizeCakeHistory(history, field, field);
+
+    const xFormat = (x) => {
+        const point = history[x];
+
+        return point ? dateFormat(point.time, 'HH:mm:ss') : '';
+    };
+
+    return <Card type="card--full" bodyType="card-wrap">
+        <div className="card-body-stats">
+            <div className={`card-value card-value-stats text-${color}`}>
+                {value}
+            </div>
+            <div className="card-title-stats">{title}</div>
+        </div>
+        <div className="card-chart-bg">
+            <Line
+                data={lineData}
+                color={STATUS_COLORS[color]}
+                xFormat={xFormat}
+                yFormat={format}
+            />
+        </div>
+    </Card>;
+};
+
+ChartCard.propTypes = {
+    history: PropTypes.array.isRequired,
+    field: PropTypes.string.isRequired,
+    value: PropTypes.string.isRequired,
+    title: PropTypes.node.isRequired,
+    color: PropTypes.string.isRequired,
+    format: PropTypes.func.isRequired,
+};
+
+export default ChartCard;
@@ -0,0 +1,64 @@
+import React from 'react';
+import PropTypes from 'prop-types';
+import { useTranslation } from 'react-i18next';
+import dateFormat from 'date-fns/format';
+
+import Card from '../ui/Card';
+import { CAKE_JOURNAL_EVENTS } from '../../helpers/constants';
+import { formatBandwidth } from '../../helpers/helpers';
+
+const EVENT_CLASSES = {
+    [CAKE_JOURNAL_EVENTS.BUFFERBLOAT]: 'text-yellow',
+    [CAKE_JOURNAL_EVENTS.RECOVERED]: 'text-green',
+    [CAKE_JOURNAL_EVENTS.SPLIT_GSO]: 'text-blue',
+    [CAKE_JOURNAL_EVENTS.ERROR]: 'text-red',
+    [CAKE_JOURNAL_EVENTS.PROBE]: 'text-blue',
+    [CAKE_JOURNAL_EVENTS.WINDOW]: 'text-blue',
+    [CAKE_JOURNAL_EVENTS.SOURCE_EXCLUDED]: 'text-yellow',
+    [CAKE_JOURNAL_EVENTS.SOURCE_RESTORED]: 'text-green',
+};
+
+/**
+ * Journal shows the latest notable reconfigurations of CAKE, newest first.
+ */
+const Journal = ({ journal }) => {
+    const { t } = useTranslation();
+
+    return <Card title={t('cake_journal')} bodyType="card-table-overflow">
+        {journal.length === 0 ? (
+            <div className="card-body">{t('cake_journal_empty')}</div>
+        ) : (
+            <table className="table card-table">
+                <thead>
+                    <tr>
+                        <th>{t('time_table_header')}</th>
+                        <th>{t('cake_event')}</th>
+                        <th>{t('cake_rtt')}</th>
+                        <th>{t('cake_upload')}</th>
+                        <th>{t('cake_download')}</th>
+                    </tr>
+                </thead>
+                <tbody>
+                    {[...journal].reverse().map((entry) => <tr key={`${entry.time}_${entry.event}`}>
+                        <td>{dateFormat(entry.time, 'D MMM HH:mm:ss')}</td>
+                        <td className={EVENT_CLASSES[entry.event]} title={entry.message}>
+                            {t(`cake_event_${entry.event}`)}
+                            {entry.message && entry.event !== CAKE_JOURNAL_EVENTS.ERROR && (
+                                <div className="small text-muted">{entry.message}</div>
+                            )}
+                        </td>
+                        <td>{(entry.rtt / 1000).toFixed(2)} {t('milliseconds_abbreviation')}</td>
+                        <td>{formatBandwidth(entry.bandwidthUpload)}</td>
+                        <td>{formatBandwidth(entry.bandwidthDownload)}</td>
+                    </tr>)}
+                </tbody>
+            </table>
+        )}
+    </Card>;
+};
+
+Journal.propTypes = {
+    journal: PropTypes.array.isRequired,
+};
+
+export default Journal;
@@ -0,0 +1,73 @@
+import React from 'react';
+import PropTypes from 'prop-types';
+import { useTranslation } from 'react-i18next';
+import dateFormat from 'date-fns/format';
+
+import Card from '../ui/Card';
+import { CAKE_SOURCE_STATES } from '../../helpers/constants';
+
+const STATE_CLASSES = {
+    [CAKE_SOURCE_STATES.HEALTHY]: 'text-green',
+    [CAKE_SOURCE_STATES.UNRELIABLE]: 'text-yellow',
+    [CAKE_SOURCE_STATES.EXCLUDED]: 'text-red',
+};
+
+const formatUs = (us) => `${(us / 1000).toFixed(1)} ms`;
+
+/**
+ * Sources shows the health of the sources of the latency samples.
+ */
+const Sources = ({ sources }) => {
+    const { t } = useTranslation();
+
+    return <Card title={t('cake_sources')} bodyType="card-table-overflow">
+        {sources.length === 0 ? (
+            <div className="card-body">{t('cake_sources_empty')}</div>
+        ) : (
+            <table className="table card-table">
+                <thead>
+                    <tr>
+                        <th>{t('cake_source')}</th>
+                        <th>{t('cake_source_state')}</th>
+                        <th>{t('cake_source_score')}</th>
+                        <th>{t('cake_rtt')}</th>
+                        <th>{t('cake_source_baseline')}</th>
+                        <th>{t('cake_source_jitter')}</th>
+                        <th>{t('cake_source_deviation')}</th>
+                        <th>{t('cake_source_loss')}</th>
+                    </tr>
+                </thead>
+                <tbody>
+                    {sources.map((source) => <tr key={source.name}>
+                        <td>
+                            {source.name}
+                            <div className="small text-muted">
+                                {t(`cake_source_kind_${source.kind}`)}
+                            </div>
+                        </td>
+                        <td className={STATE_CLASSES[source.state]}>
+                            {t(`cake_source_state_${source.state}`, {
+                                time: source.excludedUntil && dateFormat(source.excludedUntil, 'HH:mm:ss'),
+                            })}
+                            {source.reason && source.state !== CAKE_SOURCE_STATES.HEALTHY && (
+                                <div className="small text-muted">{t(`cake_source_${source.reason}`)}</div>
+                            )}
+                        </td>
+                        <td>{Math.round(source.score * 100)}%</td>
+                        <td>{formatUs(source.rtt)}</td>
+                        <td>{formatUs(source.baseline)}</td>
+                        <td>{formatUs(source.jitter)}</td>
+                        <td>{formatUs(source.deviation)}</td>
+                        <td>{Math.round(source.loss * 100)}%</td>
+                    </tr>)}
+                </tbody>
+            </table>
+        )}
+    </Card>;
+};
+
+Sources.propTypes = {
+    sources: PropTypes.array.isRequired,
+};
+
+export default Sources;
@@ -0,0 +1,52 @@
+import React from 'react';
+import PropTypes from 'prop-types';
+import { useTranslation } from 'react-i18next';
+
+import Card from '../ui/Card';
+import { formatBandwidth, formatNumber } from '../../helpers/helpers';
+
+/**
+ * Tins shows the statistics of the tins of CAKE on a single interface.
+ */
+const Tins = ({ title, tins }) => {
+    const { t } = useTranslation();
+
+    return <Card title={title} bodyType="card-table-overflow">
+        {tins.length === 0 ? (
+            <div className="card-body">{t('cake_no_tins')}</div>
+        ) : (
+            <table className="table card-table">
+                <thead>
+                    <tr>
+                        <th>{t('cake_tin')}</th>
+                        <th>{t('cake_threshold')}</th>
+                        <th>{t('cake_sent_packets')}</th>
+                        <th>{t('cake_drops')}</th>
+                        <th>{t('cake_ecn_marks')}</th>
+                        <th>{t('cake_avg_delay')}</th>
+                        <th>{t('cake_peak_delay')}</th>
+                    </tr>
+                </thead>
+                <tbody>
+                    {tins.map((tin) => <tr key={tin.name}>
+                        <td>{tin.name}</td>
+                        {/* The threshold rate is reported in bytes per second. */}
+                        <td>{formatBandwidth((tin.thresholdRate * 8) / 1000)}</td>
+                        <td>{formatNumber(tin.sentPackets)}</td>
+                        <td>{formatNumber(tin.drops)}</td>
+                        <td>{formatNumber(tin.ecnMarks)}</td>
+                        <td>{formatNumber(tin.avgDelayUs)} µs</td>
+                        <td>{formatNumber(tin.peakDelayUs)} µs</td>
+                    </tr>)}
+                </tbody>
+            </table>
+        )}
+    </Card>;
+};
+
+Tins.propTypes = {
+    title: PropTypes.string.isRequired,
+    tins: PropTypes.array.isRequired,
+};
+
+export default Tins;
@@ -0,0 +1,166 @@
+import React, { useEffect } from 'react';
+import { shallowEqual, useDispatch, useSelector } from 'react-redux';
+import { useTranslation } from 'react-i18next';
+
+import { getCakeStatus, subscribeCakeEvents } from '../../actions/cake';
+import { formatBandwidth, isCakeThrottled } from '../../helpers/helpers';
+import PageTitle from '../ui/PageTitle';
+import Loading from '../ui/Loading';
+import Card from '../ui/Card';
+import ChartCard from './ChartCard';
+import Tins from './Tins';
+import Journal from './Journal';
+import Sources from './Sources';
+
+const formatRTT = (us) => `${(us / 1000).toFixed(2)} ms`;
+
+const Cake = () => {
+    const { t } = useTranslation();
+    const dispatch = useDispatch();
+
+    const {
+        enabled,
+        processing,
+        params,
+        tins,
+        journal,
+        history,
+        probe,
+        sources,
+    } = useSelector((state) => state.cake, shallowEqual);
+
+    useEffect(() => {
+        dispatch(getCakeStatus(true));
+
+        return dispatch(subscribeCakeEvents());
+    }, []);
+
+    if (processing && history.length === 0) {
+        return <Loading />;
+    }
+
+    if (!enabled) {
+        return <>
+            <PageTitle title={t('cake_title')} />
+            <Card>{t('cake_disabled')}</Card>
+        </>;
+    }
+
+    const last = history[history.length - 1] || {};
+    const throttled = isCakeThrottled(params);
+
+    let verdict = throttled ? t('cake_verdict_throttled') : t('cake_verdict_ok');
+    if (params.probing) {
+        verdict = t('cake_probing');
+    }
+
+    return <>
+        <PageTitle
+            title={t('cake_title')}
+            subtitle={verdict}
+        />
+        <div className="row row-cards">
+            <div className="col-lg-4">
+                <ChartCard
+                    history={history}
+                    field="rtt"
+                    value={formatRTT(params.rtt)}
+                    title={t('cake_rtt')}
+                    color={throttled ? 'yellow' : 'green'}
+                    format={formatRTT}
+                />
+            </div>
+            <div className="col-lg-4">
+                <ChartCard
+                    history={history}
+                    field="throughputUpload"
+                    value={formatBandwidth(last.throughputUpload || 0)}
+                    title={t('cake_upload_of', { limit: formatBandwidth(params.bandwidthUpload) })}
+                    color="blue"
+                    format={formatBandwidth}
+                />
+            </div>
+            <div className="col-lg-4">
+                <ChartCard
+                    history={history}
+                    field="throughputDownload"
+                    value={formatBandwidth(last.throughputDownload || 0)}
+                    title={t('cake_download_of', { limit: formatBandwidth(params.bandwidthDownload) })}
+                    color="blue"
+                    format={formatBandwidth}
+                />
+            </div>
+            <div className="col-lg-6">
+                <Card title={t('cake_params')} bodyType="card-table-overflow">
+                    <table className="table card-table">
+                        <tbody>
+                            <tr>
+                                <td>{t('cake_uplink_interface')}</td>
+                                <td>{params.uplinkInterface}</td>
+                            </tr>
+                            <tr>
+                                <td>{t('cake_downlink_interface')}</td>
+                                <td>{params.downlinkInterface}</td>
+                            </tr>
+                            <tr>
+                                <td>{t('cake_upload')}</td>
+                                <td>
+                                    {formatBandwidth(params.bandwidthUpload)}
+                                    {' / '}
+                                    {formatBandwidth(params.maxUpload)}
+                                </td>
+                            </tr>
+                            <tr>
+                                <td>{t('cake_download')}</td>
+                                <td>
+                                    {formatBandwidth(params.bandwidthDownload)}
+                                    {' / '}
+                                    {formatBandwidth(params.maxDownload)}
+                                </td>
+                            </tr>
+                            <tr>
+                                <td>{t('cake_window')}</td>
+                                <td>{params.window}</td>
+                            </tr>
+                            <tr>
+                                <td>{t('cake_rtt')}</td>
+                                <td>{formatRTT(params.rtt)}</td>
+                            </tr>
+                            <tr>
+                                <td>{t('cake_split_gso')}</td>
+                                <td>{params.splitGSO}</td>
+                            </tr>
+                            <tr>
+                                <td>{t('cake_downlink_tins')}</td>
+                                <td>{params.downlinkTins}</td>
+                            </tr>
+                            {probe && <tr>
+                                <td>{t('cake_probe')}</td>
+                                <td>
+                                    {t(probe.applied ? 'cake_probe_applied' : 'cake_probe_proposed', {
+                                        upload: formatBandwidth(probe.upload),
+                                        download: formatBandwidth(probe.download),
+                                    })}
+                                </td>
+                            </tr>}
+                        </tbody>
+                    </table>
+                </Card>
+            </div>
+            <div className="col-lg-6">
+                <Journal journal={journal} />
+            </div>
+            <div className="col-lg-12">
+                <Sources sources={sources} />
+            </div>
+            <div className="col-lg-6">
+                <Tins title={t('cake_uplink_tins')} tins={tins.uplink || []} />
+            </div>
+            <div className="col-lg-6">
+                <Tins title={t('cake_downlink_tins_stats')} tins={tins.downlink || []} />
+            </div>
+        </div>
+    </>;
+};
+
+export default Cake;
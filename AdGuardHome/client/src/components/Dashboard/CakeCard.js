@@ -0,0 +1,61 @@
+import React from 'react';
+import PropTypes from 'prop-types';
+import { Link } from 'react-router-dom';
+import { useTranslation } from 'react-i18next';
+import dateFormat from 'date-fns/format';
+
+import { MENU_URLS, STATUS_COLORS } from '../../helpers/constants';
+import {
+    formatBandwidth,
+    isCakeThrottled,
+    normalizeCakeHistory,
+} from '../../helpers/helpers';
+import Card from '../ui/Card';
+import Line from '../ui/Line';
+
+/**
+ * CakeCard answers whether the connection is currently slowed down by the
+ * bufferbloat and links to the detailed latency page.
+ */
+const CakeCard = ({ cake: { params, history }, refreshButton }) => {
+    const { t } = useTranslation();
+
+    const throttled = isCakeThrottled(params);
+    const color = throttled ? 'yellow' : 'green';
+
+    return <Card
+        title={t('cake_title')}
+        subtitle={throttled ? t('cake_verdict_throttled') : t('cake_verdict_ok')}
+        bodyType="card-wrap"
+        refresh={refreshButton}
+    >
+        <div className="card-body-stats">
+            <div className={`card-value card-value-stats text-${color}`}>
+                {(params.rtt / 1000).toFixed(2)} {t('milliseconds_abbreviation')}
+            </div>
+            <div className="card-title-stats">
+                {t('cake_bandwidth_summary', {
+                    upload: formatBandwidth(params.bandwidthUpload),
+                    download: formatBandwidth(params.bandwidthDownload),
+                })}
+                {' '}
+                <Link to={MENU_URLS.cake}>{t('cake_details')}</Link>
+            </div>
+        </div>
+        <div className="card-chart-bg">
+            <Line
+                data={normalizeCakeHistory(history, 'rtt', 'rtt')}
+                color={STATUS_COLORS[color]}
+                xFormat={(x) => (history[x] ? dateFormat(history[x].time, 'HH:mm:ss') : '')}
+                yFormat={(y) => `${(y / 1000).toFixed(2)} ${t('milliseconds_abbreviation')}`}
+            />
+        </div>
+    </Card>;
+};
+
+CakeCard.propTypes = {
+    cake: PropTypes.object.isRequired,
+    refreshButton: PropTypes.node.isRequired,
+};
+
+export default CakeCard;
@@ -28,16 +28,20 @@ import './Dashboard.css';
 import Dropdown from '../ui/Dropdown';
 import UpstreamResponses from './UpstreamResponses';
 import UpstreamAvgTime from './UpstreamAvgTime';
+import CakeCard from './CakeCard';
 
 const Dashboard = ({
     getAccessList,
     getStats,
     getStatsConfig,
+    getCakeStatus,
+    subscribeCakeEvents,
     dashboard,
     dashboard: { protectionEnabled, processingProtection, protectionDisabledDuration },
     toggleProtection,
     stats,
     access,
+    cake,
 }) => {
     const { t } = useTranslation();
 
@@ -45,10 +49,13 @@ const Dashboard = ({
         getAccessList();
         getStats();
         getStatsConfig();
+        getCakeStatus();
     };
 
     useEffect(() => {
         getAllStats();
+
+        return subscribeCakeEvents();
     }, []);
     const getSubtitle = () => {
         if (!stats.enabled) {
@@ -207,6 +214,12 @@ const Dashboard = ({
                     refreshButton={refreshButton}
                 />
             </div>
+            {cake.enabled && <div className="col-lg-12">
+                <CakeCard
+                    cake={cake}
+                    refreshButton={refreshButton}
+                />
+            </div>}
             <div className="col-lg-6">
                 <Counters
                     subtitle={subtitle}
@@ -266,8 +279,11 @@ Dashboard.propTypes = {
     dashboard: PropTypes.object.isRequired,
     stats: PropTypes.object.isRequired,
     access: PropTypes.object.isRequired,
+    cake: PropTypes.object.isRequired,
     getStats: PropTypes.func.isRequired,
     getStatsConfig: PropTypes.func.isRequired,
+    getCakeStatus: PropTypes.func.isRequired,
+    subscribeCakeEvents: PropTypes.func.isRequired,
     toggleProtection: PropTypes.func.isRequired,
     getClients: PropTypes.func.isRequired,
     getAccessList: PropTypes.func.isRequired,
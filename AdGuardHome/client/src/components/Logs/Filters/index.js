@@ -5,6 +5,8 @@ import { useDispatch } from 'react-redux';
 import Form from './Form';
 import { refreshFilteredLogs } from '../../../actions/queryLogs';
 import { addSuccessToast } from '../../../actions/toasts';
+import apiClient from '../../../api/Api';
+import { QUERY_LOG_EXPORT_FORMATS } from '../../../helpers/constants';
 
 const Filters = ({ filter, setIsLoading }) => {
     const { t } = useTranslation();
@@ -30,6 +32,21 @@ const Filters = ({ filter, setIsLoading }) => {
                     <use xlinkHref="#update" />
                 </svg>
             </button>
+            <span className="logs__export">
+                <span className="text-muted mr-2">{t('query_log_export')}</span>
+                {QUERY_LOG_EXPORT_FORMATS.map((format) => <a
+                    key={format}
+                    className="btn btn-outline-secondary btn-sm mr-1"
+                    href={apiClient.getQueryLogExportUrl({
+                        format,
+                        search: filter.search || '',
+                        response_status: filter.response_status || '',
+                    })}
+                    download
+                >
+                    {t(`query_log_export_${format}`)}
+                </a>)}
+            </span>
         </h1>
         <Form
                 responseStatusClass="d-sm-block"
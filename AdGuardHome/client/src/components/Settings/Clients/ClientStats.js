@@ -0,0 +1,106 @@
+import React, { useEffect, useState } from 'react';
+import PropTypes from 'prop-types';
+import { useTranslation } from 'react-i18next';
+
+import apiClient from '../../../api/Api';
+import Loading from '../../ui/Loading';
+
+const TOP_DOMAINS_LIMIT = 10;
+
+const DomainsTable = ({ title, domains }) => {
+    const { t } = useTranslation();
+
+    return <div className="col-12 col-md-6 mb-3">
+        <div className="form__label">{title}</div>
+        {domains.length === 0 ? (
+            <div className="text-muted">{t('no_domains_found')}</div>
+        ) : (
+            <table className="table table-sm">
+                <thead>
+                    <tr>
+                        <th>{t('domain')}</th>
+                        <th>{t('requests_count')}</th>
+                    </tr>
+                </thead>
+                <tbody>
+                    {domains.slice(0, TOP_DOMAINS_LIMIT).map((top) => {
+                        const [domain, count] = Object.entries(top)[0];
+
+                        return <tr key={domain}>
+                            <td className="text-break">{domain}</td>
+                            <td>{count}</td>
+                        </tr>;
+                    })}
+                </tbody>
+            </table>
+        )}
+    </div>;
+};
+
+DomainsTable.propTypes = {
+    title: PropTypes.string.isRequired,
+    domains: PropTypes.array.isRequired,
+};
+
+/**
+ * ClientStats shows the top domains requested by the persistent client within
+ * the statistics retention interval.
+ */
+const ClientStats = ({ name }) => {
+    const { t } = useTranslation();
+    const [stats, setStats] = useState(null);
+    const [failed, setFailed] = useState(false);
+
+    useEffect(() => {
+        let cancelled = false;
+
+        apiClient.getClientStats(name)
+            .then((data) => {
+                if (!cancelled) {
+                    setStats(data);
+                }
+            })
+            .catch(() => {
+                if (!cancelled) {
+                    setFailed(true);
+                }
+            });
+
+        return () => {
+            cancelled = true;
+        };
+    }, [name]);
+
+    if (failed) {
+        return null;
+    }
+
+    if (!stats) {
+        return <Loading />;
+    }
+
+    return <div className="form__group mb-0">
+        <div className="form__desc mb-3">
+            {t('client_stats_summary', {
+                queries: stats.num_dns_queries,
+                blocked: stats.num_blocked,
+            })}
+        </div>
+        <div className="row">
+            <DomainsTable
+                title={t('stats_query_domain')}
+                domains={stats.top_queried_domains}
+            />
+            <DomainsTable
+                title={t('top_blocked_domains')}
+                domains={stats.top_blocked_domains}
+            />
+        </div>
+    </div>;
+};
+
+ClientStats.propTypes = {
+    name: PropTypes.string.isRequired,
+};
+
+export default ClientStats;
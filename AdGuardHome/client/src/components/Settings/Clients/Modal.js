@@ -5,6 +5,7 @@ import ReactModal from 'react-modal';
 
 import { MODAL_TYPE } from '../../../helpers/constants';
 import Form from './Form';
+import ClientStats from './ClientStats';
 
 const getInitialData = ({
     initial, modalType, clientId, clientName,
@@ -73,6 +74,14 @@ const Modal = ({
                         <span className="sr-only">Close</span>
                     </button>
                 </div>
+                {modalType === MODAL_TYPE.EDIT_CLIENT && currentClientData.name && (
+                    <div className="modal-body pb-0">
+                        <div className="form__label">
+                            <Trans>client_stats</Trans>
+                        </div>
+                        <ClientStats name={currentClientData.name} />
+                    </div>
+                )}
                 <Form
                     initialValues={{ ...initialData }}
                     onSubmit={handleSubmit}
@@ -5,6 +5,7 @@ import { Field, reduxForm } from 'redux-form';
 import { Trans, useTranslation } from 'react-i18next';
 import classnames from 'classnames';
 import Examples from './Examples';
+import Scores from './Scores';
 import { renderRadioField, renderTextareaField, CheckboxField } from '../../../../helpers/form';
 import {
     DNS_REQUEST_OPTIONS,
@@ -117,6 +118,14 @@ const INPUT_FIELDS = [
         subtitle: 'fastest_addr_desc',
         placeholder: 'fastest_addr',
     },
+    {
+        name: UPSTREAM_MODE_NAME,
+        type: 'radio',
+        value: DNS_REQUEST_OPTIONS.ADAPTIVE,
+        component: renderRadioField,
+        subtitle: 'adaptive_upstream_desc',
+        placeholder: 'adaptive_upstream',
+    },
 ];
 
 const Form = ({
@@ -130,6 +139,7 @@ const Form = ({
     const defaultLocalPtrUpstreams = useSelector(
         (state) => state.dnsConfig.default_local_ptr_upstreams,
     );
+    const upstreamsScores = useSelector((state) => state.dnsConfig.upstreams_scores);
 
     const handleUpstreamTest = () => dispatch(testUpstreamWithFormValues());
 
@@ -175,6 +185,7 @@ const Form = ({
                 </div>
             </div>
             {INPUT_FIELDS.map(renderField)}
+            {upstreamsScores?.length > 0 && <Scores scores={upstreamsScores} />}
             <div className="col-12">
                 <Examples />
                 <hr />
@@ -0,0 +1,57 @@
+import React from 'react';
+import PropTypes from 'prop-types';
+import { useTranslation } from 'react-i18next';
+
+import { UPSTREAM_SCORE_STATES } from '../../../../helpers/constants';
+
+const STATE_CLASSES = {
+    [UPSTREAM_SCORE_STATES.BEST]: 'text-green',
+    [UPSTREAM_SCORE_STATES.PROBED]: 'text-muted',
+    [UPSTREAM_SCORE_STATES.QUARANTINED]: 'text-red',
+};
+
+const formatMs = (ms) => `${ms.toFixed(1)} ms`;
+
+/**
+ * Scores shows the scores of the upstreams in the adaptive upstream mode.
+ */
+const Scores = ({ scores }) => {
+    const { t } = useTranslation();
+
+    return <div className="col-12 mb-4">
+        <div className="form__label">{t('upstream_scores')}</div>
+        <div className="table-responsive">
+            <table className="table table-sm">
+                <thead>
+                    <tr>
+                        <th>{t('upstream')}</th>
+                        <th>{t('upstream_score')}</th>
+                        <th>{t('upstream_score_latency')}</th>
+                        <th>{t('upstream_score_error_rate')}</th>
+                        <th>{t('upstream_score_requests')}</th>
+                    </tr>
+                </thead>
+                <tbody>
+                    {scores.map((score) => <tr key={score.address}>
+                        <td className="font-monospace">
+                            {score.address}
+                            <div className={`small ${STATE_CLASSES[score.state]}`}>
+                                {t(`upstream_score_state_${score.state}`)}
+                            </div>
+                        </td>
+                        <td>{formatMs(score.score)}</td>
+                        <td>{formatMs(score.latency)}</td>
+                        <td>{Math.round(score.error_rate * 100)}%</td>
+                        <td>{score.requests}</td>
+                    </tr>)}
+                </tbody>
+            </table>
+        </div>
+    </div>;
+};
+
+Scores.propTypes = {
+    scores: PropTypes.array.isRequired,
+};
+
+export default Scores;
@@ -12,7 +12,7 @@ import { msToDays, msToHours } from '../../helpers/helpers';
 import { TIME_UNITS } from '../../helpers/constants';
 
 const Line = ({
-    data, color = 'black',
+    data, color = 'black', xFormat, yFormat,
 }) => {
     const interval = useSelector((state) => state.stats.interval);
     const timeUnits = useSelector((state) => state.stats.timeUnits);
@@ -44,7 +44,7 @@ const Line = ({
         enableGridX={false}
         enableGridY={false}
         enablePoints={false}
-        xFormat={(x) => {
+        xFormat={xFormat || ((x) => {
             if (timeUnits === TIME_UNITS.HOURS) {
                 const hoursAgo = msToHours(interval) - x - 1;
                 return dateFormat(subHours(Date.now(), hoursAgo), 'D MMM HH:00');
@@ -52,8 +52,8 @@ const Line = ({
 
             const daysAgo = subDays(Date.now(), msToDays(interval) - 1);
             return dateFormat(addDays(daysAgo, x), 'D MMM YYYY');
-        }}
-        yFormat={(y) => round(y, 2)}
+        })}
+        yFormat={yFormat || ((y) => round(y, 2))}
         sliceTooltip={(slice) => {
             const { xFormatted, yFormatted } = slice.slice.points[0].data;
             return <div className="line__tooltip">
@@ -70,6 +70,8 @@ const Line = ({
 Line.propTypes = {
     data: PropTypes.array.isRequired,
     color: PropTypes.string,
+    xFormat: PropTypes.func,
+    yFormat: PropTypes.func,
     width: PropTypes.number,
     height: PropTypes.number,
 };
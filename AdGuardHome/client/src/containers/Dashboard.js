@@ -2,11 +2,16 @@ import { connect } from 'react-redux';
 import { toggleProtection, getClients } from '../actions';
 import { getStats, getStatsConfig, setStatsConfig } from '../actions/stats';
 import { getAccessList } from '../actions/access';
+import { getCakeStatus, subscribeCakeEvents } from '../actions/cake';
 import Dashboard from '../components/Dashboard';
 
 const mapStateToProps = (state) => {
-    const { dashboard, stats, access } = state;
-    const props = { dashboard, stats, access };
+    const {
+        dashboard, stats, access, cake,
+    } = state;
+    const props = {
+        dashboard, stats, access, cake,
+    };
     return props;
 };
 
@@ -17,6 +22,8 @@ const mapDispatchToProps = {
     getStatsConfig,
     setStatsConfig,
     getAccessList,
+    getCakeStatus,
+    subscribeCakeEvents,
 };
 
 export default connect(
@@ -1,5 +1,7 @@
 export const R_URL_REQUIRES_PROTOCOL = /^https?:\/\/[^/\s]+(\/.*)?$/;
 
+export const R_URL_RPZ = /^rpz:\/\/[^/\s]+\/[^/\s]+$/;
+
 // matches hostname or *.wildcard
 export const R_HOST = /^(\*\.)?[\w.-]+$/;
 
@@ -48,6 +50,31 @@ export const STATS_NAMES = {
     replaced_safesearch: 'enforced_save_search',
 };
 
+// CAKE_HISTORY_SIZE is the maximum number of the CAKE history points kept by
+// the UI, which is five minutes of the per-second samples.
+export const CAKE_HISTORY_SIZE = 300;
+
+// CAKE_JOURNAL_EVENTS are the kinds of the CAKE reconfiguration journal
+// entries.
+export const CAKE_JOURNAL_EVENTS = {
+    BUFFERBLOAT: 'bufferbloat',
+    RECOVERED: 'recovered',
+    SPLIT_GSO: 'split-gso',
+    ERROR: 'error',
+    PROBE: 'probe',
+    WINDOW: 'window',
+    SOURCE_EXCLUDED: 'source_excluded',
+    SOURCE_RESTORED: 'source_restored',
+};
+
+// CAKE_SOURCE_STATES are the health states of the sources of the CAKE latency
+// samples.
+export const CAKE_SOURCE_STATES = {
+    HEALTHY: 'healthy',
+    UNRELIABLE: 'unreliable',
+    EXCLUDED: 'excluded',
+};
+
 export const STATUS_COLORS = {
     blue: '#467fcf',
     red: '#cd201f',
@@ -195,6 +222,7 @@ export const MENU_URLS = {
     root: '/',
     logs: '/logs',
     guide: '/guide',
+    cake: '/cake',
 };
 
 export const SETTINGS_URLS = {
@@ -286,6 +314,8 @@ export const FILTERED_STATUS = {
     FILTERED_SAFE_SEARCH: 'FilteredSafeSearch',
     FILTERED_SAFE_BROWSING: 'FilteredSafeBrowsing',
     FILTERED_PARENTAL: 'FilteredParental',
+    FILTERED_ANOMALY: 'FilteredAnomaly',
+    FILTERED_RESPONSE_RULE: 'FilteredResponseRule',
 };
 
 export const RESPONSE_FILTER = {
@@ -329,6 +359,10 @@ export const RESPONSE_FILTER = {
         QUERY: 'safe_search',
         LABEL: 'safe_search',
     },
+    ANOMALY: {
+        QUERY: 'anomaly',
+        LABEL: 'anomaly',
+    },
 };
 
 export const RESPONSE_FILTER_QUERIES = Object.values(RESPONSE_FILTER)
@@ -386,6 +420,14 @@ export const FILTERED_STATUS_TO_META_MAP = {
         LABEL: RESPONSE_FILTER.BLOCKED_ADULT_WEBSITES.LABEL,
         COLOR: QUERY_STATUS_COLORS.YELLOW,
     },
+    [FILTERED_STATUS.FILTERED_ANOMALY]: {
+        LABEL: RESPONSE_FILTER.ANOMALY.LABEL,
+        COLOR: QUERY_STATUS_COLORS.YELLOW,
+    },
+    [FILTERED_STATUS.FILTERED_RESPONSE_RULE]: {
+        LABEL: 'response_rule',
+        COLOR: QUERY_STATUS_COLORS.RED,
+    },
 };
 
 export const DEFAULT_TIME_FORMAT = 'HH:mm:ss';
@@ -420,6 +462,8 @@ export const SPECIAL_FILTER_ID = {
     PARENTAL: -3,
     SAFE_BROWSING: -4,
     SAFE_SEARCH: -5,
+    RESPONSE_RULES: -6,
+    ANOMALY_DETECTION: -7,
 };
 
 export const BLOCK_ACTIONS = {
@@ -439,6 +483,15 @@ export const DNS_REQUEST_OPTIONS = {
     PARALLEL: 'parallel',
     FASTEST_ADDR: 'fastest_addr',
     LOAD_BALANCING: 'load_balance',
+    ADAPTIVE: 'adaptive',
+};
+
+export const QUERY_LOG_EXPORT_FORMATS = ['csv', 'ndjson', 'parquet'];
+
+export const UPSTREAM_SCORE_STATES = {
+    BEST: 'best',
+    PROBED: 'probed',
+    QUARANTINED: 'quarantined',
 };
 
 export const DHCP_FORM_NAMES = {
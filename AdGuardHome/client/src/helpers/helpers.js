@@ -839,6 +839,10 @@ export const getSpecialFilterName = (filterId) => {
             return i18n.t('safe_browsing');
         case SPECIAL_FILTER_ID.SAFE_SEARCH:
             return i18n.t('safe_search');
+        case SPECIAL_FILTER_ID.RESPONSE_RULES:
+            return i18n.t('response_rules');
+        case SPECIAL_FILTER_ID.ANOMALY_DETECTION:
+            return i18n.t('anomaly_detection');
         default:
             return i18n.t('unknown_filter', { filterId });
     }
@@ -1051,3 +1055,46 @@ export const getServiceName = (services, id) => getService(services, id)?.name;
  * @returns {string}
  */
 export const getServiceIcon = (services, id) => getService(services, id)?.icon_svg;
+
+/**
+ * Formats the bandwidth in kbit/s as in the CAKE metrics.
+ *
+ * @param kbit {number}
+ * @returns {string}
+ */
+export const formatBandwidth = (kbit) => {
+    if (kbit >= 1000) {
+        return `${formatNumber(round(kbit / 1000, 2))} Mbit/s`;
+    }
+
+    return `${formatNumber(round(kbit, 2))} kbit/s`;
+};
+
+/**
+ * Converts the CAKE history points into the data for the Line chart.
+ *
+ * @param history {array}
+ * @param field {string} Name of the field of the points to use.
+ * @param id {string}
+ * @returns {array}
+ */
+export const normalizeCakeHistory = (history, field, id) => [{
+    id,
+    data: history.map((point, idx) => ({
+        x: idx,
+        y: point[field],
+    })),
+}];
+
+/**
+ * Returns true if the CAKE controller is currently slowing the connection down
+ * because of a latency increase, that is when any of the bandwidths is below
+ * its ceiling, which is 90% of the maximum.
+ *
+ * @param params {object}
+ * @returns {boolean}
+ */
+export const isCakeThrottled = (params) => (
+    params.bandwidthUpload < params.maxUpload * 0.9
+    || params.bandwidthDownload < params.maxDownload * 0.9
+);
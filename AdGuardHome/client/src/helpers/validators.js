@@ -9,6 +9,7 @@ import {
     R_IPV6,
     R_MAC,
     R_URL_REQUIRES_PROTOCOL,
+    R_URL_RPZ,
     STANDARD_WEB_PORT,
     UNSAFE_PORTS,
     R_CLIENT_ID,
@@ -310,7 +311,10 @@ export const validateAnswer = (value) => {
  * @returns {undefined|string}
  */
 export const validatePath = (value) => {
-    if (value && !isValidAbsolutePath(value) && !R_URL_REQUIRES_PROTOCOL.test(value)) {
+    if (value
+        && !isValidAbsolutePath(value)
+        && !R_URL_REQUIRES_PROTOCOL.test(value)
+        && !R_URL_RPZ.test(value)) {
         return 'form_error_url_or_path_format';
     }
     return undefined;
@@ -0,0 +1,50 @@
+import { handleActions } from 'redux-actions';
+
+import { CAKE_HISTORY_SIZE } from '../helpers/constants';
+import * as actions from '../actions/cake';
+
+const defaultCake = {
+    enabled: false,
+    processing: true,
+    metrics: {},
+    params: {},
+    tins: { uplink: [], downlink: [] },
+    journal: [],
+    history: [],
+    probe: null,
+    sources: [],
+};
+
+const cake = handleActions(
+    {
+        [actions.getCakeStatusRequest]: (state) => ({ ...state, processing: true }),
+        [actions.getCakeStatusFailure]: (state) => ({
+            ...state,
+            enabled: false,
+            processing: false,
+        }),
+        [actions.getCakeStatusSuccess]: (state, { payload }) => ({
+            ...state,
+            ...payload,
+            history: payload.history || [],
+            journal: payload.journal || [],
+            sources: payload.sources || [],
+            enabled: true,
+            processing: false,
+        }),
+
+        // The updates only carry the history points added since the previous
+        // one.
+        [actions.cakeStatusUpdate]: (state, { payload }) => ({
+            ...state,
+            ...payload,
+            history: [...state.history, ...(payload.history || [])].slice(-CAKE_HISTORY_SIZE),
+            journal: payload.journal || [],
+            sources: payload.sources || [],
+            enabled: true,
+        }),
+    },
+    defaultCake,
+);
+
+export default cake;
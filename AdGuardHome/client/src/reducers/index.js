@@ -14,6 +14,7 @@ import filtering from './filtering';
 import settings from './settings';
 import dashboard from './dashboard';
 import dhcp from './dhcp';
+import cake from './cake';
 
 export default combineReducers({
     settings,
@@ -29,6 +30,7 @@ export default combineReducers({
     services,
     stats,
     dnsConfig,
+    cake,
     loadingBar: loadingBarReducer,
     form: formReducer,
 });
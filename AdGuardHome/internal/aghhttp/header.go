@@ -5,6 +5,9 @@ package aghhttp
 // HTTP header value constants.
 const (
 	HdrValApplicationJSON         = "application/json"
+	HdrValApplicationOctetStream  = "application/octet-stream"
+	HdrValNoCache                 = "no-cache"
 	HdrValStrictTransportSecurity = "max-age=31536000; includeSubDomains"
+	HdrValTextEventStream         = "text/event-stream"
 	HdrValTextPlain               = "text/plain"
 )
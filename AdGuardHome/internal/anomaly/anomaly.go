@@ -0,0 +1,453 @@
+// Package anomaly contains the detector of the anomalies in the DNS traffic of
+// the clients, such as the bursts of NXDOMAIN responses caused by the domain
+// generation algorithms, DNS tunnelling, and query volume spikes.
+package anomaly
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Default values of the configuration.
+const (
+	defaultWindow             = 1 * time.Minute
+	defaultNXDomainThreshold  = 50
+	defaultSubdomainThreshold = 100
+	defaultEntropyThreshold   = 3.5
+	defaultSpikeFactor        = 10
+	defaultSpikeMinQueries    = 500
+	defaultMaxClients         = 10_000
+)
+
+const (
+	// maxParents is the maximum number of the parent domains tracked for a
+	// single client.
+	maxParents = 1000
+
+	// idleWindows is the number of windows after which the state of an idle
+	// client is removed.
+	idleWindows = 10
+)
+
+// Config is the configuration of the anomaly detector.
+type Config struct {
+	// Window is the length of the sliding window the events are counted
+	// within.  If zero, one minute is used.
+	Window timeutil.Duration `yaml:"window"`
+
+	// NXDomainThreshold is the number of NXDOMAIN responses to a client within
+	// the window, after which its queries for the names with a high entropy
+	// are detected as generated by a DGA.  If zero, 50 is used.
+	NXDomainThreshold uint `yaml:"nxdomain_threshold"`
+
+	// SubdomainThreshold is the number of unique subdomains of a single
+	// parent domain queried by a client within the window, after which its
+	// queries for the subdomains with a high entropy are detected as DNS
+	// tunnelling.  If zero, 100 is used.
+	SubdomainThreshold uint `yaml:"subdomain_threshold"`
+
+	// EntropyThreshold is the minimum Shannon entropy, in bits per character,
+	// of a label to be considered random.  If zero, 3.5 is used.
+	EntropyThreshold float64 `yaml:"entropy_threshold"`
+
+	// SpikeFactor is the ratio of the number of queries from a client within
+	// the window to its average, above which the queries are detected as a
+	// spike.  If zero, 10 is used.
+	SpikeFactor float64 `yaml:"spike_factor"`
+
+	// SpikeMinQueries is the minimum number of queries from a client within
+	// the window to be detected as a spike.  If zero, 500 is used.
+	SpikeMinQueries uint `yaml:"spike_min_queries"`
+
+	// MaxClients is the maximum number of the tracked clients.  The queries
+	// of the clients beyond the limit aren't checked.  If zero, 10000 is used.
+	MaxClients int `yaml:"max_clients"`
+
+	// Enabled defines if the anomalies are detected.
+	Enabled bool `yaml:"enabled"`
+
+	// Block defines if the queries detected as anomalous are blocked.
+	// Otherwise, they are only logged.
+	Block bool `yaml:"block"`
+}
+
+// validate returns an error if c is not valid.
+func (c *Config) validate() (err error) {
+	switch {
+	case c.Window.Duration < 0:
+		return fmt.Errorf("window: negative value %s", c.Window)
+	case c.EntropyThreshold < 0:
+		return fmt.Errorf("entropy_threshold: negative value %v", c.EntropyThreshold)
+	case c.SpikeFactor < 0:
+		return fmt.Errorf("spike_factor: negative value %v", c.SpikeFactor)
+	case c.MaxClients < 0:
+		return fmt.Errorf("max_clients: negative value %d", c.MaxClients)
+	default:
+		return nil
+	}
+}
+
+// Kind is the kind of a detected anomaly.
+type Kind string
+
+// Supported Kind values.
+const (
+	// KindDGA is a query for a random name from a client with many NXDOMAIN
+	// responses, which is typical for the malware using a domain generation
+	// algorithm.
+	KindDGA Kind = "dga"
+
+	// KindTunnel is a query for a random subdomain of a parent domain with many
+	// unique subdomains queried, which is typical for DNS tunnelling.
+	KindTunnel Kind = "tunnel"
+
+	// KindSpike is a query from a client with a number of queries much greater
+	// than usual.
+	KindSpike Kind = "spike"
+)
+
+// Detection is a query detected as anomalous.
+type Detection struct {
+	// Kind is the kind of the anomaly.
+	Kind Kind
+
+	// Client is the ID of the client that has sent the query.
+	Client string
+
+	// Domain is the domain the anomaly is related to.  It's the parent domain
+	// for [KindTunnel] and the queried one for the others.
+	Domain string
+
+	// Count is the number of the events within the window which caused the
+	// detection:  the NXDOMAIN responses for [KindDGA], the unique subdomains
+	// for [KindTunnel], and the queries for [KindSpike].
+	Count uint64
+
+	// Score is the entropy of the random label for [KindDGA] and [KindTunnel]
+	// and the ratio of the number of queries to the average for [KindSpike].
+	Score float64
+
+	// Block is true if the query should be blocked.
+	Block bool
+}
+
+// String implements the [fmt.Stringer] interface for *Detection.
+func (d *Detection) String() (s string) {
+	switch d.Kind {
+	case KindDGA:
+		return fmt.Sprintf("anomaly %s: %d nxdomain responses, entropy %.2f", d.Kind, d.Count, d.Score)
+	case KindTunnel:
+		return fmt.Sprintf(
+			"anomaly %s: %d unique subdomains of %s, entropy %.2f",
+			d.Kind,
+			d.Count,
+			d.Domain,
+			d.Score,
+		)
+	default:
+		return fmt.Sprintf("anomaly %s: %d queries, %.1f times the average", d.Kind, d.Count, d.Score)
+	}
+}
+
+// Detector detects the anomalies in the DNS traffic of the clients using the
+// per-client sliding windows.
+type Detector struct {
+	// mu protects clients and nextCleanup.
+	mu *sync.Mutex
+
+	// clients are the states of the tracked clients by their IDs.
+	clients map[string]*clientState
+
+	// nextCleanup is the time of the next removal of the idle clients.
+	nextCleanup time.Time
+
+	window             time.Duration
+	bucketIvl          time.Duration
+	nxdomainThreshold  uint64
+	subdomainThreshold uint64
+	entropyThreshold   float64
+	spikeFactor        float64
+	spikeMinQueries    uint64
+	maxClients         int
+	block              bool
+}
+
+// New returns a new properly initialized *Detector.  c must not be nil.
+func New(c *Config) (d *Detector, err error) {
+	err = c.validate()
+	if err != nil {
+		return nil, fmt.Errorf("anomaly: %w", err)
+	}
+
+	window := cmp.Or(c.Window.Duration, defaultWindow)
+
+	return &Detector{
+		mu:                 &sync.Mutex{},
+		clients:            map[string]*clientState{},
+		window:             window,
+		bucketIvl:          max(window/windowBuckets, 1),
+		nxdomainThreshold:  uint64(cmp.Or(c.NXDomainThreshold, defaultNXDomainThreshold)),
+		subdomainThreshold: uint64(cmp.Or(c.SubdomainThreshold, defaultSubdomainThreshold)),
+		entropyThreshold:   cmp.Or(c.EntropyThreshold, defaultEntropyThreshold),
+		spikeFactor:        cmp.Or(c.SpikeFactor, defaultSpikeFactor),
+		spikeMinQueries:    uint64(cmp.Or(c.SpikeMinQueries, defaultSpikeMinQueries)),
+		maxClients:         cmp.Or(c.MaxClients, defaultMaxClients),
+		block:              c.Block,
+	}, nil
+}
+
+// clientState is the state of the traffic of a single client.
+type clientState struct {
+	// parents are the unique subdomains queried within the window by their
+	// parent domains.
+	parents map[string]*subdomainSet
+
+	// reported are the bucket indexes of the last logged detections by kind.
+	reported map[Kind]int64
+
+	// queries are the queries within the window.
+	queries slidingCounter
+
+	// nxdomains are the NXDOMAIN responses within the window.
+	nxdomains slidingCounter
+
+	// baseline is the average number of queries per bucket.
+	baseline baseline
+}
+
+// newClientState returns a new properly initialized *clientState starting at
+// the bucket with index idx.
+func newClientState(idx int64) (cs *clientState) {
+	return &clientState{
+		parents:   map[string]*subdomainSet{},
+		reported:  map[Kind]int64{},
+		queries:   slidingCounter{idx: idx},
+		nxdomains: slidingCounter{idx: idx},
+	}
+}
+
+// advance moves the windows of cs to the bucket with index idx.
+func (cs *clientState) advance(idx int64) {
+	if closed, skipped := cs.queries.advance(idx); closed != 0 || skipped != 0 {
+		cs.baseline.update(closed, skipped)
+	}
+
+	cs.nxdomains.advance(idx)
+}
+
+// subdomainSet is the set of the unique subdomains of a parent domain.
+type subdomainSet struct {
+	// seen are the bucket indexes of the last queries by subdomain.
+	seen map[string]int64
+
+	// last is the bucket index of the last query.
+	last int64
+}
+
+// add adds sub queried within the bucket with index idx and returns the number
+// of unique subdomains within the window.  It tracks at most limit subdomains.
+func (s *subdomainSet) add(sub string, idx int64, limit uint64) (n uint64) {
+	s.last = idx
+	if _, ok := s.seen[sub]; ok || uint64(len(s.seen)) < limit {
+		s.seen[sub] = idx
+	}
+
+	if uint64(len(s.seen)) < limit {
+		return uint64(len(s.seen))
+	}
+
+	// Make sure that only the subdomains within the window are counted.
+	for k, i := range s.seen {
+		if i <= idx-windowBuckets {
+			delete(s.seen, k)
+		}
+	}
+
+	if uint64(len(s.seen)) < limit {
+		s.seen[sub] = idx
+	}
+
+	return uint64(len(s.seen))
+}
+
+// Check counts the query for host from the client with the specified ID and
+// returns the detected anomaly, if any.  It's safe for concurrent use.
+func (d *Detector) Check(client, host string, now time.Time) (det *Detection) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	idx := bucketIndex(now, d.bucketIvl)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cs := d.clientState(client, idx, now)
+	if cs == nil {
+		return nil
+	}
+
+	cs.advance(idx)
+	cs.queries.add()
+
+	det = d.checkSpike(cs)
+	if det == nil {
+		det = d.checkNames(cs, host, idx)
+	}
+
+	if det == nil {
+		return nil
+	}
+
+	det.Client, det.Block = client, d.block
+	if last, ok := cs.reported[det.Kind]; !ok || last <= idx-windowBuckets {
+		cs.reported[det.Kind] = idx
+		log.Info("anomaly: warning: client %s: %s", client, det)
+	}
+
+	return det
+}
+
+// Observe counts the response with the specified code to the client with the
+// specified ID.  It's safe for concurrent use.
+func (d *Detector) Observe(client string, rcode int, now time.Time) {
+	if rcode != dns.RcodeNameError {
+		return
+	}
+
+	idx := bucketIndex(now, d.bucketIvl)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cs := d.clientState(client, idx, now)
+	if cs == nil {
+		return
+	}
+
+	cs.advance(idx)
+	cs.nxdomains.add()
+}
+
+// clientState returns the state of the client, creating it if necessary.  cs
+// is nil if there are too many clients.  d.mu is expected to be locked.
+func (d *Detector) clientState(client string, idx int64, now time.Time) (cs *clientState) {
+	if now.After(d.nextCleanup) {
+		d.removeIdle(idx)
+		d.nextCleanup = now.Add(d.window)
+	}
+
+	cs = d.clients[client]
+	if cs != nil {
+		return cs
+	}
+
+	if len(d.clients) >= d.maxClients {
+		return nil
+	}
+
+	cs = newClientState(idx)
+	d.clients[client] = cs
+
+	return cs
+}
+
+// removeIdle removes the states of the clients idle for idleWindows windows
+// and the parent domains not queried within the window.  d.mu is expected to
+// be locked.
+func (d *Detector) removeIdle(idx int64) {
+	for c, cs := range d.clients {
+		if cs.queries.idx <= idx-idleWindows*windowBuckets {
+			delete(d.clients, c)
+
+			continue
+		}
+
+		for p, s := range cs.parents {
+			if s.last <= idx-windowBuckets {
+				delete(cs.parents, p)
+			}
+		}
+	}
+}
+
+// checkSpike returns the detection if the number of queries within the window
+// is much greater than the average.
+func (d *Detector) checkSpike(cs *clientState) (det *Detection) {
+	n := cs.queries.sum()
+	if n < d.spikeMinQueries || !cs.baseline.ready() {
+		return nil
+	}
+
+	// Make sure the ratio is finite for the clients that have been silent.
+	avg := max(cs.baseline.avg*windowBuckets, 1)
+	ratio := float64(n) / avg
+	if ratio <= d.spikeFactor {
+		return nil
+	}
+
+	return &Detection{
+		Kind:  KindSpike,
+		Count: n,
+		Score: ratio,
+	}
+}
+
+// checkNames returns the detection if host looks generated by a DGA or used for
+// DNS tunnelling.
+func (d *Detector) checkNames(cs *clientState, host string, idx int64) (det *Detection) {
+	parent, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		// The host is a public suffix itself or is invalid.
+		return nil
+	}
+
+	if nx := cs.nxdomains.sum(); nx >= d.nxdomainThreshold {
+		label, _, _ := strings.Cut(parent, ".")
+		if e := entropy(label); e >= d.entropyThreshold {
+			return &Detection{
+				Kind:   KindDGA,
+				Domain: host,
+				Count:  nx,
+				Score:  e,
+			}
+		}
+	}
+
+	sub := strings.TrimSuffix(host, "."+parent)
+	if sub == host {
+		return nil
+	}
+
+	s := cs.parents[parent]
+	if s == nil {
+		if len(cs.parents) >= maxParents {
+			return nil
+		}
+
+		s = &subdomainSet{seen: map[string]int64{}}
+		cs.parents[parent] = s
+	}
+
+	n := s.add(sub, idx, d.subdomainThreshold)
+	if n < d.subdomainThreshold {
+		return nil
+	}
+
+	e := entropy(strings.ReplaceAll(sub, ".", ""))
+	if e < d.entropyThreshold {
+		return nil
+	}
+
+	return &Detection{
+		Kind:   KindTunnel,
+		Domain: parent,
+		Count:  n,
+		Score:  e,
+	}
+}
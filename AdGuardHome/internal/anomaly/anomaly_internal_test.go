@@ -0,0 +1,172 @@
+package anomaly
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStart is the start time of the tests.
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testClient is the client ID used in tests.
+const testClient = "192.0.2.1"
+
+// newTestDetector returns a new *Detector with a one-minute window and small
+// thresholds.
+func newTestDetector(t *testing.T, block bool) (d *Detector) {
+	t.Helper()
+
+	d, err := New(&Config{
+		Window:             timeutil.Duration{Duration: time.Minute},
+		NXDomainThreshold:  5,
+		SubdomainThreshold: 5,
+		EntropyThreshold:   3,
+		SpikeFactor:        5,
+		SpikeMinQueries:    20,
+		MaxClients:         2,
+		Enabled:            true,
+		Block:              block,
+	})
+	require.NoError(t, err)
+
+	return d
+}
+
+func TestEntropy(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want float64
+	}{{
+		name: "empty",
+		in:   "",
+		want: 0,
+	}, {
+		name: "same",
+		in:   "aaaa",
+		want: 0,
+	}, {
+		name: "two",
+		in:   "abab",
+		want: 1,
+	}, {
+		name: "unique",
+		in:   "abcdefgh",
+		want: 3,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.InDelta(t, tc.want, entropy(tc.in), 1e-9)
+		})
+	}
+}
+
+func TestDetector_Check_dga(t *testing.T) {
+	d := newTestDetector(t, true)
+
+	const randomHost = "xk3j9q2vbz7w.com"
+
+	// Not enough NXDOMAIN responses yet.
+	now := testStart
+	for range 4 {
+		assert.Nil(t, d.Check(testClient, randomHost, now))
+		d.Observe(testClient, dns.RcodeNameError, now)
+	}
+
+	// The other response codes are ignored.
+	d.Observe(testClient, dns.RcodeSuccess, now)
+	assert.Nil(t, d.Check(testClient, randomHost, now))
+
+	d.Observe(testClient, dns.RcodeNameError, now)
+
+	det := d.Check(testClient, randomHost, now)
+	require.NotNil(t, det)
+
+	assert.Equal(t, KindDGA, det.Kind)
+	assert.Equal(t, testClient, det.Client)
+	assert.Equal(t, randomHost, det.Domain)
+	assert.Equal(t, uint64(5), det.Count)
+	assert.True(t, det.Block)
+
+	// The names with a low entropy aren't detected.
+	assert.Nil(t, d.Check(testClient, "google.com", now))
+
+	// Other clients aren't affected.
+	assert.Nil(t, d.Check("192.0.2.2", randomHost, now))
+
+	// The responses expire with the window.
+	assert.Nil(t, d.Check(testClient, randomHost, now.Add(2*time.Minute)))
+}
+
+func TestDetector_Check_tunnel(t *testing.T) {
+	d := newTestDetector(t, false)
+
+	now := testStart
+	for i := range 4 {
+		host := "q" + strconv.Itoa(i) + "v8xk2mz7t.tunnel.example"
+		assert.Nil(t, d.Check(testClient, host, now))
+	}
+
+	det := d.Check(testClient, "q4v8xk2mz7t.tunnel.example", now)
+	require.NotNil(t, det)
+
+	assert.Equal(t, KindTunnel, det.Kind)
+	assert.Equal(t, "tunnel.example", det.Domain)
+	assert.Equal(t, uint64(5), det.Count)
+	assert.False(t, det.Block)
+
+	// The subdomains with a low entropy aren't detected.
+	assert.Nil(t, d.Check(testClient, "www.tunnel.example", now))
+
+	// The subdomains expire with the window.
+	later := now.Add(2 * time.Minute)
+	assert.Nil(t, d.Check(testClient, "q5v8xk2mz7t.tunnel.example", later))
+}
+
+func TestDetector_Check_spike(t *testing.T) {
+	d := newTestDetector(t, false)
+
+	// Collect the baseline of one query per bucket for two windows.
+	now := testStart
+	for range 2 * windowBuckets {
+		assert.Nil(t, d.Check(testClient, "example.org", now))
+		now = now.Add(10 * time.Second)
+	}
+
+	var det *Detection
+	for range 40 {
+		det = d.Check(testClient, "example.org", now)
+	}
+
+	require.NotNil(t, det)
+
+	assert.Equal(t, KindSpike, det.Kind)
+	// The five previous buckets within the window have a query each.
+	assert.Equal(t, uint64(45), det.Count)
+	assert.Greater(t, det.Score, 5.0)
+}
+
+func TestDetector_Check_maxClients(t *testing.T) {
+	d := newTestDetector(t, false)
+
+	now := testStart
+	d.Check("192.0.2.1", "example.org", now)
+	d.Check("192.0.2.2", "example.org", now)
+	d.Check("192.0.2.3", "example.org", now)
+
+	assert.Len(t, d.clients, 2)
+	assert.NotContains(t, d.clients, "192.0.2.3")
+
+	// The idle clients are removed.
+	d.Check("192.0.2.3", "example.org", now.Add(idleWindows*time.Minute+time.Second))
+
+	assert.Len(t, d.clients, 1)
+	assert.Contains(t, d.clients, "192.0.2.3")
+}
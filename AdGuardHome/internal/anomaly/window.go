@@ -0,0 +1,112 @@
+package anomaly
+
+import (
+	"math"
+	"time"
+)
+
+// windowBuckets is the number of buckets a sliding window is divided into.
+const windowBuckets = 6
+
+// bucketIndex returns the absolute index of the bucket of the length ivl
+// containing now.
+func bucketIndex(now time.Time, ivl time.Duration) (idx int64) {
+	return now.UnixNano() / int64(ivl)
+}
+
+// slidingCounter counts the events within the last windowBuckets buckets.
+type slidingCounter struct {
+	// buckets are the numbers of events in each bucket, indexed by the
+	// absolute bucket index modulo windowBuckets.
+	buckets [windowBuckets]uint64
+
+	// idx is the absolute index of the current bucket.
+	idx int64
+}
+
+// advance moves the current bucket to idx, resetting the expired buckets.
+// closed is the number of events in the bucket which has been current before
+// the call, and skipped is the number of the following buckets without events.
+// Both are zero if the current bucket hasn't changed.
+func (c *slidingCounter) advance(idx int64) (closed uint64, skipped int64) {
+	if idx <= c.idx {
+		return 0, 0
+	}
+
+	closed = c.buckets[c.idx%windowBuckets]
+	skipped = idx - c.idx - 1
+
+	for i := c.idx + 1; i <= idx && i <= c.idx+windowBuckets; i++ {
+		c.buckets[i%windowBuckets] = 0
+	}
+
+	c.idx = idx
+
+	return closed, skipped
+}
+
+// add counts an event in the current bucket.
+func (c *slidingCounter) add() {
+	c.buckets[c.idx%windowBuckets]++
+}
+
+// sum returns the number of events within the window.
+func (c *slidingCounter) sum() (n uint64) {
+	for _, b := range c.buckets {
+		n += b
+	}
+
+	return n
+}
+
+// baselineAlpha is the smoothing factor of the exponential moving average of
+// the number of queries per bucket.
+const baselineAlpha = 0.1
+
+// baseline is the exponential moving average of the number of events per
+// bucket.
+type baseline struct {
+	// avg is the current average.
+	avg float64
+
+	// n is the number of the buckets taken into account.
+	n int64
+}
+
+// update takes the closed bucket with the specified number of events and the
+// skipped empty buckets into account.
+func (b *baseline) update(closed uint64, skipped int64) {
+	b.avg += baselineAlpha * (float64(closed) - b.avg)
+	b.avg *= math.Pow(1-baselineAlpha, float64(skipped))
+	b.n += 1 + skipped
+}
+
+// ready returns true if the baseline has been collected for at least two
+// windows.
+func (b *baseline) ready() (ok bool) {
+	return b.n >= 2*windowBuckets
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) (e float64) {
+	if s == "" {
+		return 0
+	}
+
+	var counts [256]int
+	for i := range len(s) {
+		counts[s[i]]++
+	}
+
+	n := float64(len(s))
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+
+		p := float64(c) / n
+		e -= p * math.Log2(p)
+	}
+
+	return e
+}
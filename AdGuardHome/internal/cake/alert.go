@@ -0,0 +1,437 @@
+package cake
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// Defaults of the alerts.
+const (
+	// defaultAlertTimeout is the default timeout of a webhook request.
+	defaultAlertTimeout = 10 * time.Second
+
+	// alertInterval is the interval between the evaluations of the alert
+	// rules.
+	alertInterval = 1 * time.Second
+)
+
+// HdrSignature is the header containing the HMAC-SHA256 signature of the body
+// of a webhook request in the "sha256=<hex>" format.
+const HdrSignature = "X-AdGuardHome-Signature-256"
+
+// AlertKind is the kind of the value an alert rule watches.
+type AlertKind string
+
+// Alert kinds.
+const (
+	// AlertKindRTT watches the shaped RTT, in milliseconds.
+	AlertKindRTT AlertKind = "rtt"
+
+	// AlertKindBandwidthFloor watches if any of the bandwidths is pinned at its
+	// floor.  The thresholds aren't used.
+	AlertKindBandwidthFloor AlertKind = "bandwidth_floor"
+
+	// AlertKindReconfigureErrors watches the number of consecutive failed
+	// reconfigurations of CAKE.
+	AlertKindReconfigureErrors AlertKind = "reconfigure_errors"
+)
+
+// AlertStatus is the status of an alert sent in the webhook.
+type AlertStatus string
+
+// Alert statuses.
+const (
+	AlertStatusFiring   AlertStatus = "firing"
+	AlertStatusResolved AlertStatus = "resolved"
+)
+
+// AlertRule is a condition that triggers an alert.
+type AlertRule struct {
+	// Name is the name of the rule sent in the webhook.  It must not be
+	// empty.
+	Name string `yaml:"name"`
+
+	// Kind is the kind of the watched value.
+	Kind AlertKind `yaml:"kind"`
+
+	// Threshold is the value at or above which the condition holds.
+	Threshold float64 `yaml:"threshold"`
+
+	// ClearThreshold is the value below which the condition of a firing alert
+	// stops holding.  It must not be greater than Threshold.  If zero,
+	// Threshold is used.
+	ClearThreshold float64 `yaml:"clear_threshold"`
+
+	// For is how long the condition must hold, or not hold, before the alert
+	// fires, or resolves.
+	For timeutil.Duration `yaml:"for"`
+}
+
+// validate returns an error if r is not valid.
+func (r *AlertRule) validate() (err error) {
+	if r == nil {
+		return errors.Error("no rule")
+	} else if r.Name == "" {
+		return errors.Error("no name")
+	}
+
+	switch r.Kind {
+	case AlertKindBandwidthFloor:
+		// Go on.
+	case AlertKindRTT, AlertKindReconfigureErrors:
+		if r.Threshold <= 0 {
+			return fmt.Errorf("threshold: must be positive, got %v", r.Threshold)
+		} else if r.ClearThreshold < 0 || r.ClearThreshold > r.Threshold {
+			return fmt.Errorf(
+				"clear_threshold: must be from 0 to threshold %v, got %v",
+				r.Threshold,
+				r.ClearThreshold,
+			)
+		}
+	default:
+		return fmt.Errorf("bad kind %q", r.Kind)
+	}
+
+	if r.For.Duration < 0 {
+		return fmt.Errorf("for: must not be negative, got %s", r.For)
+	}
+
+	return nil
+}
+
+// thresholds returns the value at which the alert fires and the value below
+// which it resolves.
+func (r *AlertRule) thresholds() (on, off float64) {
+	if r.Kind == AlertKindBandwidthFloor {
+		return 1, 1
+	}
+
+	off = r.ClearThreshold
+	if off == 0 {
+		off = r.Threshold
+	}
+
+	return r.Threshold, off
+}
+
+// AlertConfig is the configuration of the alerts sent to a webhook.
+type AlertConfig struct {
+	// URL is the URL of the webhook the alerts are sent to using POST.
+	URL string `yaml:"url"`
+
+	// Secret is the key used to sign the body of the webhook requests.  If
+	// empty, the requests aren't signed.
+	Secret string `yaml:"secret"`
+
+	// Rules are the conditions that trigger the alerts.
+	Rules []*AlertRule `yaml:"rules"`
+
+	// Timeout is the timeout of a webhook request.  If zero,
+	// defaultAlertTimeout is used.
+	Timeout timeutil.Duration `yaml:"timeout"`
+
+	// Cooldown is the minimum time between two firing alerts of the same rule.
+	// The alerts firing again sooner aren't sent, and neither are their
+	// resolutions.
+	Cooldown timeutil.Duration `yaml:"cooldown"`
+
+	// Enabled defines if the alerts are enabled.
+	Enabled bool `yaml:"enabled"`
+}
+
+// validate returns an error if c is not valid.  c may be nil.
+func (c *AlertConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("url: %w", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url: bad scheme %q", u.Scheme)
+	}
+
+	switch {
+	case c.Timeout.Duration < 0:
+		return fmt.Errorf("timeout: must not be negative, got %s", c.Timeout)
+	case c.Cooldown.Duration < 0:
+		return fmt.Errorf("cooldown: must not be negative, got %s", c.Cooldown)
+	case len(c.Rules) == 0:
+		return errors.Error("no rules")
+	}
+
+	for i, r := range c.Rules {
+		err = r.validate()
+		if err != nil {
+			return fmt.Errorf("rules: at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Alert is the JSON body of a webhook request.
+type Alert struct {
+	// Time is the time the status of the alert has changed.
+	Time time.Time `json:"time"`
+
+	// Rule is the name of the rule.
+	Rule string `json:"rule"`
+
+	// Kind is the kind of the watched value.
+	Kind AlertKind `json:"kind"`
+
+	// Status is the new status of the alert.
+	Status AlertStatus `json:"status"`
+
+	// Params are the parameters of CAKE at the time of the change.
+	Params *ParamsStatus `json:"params"`
+
+	// Value is the watched value at the time of the change.
+	Value float64 `json:"value"`
+
+	// Threshold is the value at which the alert fires.
+	Threshold float64 `json:"threshold"`
+}
+
+// alertValues are the values watched by the alert rules.
+type alertValues struct {
+	// params are the current parameters of CAKE.
+	params *ParamsStatus
+
+	// rtt is the shaped RTT.
+	rtt time.Duration
+
+	// failures is the number of consecutive failed reconfigurations.
+	failures int
+
+	// atFloor is true if any of the bandwidths is pinned at its floor.
+	atFloor bool
+}
+
+// value returns the value watched by r.
+func (v *alertValues) value(r *AlertRule) (val float64) {
+	switch r.Kind {
+	case AlertKindRTT:
+		return float64(v.rtt) / float64(time.Millisecond)
+	case AlertKindReconfigureErrors:
+		return float64(v.failures)
+	case AlertKindBandwidthFloor:
+		if v.atFloor {
+			return 1
+		}
+
+		return 0
+	default:
+		panic(fmt.Errorf("bad alert kind %q", r.Kind))
+	}
+}
+
+// alertState is the state of a single alert rule.
+type alertState struct {
+	// pendingSince is the time the condition has started to differ from the
+	// status.  It is zero if it doesn't.
+	pendingSince time.Time
+
+	// lastSent is the time the latest firing alert has been sent.
+	lastSent time.Time
+
+	// firing is true if the alert is firing.
+	firing bool
+
+	// sent is true if the firing alert has been sent, so its resolution must
+	// be sent as well.
+	sent bool
+}
+
+// step updates the state with the value at now and returns true if the alert
+// has started firing or has resolved.
+func (s *alertState) step(r *AlertRule, val float64, now time.Time) (changed bool) {
+	on, off := r.thresholds()
+
+	holds := val >= on
+	if s.firing {
+		holds = val >= off
+	}
+
+	if holds == s.firing {
+		s.pendingSince = time.Time{}
+
+		return false
+	}
+
+	if s.pendingSince.IsZero() {
+		s.pendingSince = now
+	}
+
+	if now.Sub(s.pendingSince) < r.For.Duration {
+		return false
+	}
+
+	s.firing = holds
+	s.pendingSince = time.Time{}
+
+	return true
+}
+
+// alerter evaluates the alert rules and sends the alerts to the webhook.
+type alerter struct {
+	conf   *AlertConfig
+	client *http.Client
+	states []alertState
+}
+
+// newAlerter returns a new alerter for conf, which must be valid and enabled.
+func newAlerter(conf *AlertConfig) (a *alerter) {
+	timeout := conf.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultAlertTimeout
+	}
+
+	return &alerter{
+		conf: conf,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		states: make([]alertState, len(conf.Rules)),
+	}
+}
+
+// evaluate updates the states of the rules with v at now and returns the
+// alerts to send.
+func (a *alerter) evaluate(v *alertValues, now time.Time) (alerts []*Alert) {
+	for i, r := range a.conf.Rules {
+		st := &a.states[i]
+		val := v.value(r)
+		if !st.step(r, val, now) {
+			continue
+		}
+
+		status := AlertStatusResolved
+		if st.firing {
+			if !st.lastSent.IsZero() && now.Sub(st.lastSent) < a.conf.Cooldown.Duration {
+				log.Debug("cake: alert %q: firing again within cooldown", r.Name)
+				st.sent = false
+
+				continue
+			}
+
+			status = AlertStatusFiring
+			st.sent = true
+			st.lastSent = now
+		} else if st.sent {
+			st.sent = false
+		} else {
+			// The firing alert has been suppressed by the cooldown.
+			continue
+		}
+
+		on, _ := r.thresholds()
+		alerts = append(alerts, &Alert{
+			Time:      now,
+			Rule:      r.Name,
+			Kind:      r.Kind,
+			Status:    status,
+			Params:    v.params,
+			Value:     val,
+			Threshold: on,
+		})
+	}
+
+	return alerts
+}
+
+// send sends alert to the webhook.
+func (a *alerter) send(ctx context.Context, alert *Alert) (err error) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("encoding alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(httphdr.ContentType, aghhttp.HdrValApplicationJSON)
+	if a.conf.Secret != "" {
+		req.Header.Set(HdrSignature, "sha256="+sign([]byte(a.conf.Secret), body))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("requesting: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// sign returns the hex-encoded HMAC-SHA256 of body with key.
+func sign(key, body []byte) (sig string) {
+	mac := hmac.New(sha256.New, key)
+
+	// Don't check the error, since hash.Hash never returns one.
+	_, _ = mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// alertValues returns the current values watched by the alert rules.
+func (c *Controller) alertValues() (v *alertValues) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &alertValues{
+		params:   c.statusLocked().Params,
+		rtt:      c.rtt,
+		failures: c.failures,
+		atFloor: (c.lim.minUL > 0 && c.bwUL <= c.lim.minUL) ||
+			(c.lim.minDL > 0 && c.bwDL <= c.lim.minDL),
+	}
+}
+
+// alertLoop evaluates the alert rules every alertInterval and sends the alerts
+// until ctx is canceled.  It is intended to be used as a goroutine.
+func (c *Controller) alertLoop(ctx context.Context) {
+	defer log.OnPanic("cake: alert loop")
+	defer c.wg.Done()
+
+	t := time.NewTicker(alertInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			for _, alert := range c.alerter.evaluate(c.alertValues(), now) {
+				log.Info("cake: alert %q is %s", alert.Rule, alert.Status)
+
+				err := c.alerter.send(ctx, alert)
+				if err != nil {
+					log.Error("cake: sending alert %q: %s", alert.Rule, err)
+				}
+			}
+		}
+	}
+}
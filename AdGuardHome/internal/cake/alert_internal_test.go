@@ -0,0 +1,260 @@
+package cake
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSecret is the webhook secret used in tests.
+const testSecret = "secret"
+
+func TestAlertRule_validate(t *testing.T) {
+	testCases := []struct {
+		rule       *AlertRule
+		name       string
+		wantErrMsg string
+	}{{
+		rule:       &AlertRule{Name: "rtt", Kind: AlertKindRTT, Threshold: 100, ClearThreshold: 80},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		rule:       &AlertRule{Name: "floor", Kind: AlertKindBandwidthFloor},
+		name:       "valid_floor",
+		wantErrMsg: "",
+	}, {
+		rule:       &AlertRule{Kind: AlertKindRTT, Threshold: 100},
+		name:       "no_name",
+		wantErrMsg: "no name",
+	}, {
+		rule:       &AlertRule{Name: "loss", Kind: "loss"},
+		name:       "bad_kind",
+		wantErrMsg: `bad kind "loss"`,
+	}, {
+		rule:       &AlertRule{Name: "errors", Kind: AlertKindReconfigureErrors},
+		name:       "no_threshold",
+		wantErrMsg: "threshold: must be positive, got 0",
+	}, {
+		rule:       &AlertRule{Name: "rtt", Kind: AlertKindRTT, Threshold: 100, ClearThreshold: 120},
+		name:       "bad_clear_threshold",
+		wantErrMsg: "clear_threshold: must be from 0 to threshold 100, got 120",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.rule.validate())
+		})
+	}
+}
+
+func TestAlerter_evaluate(t *testing.T) {
+	a := newAlerter(&AlertConfig{
+		URL: "http://192.0.2.1",
+		Rules: []*AlertRule{{
+			Name:           "high_rtt",
+			Kind:           AlertKindRTT,
+			Threshold:      100,
+			ClearThreshold: 80,
+			For:            timeutil.Duration{Duration: time.Minute},
+		}},
+		Cooldown: timeutil.Duration{Duration: time.Hour},
+		Enabled:  true,
+	})
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	rtt := func(ms int) (v *alertValues) {
+		return &alertValues{rtt: time.Duration(ms) * time.Millisecond}
+	}
+
+	testCases := []struct {
+		values     *alertValues
+		name       string
+		wantStatus AlertStatus
+		after      time.Duration
+	}{{
+		values:     rtt(150),
+		name:       "pending",
+		wantStatus: "",
+		after:      0,
+	}, {
+		values:     rtt(150),
+		name:       "still_pending",
+		wantStatus: "",
+		after:      30 * time.Second,
+	}, {
+		values:     rtt(150),
+		name:       "firing",
+		wantStatus: AlertStatusFiring,
+		after:      time.Minute,
+	}, {
+		values:     rtt(90),
+		name:       "hysteresis",
+		wantStatus: "",
+		after:      5 * time.Minute,
+	}, {
+		values:     rtt(50),
+		name:       "resolving",
+		wantStatus: "",
+		after:      6 * time.Minute,
+	}, {
+		values:     rtt(50),
+		name:       "resolved",
+		wantStatus: AlertStatusResolved,
+		after:      7 * time.Minute,
+	}, {
+		values:     rtt(150),
+		name:       "pending_again",
+		wantStatus: "",
+		after:      8 * time.Minute,
+	}, {
+		values:     rtt(150),
+		name:       "cooldown",
+		wantStatus: "",
+		after:      9 * time.Minute,
+	}, {
+		values:     rtt(50),
+		name:       "resolving_suppressed",
+		wantStatus: "",
+		after:      10 * time.Minute,
+	}, {
+		values:     rtt(50),
+		name:       "resolved_suppressed",
+		wantStatus: "",
+		after:      11 * time.Minute,
+	}}
+
+	for _, tc := range testCases {
+		alerts := a.evaluate(tc.values, start.Add(tc.after))
+		if tc.wantStatus == "" {
+			assert.Empty(t, alerts, tc.name)
+
+			continue
+		}
+
+		require.Len(t, alerts, 1, tc.name)
+
+		assert.Equal(t, tc.wantStatus, alerts[0].Status, tc.name)
+		assert.Equal(t, "high_rtt", alerts[0].Rule, tc.name)
+		assert.Equal(t, float64(100), alerts[0].Threshold, tc.name)
+	}
+}
+
+func TestAlerter_send(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(testutil.PanicT{}, err)
+
+		mac := hmac.New(sha256.New, []byte(testSecret))
+		_, _ = mac.Write(body)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+		if !hmac.Equal([]byte(want), []byte(r.Header.Get(HdrSignature))) {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		assert.Equal(testutil.PanicT{}, "application/json", r.Header.Get(httphdr.ContentType))
+
+		bodies <- body
+	}))
+	t.Cleanup(srv.Close)
+
+	alert := &Alert{
+		Time:      time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Rule:      "floor",
+		Kind:      AlertKindBandwidthFloor,
+		Status:    AlertStatusFiring,
+		Params:    &ParamsStatus{UplinkInterface: "eth0"},
+		Value:     1,
+		Threshold: 1,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	t.Run("signed", func(t *testing.T) {
+		a := newAlerter(&AlertConfig{URL: srv.URL, Secret: testSecret, Enabled: true})
+		require.NoError(t, a.send(ctx, alert))
+
+		got := &Alert{}
+		require.NoError(t, json.Unmarshal(<-bodies, got))
+
+		assert.Equal(t, alert, got)
+	})
+
+	t.Run("bad_secret", func(t *testing.T) {
+		a := newAlerter(&AlertConfig{URL: srv.URL, Secret: "other", Enabled: true})
+
+		err := a.send(ctx, alert)
+		require.Error(t, err)
+
+		assert.True(t, strings.HasSuffix(err.Error(), "unexpected status code 401"))
+	})
+}
+
+func TestController_alertValues(t *testing.T) {
+	c, rec := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+	})
+
+	rec.err = assert.AnError
+
+	ctx := context.Background()
+	c.iterate(ctx)
+	c.iterate(ctx)
+
+	v := c.alertValues()
+	assert.Equal(t, 2, v.failures)
+	assert.False(t, v.atFloor)
+
+	rec.err = nil
+	c.iterate(ctx)
+
+	v = c.alertValues()
+	assert.Zero(t, v.failures)
+}
+
+func TestController_alertValues_atFloor(t *testing.T) {
+	const minBW = testMaxBW * 0.6
+
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+		MinUL:           minBW,
+	})
+
+	ctx := context.Background()
+	c.iterate(ctx)
+
+	v := c.alertValues()
+	assert.False(t, v.atFloor)
+
+	// Halving the bandwidth would go below the floor, so it's pinned there.
+	c.ObserveLatency("1.1.1.1:53", 200*time.Millisecond, false)
+	c.iterate(ctx)
+
+	assert.Equal(t, minBW, c.bwUL)
+	assert.Equal(t, testMaxBW*0.9/2+Mbit, c.bwDL)
+
+	v = c.alertValues()
+	assert.True(t, v.atFloor)
+}
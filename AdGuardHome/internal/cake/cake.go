@@ -0,0 +1,662 @@
+// Package cake contains the controller that adjusts the parameters of the CAKE
+// queueing discipline in real time based on the latency of DNS requests.
+package cake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/nftset"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// Round-trip times from the tc-cake(8) manual used to bound the observed
+// latency.
+const (
+	metroRTT          time.Duration = 10 * time.Millisecond
+	internetRTT       time.Duration = 100 * time.Millisecond
+	interplanetaryRTT time.Duration = 3600 * time.Second
+)
+
+// Bandwidth units.  All bandwidth values are in kbit/s.
+const (
+	// Mbit is one megabit per second.
+	Mbit float64 = 1_000
+
+	// Gbit is one gigabit per second.
+	Gbit float64 = 1_000_000
+)
+
+// DefaultInterval is the default pause between the iterations of the control
+// loop.
+const DefaultInterval = 10 * time.Millisecond
+
+// dataLimit is the maximum number of samples accumulated for the averages
+// before they are reset.
+const dataLimit = 100_000
+
+// Config is the configuration of the CAKE controller.
+type Config struct {
+	// HTTPRegister is used to register the HTTP API of the controller.  It may
+	// be nil.
+	HTTPRegister aghhttp.RegisterFunc `yaml:"-"`
+
+	// UplinkInterface is the name of the network interface connected to the
+	// ISP.  It is shaped on egress.
+	UplinkInterface string `yaml:"uplink_interface"`
+
+	// DownlinkInterface is the name of the IFB interface the ingress traffic of
+	// UplinkInterface is redirected to.  If empty, "ifb4" followed by
+	// UplinkInterface is used.
+	DownlinkInterface string `yaml:"downlink_interface"`
+
+	// MiscInterfaces are the names of the other interfaces shaped with the
+	// uplink parameters, for example tunnels.
+	MiscInterfaces []string `yaml:"misc_interfaces"`
+
+	// Sidecars are the processes started and supervised along with the
+	// controller.
+	Sidecars []*SidecarConfig `yaml:"sidecars"`
+
+	// Hooks are the commands run on the lifecycle events of the controller.
+	Hooks []*HookConfig `yaml:"hooks"`
+
+	// DSCP are the rules that put the connections to the resolved addresses
+	// into the tins of CAKE.  The first matching rule is used.
+	DSCP []*DSCPRule `yaml:"dscp"`
+
+	// Probe is the configuration of the link-capacity probe.  It may be nil.
+	Probe *ProbeConfig `yaml:"probe"`
+
+	// Alerts is the configuration of the alerts.  It may be nil.
+	Alerts *AlertConfig `yaml:"alerts"`
+
+	// Sources is the configuration of the health scoring of the sources of
+	// the latency samples.  It may be nil.
+	Sources *SourceConfig `yaml:"sources"`
+
+	// Windows are the time windows with their own bandwidth limits.  The first
+	// active window is used.  Outside of them, MaxUL and MaxDL are used.
+	Windows []*ShapingWindow `yaml:"windows"`
+
+	// CongestionThreshold is the share of the packets dropped or ECN-marked by
+	// CAKE, smoothed over a few seconds, at which the bandwidth of the
+	// direction is reduced.  If zero, defaultCongestionThreshold is used.
+	CongestionThreshold float64 `yaml:"congestion_threshold"`
+
+	// WindowTransition is the duration of the gradual switch between the
+	// limits of the windows.  If zero, defaultWindowTransition is used.
+	WindowTransition timeutil.Duration `yaml:"window_transition"`
+
+	// Interval is the pause between the iterations of the control loop.  If
+	// zero, DefaultInterval is used.
+	Interval timeutil.Duration `yaml:"interval"`
+
+	// MaxUL is the maximum uplink bandwidth advertised by the ISP, in kbit/s.
+	MaxUL float64 `yaml:"max_ul"`
+
+	// MaxDL is the maximum downlink bandwidth advertised by the ISP, in
+	// kbit/s.
+	MaxDL float64 `yaml:"max_dl"`
+
+	// MinUL is the uplink bandwidth the controller never goes below, in
+	// kbit/s.  The windows may override it.  If zero, there is no floor.
+	MinUL float64 `yaml:"min_ul"`
+
+	// MinDL is the downlink bandwidth the controller never goes below, in
+	// kbit/s.  The windows may override it.  If zero, there is no floor.
+	MinDL float64 `yaml:"min_dl"`
+
+	// Enabled defines if the controller is enabled.
+	Enabled bool `yaml:"enabled"`
+
+	// SpeedtestServer defines if the speedtest endpoints used by the probes
+	// of the peer instances are served.  It doesn't depend on Enabled.
+	SpeedtestServer bool `yaml:"speedtest_server"`
+}
+
+// validate returns an error if c is not valid.
+func (c *Config) validate() (err error) {
+	switch {
+	case c.UplinkInterface == "":
+		return errors.Error("no uplink_interface")
+	case c.MaxUL <= 0:
+		return fmt.Errorf("max_ul: must be positive, got %v", c.MaxUL)
+	case c.MaxDL <= 0:
+		return fmt.Errorf("max_dl: must be positive, got %v", c.MaxDL)
+	case c.MinUL < 0 || c.MinUL > c.MaxUL:
+		return fmt.Errorf("min_ul: must be from 0 to max_ul %v, got %v", c.MaxUL, c.MinUL)
+	case c.MinDL < 0 || c.MinDL > c.MaxDL:
+		return fmt.Errorf("min_dl: must be from 0 to max_dl %v, got %v", c.MaxDL, c.MinDL)
+	case c.Interval.Duration < 0:
+		return fmt.Errorf("interval: must not be negative, got %s", c.Interval)
+	case c.WindowTransition.Duration < 0:
+		return fmt.Errorf("window_transition: must not be negative, got %s", c.WindowTransition)
+	}
+
+	for i, s := range c.Sidecars {
+		err = s.validate()
+		if err != nil {
+			return fmt.Errorf("sidecars: at index %d: %w", i, err)
+		}
+	}
+
+	for i, h := range c.Hooks {
+		err = h.validate()
+		if err != nil {
+			return fmt.Errorf("hooks: at index %d: %w", i, err)
+		}
+	}
+
+	for i, r := range c.DSCP {
+		err = r.validate()
+		if err != nil {
+			return fmt.Errorf("dscp: at index %d: %w", i, err)
+		}
+	}
+
+	err = validateCongestionThreshold(c.CongestionThreshold)
+	if err != nil {
+		return err
+	}
+
+	for i, w := range c.Windows {
+		err = w.validate()
+		if err != nil {
+			return fmt.Errorf("windows: at index %d: %w", i, err)
+		}
+	}
+
+	err = c.Sources.validate()
+	if err != nil {
+		return fmt.Errorf("sources: %w", err)
+	}
+
+	err = c.Probe.validate()
+	if err != nil {
+		return fmt.Errorf("probe: %w", err)
+	}
+
+	err = c.Alerts.validate()
+	if err != nil {
+		return fmt.Errorf("alerts: %w", err)
+	}
+
+	return nil
+}
+
+// cmdRunner runs the command with the given arguments and returns its combined
+// output.
+type cmdRunner func(ctx context.Context, name string, args ...string) (out []byte, err error)
+
+// Controller adjusts the rtt and bandwidth parameters of CAKE on the shaped
+// interfaces using the latency observed by the DNS server.
+type Controller struct {
+	conf *Config
+
+	// runCmd runs the tc, ip, and nft commands.
+	runCmd cmdRunner
+
+	// nft adds the addresses to the sets of the DSCP classes.  It is nil if
+	// DSCP marking is disabled.
+	nft nftset.Adder
+
+	// prober measures the capacity of the link.  It is nil if the probe is
+	// disabled.
+	prober *prober
+
+	// alerter sends the alerts.  It is nil if the alerts are disabled.
+	alerter *alerter
+
+	// reflect queries the reflectors.
+	reflect reflectFunc
+
+	// cancel stops the control loop and the sidecars.
+	cancel context.CancelFunc
+
+	// wg is used to wait for the control loop and the sidecars to exit.
+	wg *sync.WaitGroup
+
+	// done is closed when the controller is closed.
+	done chan struct{}
+
+	// hookRunning contains a flag for each hook in conf.Hooks that is set
+	// while the hook is being run.
+	hookRunning []atomic.Bool
+
+	// mu protects the fields below.
+	mu *sync.Mutex
+
+	// metrics are the latest metrics of the controller.
+	metrics *Metrics
+
+	// stats contains the accumulated samples.
+	stats *sampleStats
+
+	// sources scores the sources of the latency samples.
+	sources *sourceTracker
+
+	// tins are the latest statistics of the tins.  It is nil until the first
+	// collection.
+	tins *Tins
+
+	// journal contains the latest notable reconfigurations.
+	journal []*JournalEntry
+
+	// history contains the latest samples of the latency and bandwidth.
+	history []*HistoryPoint
+
+	// subs are the channels of the status subscribers.
+	subs map[chan *Status]struct{}
+
+	// lastSample is the time of the latest status collection.
+	lastSample time.Time
+
+	// lastErr is the message of the error of the previous iteration, if any.
+	lastErr string
+
+	// failures is the number of consecutive failed reconfigurations.
+	failures int
+
+	// applied are the parameters of the latest successful reconfiguration.
+	// It's nil until the first one.  It's only accessed by the control loop.
+	applied *params
+
+	// probeResult is the result of the latest probe.  It is nil until the
+	// first successful probe.
+	probeResult *ProbeResult
+
+	// downlink is the name of the downlink IFB interface.
+	downlink string
+
+	// splitGSO is the current split-gso setting, either "split-gso" or
+	// "no-split-gso".
+	splitGSO string
+
+	// bwUL and bwDL are the current bandwidths, in kbit/s.
+	bwUL float64
+	bwDL float64
+
+	// maxUL and maxDL are the maximum bandwidths, in kbit/s.  They are
+	// initially taken from the configuration and may be replaced by the
+	// probe.
+	maxUL float64
+	maxDL float64
+
+	// window is the active shaping window.  It is nil outside of the windows.
+	window *ShapingWindow
+
+	// limFrom are the limits the transition started from.
+	limFrom limits
+
+	// limTo are the target limits of the transition.
+	limTo limits
+
+	// lim are the limits applied by the latest iteration.
+	lim limits
+
+	// switchedAt is the time the latest transition started.
+	switchedAt time.Time
+
+	// rtt is the latest observed latency.
+	rtt time.Duration
+
+	// lastRTT is the latency observed before rtt.
+	lastRTT time.Duration
+
+	// bloated is set when a latency increase has been observed since the last
+	// iteration of the control loop.
+	bloated bool
+
+	// congUL and congDL are the congestion signals from the statistics of
+	// CAKE on the uplink and the downlink.
+	congUL congestion
+	congDL congestion
+
+	// congReadAt is the time of the latest reading of the statistics for the
+	// congestion signals.  It's only accessed by the control loop.
+	congReadAt time.Time
+
+	// throttled is set when the bandwidth has been reduced and not yet
+	// restored to its ceiling.
+	throttled bool
+
+	// probing is set while the probe is measuring the link capacity.
+	probing bool
+}
+
+// New creates a new CAKE controller.  conf must not be nil and must not be
+// modified after calling New.
+func New(conf *Config) (c *Controller, err error) {
+	return newController(conf, nftset.New)
+}
+
+// newController creates a new CAKE controller that uses newAdder to access the
+// nftables sets.
+func newController(conf *Config, newAdder func() (nftset.Adder, error)) (c *Controller, err error) {
+	err = conf.validate()
+	if err != nil {
+		return nil, fmt.Errorf("cake: validating config: %w", err)
+	}
+
+	nft, err := newDSCPAdder(conf, newAdder)
+	if err != nil {
+		return nil, fmt.Errorf("cake: %w", err)
+	}
+
+	downlink := conf.DownlinkInterface
+	if downlink == "" {
+		downlink = "ifb4" + conf.UplinkInterface
+	}
+
+	c = &Controller{
+		conf:        conf,
+		runCmd:      runCommand,
+		nft:         nft,
+		wg:          &sync.WaitGroup{},
+		done:        make(chan struct{}),
+		hookRunning: make([]atomic.Bool, len(conf.Hooks)),
+		mu:          &sync.Mutex{},
+		metrics:     &Metrics{},
+		stats:       &sampleStats{},
+		sources:     newSourceTracker(conf.Sources),
+		subs:        map[chan *Status]struct{}{},
+		downlink:    downlink,
+		splitGSO:    splitGSO,
+		bwUL:        conf.MaxUL,
+		bwDL:        conf.MaxDL,
+		maxUL:       conf.MaxUL,
+		maxDL:       conf.MaxDL,
+		rtt:         internetRTT,
+		lastRTT:     internetRTT,
+	}
+
+	// Start within the current window without a transition.
+	c.window = c.activeWindow(time.Now())
+	c.limTo = c.windowLimits(c.window)
+	c.limFrom, c.lim = c.limTo, c.limTo
+
+	if conf.Probe != nil && conf.Probe.Enabled {
+		c.prober = newProber(conf.Probe)
+	}
+
+	if conf.Alerts != nil && conf.Alerts.Enabled {
+		c.alerter = newAlerter(conf.Alerts)
+	}
+
+	if conf.Sources != nil {
+		timeout := conf.Sources.ReflectorTimeout.Duration
+		if timeout == 0 {
+			timeout = defaultReflectorTimeout
+		}
+
+		c.reflect = newDNSReflect(timeout)
+	}
+
+	return c, nil
+}
+
+// Start initializes the shaped interfaces and starts the control loop and the
+// sidecars.
+func (c *Controller) Start() {
+	if c.conf.HTTPRegister != nil {
+		c.initWeb()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	// Let the hooks prepare the system before anything is shaped.
+	c.runHooks(ctx, HookEventPreShape)
+
+	c.initInterfaces(ctx)
+	c.initDSCP(ctx)
+
+	c.startSidecars(ctx)
+
+	c.wg.Add(2)
+	go c.loop(ctx)
+	go c.sample(ctx)
+
+	if c.prober != nil {
+		c.wg.Add(1)
+		go c.probeLoop(ctx)
+	}
+
+	if c.alerter != nil {
+		c.wg.Add(1)
+		go c.alertLoop(ctx)
+	}
+
+	if c.conf.Sources != nil && len(c.conf.Sources.Reflectors) > 0 {
+		c.wg.Add(1)
+		go c.reflectLoop(ctx)
+	}
+}
+
+// Close stops the control loop and the sidecars.  It doesn't remove the qdiscs
+// from the interfaces.
+func (c *Controller) Close() (err error) {
+	close(c.done)
+
+	if c.cancel != nil {
+		c.cancel()
+		c.wg.Wait()
+	}
+
+	if c.nft != nil {
+		return c.nft.Close()
+	}
+
+	return nil
+}
+
+// ObserveLatency implements the [dnsforward.LatencyObserver] interface for
+// *Controller.  Only the latency of the uncached requests is used, since the
+// cached ones never leave the host.  The latency from the excluded upstreams is
+// ignored.
+func (c *Controller) ObserveLatency(upstream string, elapsed time.Duration, cached bool) {
+	if cached {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if upstream != "" && !c.observeSource(upstream, SourceKindUpstream, elapsed, time.Now()) {
+		return
+	}
+
+	c.observeRTT(elapsed)
+}
+
+// observeRTT updates the latency with a sample from a reliable source.  The
+// samples below metroRTT are ignored.  c.mu is expected to be locked.
+func (c *Controller) observeRTT(elapsed time.Duration) {
+	if elapsed < metroRTT {
+		return
+	}
+
+	c.rtt = elapsed
+	if elapsed > c.lastRTT {
+		c.bloated = true
+	}
+
+	c.lastRTT = elapsed
+}
+
+// loop runs the control loop until ctx is canceled.  It is intended to be used
+// as a goroutine.
+func (c *Controller) loop(ctx context.Context) {
+	defer log.OnPanic("cake: control loop")
+	defer c.wg.Done()
+
+	ivl := c.conf.Interval.Duration
+	if ivl == 0 {
+		ivl = DefaultInterval
+	}
+
+	t := time.NewTicker(ivl)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c.iterate(ctx)
+		}
+	}
+}
+
+// iterate performs a single iteration of the control loop.
+func (c *Controller) iterate(ctx context.Context) {
+	start := time.Now()
+
+	p := c.adjust(start)
+
+	err := c.reconfigure(ctx, p)
+	if err != nil {
+		log.Error("cake: reconfiguring: %s", err)
+	} else if !p.sameArgs(c.applied) {
+		// Let the hooks know about the new parameters.
+		c.applied = p
+		c.runHooks(ctx, HookEventPostReconfigure)
+	}
+
+	// Read the drops and marks caused by the parameters for the next
+	// iteration.
+	c.observeCongestion(ctx, time.Now())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		c.failures++
+	} else {
+		c.failures = 0
+	}
+
+	c.journalError(err)
+	c.stats.addExecTime(time.Since(start))
+
+	m := c.stats.metrics()
+	m.DropRateUp, m.DropRateDown = c.congUL.rates.drops, c.congDL.rates.drops
+	m.ECNMarkRateUp, m.ECNMarkRateDown = c.congUL.rates.marks, c.congDL.rates.marks
+	c.metrics = m
+}
+
+// adjust calculates the new parameters of CAKE at now from the observed
+// latency and returns them.
+func (c *Controller) adjust(now time.Time) (p *params) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rtt := max(c.rtt, metroRTT)
+	rtt = min(rtt, interplanetaryRTT)
+
+	if c.probing {
+		// Let the probe saturate the link while CAKE still manages the
+		// queues.  The latency increase and the drops are expected here.
+		c.bloated = false
+		c.congUL.congested, c.congDL.congested = false, false
+
+		return &params{
+			rtt:      rtt,
+			bwUL:     probeBandwidth,
+			bwDL:     probeBandwidth,
+			splitGSO: c.splitGSO,
+			diffserv: c.nft != nil,
+		}
+	}
+
+	lim := c.updateLimits(now)
+
+	// When a bufferbloat is detected, slow things down.  The latency increase
+	// affects both directions, while the drops and marks only affect their
+	// own.
+	cutUL := c.bloated || c.congUL.congested
+	cutDL := c.bloated || c.congDL.congested
+	if cutUL {
+		c.bwUL *= 1 - lim.aggressiveness
+	}
+
+	if cutDL {
+		c.bwDL *= 1 - lim.aggressiveness
+	}
+
+	if cutUL || cutDL {
+		c.throttled = true
+		msg := congestionSignals(c.bloated, c.congUL.congested, c.congDL.congested)
+		c.addJournal(JournalEventBufferbloat, msg)
+	}
+
+	c.bloated = false
+	c.congUL.congested, c.congDL.congested = false, false
+
+	// Keep restoring the bandwidth up to 90% of the maximum but never go below
+	// the minimum.
+	ceilUL, ceilDL := lim.maxUL*0.9, lim.maxDL*0.9
+	c.bwUL = max(min(c.bwUL+Mbit, ceilUL), min(lim.minUL, ceilUL))
+	c.bwDL = max(min(c.bwDL+Mbit, ceilDL), min(lim.minDL, ceilDL))
+	if c.throttled && c.bwUL == ceilUL && c.bwDL == ceilDL {
+		c.throttled = false
+		c.addJournal(JournalEventRecovered, "")
+	}
+
+	// For faster recovery in a server-like environment, it's better to only
+	// use split-gso when the bandwidth is less than 1 Gbit/s.
+	gso := noSplitGSO
+	if c.bwUL < Gbit || c.bwDL < Gbit {
+		gso = splitGSO
+	}
+
+	if gso != c.splitGSO {
+		c.splitGSO = gso
+		c.addJournal(JournalEventSplitGSO, "")
+	}
+
+	c.stats.add(rtt, c.bwUL, c.bwDL)
+
+	return &params{
+		rtt:      rtt,
+		bwUL:     c.bwUL,
+		bwDL:     c.bwDL,
+		splitGSO: c.splitGSO,
+		diffserv: c.nft != nil,
+	}
+}
+
+// currentMetrics returns the latest metrics of the controller.  m must not be
+// modified.
+func (c *Controller) currentMetrics() (m *Metrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.metrics
+}
+
+// state returns the current state of the controller for the hooks.
+func (c *Controller) state(ev HookEvent) (s *State) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &State{
+		Metrics:           c.metrics,
+		Event:             ev,
+		Time:              time.Now(),
+		UplinkInterface:   c.conf.UplinkInterface,
+		DownlinkInterface: c.downlink,
+		SplitGSO:          c.splitGSO,
+		MiscInterfaces:    c.conf.MiscInterfaces,
+		RTT:               c.rtt.Microseconds(),
+		BandwidthUpload:   c.bwUL,
+		BandwidthDownload: c.bwDL,
+	}
+}
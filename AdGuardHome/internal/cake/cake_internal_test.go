@@ -0,0 +1,423 @@
+package cake
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/nftset"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	filtering.InitModule()
+	testutil.DiscardLogOutput(m)
+}
+
+// testMaxBW is the maximum bandwidth used in tests, in kbit/s.
+const testMaxBW float64 = 100 * Mbit
+
+// cmdRecorder is a [cmdRunner] that records the commands instead of running
+// them.
+type cmdRecorder struct {
+	mu   *sync.Mutex
+	cmds []string
+
+	// err is returned from run, if not nil.
+	err error
+}
+
+// run implements the [cmdRunner] for *cmdRecorder.
+func (r *cmdRecorder) run(_ context.Context, name string, args ...string) (out []byte, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cmds = append(r.cmds, name+" "+strings.Join(args, " "))
+
+	return nil, r.err
+}
+
+// newTestController returns a controller that records the commands into the
+// returned recorder.
+func newTestController(t *testing.T, conf *Config) (c *Controller, rec *cmdRecorder) {
+	t.Helper()
+
+	c, err := New(conf)
+	require.NoError(t, err)
+
+	rec = &cmdRecorder{mu: &sync.Mutex{}}
+	c.runCmd = rec.run
+
+	return c, rec
+}
+
+func TestConfig_validate(t *testing.T) {
+	testCases := []struct {
+		conf       *Config
+		name       string
+		wantErrMsg string
+	}{{
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: &Config{
+			MaxUL: testMaxBW,
+			MaxDL: testMaxBW,
+		},
+		name:       "no_uplink",
+		wantErrMsg: "no uplink_interface",
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxDL:           testMaxBW,
+		},
+		name:       "no_max_ul",
+		wantErrMsg: "max_ul: must be positive, got 0",
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			MinDL:           2 * testMaxBW,
+		},
+		name:       "min_dl_too_big",
+		wantErrMsg: "min_dl: must be from 0 to max_dl 100000, got 200000",
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			Sidecars:        []*SidecarConfig{{Command: "x", Restart: "sometimes"}},
+		},
+		name:       "bad_restart",
+		wantErrMsg: `sidecars: at index 0: bad restart policy "sometimes"`,
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			Hooks:           []*HookConfig{{Command: "x", Event: "post-shape"}},
+		},
+		name:       "bad_hook_event",
+		wantErrMsg: `hooks: at index 0: bad event "post-shape"`,
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			DSCP:            []*DSCPRule{{Class: "realtime", Domains: []string{"example.com"}}},
+		},
+		name:       "bad_dscp_class",
+		wantErrMsg: `dscp: at index 0: bad class "realtime"`,
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			DSCP:            []*DSCPRule{{Class: DSCPClassBulk, Services: []string{"none"}}},
+		},
+		name:       "bad_dscp_service",
+		wantErrMsg: `dscp: at index 0: unknown blocked-service "none"`,
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			Probe:           &ProbeConfig{URL: "ftp://192.0.2.1", Enabled: true},
+		},
+		name:       "bad_probe_url",
+		wantErrMsg: `probe: url: bad scheme "ftp"`,
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			Probe: &ProbeConfig{
+				URL:      "http://192.0.2.1",
+				Duration: timeutil.Duration{Duration: time.Minute},
+				Enabled:  true,
+			},
+		},
+		name:       "bad_probe_duration",
+		wantErrMsg: "probe: duration: must not be greater than 30s, got 1m",
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			Probe:           &ProbeConfig{URL: "ftp://192.0.2.1", Enabled: false},
+		},
+		name:       "disabled_probe",
+		wantErrMsg: "",
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			Alerts: &AlertConfig{
+				URL:     "https://hooks.example/cake",
+				Rules:   []*AlertRule{{Kind: AlertKindBandwidthFloor}},
+				Enabled: true,
+			},
+		},
+		name:       "bad_alert_rule",
+		wantErrMsg: "alerts: rules: at index 0: no name",
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			Alerts: &AlertConfig{
+				URL:     "https://hooks.example/cake",
+				Enabled: true,
+			},
+		},
+		name:       "no_alert_rules",
+		wantErrMsg: "alerts: no rules",
+	}, {
+		conf: &Config{
+			UplinkInterface:     "eth0",
+			MaxUL:               testMaxBW,
+			MaxDL:               testMaxBW,
+			CongestionThreshold: 1.5,
+		},
+		name:       "bad_congestion_threshold",
+		wantErrMsg: "congestion_threshold: must be from 0 to 1, got 1.5",
+	}, {
+		conf: &Config{
+			UplinkInterface: "eth0",
+			MaxUL:           testMaxBW,
+			MaxDL:           testMaxBW,
+			Sources:         &SourceConfig{Reflectors: []string{"9.9.9.9"}},
+		},
+		name:       "bad_reflector",
+		wantErrMsg: "sources: reflectors: at index 0: address 9.9.9.9: missing port in address",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.validate())
+		})
+	}
+}
+
+func TestController_iterate(t *testing.T) {
+	c, rec := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MiscInterfaces:  []string{"wg0"},
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+	})
+
+	ctx := context.Background()
+
+	c.iterate(ctx)
+
+	// The qdiscs are replaced and then their statistics are read.
+	require.Len(t, rec.cmds, 5)
+
+	assert.Equal(t, testMaxBW*0.9, c.bwUL)
+	assert.Equal(t, testMaxBW*0.9, c.bwDL)
+	assert.Equal(t, splitGSO, c.splitGSO)
+
+	assert.Contains(t, rec.cmds[0], "dev eth0 root cake rtt 98000us bandwidth 90000.000000kbit")
+	assert.Contains(t, rec.cmds[1], "dev ifb4eth0 root cake rtt 98000us")
+	assert.Contains(t, rec.cmds[2], "dev wg0 root cake")
+	assert.Equal(t, "tc -s -j qdisc show dev eth0 root", rec.cmds[3])
+	assert.Equal(t, "tc -s -j qdisc show dev ifb4eth0 root", rec.cmds[4])
+
+	// A latency increase halves the bandwidth.
+	c.ObserveLatency("1.1.1.1:53", 200*time.Millisecond, false)
+	c.iterate(ctx)
+
+	assert.Equal(t, testMaxBW*0.9/2+Mbit, c.bwUL)
+	assert.Equal(t, testMaxBW*0.9/2+Mbit, c.bwDL)
+	assert.Contains(t, rec.cmds[5], "rtt 196000us")
+
+	// Cached responses are ignored.
+	c.ObserveLatency("1.1.1.1:53", 300*time.Millisecond, true)
+	c.iterate(ctx)
+
+	assert.Equal(t, testMaxBW*0.9/2+2*Mbit, c.bwUL)
+
+	m := c.currentMetrics()
+	assert.Equal(t, "3 of 100000", m.DataTotal)
+}
+
+func TestController_runHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a unix shell")
+	}
+
+	out := filepath.Join(t.TempDir(), "state.json")
+
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+		Hooks: []*HookConfig{{
+			Event:   HookEventPreShape,
+			Command: "/bin/sh",
+			Args:    []string{"-c", `cat > "$0"`, out},
+			Timeout: timeutil.Duration{Duration: time.Second},
+		}},
+	})
+
+	c.runHooks(context.Background(), HookEventPreShape)
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(data), `"event":"pre-shape"`)
+	assert.Contains(t, string(data), `"uplinkInterface":"eth0"`)
+}
+
+func TestController_iterate_hooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a unix shell")
+	}
+
+	out := filepath.Join(t.TempDir(), "states.json")
+
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+		Hooks: []*HookConfig{{
+			Event:   HookEventPostReconfigure,
+			Command: "/bin/sh",
+			Args:    []string{"-c", `cat >> "$0"`, out},
+			Timeout: timeutil.Duration{Duration: time.Second},
+		}},
+	})
+
+	ctx := context.Background()
+	iterate := func() (runs int) {
+		c.iterate(ctx)
+		c.wg.Wait()
+
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+
+		return strings.Count(string(data), `"event":"post-reconfigure"`)
+	}
+
+	assert.Equal(t, 1, iterate())
+
+	// The parameters haven't changed, so the hooks aren't run again.
+	assert.Equal(t, 1, iterate())
+
+	c.ObserveLatency("1.1.1.1:53", 200*time.Millisecond, false)
+	assert.Equal(t, 2, iterate())
+}
+
+func TestShouldRestart(t *testing.T) {
+	testErr := assert.AnError
+
+	assert.True(t, shouldRestart(RestartAlways, nil))
+	assert.True(t, shouldRestart(RestartAlways, testErr))
+	assert.False(t, shouldRestart(RestartNever, testErr))
+	assert.False(t, shouldRestart(RestartOnFailure, nil))
+	assert.True(t, shouldRestart(RestartOnFailure, testErr))
+	assert.True(t, shouldRestart("", testErr))
+}
+
+// fakeAdder is a fake [nftset.Adder] for tests.
+type fakeAdder struct {
+	added map[string][]netip.Addr
+}
+
+// type check
+var _ nftset.Adder = (*fakeAdder)(nil)
+
+// Add implements the [nftset.Adder] interface for *fakeAdder.
+func (a *fakeAdder) Add(set *nftset.Set, ips []netip.Addr, _ time.Duration) (n int, err error) {
+	for _, ip := range ips {
+		if ip.Is6() == strings.HasSuffix(set.Name, "6") {
+			a.added[set.Name] = append(a.added[set.Name], ip)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Close implements the [nftset.Adder] interface for *fakeAdder.
+func (a *fakeAdder) Close() (err error) {
+	return nil
+}
+
+func TestController_ObserveAnswer(t *testing.T) {
+	adder := &fakeAdder{added: map[string][]netip.Addr{}}
+	newAdder := func() (a nftset.Adder, err error) { return adder, nil }
+
+	c, err := newController(&Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+		DSCP: []*DSCPRule{{
+			Class:   DSCPClassVoice,
+			Domains: []string{"meet.example"},
+		}, {
+			Class:    DSCPClassBulk,
+			Services: []string{"steam"},
+		}},
+	}, newAdder)
+	require.NoError(t, err)
+
+	rec := &cmdRecorder{mu: &sync.Mutex{}}
+	c.runCmd = rec.run
+
+	ip4 := netip.MustParseAddr("1.2.3.4")
+	ip6 := netip.MustParseAddr("1234::5678")
+	ips := []netip.Addr{ip4, ip6}
+
+	c.ObserveAnswer("call.meet.example", ips, time.Minute)
+	c.ObserveAnswer("steamcontent.com", ips, time.Minute)
+	c.ObserveAnswer("example.org", ips, time.Minute)
+
+	assert.Equal(t, map[string][]netip.Addr{
+		"voice4": {ip4},
+		"voice6": {ip6},
+		"bulk4":  {ip4},
+		"bulk6":  {ip6},
+	}, adder.added)
+
+	c.initInterfaces(context.Background())
+	c.initDSCP(context.Background())
+
+	require.Len(t, rec.cmds, 7)
+
+	assert.Contains(t, rec.cmds[3], "diffserv4")
+	assert.Contains(t, rec.cmds[5], "action ctinfo dscp 0xfc000000 0x01000000 action mirred")
+
+	rs := rec.cmds[6]
+	assert.Contains(t, rs, "set voice4 { type ipv4_addr; flags timeout; }")
+	assert.Contains(t, rs, "set bulk6 { type ipv6_addr; flags timeout; }")
+	assert.NotContains(t, rs, "video")
+	assert.Contains(
+		t,
+		rs,
+		`oifname "eth0" ip daddr @voice4 ip dscp set 46 ct mark set ct mark and 0x02ffffff or 0xb9000000`,
+	)
+	assert.Contains(
+		t,
+		rs,
+		`oifname "eth0" ip6 daddr @bulk6 ip6 dscp set 8 ct mark set ct mark and 0x02ffffff or 0x21000000`,
+	)
+}
@@ -0,0 +1,198 @@
+package cake
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// defaultCongestionThreshold is the default smoothed share of the packets
+// dropped or marked by CAKE at which the direction is considered congested.
+const defaultCongestionThreshold = 0.05
+
+const (
+	// congestionReadInterval is the minimum period of time between reading the
+	// statistics of CAKE.  The control loop runs much more often, but reading
+	// the statistics that often is both costly and too noisy.
+	congestionReadInterval = 500 * time.Millisecond
+
+	// congestionWindow is the time constant of the exponential smoothing of the
+	// congestion rates.
+	congestionWindow = 2 * time.Second
+
+	// congestionMinPackets is the minimum number of the packets sent or
+	// dropped between two readings for the share of the dropped and marked
+	// ones to be taken into account.  The share of fewer packets is too
+	// random.
+	congestionMinPackets = 100
+)
+
+// congestionCounters are the totals of the congestion counters of all tins of
+// a direction.
+type congestionCounters struct {
+	packets uint64
+	drops   uint64
+	marks   uint64
+}
+
+// countersOf returns the totals of the counters of tins.
+func countersOf(tins []*TinStats) (cc congestionCounters) {
+	for _, t := range tins {
+		cc.packets += t.SentPackets
+		cc.drops += t.Drops
+		cc.marks += t.ECNMarks
+	}
+
+	return cc
+}
+
+// congestionRates are the rates of change of the congestion counters of a
+// direction.
+type congestionRates struct {
+	// drops is the number of the dropped packets per second.
+	drops float64
+
+	// marks is the number of the ECN-marked packets per second.
+	marks float64
+
+	// share is the share of the dropped and marked packets among all packets.
+	share float64
+
+	// packets is the number of the packets sent or dropped.
+	packets uint64
+}
+
+// ratesOf returns the rates of change between prev and cur taken secs apart.
+// ok is false if the counters have been reset.
+func ratesOf(prev, cur congestionCounters, secs float64) (r congestionRates, ok bool) {
+	if secs <= 0 || cur.packets < prev.packets || cur.drops < prev.drops || cur.marks < prev.marks {
+		return congestionRates{}, false
+	}
+
+	sent := cur.packets - prev.packets
+	dropped := cur.drops - prev.drops
+	marked := cur.marks - prev.marks
+
+	r.drops = float64(dropped) / secs
+	r.marks = float64(marked) / secs
+
+	// The marked packets are sent, but the dropped ones aren't.
+	r.packets = sent + dropped
+	if r.packets > 0 {
+		r.share = float64(dropped+marked) / float64(r.packets)
+	}
+
+	return r, true
+}
+
+// congestion is the congestion signal of a direction.
+type congestion struct {
+	// prevTime is the time of prev.  It is zero if there is no baseline.
+	prevTime time.Time
+
+	// prev are the counters read by the previous update.
+	prev congestionCounters
+
+	// rates are the exponentially smoothed rates of change.  The share is only
+	// smoothed over the readings with at least congestionMinPackets packets.
+	rates congestionRates
+
+	// congested is set when the smoothed share of the dropped and marked
+	// packets has reached the threshold since the last iteration of the
+	// control loop.
+	congested bool
+}
+
+// update updates the signal with the statistics of tins read at now.  A nil
+// tins means that the statistics aren't available.
+func (g *congestion) update(tins []*TinStats, now time.Time, threshold float64) {
+	if tins == nil {
+		// Start over, since the counters may have been reset.
+		g.prevTime = time.Time{}
+
+		return
+	}
+
+	cur := countersOf(tins)
+	if !g.prevTime.IsZero() {
+		elapsed := now.Sub(g.prevTime)
+		r, ok := ratesOf(g.prev, cur, elapsed.Seconds())
+		if ok {
+			g.smooth(r, elapsed)
+			g.congested = g.congested || g.rates.share >= threshold
+		}
+	}
+
+	g.prev, g.prevTime = cur, now
+}
+
+// smooth adds the rates r measured over elapsed to the smoothed ones.
+func (g *congestion) smooth(r congestionRates, elapsed time.Duration) {
+	// Weigh the sample according to the time it covers, since the readings
+	// aren't necessarily evenly spaced.
+	a := 1 - math.Exp(-elapsed.Seconds()/congestionWindow.Seconds())
+
+	g.rates.drops += a * (r.drops - g.rates.drops)
+	g.rates.marks += a * (r.marks - g.rates.marks)
+	g.rates.packets = r.packets
+	if r.packets >= congestionMinPackets {
+		g.rates.share += a * (r.share - g.rates.share)
+	}
+}
+
+// observeCongestion reads the statistics of CAKE on the uplink and downlink
+// interfaces and updates the congestion signals, unless the statistics have
+// been read less than congestionReadInterval before now.  It's only called by
+// the control loop.
+func (c *Controller) observeCongestion(ctx context.Context, now time.Time) {
+	if now.Sub(c.congReadAt) < congestionReadInterval {
+		return
+	}
+
+	c.congReadAt = now
+
+	ul := c.tinStats(ctx, c.conf.UplinkInterface)
+	dl := c.tinStats(ctx, c.downlink)
+
+	threshold := c.conf.CongestionThreshold
+	if threshold == 0 {
+		threshold = defaultCongestionThreshold
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.congUL.update(ul, now, threshold)
+	c.congDL.update(dl, now, threshold)
+}
+
+// congestionSignals returns the description of the signals that have caused
+// the bandwidth reduction for the journal.
+func congestionSignals(rtt, ul, dl bool) (msg string) {
+	var signals []string
+	if rtt {
+		signals = append(signals, "rtt increase")
+	}
+
+	if ul {
+		signals = append(signals, "uplink drops and marks")
+	}
+
+	if dl {
+		signals = append(signals, "downlink drops and marks")
+	}
+
+	return strings.Join(signals, ", ")
+}
+
+// validateCongestionThreshold returns an error if t is not a valid congestion
+// threshold.
+func validateCongestionThreshold(t float64) (err error) {
+	if t < 0 || t > 1 {
+		return fmt.Errorf("congestion_threshold: must be from 0 to 1, got %v", t)
+	}
+
+	return nil
+}
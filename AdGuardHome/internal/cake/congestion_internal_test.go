@@ -0,0 +1,161 @@
+package cake
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatesOf(t *testing.T) {
+	prev := congestionCounters{packets: 1000, drops: 10, marks: 20}
+
+	testCases := []struct {
+		cur    congestionCounters
+		want   congestionRates
+		name   string
+		secs   float64
+		wantOK bool
+	}{{
+		cur:    congestionCounters{packets: 1090, drops: 20, marks: 25},
+		want:   congestionRates{drops: 20, marks: 10, share: 0.15},
+		name:   "congested",
+		secs:   0.5,
+		wantOK: true,
+	}, {
+		cur:    prev,
+		want:   congestionRates{},
+		name:   "idle",
+		secs:   1,
+		wantOK: true,
+	}, {
+		cur:    congestionCounters{packets: 10},
+		want:   congestionRates{},
+		name:   "reset",
+		secs:   1,
+		wantOK: false,
+	}, {
+		cur:    congestionCounters{packets: 1100, drops: 10, marks: 20},
+		want:   congestionRates{},
+		name:   "no_time",
+		secs:   0,
+		wantOK: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := ratesOf(prev, tc.cur, tc.secs)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.InDelta(t, tc.want.drops, got.drops, 1e-9)
+			assert.InDelta(t, tc.want.marks, got.marks, 1e-9)
+			assert.InDelta(t, tc.want.share, got.share, 1e-9)
+		})
+	}
+}
+
+func TestCongestion_update(t *testing.T) {
+	g := &congestion{}
+	now := time.Now()
+	tins := func(packets, drops, marks uint64) (ts []*TinStats) {
+		return []*TinStats{{SentPackets: packets, Drops: drops, ECNMarks: marks}}
+	}
+
+	g.update(tins(100, 0, 0), now, defaultCongestionThreshold)
+	assert.False(t, g.congested)
+
+	// 1 drop and 1 mark among 200 packets is 1%.
+	now = now.Add(time.Second)
+	g.update(tins(299, 1, 1), now, defaultCongestionThreshold)
+	assert.False(t, g.congested)
+	assert.Positive(t, g.rates.drops)
+	assert.Less(t, g.rates.drops, 1.0)
+
+	// Unavailable statistics reset the baseline.
+	now = now.Add(time.Second)
+	g.update(nil, now, defaultCongestionThreshold)
+
+	now = now.Add(time.Second)
+	g.update(tins(10, 0, 0), now, defaultCongestionThreshold)
+	assert.False(t, g.congested)
+
+	// 5 drops among 10 packets are too few to count.
+	now = now.Add(time.Second)
+	g.update(tins(15, 5, 0), now, defaultCongestionThreshold)
+	assert.False(t, g.congested)
+
+	// 2 drops and 4 marks among 100 packets is 6%, but a single reading isn't
+	// enough to reach the threshold.
+	now = now.Add(congestionReadInterval)
+	g.update(tins(113, 7, 4), now, defaultCongestionThreshold)
+	assert.False(t, g.congested)
+
+	// The sustained congestion is.
+	for i := range 20 {
+		now = now.Add(congestionReadInterval)
+		packets, drops, marks := uint64(113+98*(i+1)), uint64(7+2*(i+1)), uint64(4+4*(i+1))
+		g.update(tins(packets, drops, marks), now, defaultCongestionThreshold)
+	}
+
+	assert.True(t, g.congested)
+	assert.InDelta(t, 0.06, g.rates.share, 0.01)
+}
+
+func TestController_iterate_congestion(t *testing.T) {
+	c, rec := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+	})
+
+	// Only the uplink is congested.
+	var ulMarks uint64
+	c.runCmd = func(ctx context.Context, name string, args ...string) (out []byte, err error) {
+		if len(args) < 2 || args[1] != "-j" {
+			return rec.run(ctx, name, args...)
+		}
+
+		marks := uint64(0)
+		if args[len(args)-2] == "eth0" {
+			ulMarks += 50
+			marks = ulMarks
+		}
+
+		return []byte(fmt.Sprintf(
+			`[{"kind":"cake","tins":[{"sent_packets":%d,"ecn_mark":%d}]}]`,
+			ulMarks*10,
+			marks,
+		)), nil
+	}
+
+	ctx := context.Background()
+
+	// The statistics are read no more often than congestionReadInterval.
+	start := time.Now().Add(-time.Minute)
+	c.observeCongestion(ctx, start)
+	c.observeCongestion(ctx, start.Add(congestionReadInterval/2))
+	assert.Equal(t, uint64(50), ulMarks)
+
+	for i := range 10 {
+		c.observeCongestion(ctx, start.Add(time.Duration(i+1)*congestionReadInterval))
+	}
+
+	c.iterate(ctx)
+
+	m := c.currentMetrics()
+	assert.Positive(t, m.ECNMarkRateUp)
+	assert.Zero(t, m.ECNMarkRateDown)
+	assert.Zero(t, m.DropRateUp)
+
+	assert.Equal(t, testMaxBW*(1-defaultAggressiveness)+Mbit, c.bwUL)
+	assert.Equal(t, testMaxBW*0.9, c.bwDL)
+
+	s := c.status()
+	require.NotEmpty(t, s.Journal)
+
+	last := s.Journal[len(s.Journal)-1]
+	assert.Equal(t, JournalEventBufferbloat, last.Event)
+	assert.Equal(t, "uplink drops and marks", last.Message)
+}
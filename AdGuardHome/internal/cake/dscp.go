@@ -0,0 +1,269 @@
+package cake
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/nftset"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// DSCPClass is the traffic class the connections to the resolved addresses are
+// put into.  Each class corresponds to a tin of the diffserv4 mode of CAKE.
+type DSCPClass string
+
+// Traffic classes.
+const (
+	// DSCPClassVoice is the class for latency-sensitive traffic, such as
+	// video calls.  It is marked with EF.
+	DSCPClassVoice DSCPClass = "voice"
+
+	// DSCPClassVideo is the class for streaming traffic.  It is marked with
+	// AF41.
+	DSCPClassVideo DSCPClass = "video"
+
+	// DSCPClassBestEffort is the class for the usual traffic.  It is marked
+	// with CS0, which also clears the marks set by the applications.
+	DSCPClassBestEffort DSCPClass = "besteffort"
+
+	// DSCPClassBulk is the class for the background traffic, such as game
+	// downloads.  It is marked with CS1.
+	DSCPClassBulk DSCPClass = "bulk"
+)
+
+// dscpClasses are the valid traffic classes in the order of the tins.
+var dscpClasses = []DSCPClass{
+	DSCPClassVoice,
+	DSCPClassVideo,
+	DSCPClassBestEffort,
+	DSCPClassBulk,
+}
+
+// codepoint returns the DSCP value for c.  c must be valid.
+func (c DSCPClass) codepoint() (cp uint8) {
+	switch c {
+	case DSCPClassVoice:
+		return 46
+	case DSCPClassVideo:
+		return 34
+	case DSCPClassBulk:
+		return 8
+	default:
+		return 0
+	}
+}
+
+// DSCPRule puts the connections to the addresses of the matching hosts into a
+// traffic class.
+type DSCPRule struct {
+	// Class is the traffic class for the matching hosts.
+	Class DSCPClass `yaml:"class"`
+
+	// Domains are the domain names matching the rule along with their
+	// subdomains.
+	Domains []string `yaml:"domains"`
+
+	// Services are the IDs of the blocked services, see
+	// [filtering.BlockedServices], the hosts of which match the rule.
+	Services []string `yaml:"services"`
+}
+
+// validate returns an error if r is not valid.
+func (r *DSCPRule) validate() (err error) {
+	switch {
+	case r == nil:
+		return errors.Error("no value")
+	case len(r.Domains) == 0 && len(r.Services) == 0:
+		return errors.Error("no domains or services")
+	}
+
+	found := false
+	for _, c := range dscpClasses {
+		found = found || c == r.Class
+	}
+
+	if !found {
+		return fmt.Errorf("bad class %q", r.Class)
+	}
+
+	return (&filtering.BlockedServices{IDs: r.Services}).Validate()
+}
+
+// match returns true if host matches r.
+func (r *DSCPRule) match(host string) (ok bool) {
+	for _, d := range r.Domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+
+	for _, id := range r.Services {
+		if filtering.MatchService(id, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dscpTable is the name of the nftables table managed by the controller.
+const dscpTable = "agh_cake"
+
+// minDSCPTimeout is the minimum lifetime of the addresses in the sets, since
+// the connections usually outlive the TTL of the records.
+const minDSCPTimeout = 10 * time.Minute
+
+// ctinfo masks for the connection mark.  The upper six bits of the mark keep
+// the DSCP value, and the next bit tells that the value has been stored.  See
+// tc-ctinfo(8).
+const (
+	ctMarkDSCPMask  = 0xfc000000
+	ctMarkStateMask = 0x01000000
+)
+
+// dscpSet returns the address of the nftables set for the class and the IP
+// version.
+func dscpSet(c DSCPClass, ipv6 bool) (s *nftset.Set) {
+	name := string(c) + "4"
+	if ipv6 {
+		name = string(c) + "6"
+	}
+
+	return &nftset.Set{
+		Family: "inet",
+		Table:  dscpTable,
+		Name:   name,
+	}
+}
+
+// dscpRuleset returns the nftables ruleset that marks the egress traffic of
+// the uplink interface to the addresses in the sets of the classes.  The DSCP
+// value is also stored in the connection mark so that it can be restored on
+// ingress.  The table is recreated each time to remove the stale rules.
+func (c *Controller) dscpRuleset() (rs string) {
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "table inet %[1]s {}\ndelete table inet %[1]s\ntable inet %[1]s {\n", dscpTable)
+
+	classes := c.dscpClasses()
+	for _, cl := range classes {
+		fmt.Fprintf(b, "\tset %s { type ipv4_addr; flags timeout; }\n", dscpSet(cl, false).Name)
+		fmt.Fprintf(b, "\tset %s { type ipv6_addr; flags timeout; }\n", dscpSet(cl, true).Name)
+	}
+
+	b.WriteString("\tchain postrouting {\n")
+	b.WriteString("\t\ttype filter hook postrouting priority mangle; policy accept;\n")
+
+	for _, cl := range classes {
+		cp := cl.codepoint()
+		mark := uint32(cp)<<26 | ctMarkStateMask
+		for _, proto := range []string{"ip", "ip6"} {
+			fmt.Fprintf(
+				b,
+				"\t\toifname %q %s daddr @%s %s dscp set %d ct mark set ct mark and 0x%08x or 0x%08x\n",
+				c.conf.UplinkInterface,
+				proto,
+				dscpSet(cl, proto == "ip6").Name,
+				proto,
+				cp,
+				^uint32(ctMarkDSCPMask|ctMarkStateMask),
+				mark,
+			)
+		}
+	}
+
+	b.WriteString("\t}\n}\n")
+
+	return b.String()
+}
+
+// dscpClasses returns the classes used by the configured rules in the order
+// of the tins.
+func (c *Controller) dscpClasses() (classes []DSCPClass) {
+	for _, cl := range dscpClasses {
+		for _, r := range c.conf.DSCP {
+			if r.Class == cl {
+				classes = append(classes, cl)
+
+				break
+			}
+		}
+	}
+
+	return classes
+}
+
+// initDSCP creates the nftables table with the sets of the classes.
+func (c *Controller) initDSCP(ctx context.Context) {
+	if c.nft == nil {
+		return
+	}
+
+	_, err := c.runCmd(ctx, "nft", c.dscpRuleset())
+	if err != nil {
+		log.Error("cake: initializing dscp marking: %s", err)
+	}
+}
+
+// newDSCPAdder returns an adder for the sets of the classes using newAdder.
+// It returns nil if DSCP marking isn't configured or isn't supported.
+func newDSCPAdder(conf *Config, newAdder func() (nftset.Adder, error)) (a nftset.Adder, err error) {
+	if len(conf.DSCP) == 0 {
+		return nil, nil
+	}
+
+	a, err = newAdder()
+	if errors.Is(err, os.ErrInvalid) || errors.Is(err, os.ErrPermission) {
+		log.Info("cake: warning: cannot initialize dscp marking: %s", err)
+
+		return nil, nil
+	} else if unsupErr := (&aghos.UnsupportedError{}); errors.As(err, &unsupErr) {
+		log.Info("cake: warning: %s", err)
+
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("initializing dscp marking: %w", err)
+	}
+
+	return a, nil
+}
+
+// ObserveAnswer implements the [dnsforward.AnswerObserver] interface for
+// *Controller.  It puts ips into the sets of the class of the first rule
+// matching host.
+func (c *Controller) ObserveAnswer(host string, ips []netip.Addr, ttl time.Duration) {
+	if c.nft == nil {
+		return
+	}
+
+	for _, r := range c.conf.DSCP {
+		if r.match(host) {
+			c.addDSCPAddrs(host, r.Class, ips, max(ttl, minDSCPTimeout))
+
+			return
+		}
+	}
+}
+
+// addDSCPAddrs adds ips resolved for host to the sets of class.
+func (c *Controller) addDSCPAddrs(host string, class DSCPClass, ips []netip.Addr, timeout time.Duration) {
+	var n int
+	for _, ipv6 := range []bool{false, true} {
+		added, err := c.nft.Add(dscpSet(class, ipv6), ips, timeout)
+		if err != nil {
+			// Consider the errors non-critical to the request.
+			log.Error("cake: dscp: host %q: %s", host, err)
+		}
+
+		n += added
+	}
+
+	log.Debug("cake: dscp: added %d addrs of %q to class %s", n, host, class)
+}
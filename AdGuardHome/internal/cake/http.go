@@ -0,0 +1,99 @@
+package cake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// eventsMaxDuration is the maximum duration of a single status event stream.
+// It is less than the write timeout of the web server, and the clients
+// reconnect automatically.
+const eventsMaxDuration = 50 * time.Second
+
+// initWeb registers the handlers for web endpoints of the controller.
+func (c *Controller) initWeb() {
+	c.conf.HTTPRegister(http.MethodGet, "/control/cake", c.handleCake)
+	c.conf.HTTPRegister(http.MethodGet, "/control/cake/status", c.handleCakeStatus)
+	c.conf.HTTPRegister(http.MethodGet, "/control/cake/events", c.handleCakeEvents)
+}
+
+// handleCake is the handler for the GET /control/cake HTTP API.
+func (c *Controller) handleCake(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, c.currentMetrics())
+}
+
+// handleCakeStatus is the handler for the GET /control/cake/status HTTP API.
+func (c *Controller) handleCakeStatus(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, c.status())
+}
+
+// handleCakeEvents is the handler for the GET /control/cake/events HTTP API.
+// It streams the status updates as server-sent events.  The history of each
+// update only contains the point added since the previous one.
+func (c *Controller) handleCakeEvents(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "streaming is not supported")
+
+		return
+	}
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, aghhttp.HdrValTextEventStream)
+	h.Set(httphdr.CacheControl, aghhttp.HdrValNoCache)
+	w.WriteHeader(http.StatusOK)
+
+	updates, unsubscribe := c.subscribe()
+	defer unsubscribe()
+
+	// Ask the client to reconnect quickly once the stream is over.
+	_, err := fmt.Fprintf(w, "retry: %d\n\n", time.Second.Milliseconds())
+	if err != nil {
+		log.Debug("cake: writing events: %s", err)
+
+		return
+	}
+
+	f.Flush()
+
+	timer := time.NewTimer(eventsMaxDuration)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.done:
+			return
+		case <-timer.C:
+			return
+		case s := <-updates:
+			err = writeEvent(w, s)
+			if err != nil {
+				log.Debug("cake: writing events: %s", err)
+
+				return
+			}
+
+			f.Flush()
+		}
+	}
+}
+
+// writeEvent writes s into w as a server-sent event.
+func writeEvent(w http.ResponseWriter, s *Status) (err error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("encoding status: %w", err)
+	}
+
+	_, err = fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+
+	return err
+}
@@ -0,0 +1,69 @@
+package cake
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestController_handleCakeEvents(t *testing.T) {
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(c.handleCakeEvents))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get(httphdr.ContentType))
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+
+	assert.Equal(t, "retry: 1000\n", line)
+
+	// Wait for the handler to subscribe.
+	require.Eventually(t, func() (ok bool) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return len(c.subs) == 1
+	}, time.Second, time.Millisecond)
+
+	c.collect(ctx, time.Now())
+
+	for !strings.HasPrefix(line, "data: ") {
+		line, err = r.ReadString('\n')
+		require.NoError(t, err)
+	}
+
+	data := strings.TrimPrefix(strings.TrimSpace(line), "data: ")
+
+	s := &Status{}
+	err = json.Unmarshal([]byte(data), s)
+	require.NoError(t, err)
+
+	assert.Equal(t, "eth0", s.Params.UplinkInterface)
+	assert.Len(t, s.History, 1)
+}
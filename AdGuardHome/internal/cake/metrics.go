@@ -0,0 +1,135 @@
+package cake
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metrics are the metrics of the controller served by the HTTP API.
+type Metrics struct {
+	RTTAverageString    string  `json:"rttAverageString"`
+	BwUpAverageString   string  `json:"bwUpAverageString"`
+	BwDownAverageString string  `json:"bwDownAverageString"`
+	BwUpMedianString    string  `json:"bwUpMedianString"`
+	BwDownMedianString  string  `json:"bwDownMedianString"`
+	DataTotal           string  `json:"dataTotal"`
+	ExecTimeCAKE        string  `json:"execTimeCAKE"`
+	ExecTimeAverageCAKE string  `json:"execTimeAverageCAKE"`
+	RTTAverage          int64   `json:"rttAverage"`
+	BwUpAverage         float64 `json:"bwUpAverage"`
+	BwDownAverage       float64 `json:"bwDownAverage"`
+	BwUpMedian          float64 `json:"bwUpMedian"`
+	BwDownMedian        float64 `json:"bwDownMedian"`
+
+	// DropRateUp and DropRateDown are the numbers of the packets dropped by
+	// CAKE per second during the latest iteration.
+	DropRateUp   float64 `json:"dropRateUp"`
+	DropRateDown float64 `json:"dropRateDown"`
+
+	// ECNMarkRateUp and ECNMarkRateDown are the numbers of the packets marked
+	// by CAKE per second during the latest iteration.
+	ECNMarkRateUp   float64 `json:"ecnMarkRateUp"`
+	ECNMarkRateDown float64 `json:"ecnMarkRateDown"`
+}
+
+// sampleStats accumulates the parameters applied by the controller.  All
+// accumulated values are reset when dataLimit is reached.
+type sampleStats struct {
+	// rttSum is the sum of the applied rtt values, in microseconds.
+	rttSum float64
+
+	// bwUpSum and bwDownSum are the sums of the applied bandwidths, in kbit/s.
+	bwUpSum   float64
+	bwDownSum float64
+
+	// lastBwUp and lastBwDown are the latest applied bandwidths, in kbit/s.
+	lastBwUp   float64
+	lastBwDown float64
+
+	// execSum is the sum of the durations of the control loop iterations.
+	execSum time.Duration
+
+	// lastExec is the duration of the latest iteration.
+	lastExec time.Duration
+
+	// num is the number of the accumulated samples.
+	num int
+
+	// execNum is the number of the accumulated iterations.
+	execNum int
+}
+
+// add accumulates the parameters applied during an iteration.
+func (s *sampleStats) add(rtt time.Duration, bwUp, bwDown float64) {
+	if s.num >= dataLimit {
+		*s = sampleStats{}
+	}
+
+	s.rttSum += float64(rtt.Microseconds())
+	s.bwUpSum += bwUp
+	s.bwDownSum += bwDown
+	s.lastBwUp, s.lastBwDown = bwUp, bwDown
+	s.num++
+}
+
+// addExecTime accumulates the duration of an iteration.
+func (s *sampleStats) addExecTime(d time.Duration) {
+	s.execSum += d
+	s.lastExec = d
+	s.execNum++
+}
+
+// metrics returns the metrics calculated from the accumulated samples.
+func (s *sampleStats) metrics() (m *Metrics) {
+	if s.num == 0 || s.execNum == 0 {
+		return &Metrics{}
+	}
+
+	n := float64(s.num)
+	rttAvg := s.rttSum / n
+	bwUpAvg := s.bwUpSum / n
+	bwDownAvg := s.bwDownSum / n
+	bwUpMed := median(s.lastBwUp, s.num)
+	bwDownMed := median(s.lastBwDown, s.num)
+	execAvg := s.execSum / time.Duration(s.execNum)
+
+	return &Metrics{
+		RTTAverage:          int64(rttAvg),
+		RTTAverageString:    fmt.Sprintf("%.2f ms | %.2f μs", rttAvg/1000, rttAvg),
+		BwUpAverage:         bwUpAvg,
+		BwUpAverageString:   bandwidthString(bwUpAvg),
+		BwDownAverage:       bwDownAvg,
+		BwDownAverageString: bandwidthString(bwDownAvg),
+		BwUpMedian:          bwUpMed,
+		BwUpMedianString:    bandwidthString(bwUpMed),
+		BwDownMedian:        bwDownMed,
+		BwDownMedianString:  bandwidthString(bwDownMed),
+		DataTotal:           fmt.Sprintf("%d of %d", s.num, dataLimit),
+		ExecTimeCAKE:        durationString(s.lastExec),
+		ExecTimeAverageCAKE: durationString(execAvg),
+	}
+}
+
+// median returns the estimation of the median bandwidth from the latest one
+// and the number of samples.
+func median(last float64, num int) (m float64) {
+	if num%2 == 0 {
+		return last/2 + (last/2+1)/2
+	}
+
+	return (last + 1) / 2
+}
+
+// bandwidthString formats bw, in kbit/s, for the metrics.
+func bandwidthString(bw float64) (s string) {
+	return fmt.Sprintf("%.2f kbit | %.2f Mbit", bw, bw/Mbit)
+}
+
+// durationString formats d for the metrics.
+func durationString(d time.Duration) (s string) {
+	return fmt.Sprintf(
+		"%.2f ms | %.2f μs",
+		float64(d)/float64(time.Millisecond),
+		float64(d)/float64(time.Microsecond),
+	)
+}
@@ -0,0 +1,216 @@
+package cake
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// newSpeedtestServer returns a test server with the speedtest endpoints and
+// the base URL for the probe.
+func newSpeedtestServer(t *testing.T) (baseURL string) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	RegisterSpeedtest(func(method, path string, h http.HandlerFunc) {
+		mux.HandleFunc(method+" "+path, h)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv.URL + "/control/cake/speedtest"
+}
+
+func TestProber_measure(t *testing.T) {
+	p := newProber(&ProbeConfig{
+		URL:      newSpeedtestServer(t),
+		Duration: timeutil.Duration{Duration: 100 * time.Millisecond},
+		Streams:  2,
+		Enabled:  true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	ul, dl, err := p.measure(ctx)
+	require.NoError(t, err)
+
+	assert.Positive(t, ul)
+	assert.Positive(t, dl)
+}
+
+func TestHandleSpeedtestDownload(t *testing.T) {
+	testCases := []struct {
+		name     string
+		duration string
+		wantCode int
+	}{{
+		name:     "valid",
+		duration: "10ms",
+		wantCode: http.StatusOK,
+	}, {
+		name:     "bad",
+		duration: "fast",
+		wantCode: http.StatusBadRequest,
+	}, {
+		name:     "too_long",
+		duration: "1m",
+		wantCode: http.StatusBadRequest,
+	}, {
+		name:     "negative",
+		duration: "-1s",
+		wantCode: http.StatusBadRequest,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := url.Values{"duration": []string{tc.duration}}
+			r := httptest.NewRequest(http.MethodGet, "/control/cake/speedtest/download?"+q.Encode(), nil)
+			w := httptest.NewRecorder()
+
+			handleSpeedtestDownload(w, r)
+
+			assert.Equal(t, tc.wantCode, w.Code)
+			if tc.wantCode == http.StatusOK {
+				assert.Positive(t, w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestPayload(t *testing.T) {
+	t.Run("expired", func(t *testing.T) {
+		data, err := io.ReadAll(newPayload(time.Now().Add(-time.Second)))
+		require.NoError(t, err)
+
+		assert.Equal(t, `""`, string(data))
+	})
+
+	t.Run("short_buffer", func(t *testing.T) {
+		p := newPayload(time.Now().Add(-time.Second))
+		b := make([]byte, 1)
+
+		var data []byte
+		for {
+			n, err := p.Read(b)
+			data = append(data, b[:n]...)
+			if err == io.EOF {
+				break
+			}
+
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, `""`, string(data))
+	})
+
+	t.Run("valid_json", func(t *testing.T) {
+		data, err := io.ReadAll(newPayload(time.Now().Add(10 * time.Millisecond)))
+		require.NoError(t, err)
+
+		assert.Greater(t, len(data), 2)
+		assert.True(t, json.Valid(data))
+	})
+}
+
+func TestProbeDue(t *testing.T) {
+	sched := &schedule.Weekly{}
+	err := yaml.Unmarshal([]byte(`
+time_zone: UTC
+mon:
+  start: 3h
+  end: 4h
+`), sched)
+	require.NoError(t, err)
+
+	// Monday.
+	day := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		now      time.Time
+		name     string
+		wasIn    bool
+		wantDue  bool
+		wantIsIn bool
+	}{{
+		now:      day.Add(2 * time.Hour),
+		name:     "before",
+		wasIn:    false,
+		wantDue:  false,
+		wantIsIn: false,
+	}, {
+		now:      day.Add(3*time.Hour + time.Minute),
+		name:     "began",
+		wasIn:    false,
+		wantDue:  true,
+		wantIsIn: true,
+	}, {
+		now:      day.Add(3*time.Hour + 2*time.Minute),
+		name:     "within",
+		wasIn:    true,
+		wantDue:  false,
+		wantIsIn: true,
+	}, {
+		now:      day.Add(5 * time.Hour),
+		name:     "after",
+		wasIn:    true,
+		wantDue:  false,
+		wantIsIn: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			due, isIn := probeDue(sched, tc.wasIn, tc.now)
+			assert.Equal(t, tc.wantDue, due)
+			assert.Equal(t, tc.wantIsIn, isIn)
+		})
+	}
+}
+
+func TestController_runProbe(t *testing.T) {
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+		Probe: &ProbeConfig{
+			URL:      newSpeedtestServer(t),
+			Duration: timeutil.Duration{Duration: 50 * time.Millisecond},
+			Apply:    true,
+			Enabled:  true,
+		},
+	})
+
+	c.setProbing(true)
+	p := c.adjust(time.Now())
+	assert.Equal(t, probeBandwidth, p.bwUL)
+	assert.Equal(t, probeBandwidth, p.bwDL)
+
+	c.setProbing(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	c.runProbe(ctx)
+
+	s := c.status()
+	require.NotNil(t, s.Probe)
+	require.NotEmpty(t, s.Journal)
+
+	assert.True(t, s.Probe.Applied)
+	assert.False(t, s.Params.Probing)
+	assert.Equal(t, s.Probe.Upload, c.maxUL)
+	assert.Equal(t, s.Probe.Download, c.maxDL)
+	assert.Equal(t, JournalEventProbe, s.Journal[len(s.Journal)-1].Event)
+}
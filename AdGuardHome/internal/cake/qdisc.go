@@ -0,0 +1,173 @@
+package cake
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The values of the split-gso setting of CAKE.
+const (
+	splitGSO   = "split-gso"
+	noSplitGSO = "no-split-gso"
+)
+
+// params are the parameters of CAKE managed by the controller.
+type params struct {
+	// splitGSO is either "split-gso" or "no-split-gso".
+	splitGSO string
+
+	// rtt is the rtt parameter.
+	rtt time.Duration
+
+	// bwUL is the bandwidth of the uplink, in kbit/s.
+	bwUL float64
+
+	// bwDL is the bandwidth of the downlink, in kbit/s.
+	bwDL float64
+
+	// diffserv is true if the downlink traffic is split into the diffserv4
+	// tins according to the DSCP values restored from the connection marks.
+	diffserv bool
+}
+
+// rttArg returns the rtt argument for tc.  It uses 98% of the RTT to reduce the
+// size of bursts.
+func (p *params) rttArg() (arg string) {
+	return fmt.Sprintf("%dus", p.rtt.Microseconds()*98/100)
+}
+
+// sameArgs returns true if other isn't nil and sets the same qdisc parameters
+// as p.
+func (p *params) sameArgs(other *params) (ok bool) {
+	return other != nil &&
+		p.rttArg() == other.rttArg() &&
+		p.bwUL == other.bwUL &&
+		p.bwDL == other.bwDL &&
+		p.splitGSO == other.splitGSO &&
+		p.diffserv == other.diffserv
+}
+
+// uplinkArgs returns the tc arguments to set CAKE on the uplink interface.
+func (p *params) uplinkArgs(iface string) (args []string) {
+	return []string{
+		"qdisc", "replace", "dev", iface, "root", "cake",
+		"rtt", p.rttArg(),
+		"bandwidth", fmt.Sprintf("%fkbit", p.bwUL),
+		p.splitGSO,
+		"diffserv4", "nat", "nowash", "conservative", "dual-srchost",
+		"memlimit", "32mb",
+	}
+}
+
+// downlinkArgs returns the tc arguments to set CAKE on the downlink IFB
+// interface.
+func (p *params) downlinkArgs(iface string) (args []string) {
+	tins := "besteffort"
+	if p.diffserv {
+		tins = "diffserv4"
+	}
+
+	return []string{
+		"qdisc", "replace", "dev", iface, "root", "cake",
+		"rtt", p.rttArg(),
+		"bandwidth", fmt.Sprintf("%fkbit", p.bwDL),
+		p.splitGSO,
+		tins, "nat", "wash", "conservative", "dual-dsthost", "ingress",
+		"memlimit", "32mb",
+	}
+}
+
+// miscArgs returns the tc arguments to set CAKE on one of the other shaped
+// interfaces.
+func (p *params) miscArgs(iface string) (args []string) {
+	return []string{
+		"qdisc", "replace", "dev", iface, "root", "cake",
+		"rtt", p.rttArg(),
+		"bandwidth", fmt.Sprintf("%fkbit", p.bwUL),
+		p.splitGSO,
+		"besteffort", "nat", "nowash", "conservative", "triple-isolate",
+		"memlimit", "32mb",
+	}
+}
+
+// runCommand is the default [cmdRunner] that executes the command.
+func runCommand(ctx context.Context, name string, args ...string) (out []byte, err error) {
+	out, err = exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("running %s: %w: %s", name, err, out)
+	}
+
+	return out, nil
+}
+
+// initInterfaces sets CAKE on the uplink interface and creates the downlink IFB
+// interface that receives the ingress traffic of the uplink one.  The errors
+// are only logged, since the interfaces may already be configured by the
+// previous run.
+func (c *Controller) initInterfaces(ctx context.Context) {
+	p := c.adjust(time.Now())
+	up := c.conf.UplinkInterface
+
+	redirect := []string{"filter", "add", "dev", up, "parent", "ffff:", "matchall"}
+	if p.diffserv {
+		// Restore the DSCP values stored in the connection marks by the
+		// nftables rules before the traffic reaches the IFB interface.
+		redirect = append(
+			redirect,
+			"action", "ctinfo", "dscp",
+			fmt.Sprintf("0x%08x", ctMarkDSCPMask),
+			fmt.Sprintf("0x%08x", ctMarkStateMask),
+		)
+	}
+
+	redirect = append(redirect, "action", "mirred", "egress", "redirect", "dev", c.downlink)
+
+	cmds := [][]string{
+		p.uplinkArgs(up),
+		{"link", "add", "name", c.downlink, "type", "ifb"},
+		{"qdisc", "add", "dev", up, "handle", "ffff:", "ingress"},
+		p.downlinkArgs(c.downlink),
+		{"link", "set", c.downlink, "up"},
+		redirect,
+	}
+
+	for _, args := range cmds {
+		name := "tc"
+		if args[0] == "link" {
+			name = "ip"
+		}
+
+		_, err := c.runCmd(ctx, name, args...)
+		if err != nil {
+			log.Info("cake: initializing interfaces: %s", err)
+		}
+	}
+}
+
+// reconfigure applies p to all shaped interfaces.
+func (c *Controller) reconfigure(ctx context.Context, p *params) (err error) {
+	_, err = c.runCmd(ctx, "tc", p.uplinkArgs(c.conf.UplinkInterface)...)
+	if err != nil {
+		return fmt.Errorf("uplink: %w", err)
+	}
+
+	_, err = c.runCmd(ctx, "tc", p.downlinkArgs(c.downlink)...)
+	if err != nil {
+		return fmt.Errorf("downlink: %w", err)
+	}
+
+	var errs []error
+	for _, iface := range c.conf.MiscInterfaces {
+		_, err = c.runCmd(ctx, "tc", p.miscArgs(iface)...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("interface %q: %w", iface, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
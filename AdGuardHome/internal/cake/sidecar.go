@@ -0,0 +1,347 @@
+package cake
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// RestartPolicy tells when a sidecar should be started again after it exits.
+type RestartPolicy string
+
+// Restart policies for sidecars.
+const (
+	// RestartAlways restarts the sidecar whenever it exits.
+	RestartAlways RestartPolicy = "always"
+
+	// RestartOnFailure restarts the sidecar only when it exits with an error.
+	RestartOnFailure RestartPolicy = "on-failure"
+
+	// RestartNever runs the sidecar only once.
+	RestartNever RestartPolicy = "never"
+)
+
+// Default values for the sidecars and hooks.
+const (
+	defaultMinBackoff  = 1 * time.Second
+	defaultMaxBackoff  = 1 * time.Minute
+	defaultHookTimeout = 10 * time.Second
+)
+
+// SidecarConfig is the configuration of a single process that is started and
+// supervised along with the controller.
+type SidecarConfig struct {
+	// Name is used to prefix the captured output of the process in the logs.
+	Name string `yaml:"name"`
+
+	// Command is the path to the executable.
+	Command string `yaml:"command"`
+
+	// Restart is the restart policy.  If empty, RestartOnFailure is used.
+	Restart RestartPolicy `yaml:"restart"`
+
+	// Args are the arguments passed to Command.
+	Args []string `yaml:"args"`
+
+	// MinBackoff is the delay before the first restart.  It is doubled after
+	// each consecutive restart up to MaxBackoff.  If zero, one second is used.
+	MinBackoff timeutil.Duration `yaml:"min_backoff"`
+
+	// MaxBackoff is the upper limit for the restart delay.  If zero, one
+	// minute is used.
+	MaxBackoff timeutil.Duration `yaml:"max_backoff"`
+}
+
+// validate returns an error if c is not valid.
+func (c *SidecarConfig) validate() (err error) {
+	switch {
+	case c == nil:
+		return errors.Error("no value")
+	case c.Command == "":
+		return errors.Error("no command")
+	case c.MinBackoff.Duration < 0, c.MaxBackoff.Duration < 0:
+		return errors.Error("negative backoff")
+	}
+
+	switch c.Restart {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+		return nil
+	default:
+		return fmt.Errorf("bad restart policy %q", c.Restart)
+	}
+}
+
+// HookEvent is the name of the lifecycle event of the controller a hook is run
+// on.
+type HookEvent string
+
+// Lifecycle events of the controller.
+const (
+	// HookEventPreShape is sent once before the controller applies CAKE to
+	// the interfaces.  The hooks for this event are waited for, since they
+	// are meant to prepare the system.
+	HookEventPreShape HookEvent = "pre-shape"
+
+	// HookEventPostReconfigure is sent after the controller has successfully
+	// reconfigured the qdiscs with the parameters different from the
+	// previously applied ones.
+	HookEventPostReconfigure HookEvent = "post-reconfigure"
+)
+
+// HookConfig is the configuration of a command that is run on a lifecycle
+// event of the controller.  The command receives the state of the controller,
+// see [State], as JSON on its standard input.
+type HookConfig struct {
+	// Event is the lifecycle event the command is run on.
+	Event HookEvent `yaml:"event"`
+
+	// Command is the path to the executable.
+	Command string `yaml:"command"`
+
+	// Args are the arguments passed to Command.
+	Args []string `yaml:"args"`
+
+	// Timeout is the maximum duration of a single run.  If zero, ten seconds
+	// are used.
+	Timeout timeutil.Duration `yaml:"timeout"`
+}
+
+// validate returns an error if c is not valid.
+func (c *HookConfig) validate() (err error) {
+	switch {
+	case c == nil:
+		return errors.Error("no value")
+	case c.Command == "":
+		return errors.Error("no command")
+	case c.Timeout.Duration < 0:
+		return errors.Error("negative timeout")
+	}
+
+	switch c.Event {
+	case HookEventPreShape, HookEventPostReconfigure:
+		return nil
+	default:
+		return fmt.Errorf("bad event %q", c.Event)
+	}
+}
+
+// State is the state of the controller sent to the hooks.
+type State struct {
+	// Metrics are the latest metrics of the controller.
+	Metrics *Metrics `json:"metrics"`
+
+	// Event is the lifecycle event that triggered the hook.
+	Event HookEvent `json:"event"`
+
+	// Time is the time of the event.
+	Time time.Time `json:"time"`
+
+	// UplinkInterface is the name of the uplink interface.
+	UplinkInterface string `json:"uplinkInterface"`
+
+	// DownlinkInterface is the name of the downlink IFB interface.
+	DownlinkInterface string `json:"downlinkInterface"`
+
+	// SplitGSO is the current split-gso setting.
+	SplitGSO string `json:"splitGSO"`
+
+	// MiscInterfaces are the names of the other shaped interfaces.
+	MiscInterfaces []string `json:"miscInterfaces"`
+
+	// RTT is the latest observed latency, in microseconds.
+	RTT int64 `json:"rtt"`
+
+	// BandwidthUpload is the current uplink bandwidth, in kbit/s.
+	BandwidthUpload float64 `json:"bandwidthUpload"`
+
+	// BandwidthDownload is the current downlink bandwidth, in kbit/s.
+	BandwidthDownload float64 `json:"bandwidthDownload"`
+}
+
+// startSidecars starts the supervisors of all configured sidecars.
+func (c *Controller) startSidecars(ctx context.Context) {
+	for _, conf := range c.conf.Sidecars {
+		c.wg.Add(1)
+		go c.runSidecar(ctx, conf)
+	}
+}
+
+// runSidecar runs the process described by conf and restarts it according to
+// its restart policy until ctx is canceled.  It is intended to be used as a
+// goroutine.
+func (c *Controller) runSidecar(ctx context.Context, conf *SidecarConfig) {
+	defer log.OnPanic("cake: sidecar")
+	defer c.wg.Done()
+
+	minBackoff := conf.MinBackoff.Duration
+	if minBackoff == 0 {
+		minBackoff = defaultMinBackoff
+	}
+
+	maxBackoff := conf.MaxBackoff.Duration
+	if maxBackoff == 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	prefix := fmt.Sprintf("sidecar %q", conf.Name)
+	backoff := minBackoff
+	for {
+		start := time.Now()
+		err := runLogged(prefix, exec.CommandContext(ctx, conf.Command, conf.Args...))
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			log.Error("cake: %s: %s", prefix, err)
+		} else {
+			log.Info("cake: %s: exited", prefix)
+		}
+
+		if !shouldRestart(conf.Restart, err) {
+			return
+		}
+
+		// Consider a process that has been running longer than the maximum
+		// delay a healthy one and start the next run without a penalty.
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+
+		log.Info("cake: %s: restarting in %s", prefix, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+			// Go on.
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// shouldRestart returns true if a sidecar with the restart policy p should be
+// restarted after it has exited with err.
+func shouldRestart(p RestartPolicy, err error) (ok bool) {
+	switch p {
+	case RestartAlways:
+		return true
+	case RestartNever:
+		return false
+	default:
+		return err != nil
+	}
+}
+
+// runLogged runs cmd and writes every line of its standard output and error
+// into the log with prefix.
+func runLogged(prefix string, cmd *exec.Cmd) (err error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("getting stdout: %w", err)
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("getting stderr: %w", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("starting: %w", err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go logLines(wg, prefix, "stdout", stdout)
+	go logLines(wg, prefix, "stderr", stderr)
+
+	// Read the output completely before calling Wait, since Wait closes the
+	// pipes.
+	wg.Wait()
+
+	return cmd.Wait()
+}
+
+// logLines writes each line read from r into the log.  It is intended to be
+// used as a goroutine.
+func logLines(wg *sync.WaitGroup, prefix, stream string, r io.Reader) {
+	defer wg.Done()
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		log.Info("cake: %s: %s: %s", prefix, stream, s.Text())
+	}
+
+	err := s.Err()
+	if err != nil {
+		log.Debug("cake: %s: reading %s: %s", prefix, stream, err)
+	}
+}
+
+// runHooks runs all hooks configured for ev.  The hooks for HookEventPreShape
+// are waited for, and the others are run in the background.  A hook that is
+// still running from the previous event is skipped.
+func (c *Controller) runHooks(ctx context.Context, ev HookEvent) {
+	if len(c.conf.Hooks) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(c.state(ev))
+	if err != nil {
+		log.Error("cake: hook %s: encoding state: %s", ev, err)
+
+		return
+	}
+
+	for i, h := range c.conf.Hooks {
+		running := &c.hookRunning[i]
+		if h.Event != ev || !running.CompareAndSwap(false, true) {
+			continue
+		}
+
+		if ev == HookEventPreShape {
+			c.runHook(ctx, h, data, i)
+		} else {
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+
+				c.runHook(ctx, h, data, i)
+			}()
+		}
+	}
+}
+
+// runHook runs h with data on its standard input.  idx is the index of h in
+// the configuration.
+func (c *Controller) runHook(ctx context.Context, h *HookConfig, data []byte, idx int) {
+	defer log.OnPanic("cake: hook")
+	defer c.hookRunning[idx].Store(false)
+
+	timeout := h.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.Command, h.Args...)
+	cmd.Stdin = bytes.NewReader(data)
+
+	prefix := fmt.Sprintf("hook %s %q", h.Event, h.Command)
+	err := runLogged(prefix, cmd)
+	if err != nil {
+		log.Error("cake: %s: %s", prefix, err)
+	}
+}
@@ -0,0 +1,583 @@
+package cake
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/maps"
+)
+
+// Defaults of the health scoring of the RTT sources.
+const (
+	defaultReflectorInterval = 1 * time.Second
+	defaultReflectorTimeout  = 1 * time.Second
+	defaultMaxLoss           = 0.2
+	defaultMaxJitter         = 30 * time.Millisecond
+	defaultMaxDeviation      = 50 * time.Millisecond
+	defaultExcludeAfter      = 10 * time.Second
+	defaultExcludeFor        = 5 * time.Minute
+)
+
+// Smoothing factors of the moving averages of a source, the same as the ones
+// of cake-autorate where applicable.
+const (
+	// baselineUpAlpha is used when the RTT is above the baseline, so that the
+	// baseline follows the congestion slowly.
+	baselineUpAlpha = 0.001
+
+	// baselineDownAlpha is used when the RTT is below the baseline, so that
+	// the baseline follows a faster path quickly.
+	baselineDownAlpha = 0.9
+
+	deltaAlpha  = 0.095
+	jitterAlpha = 0.1
+	lossAlpha   = 0.05
+)
+
+// minSourceSamples is the number of the samples a source needs before it's
+// scored.
+const minSourceSamples = 10
+
+// SourceConfig is the configuration of the health scoring of the sources of
+// the latency samples.  The zero values are replaced with the defaults.
+type SourceConfig struct {
+	// Reflectors are the addresses of the DNS servers queried by the
+	// controller to measure the latency in addition to the upstreams, e.g.
+	// "9.9.9.9:53".
+	Reflectors []string `yaml:"reflectors"`
+
+	// ReflectorInterval is the interval between the queries to each
+	// reflector.
+	ReflectorInterval timeutil.Duration `yaml:"reflector_interval"`
+
+	// ReflectorTimeout is the time after which a query to a reflector is
+	// considered lost.
+	ReflectorTimeout timeutil.Duration `yaml:"reflector_timeout"`
+
+	// MaxLoss is the share of the lost queries from 0 to 1 at which a source
+	// is considered unreliable.
+	MaxLoss float64 `yaml:"max_loss"`
+
+	// MaxJitter is the jitter at which a source is considered unreliable.
+	MaxJitter timeutil.Duration `yaml:"max_jitter"`
+
+	// MaxDeviation is the deviation from its own baseline, in excess of the
+	// one of the other sources, at which a source is considered unreliable.
+	MaxDeviation timeutil.Duration `yaml:"max_deviation"`
+
+	// ExcludeAfter is how long a source must stay unreliable before it is
+	// excluded.
+	ExcludeAfter timeutil.Duration `yaml:"exclude_after"`
+
+	// ExcludeFor is how long an unreliable source is excluded.
+	ExcludeFor timeutil.Duration `yaml:"exclude_for"`
+}
+
+// validate returns an error if c is not valid.  c may be nil.
+func (c *SourceConfig) validate() (err error) {
+	if c == nil {
+		return nil
+	}
+
+	for i, r := range c.Reflectors {
+		_, _, err = net.SplitHostPort(r)
+		if err != nil {
+			return fmt.Errorf("reflectors: at index %d: %w", i, err)
+		}
+	}
+
+	durs := []struct {
+		name string
+		val  timeutil.Duration
+	}{
+		{"reflector_interval", c.ReflectorInterval},
+		{"reflector_timeout", c.ReflectorTimeout},
+		{"max_jitter", c.MaxJitter},
+		{"max_deviation", c.MaxDeviation},
+		{"exclude_after", c.ExcludeAfter},
+		{"exclude_for", c.ExcludeFor},
+	}
+
+	for _, d := range durs {
+		if d.val.Duration < 0 {
+			return fmt.Errorf("%s: must not be negative, got %s", d.name, d.val)
+		}
+	}
+
+	if c.MaxLoss < 0 || c.MaxLoss > 1 {
+		return fmt.Errorf("max_loss: must be from 0 to 1, got %v", c.MaxLoss)
+	}
+
+	return nil
+}
+
+// SourceKind is the kind of a source of the latency samples.
+type SourceKind string
+
+// Source kinds.
+const (
+	// SourceKindUpstream is an upstream DNS server measured by the requests of
+	// the clients.
+	SourceKindUpstream SourceKind = "upstream"
+
+	// SourceKindReflector is a DNS server queried by the controller.
+	SourceKindReflector SourceKind = "reflector"
+)
+
+// SourceState is the health state of a source of the latency samples.
+type SourceState string
+
+// Source states.
+const (
+	// SourceStateHealthy means that the samples of the source are used.
+	SourceStateHealthy SourceState = "healthy"
+
+	// SourceStateUnreliable means that the samples of the source are still
+	// used, but the source will be excluded if it stays unreliable.
+	SourceStateUnreliable SourceState = "unreliable"
+
+	// SourceStateExcluded means that the samples of the source are ignored.
+	SourceStateExcluded SourceState = "excluded"
+)
+
+// SourceStatus is the health of a source of the latency samples.  All
+// durations are in microseconds.
+type SourceStatus struct {
+	// ExcludedUntil is the time the exclusion of the source ends, if it is
+	// excluded.
+	ExcludedUntil *time.Time `json:"excludedUntil,omitempty"`
+
+	// Name is the address of the source.
+	Name string `json:"name"`
+
+	// Kind is the kind of the source.
+	Kind SourceKind `json:"kind"`
+
+	// State is the health state of the source.
+	State SourceState `json:"state"`
+
+	// Reason is the worst part of the score: "loss", "jitter", or
+	// "deviation".  It is empty if the source hasn't been scored yet.
+	Reason string `json:"reason,omitempty"`
+
+	// Score is the health of the source from 0, unreliable, to 1.
+	Score float64 `json:"score"`
+
+	// RTT is the latest latency sample.
+	RTT int64 `json:"rtt"`
+
+	// Baseline is the latency of the source without congestion.
+	Baseline int64 `json:"baseline"`
+
+	// Jitter is the average difference between the consecutive samples.
+	Jitter int64 `json:"jitter"`
+
+	// Deviation is the average increase of the latency over the baseline in
+	// excess of the one of the other sources.
+	Deviation int64 `json:"deviation"`
+
+	// Loss is the share of the lost queries from 0 to 1.
+	Loss float64 `json:"loss"`
+
+	// Samples is the number of the samples received from the source.
+	Samples uint64 `json:"samples"`
+}
+
+// ewma returns the exponentially weighted moving average of prev with v.
+func ewma(prev, v, alpha float64) (avg float64) {
+	return prev + alpha*(v-prev)
+}
+
+// source is the health of a single source of the latency samples.  All
+// durations are in nanoseconds.
+type source struct {
+	// lastSeen is the time of the latest sample.
+	lastSeen time.Time
+
+	// unreliableSince is the time the score has dropped to zero.  It is zero
+	// if the source is reliable.
+	unreliableSince time.Time
+
+	// excludedUntil is the time the exclusion ends.  It is zero if the source
+	// isn't excluded.
+	excludedUntil time.Time
+
+	kind SourceKind
+
+	// reason is the worst part of the latest score.
+	reason string
+
+	rtt       float64
+	baseline  float64
+	delta     float64
+	jitter    float64
+	loss      float64
+	deviation float64
+	score     float64
+
+	samples uint64
+}
+
+// addRTT adds a latency sample.
+func (s *source) addRTT(rtt time.Duration, now time.Time) {
+	v := float64(rtt)
+	if s.rtt == 0 {
+		s.baseline = v
+	} else {
+		s.jitter = ewma(s.jitter, abs(v-s.rtt), jitterAlpha)
+	}
+
+	alpha := baselineUpAlpha
+	if v < s.baseline {
+		alpha = baselineDownAlpha
+	}
+
+	s.baseline = ewma(s.baseline, v, alpha)
+	s.delta = ewma(s.delta, v-s.baseline, deltaAlpha)
+	s.loss = ewma(s.loss, 0, lossAlpha)
+	s.rtt = v
+	s.samples++
+	s.lastSeen = now
+}
+
+// addLoss adds a lost query.
+func (s *source) addLoss(now time.Time) {
+	s.loss = ewma(s.loss, 1, lossAlpha)
+	s.samples++
+	s.lastSeen = now
+}
+
+// excluded returns true if the source is excluded at now.
+func (s *source) excluded(now time.Time) (ok bool) {
+	return now.Before(s.excludedUntil)
+}
+
+// abs returns the absolute value of v.
+func abs(v float64) (a float64) {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
+
+// sourceTracker scores the sources of the latency samples and excludes the
+// unreliable ones.  It isn't safe for concurrent use.
+type sourceTracker struct {
+	sources map[string]*source
+
+	maxLoss      float64
+	maxJitter    time.Duration
+	maxDeviation time.Duration
+	excludeAfter time.Duration
+	excludeFor   time.Duration
+}
+
+// newSourceTracker returns a new tracker using the limits from conf, which may
+// be nil.
+func newSourceTracker(conf *SourceConfig) (t *sourceTracker) {
+	if conf == nil {
+		conf = &SourceConfig{}
+	}
+
+	return &sourceTracker{
+		sources:      map[string]*source{},
+		maxLoss:      cmp.Or(conf.MaxLoss, defaultMaxLoss),
+		maxJitter:    cmp.Or(conf.MaxJitter.Duration, defaultMaxJitter),
+		maxDeviation: cmp.Or(conf.MaxDeviation.Duration, defaultMaxDeviation),
+		excludeAfter: cmp.Or(conf.ExcludeAfter.Duration, defaultExcludeAfter),
+		excludeFor:   cmp.Or(conf.ExcludeFor.Duration, defaultExcludeFor),
+	}
+}
+
+// get returns the source with name creating it if necessary.
+func (t *sourceTracker) get(name string, kind SourceKind) (s *source) {
+	s = t.sources[name]
+	if s == nil {
+		s = &source{kind: kind, score: 1}
+		t.sources[name] = s
+	}
+
+	return s
+}
+
+// sourceChange is a change of the exclusion of a source for the journal.
+type sourceChange struct {
+	ev  JournalEvent
+	msg string
+}
+
+// observe adds the latency sample from the source with name at now.  ok is
+// false if the sample must be ignored.  ch is not nil if the source has been
+// excluded or restored.
+func (t *sourceTracker) observe(
+	name string,
+	kind SourceKind,
+	rtt time.Duration,
+	now time.Time,
+) (ok bool, ch *sourceChange) {
+	s := t.get(name, kind)
+	s.addRTT(rtt, now)
+	ch = t.evaluate(name, s, now)
+
+	return !s.excluded(now), ch
+}
+
+// lose adds a lost query to the source with name at now.  ch is not nil if the
+// source has been excluded or restored.
+func (t *sourceTracker) lose(name string, kind SourceKind, now time.Time) (ch *sourceChange) {
+	s := t.get(name, kind)
+	s.addLoss(now)
+
+	return t.evaluate(name, s, now)
+}
+
+// evaluate scores s and excludes or restores it.
+func (t *sourceTracker) evaluate(name string, s *source, now time.Time) (ch *sourceChange) {
+	t.score(name, s, now)
+
+	if !s.excludedUntil.IsZero() {
+		if s.excluded(now) {
+			return nil
+		}
+
+		// Give the source another chance.
+		s.excludedUntil, s.unreliableSince = time.Time{}, time.Time{}
+
+		return &sourceChange{ev: JournalEventSourceRestored, msg: name}
+	}
+
+	if s.score > 0 {
+		s.unreliableSince = time.Time{}
+
+		return nil
+	}
+
+	if s.unreliableSince.IsZero() {
+		s.unreliableSince = now
+	}
+
+	if now.Sub(s.unreliableSince) < t.excludeAfter || !t.hasOthers(name, now) {
+		return nil
+	}
+
+	s.excludedUntil = now.Add(t.excludeFor)
+
+	return &sourceChange{
+		ev:  JournalEventSourceExcluded,
+		msg: fmt.Sprintf("%s: %s", name, s.reason),
+	}
+}
+
+// hasOthers returns true if there are recently seen sources other than the
+// one with name that aren't excluded at now, so that the latency samples keep
+// coming without it.
+func (t *sourceTracker) hasOthers(name string, now time.Time) (ok bool) {
+	for n, s := range t.sources {
+		if n != name && !s.excluded(now) && now.Sub(s.lastSeen) < t.excludeFor {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scorePart is a value of a source relative to its limit.
+type scorePart struct {
+	name string
+	val  float64
+}
+
+// score updates the score of s, which is the smallest margin to any of the
+// limits.  The deviation is taken relative to the other scored sources, since
+// the real congestion increases the latency of all of them.
+func (t *sourceTracker) score(name string, s *source, now time.Time) {
+	if s.samples < minSourceSamples {
+		return
+	}
+
+	minDelta, found := 0.0, false
+	for n, o := range t.sources {
+		if n == name || o.samples < minSourceSamples || o.excluded(now) {
+			continue
+		}
+
+		if !found || o.delta < minDelta {
+			minDelta, found = o.delta, true
+		}
+	}
+
+	s.deviation = 0
+	if found {
+		s.deviation = max(s.delta-minDelta, 0)
+	}
+
+	parts := []scorePart{
+		{name: "loss", val: s.loss / t.maxLoss},
+		{name: "jitter", val: s.jitter / float64(t.maxJitter)},
+		{name: "deviation", val: s.deviation / float64(t.maxDeviation)},
+	}
+
+	worst := slices.MaxFunc(parts, func(a, b scorePart) (res int) {
+		return cmp.Compare(a.val, b.val)
+	})
+
+	s.score = max(1-worst.val, 0)
+	s.reason = worst.name
+}
+
+// status returns the health of all sources at now sorted by name.
+func (t *sourceTracker) status(now time.Time) (sources []*SourceStatus) {
+	sources = make([]*SourceStatus, 0, len(t.sources))
+	names := maps.Keys(t.sources)
+	slices.Sort(names)
+
+	for _, name := range names {
+		s := t.sources[name]
+		st := &SourceStatus{
+			Name:      name,
+			Kind:      s.kind,
+			State:     SourceStateHealthy,
+			Score:     s.score,
+			RTT:       nsToUs(s.rtt),
+			Baseline:  nsToUs(s.baseline),
+			Jitter:    nsToUs(s.jitter),
+			Deviation: nsToUs(s.deviation),
+			Loss:      s.loss,
+			Samples:   s.samples,
+		}
+
+		if s.samples >= minSourceSamples {
+			st.Reason = s.reason
+		}
+
+		if s.excluded(now) {
+			until := s.excludedUntil
+			st.ExcludedUntil = &until
+			st.State = SourceStateExcluded
+		} else if !s.unreliableSince.IsZero() {
+			st.State = SourceStateUnreliable
+		}
+
+		sources = append(sources, st)
+	}
+
+	return sources
+}
+
+// nsToUs converts the duration in nanoseconds to microseconds.
+func nsToUs(ns float64) (us int64) {
+	return time.Duration(ns).Microseconds()
+}
+
+// observeSource adds the latency sample from the source with name at now and
+// returns false if it must be ignored.  c.mu is expected to be locked.
+func (c *Controller) observeSource(
+	name string,
+	kind SourceKind,
+	rtt time.Duration,
+	now time.Time,
+) (ok bool) {
+	ok, ch := c.sources.observe(name, kind, rtt, now)
+	c.journalSource(ch)
+
+	return ok
+}
+
+// journalSource adds the journal entry for ch, which may be nil.  c.mu is
+// expected to be locked.
+func (c *Controller) journalSource(ch *sourceChange) {
+	if ch == nil {
+		return
+	}
+
+	log.Info("cake: rtt source %s: %s", ch.ev, ch.msg)
+	c.addJournal(ch.ev, ch.msg)
+}
+
+// reflectFunc queries the reflector at addr and returns the round-trip time.
+type reflectFunc func(ctx context.Context, addr string) (rtt time.Duration, err error)
+
+// newDNSReflect returns a reflectFunc that sends a DNS query over UDP and
+// waits for the response at most timeout.  Any response counts, since only the
+// latency matters.
+func newDNSReflect(timeout time.Duration) (f reflectFunc) {
+	cli := &dns.Client{
+		Net:     "udp",
+		Timeout: timeout,
+	}
+
+	return func(ctx context.Context, addr string) (rtt time.Duration, err error) {
+		req := (&dns.Msg{}).SetQuestion(".", dns.TypeNS)
+		_, rtt, err = cli.ExchangeContext(ctx, req, addr)
+
+		return rtt, err
+	}
+}
+
+// reflectLoop queries the reflectors every interval until ctx is canceled.  It
+// is intended to be used as a goroutine.
+func (c *Controller) reflectLoop(ctx context.Context) {
+	defer log.OnPanic("cake: reflectors")
+	defer c.wg.Done()
+
+	ivl := cmp.Or(c.conf.Sources.ReflectorInterval.Duration, defaultReflectorInterval)
+	t := time.NewTicker(ivl)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c.queryReflectors(ctx)
+		}
+	}
+}
+
+// queryReflectors queries all reflectors in parallel and adds the samples.
+func (c *Controller) queryReflectors(ctx context.Context) {
+	wg := &sync.WaitGroup{}
+	for _, addr := range c.conf.Sources.Reflectors {
+		wg.Add(1)
+		go func() {
+			defer log.OnPanic("cake: reflector")
+			defer wg.Done()
+
+			rtt, err := c.reflect(ctx, addr)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			c.observeReflector(addr, rtt, err, time.Now())
+		}()
+	}
+
+	wg.Wait()
+}
+
+// observeReflector adds the result of a query to the reflector at addr.
+func (c *Controller) observeReflector(addr string, rtt time.Duration, err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		log.Debug("cake: querying reflector %s: %s", addr, err)
+		c.journalSource(c.sources.lose(addr, SourceKindReflector, now))
+
+		return
+	}
+
+	if c.observeSource(addr, SourceKindReflector, rtt, now) {
+		c.observeRTT(rtt)
+	}
+}
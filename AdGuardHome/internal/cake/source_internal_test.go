@@ -0,0 +1,215 @@
+package cake
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSourceConf is the configuration of the sources used in tests.
+var testSourceConf = &SourceConfig{
+	ExcludeAfter: timeutil.Duration{Duration: 1 * time.Second},
+	ExcludeFor:   timeutil.Duration{Duration: 1 * time.Minute},
+}
+
+// testRTTs returns n latency samples alternating between a and b.
+func testRTTs(n int, a, b time.Duration) (rtts []time.Duration) {
+	for i := range n {
+		if i%2 == 0 {
+			rtts = append(rtts, a)
+		} else {
+			rtts = append(rtts, b)
+		}
+	}
+
+	return rtts
+}
+
+func TestSourceTracker_observe(t *testing.T) {
+	const (
+		goodRTT = 20 * time.Millisecond
+		badRTT  = 100 * time.Millisecond
+	)
+
+	testCases := []struct {
+		name       string
+		kind       SourceKind
+		wantReason string
+		wantState  SourceState
+		rtts       []time.Duration
+		lost       int
+		withOthers bool
+	}{{
+		name:       "healthy",
+		kind:       SourceKindUpstream,
+		wantReason: "jitter",
+		wantState:  SourceStateHealthy,
+		rtts:       testRTTs(40, goodRTT, goodRTT+time.Millisecond),
+		lost:       0,
+		withOthers: true,
+	}, {
+		name:       "jitter",
+		kind:       SourceKindUpstream,
+		wantReason: "jitter",
+		wantState:  SourceStateExcluded,
+		rtts:       testRTTs(40, goodRTT, badRTT),
+		lost:       0,
+		withOthers: true,
+	}, {
+		name:       "deviation",
+		kind:       SourceKindUpstream,
+		wantReason: "deviation",
+		wantState:  SourceStateExcluded,
+		rtts:       append(testRTTs(20, goodRTT, goodRTT), testRTTs(60, badRTT, badRTT)...),
+		lost:       0,
+		withOthers: true,
+	}, {
+		name:       "loss",
+		kind:       SourceKindReflector,
+		wantReason: "loss",
+		wantState:  SourceStateExcluded,
+		rtts:       testRTTs(10, goodRTT, goodRTT),
+		lost:       30,
+		withOthers: true,
+	}, {
+		name:       "last_source",
+		kind:       SourceKindUpstream,
+		wantReason: "jitter",
+		wantState:  SourceStateUnreliable,
+		rtts:       testRTTs(40, goodRTT, badRTT),
+		lost:       0,
+		withOthers: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := newSourceTracker(testSourceConf)
+			now := time.Now()
+
+			var changes []*sourceChange
+			step := func() {
+				now = now.Add(100 * time.Millisecond)
+				if tc.withOthers {
+					_, _ = tr.observe("good1", SourceKindUpstream, goodRTT, now)
+					_, _ = tr.observe("good2", SourceKindUpstream, goodRTT+time.Millisecond, now)
+				}
+			}
+
+			for _, rtt := range tc.rtts {
+				step()
+				_, ch := tr.observe("bad", tc.kind, rtt, now)
+				if ch != nil {
+					changes = append(changes, ch)
+				}
+			}
+
+			for range tc.lost {
+				step()
+				if ch := tr.lose("bad", tc.kind, now); ch != nil {
+					changes = append(changes, ch)
+				}
+			}
+
+			sources := tr.status(now)
+			i := slices.IndexFunc(sources, func(s *SourceStatus) (ok bool) { return s.Name == "bad" })
+			require.GreaterOrEqual(t, i, 0)
+
+			st := sources[i]
+			assert.Equal(t, tc.kind, st.Kind)
+			assert.Equal(t, tc.wantState, st.State)
+			assert.Equal(t, tc.wantReason, st.Reason)
+
+			if tc.wantState != SourceStateExcluded {
+				assert.Empty(t, changes)
+				assert.Nil(t, st.ExcludedUntil)
+
+				return
+			}
+
+			require.Len(t, changes, 1)
+			assert.Equal(t, JournalEventSourceExcluded, changes[0].ev)
+			assert.Equal(t, "bad: "+tc.wantReason, changes[0].msg)
+			assert.Zero(t, st.Score)
+			require.NotNil(t, st.ExcludedUntil)
+
+			// The samples of the excluded source are ignored until the
+			// exclusion ends.
+			ok, ch := tr.observe("bad", tc.kind, goodRTT, now)
+			assert.False(t, ok)
+			assert.Nil(t, ch)
+
+			ok, ch = tr.observe("bad", tc.kind, goodRTT, *st.ExcludedUntil)
+			assert.True(t, ok)
+			require.NotNil(t, ch)
+
+			assert.Equal(t, JournalEventSourceRestored, ch.ev)
+			assert.Equal(t, "bad", ch.msg)
+		})
+	}
+}
+
+func TestController_ObserveLatency_excluded(t *testing.T) {
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+		Sources:         testSourceConf,
+	})
+
+	now := time.Now()
+	c.sources.get("9.9.9.9:53", SourceKindUpstream)
+	c.sources.get("1.1.1.1:53", SourceKindUpstream).excludedUntil = now.Add(time.Minute)
+	c.sources.get("9.9.9.9:53", SourceKindUpstream).lastSeen = now
+
+	c.ObserveLatency("1.1.1.1:53", 200*time.Millisecond, false)
+	assert.Equal(t, internetRTT, c.rtt)
+	assert.False(t, c.bloated)
+
+	c.ObserveLatency("9.9.9.9:53", 200*time.Millisecond, false)
+	assert.Equal(t, 200*time.Millisecond, c.rtt)
+	assert.True(t, c.bloated)
+
+	s := c.status()
+	require.Len(t, s.Sources, 2)
+
+	assert.Equal(t, "1.1.1.1:53", s.Sources[0].Name)
+	assert.Equal(t, SourceStateExcluded, s.Sources[0].State)
+	assert.Equal(t, SourceStateHealthy, s.Sources[1].State)
+}
+
+func TestController_queryReflectors(t *testing.T) {
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+		Sources: &SourceConfig{
+			Reflectors: []string{"192.0.2.1:53", "192.0.2.2:53"},
+		},
+	})
+
+	c.reflect = func(_ context.Context, addr string) (rtt time.Duration, err error) {
+		if addr == "192.0.2.2:53" {
+			return 0, errors.Error("timeout")
+		}
+
+		return 150 * time.Millisecond, nil
+	}
+
+	c.queryReflectors(context.Background())
+
+	assert.Equal(t, 150*time.Millisecond, c.rtt)
+
+	s := c.status()
+	require.Len(t, s.Sources, 2)
+
+	assert.Equal(t, SourceKindReflector, s.Sources[0].Kind)
+	assert.Equal(t, int64(150_000), s.Sources[0].RTT)
+	assert.Zero(t, s.Sources[0].Loss)
+	assert.Equal(t, lossAlpha, s.Sources[1].Loss)
+}
@@ -0,0 +1,94 @@
+package cake
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// maxSpeedtestDuration is the maximum duration of a single speedtest transfer.
+// It is less than the read and write timeouts of the web server.
+const maxSpeedtestDuration = 30 * time.Second
+
+// speedtestChunkSize is the size of a single write of the speedtest source.
+const speedtestChunkSize = 64 * 1024
+
+// RegisterSpeedtest registers the handlers of the speedtest endpoints, which a
+// peer instance uses to probe the capacity of the link between them:
+//
+//   - GET /control/cake/speedtest/download, the source, sends the data for the
+//     duration from the query.
+//   - POST /control/cake/speedtest/upload, the sink, receives the data and
+//     responds with its size.
+//
+// reg must not be nil.
+func RegisterSpeedtest(reg aghhttp.RegisterFunc) {
+	reg(http.MethodGet, "/control/cake/speedtest/download", handleSpeedtestDownload)
+	reg(http.MethodPost, "/control/cake/speedtest/upload", handleSpeedtestUpload)
+}
+
+// handleSpeedtestDownload is the handler for the GET
+// /control/cake/speedtest/download HTTP API.
+func handleSpeedtestDownload(w http.ResponseWriter, r *http.Request) {
+	d := defaultProbeDuration
+	if s := r.URL.Query().Get("duration"); s != "" {
+		var err error
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "duration: %s", err)
+
+			return
+		}
+	}
+
+	if d <= 0 || d > maxSpeedtestDuration {
+		aghhttp.Error(
+			r,
+			w,
+			http.StatusBadRequest,
+			"duration: must be positive and not greater than %s, got %s",
+			maxSpeedtestDuration,
+			d,
+		)
+
+		return
+	}
+
+	w.Header().Set(httphdr.ContentType, aghhttp.HdrValApplicationOctetStream)
+	w.WriteHeader(http.StatusOK)
+
+	chunk := make([]byte, speedtestChunkSize)
+	end := time.Now().Add(d)
+	for time.Now().Before(end) {
+		_, err := w.Write(chunk)
+		if err != nil {
+			log.Debug("cake: speedtest: writing: %s", err)
+
+			return
+		}
+	}
+}
+
+// speedtestUploadResp is the response to the POST
+// /control/cake/speedtest/upload HTTP API.
+type speedtestUploadResp struct {
+	// Bytes is the number of bytes received.
+	Bytes int64 `json:"bytes"`
+}
+
+// handleSpeedtestUpload is the handler for the POST
+// /control/cake/speedtest/upload HTTP API.
+func handleSpeedtestUpload(w http.ResponseWriter, r *http.Request) {
+	n, err := io.Copy(io.Discard, r.Body)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading body: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &speedtestUploadResp{Bytes: n})
+}
@@ -0,0 +1,465 @@
+package cake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Limits and intervals of the status collection.
+const (
+	// statusInterval is the interval between the collections of the tin
+	// statistics and the history points.
+	statusInterval = 1 * time.Second
+
+	// historySize is the maximum number of the history points kept, which is
+	// five minutes with statusInterval.
+	historySize = 300
+
+	// journalSize is the maximum number of the journal entries kept.
+	journalSize = 50
+)
+
+// Status is the detailed state of the controller.
+type Status struct {
+	// Metrics are the latest metrics of the controller.
+	Metrics *Metrics `json:"metrics"`
+
+	// Params are the currently applied parameters of CAKE.
+	Params *ParamsStatus `json:"params"`
+
+	// Tins are the latest statistics of the tins of the shaped interfaces.
+	Tins *Tins `json:"tins"`
+
+	// Journal contains the latest notable reconfigurations, oldest first.
+	Journal []*JournalEntry `json:"journal"`
+
+	// History contains the latest samples of the latency and bandwidth,
+	// oldest first.
+	History []*HistoryPoint `json:"history"`
+
+	// Probe is the result of the latest link-capacity probe, if any.
+	Probe *ProbeResult `json:"probe,omitempty"`
+
+	// Sources is the health of the sources of the latency samples sorted by
+	// name.
+	Sources []*SourceStatus `json:"sources"`
+}
+
+// ParamsStatus are the parameters of CAKE applied by the controller.
+type ParamsStatus struct {
+	// UplinkInterface is the name of the uplink interface.
+	UplinkInterface string `json:"uplinkInterface"`
+
+	// DownlinkInterface is the name of the downlink IFB interface.
+	DownlinkInterface string `json:"downlinkInterface"`
+
+	// SplitGSO is the current split-gso setting.
+	SplitGSO string `json:"splitGSO"`
+
+	// DownlinkTins is the tin mode of the downlink, either "besteffort" or
+	// "diffserv4".
+	DownlinkTins string `json:"downlinkTins"`
+
+	// RTT is the current rtt parameter, in microseconds.
+	RTT int64 `json:"rtt"`
+
+	// BandwidthUpload is the current uplink bandwidth, in kbit/s.
+	BandwidthUpload float64 `json:"bandwidthUpload"`
+
+	// BandwidthDownload is the current downlink bandwidth, in kbit/s.
+	BandwidthDownload float64 `json:"bandwidthDownload"`
+
+	// MaxUpload is the current maximum uplink bandwidth, in kbit/s.
+	MaxUpload float64 `json:"maxUpload"`
+
+	// MaxDownload is the current maximum downlink bandwidth, in kbit/s.
+	MaxDownload float64 `json:"maxDownload"`
+
+	// MinUpload is the current minimum uplink bandwidth, in kbit/s.
+	MinUpload float64 `json:"minUpload"`
+
+	// MinDownload is the current minimum downlink bandwidth, in kbit/s.
+	MinDownload float64 `json:"minDownload"`
+
+	// Window is the name of the active shaping window or "default" outside
+	// of them.
+	Window string `json:"window"`
+
+	// Probing is true while the probe is measuring the link capacity and the
+	// bandwidth limits are lifted.
+	Probing bool `json:"probing"`
+}
+
+// Tins are the statistics of the tins per direction.
+type Tins struct {
+	Uplink   []*TinStats `json:"uplink"`
+	Downlink []*TinStats `json:"downlink"`
+}
+
+// TinStats are the statistics of a single tin of CAKE as reported by tc.
+type TinStats struct {
+	Name          string `json:"name"`
+	ThresholdRate uint64 `json:"thresholdRate"`
+	SentBytes     uint64 `json:"sentBytes"`
+	SentPackets   uint64 `json:"sentPackets"`
+	BacklogBytes  uint64 `json:"backlogBytes"`
+	Drops         uint64 `json:"drops"`
+	ECNMarks      uint64 `json:"ecnMarks"`
+	PeakDelay     uint64 `json:"peakDelayUs"`
+	AvgDelay      uint64 `json:"avgDelayUs"`
+	BaseDelay     uint64 `json:"baseDelayUs"`
+}
+
+// JournalEvent is the kind of a journal entry.
+type JournalEvent string
+
+// Journal events.
+const (
+	// JournalEventBufferbloat means that a latency increase has been detected
+	// and the bandwidth has been reduced.
+	JournalEventBufferbloat JournalEvent = "bufferbloat"
+
+	// JournalEventRecovered means that the bandwidth has been restored to its
+	// ceiling after a reduction.
+	JournalEventRecovered JournalEvent = "recovered"
+
+	// JournalEventSplitGSO means that the split-gso setting has changed.
+	JournalEventSplitGSO JournalEvent = "split-gso"
+
+	// JournalEventError means that the reconfiguration or the probe has
+	// failed.
+	JournalEventError JournalEvent = "error"
+
+	// JournalEventProbe means that the probe has measured the link capacity.
+	JournalEventProbe JournalEvent = "probe"
+
+	// JournalEventWindow means that another shaping window has become active.
+	// The message is the name of the window.
+	JournalEventWindow JournalEvent = "window"
+
+	// JournalEventSourceExcluded means that the samples of an unreliable RTT
+	// source are ignored.  The message is the name of the source and the
+	// reason.
+	JournalEventSourceExcluded JournalEvent = "source_excluded"
+
+	// JournalEventSourceRestored means that the samples of an RTT source are
+	// used again.  The message is the name of the source.
+	JournalEventSourceRestored JournalEvent = "source_restored"
+)
+
+// JournalEntry is a notable reconfiguration of CAKE.
+type JournalEntry struct {
+	// Time is the time of the reconfiguration.
+	Time time.Time `json:"time"`
+
+	// Event is the kind of the reconfiguration.
+	Event JournalEvent `json:"event"`
+
+	// SplitGSO is the split-gso setting after the reconfiguration.
+	SplitGSO string `json:"splitGSO"`
+
+	// Message is the error message, if any.
+	Message string `json:"message,omitempty"`
+
+	// RTT is the rtt parameter, in microseconds.
+	RTT int64 `json:"rtt"`
+
+	// BandwidthUpload is the uplink bandwidth, in kbit/s.
+	BandwidthUpload float64 `json:"bandwidthUpload"`
+
+	// BandwidthDownload is the downlink bandwidth, in kbit/s.
+	BandwidthDownload float64 `json:"bandwidthDownload"`
+}
+
+// HistoryPoint is a sample of the latency and bandwidth.
+type HistoryPoint struct {
+	// Time is the time of the sample.
+	Time time.Time `json:"time"`
+
+	// RTT is the rtt parameter, in microseconds.
+	RTT int64 `json:"rtt"`
+
+	// BandwidthUpload is the shaped uplink bandwidth, in kbit/s.
+	BandwidthUpload float64 `json:"bandwidthUpload"`
+
+	// BandwidthDownload is the shaped downlink bandwidth, in kbit/s.
+	BandwidthDownload float64 `json:"bandwidthDownload"`
+
+	// ThroughputUpload is the measured uplink throughput, in kbit/s.
+	ThroughputUpload float64 `json:"throughputUpload"`
+
+	// ThroughputDownload is the measured downlink throughput, in kbit/s.
+	ThroughputDownload float64 `json:"throughputDownload"`
+}
+
+// tcTin is the statistics of a tin in the JSON output of tc.
+type tcTin struct {
+	ThresholdRate uint64 `json:"threshold_rate"`
+	SentBytes     uint64 `json:"sent_bytes"`
+	SentPackets   uint64 `json:"sent_packets"`
+	BacklogBytes  uint64 `json:"backlog_bytes"`
+	Drops         uint64 `json:"drops"`
+	ECNMark       uint64 `json:"ecn_mark"`
+	PeakDelayUs   uint64 `json:"peak_delay_us"`
+	AvgDelayUs    uint64 `json:"avg_delay_us"`
+	BaseDelayUs   uint64 `json:"base_delay_us"`
+}
+
+// tcQdisc is a qdisc in the JSON output of tc.
+type tcQdisc struct {
+	Kind string   `json:"kind"`
+	Tins []*tcTin `json:"tins"`
+}
+
+// diffserv4Tins are the names of the tins in the diffserv4 mode in the order
+// reported by tc.
+var diffserv4Tins = []string{"Bulk", "Best Effort", "Video", "Voice"}
+
+// parseTinStats parses the output of "tc -s -j qdisc show" and returns the
+// statistics of the tins of the first CAKE qdisc.
+func parseTinStats(out []byte) (tins []*TinStats, err error) {
+	var qdiscs []*tcQdisc
+	err = json.Unmarshal(out, &qdiscs)
+	if err != nil {
+		return nil, fmt.Errorf("parsing tc output: %w", err)
+	}
+
+	i := slices.IndexFunc(qdiscs, func(q *tcQdisc) (ok bool) { return q.Kind == "cake" })
+	if i < 0 {
+		return nil, nil
+	}
+
+	tcTins := qdiscs[i].Tins
+	tins = make([]*TinStats, 0, len(tcTins))
+	for j, t := range tcTins {
+		name := fmt.Sprintf("Tin %d", j)
+		if len(tcTins) == len(diffserv4Tins) {
+			name = diffserv4Tins[j]
+		} else if len(tcTins) == 1 {
+			name = "Best Effort"
+		}
+
+		tins = append(tins, &TinStats{
+			Name:          name,
+			ThresholdRate: t.ThresholdRate,
+			SentBytes:     t.SentBytes,
+			SentPackets:   t.SentPackets,
+			BacklogBytes:  t.BacklogBytes,
+			Drops:         t.Drops,
+			ECNMarks:      t.ECNMark,
+			PeakDelay:     t.PeakDelayUs,
+			AvgDelay:      t.AvgDelayUs,
+			BaseDelay:     t.BaseDelayUs,
+		})
+	}
+
+	return tins, nil
+}
+
+// sentBytes returns the total number of bytes sent through tins.
+func sentBytes(tins []*TinStats) (n uint64) {
+	for _, t := range tins {
+		n += t.SentBytes
+	}
+
+	return n
+}
+
+// tinStats returns the statistics of the tins of CAKE on iface.
+func (c *Controller) tinStats(ctx context.Context, iface string) (tins []*TinStats) {
+	out, err := c.runCmd(ctx, "tc", "-s", "-j", "qdisc", "show", "dev", iface, "root")
+	if err == nil {
+		tins, err = parseTinStats(out)
+	}
+
+	if err != nil {
+		log.Debug("cake: getting tin stats of %q: %s", iface, err)
+	}
+
+	return tins
+}
+
+// sample collects the status of the controller every statusInterval until ctx
+// is canceled.  It is intended to be used as a goroutine.
+func (c *Controller) sample(ctx context.Context) {
+	defer log.OnPanic("cake: status")
+	defer c.wg.Done()
+
+	t := time.NewTicker(statusInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			c.collect(ctx, now)
+		}
+	}
+}
+
+// collect gathers the tin statistics, adds a history point, and sends the
+// status to the subscribers.
+func (c *Controller) collect(ctx context.Context, now time.Time) {
+	tins := &Tins{
+		Uplink:   c.tinStats(ctx, c.conf.UplinkInterface),
+		Downlink: c.tinStats(ctx, c.downlink),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := &HistoryPoint{
+		Time:              now,
+		RTT:               c.rtt.Microseconds(),
+		BandwidthUpload:   c.bwUL,
+		BandwidthDownload: c.bwDL,
+	}
+
+	if prev := c.tins; prev != nil && !c.lastSample.IsZero() {
+		secs := now.Sub(c.lastSample).Seconds()
+		p.ThroughputUpload = throughput(sentBytes(prev.Uplink), sentBytes(tins.Uplink), secs)
+		p.ThroughputDownload = throughput(sentBytes(prev.Downlink), sentBytes(tins.Downlink), secs)
+	}
+
+	c.tins = tins
+	c.lastSample = now
+	c.history = appendBounded(c.history, p, historySize)
+
+	if len(c.subs) == 0 {
+		return
+	}
+
+	// Only send the new point to the subscribers, since they already have the
+	// previous ones.
+	s := c.statusLocked()
+	s.History = []*HistoryPoint{p}
+	for sub := range c.subs {
+		select {
+		case sub <- s:
+		default:
+			// The subscriber is too slow, so skip the update.
+		}
+	}
+}
+
+// throughput returns the throughput in kbit/s from the byte counters.  It
+// returns zero if the counter has been reset.
+func throughput(prev, cur uint64, secs float64) (bw float64) {
+	if cur < prev || secs <= 0 {
+		return 0
+	}
+
+	return float64(cur-prev) * 8 / 1000 / secs
+}
+
+// appendBounded appends v to s and drops the oldest elements so that s has at
+// most limit elements.
+func appendBounded[T any](s []T, v T, limit int) (res []T) {
+	s = append(s, v)
+	if len(s) > limit {
+		s = slices.Delete(s, 0, len(s)-limit)
+	}
+
+	return s
+}
+
+// addJournal adds an entry for ev with the current parameters.  c.mu is
+// expected to be locked.
+func (c *Controller) addJournal(ev JournalEvent, msg string) {
+	c.journal = appendBounded(c.journal, &JournalEntry{
+		Time:              time.Now(),
+		Event:             ev,
+		SplitGSO:          c.splitGSO,
+		Message:           msg,
+		RTT:               c.rtt.Microseconds(),
+		BandwidthUpload:   c.bwUL,
+		BandwidthDownload: c.bwDL,
+	}, journalSize)
+}
+
+// journalError adds an entry for the reconfiguration error, unless the
+// previous iteration has failed with the same error.  A nil err resets the
+// last error.  c.mu is expected to be locked.
+func (c *Controller) journalError(err error) {
+	if err == nil {
+		c.lastErr = ""
+
+		return
+	}
+
+	msg := err.Error()
+	if msg != c.lastErr {
+		c.lastErr = msg
+		c.addJournal(JournalEventError, msg)
+	}
+}
+
+// status returns the current status of the controller.
+func (c *Controller) status() (s *Status) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.statusLocked()
+}
+
+// statusLocked returns the current status of the controller.  c.mu is expected
+// to be locked.
+func (c *Controller) statusLocked() (s *Status) {
+	dlTins := "besteffort"
+	if c.nft != nil {
+		dlTins = "diffserv4"
+	}
+
+	tins := c.tins
+	if tins == nil {
+		tins = &Tins{Uplink: []*TinStats{}, Downlink: []*TinStats{}}
+	}
+
+	return &Status{
+		Metrics: c.metrics,
+		Params: &ParamsStatus{
+			UplinkInterface:   c.conf.UplinkInterface,
+			DownlinkInterface: c.downlink,
+			SplitGSO:          c.splitGSO,
+			DownlinkTins:      dlTins,
+			RTT:               c.rtt.Microseconds(),
+			BandwidthUpload:   c.bwUL,
+			BandwidthDownload: c.bwDL,
+			MaxUpload:         c.lim.maxUL,
+			MaxDownload:       c.lim.maxDL,
+			MinUpload:         c.lim.minUL,
+			MinDownload:       c.lim.minDL,
+			Window:            c.window.name(),
+			Probing:           c.probing,
+		},
+		Probe:   c.probeResult,
+		Sources: c.sources.status(time.Now()),
+		Tins:    tins,
+		Journal: slices.Clone(c.journal),
+		History: slices.Clone(c.history),
+	}
+}
+
+// subscribe returns a channel that receives the status updates and a function
+// that unsubscribes from them.
+func (c *Controller) subscribe() (updates <-chan *Status, unsubscribe func()) {
+	ch := make(chan *Status, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subs[ch] = struct{}{}
+
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		delete(c.subs, ch)
+	}
+}
@@ -0,0 +1,125 @@
+package cake
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTCOutput is a shortened output of "tc -s -j qdisc show" for a CAKE qdisc
+// in the diffserv4 mode.
+const testTCOutput = `[{"kind":"cake","handle":"8001:","root":true,"tins":[` +
+	`{"threshold_rate":625000,"sent_bytes":1000,"sent_packets":10,"drops":1,"ecn_mark":2,"peak_delay_us":30},` +
+	`{"threshold_rate":10000000,"sent_bytes":2000,"sent_packets":20,"avg_delay_us":40},` +
+	`{"threshold_rate":5000000,"sent_bytes":3000,"sent_packets":30},` +
+	`{"threshold_rate":2500000,"sent_bytes":4000,"sent_packets":40,"base_delay_us":5}]}]`
+
+func TestParseTinStats(t *testing.T) {
+	tins, err := parseTinStats([]byte(testTCOutput))
+	require.NoError(t, err)
+	require.Len(t, tins, 4)
+
+	assert.Equal(t, &TinStats{
+		Name:          "Bulk",
+		ThresholdRate: 625000,
+		SentBytes:     1000,
+		SentPackets:   10,
+		Drops:         1,
+		ECNMarks:      2,
+		PeakDelay:     30,
+	}, tins[0])
+	assert.Equal(t, "Voice", tins[3].Name)
+	assert.Equal(t, uint64(10_000), sentBytes(tins))
+
+	tins, err = parseTinStats([]byte(`[{"kind":"fq_codel"}]`))
+	require.NoError(t, err)
+
+	assert.Empty(t, tins)
+
+	_, err = parseTinStats([]byte(`{`))
+	assert.Error(t, err)
+}
+
+func TestController_collect(t *testing.T) {
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+	})
+
+	sent := uint64(0)
+	c.runCmd = func(_ context.Context, _ string, _ ...string) (out []byte, err error) {
+		sent += 125_000
+
+		return []byte(`[{"kind":"cake","tins":[{"sent_bytes":` + strconv.FormatUint(sent, 10) + `}]}]`), nil
+	}
+
+	updates, unsubscribe := c.subscribe()
+	defer unsubscribe()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	c.collect(ctx, now)
+	<-updates
+
+	c.collect(ctx, now.Add(time.Second))
+	s := <-updates
+
+	require.Len(t, s.History, 1)
+
+	// Each direction has sent 250 000 bytes, which is 2 000 kbit, since the
+	// previous collection.
+	assert.Equal(t, 2_000.0, s.History[0].ThroughputUpload)
+	assert.Equal(t, 2_000.0, s.History[0].ThroughputDownload)
+	assert.Equal(t, "Best Effort", s.Tins.Uplink[0].Name)
+
+	full := c.status()
+	assert.Len(t, full.History, 2)
+	assert.Equal(t, testMaxBW, full.Params.MaxUpload)
+}
+
+func TestController_journal(t *testing.T) {
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+	})
+
+	ctx := context.Background()
+
+	c.ObserveLatency("1.1.1.1:53", 200*time.Millisecond, false)
+	c.iterate(ctx)
+
+	c.journalError(assert.AnError)
+	c.journalError(assert.AnError)
+
+	s := c.status()
+	require.Len(t, s.Journal, 2)
+
+	assert.Equal(t, JournalEventBufferbloat, s.Journal[0].Event)
+	assert.Equal(t, JournalEventError, s.Journal[1].Event)
+	assert.Equal(t, assert.AnError.Error(), s.Journal[1].Message)
+
+	for range 100 {
+		c.iterate(ctx)
+	}
+
+	s = c.status()
+	require.Len(t, s.Journal, 3)
+
+	assert.Equal(t, JournalEventRecovered, s.Journal[2].Event)
+}
+
+func TestAppendBounded(t *testing.T) {
+	var s []int
+	for i := range 5 {
+		s = appendBounded(s, i, 3)
+	}
+
+	assert.Equal(t, []int{2, 3, 4}, s)
+}
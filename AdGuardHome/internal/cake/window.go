@@ -0,0 +1,206 @@
+package cake
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// Defaults of the shaping windows.
+const (
+	// defaultAggressiveness is the fraction of the bandwidth removed when a
+	// bufferbloat is detected.
+	defaultAggressiveness = 0.5
+
+	// defaultWindowTransition is the default duration of the switch between
+	// the limits of the shaping windows.
+	defaultWindowTransition = 1 * time.Minute
+)
+
+// windowNameDefault is the name of the limits used outside of any shaping
+// window in the journal.
+const windowNameDefault = "default"
+
+// ShapingWindow is a time window with its own bandwidth limits and
+// aggressiveness of the controller.
+type ShapingWindow struct {
+	// Schedule defines when the window is active.  It must not be nil.
+	Schedule *schedule.Weekly `yaml:"schedule"`
+
+	// Name is the name of the window shown in the status and the journal.
+	Name string `yaml:"name"`
+
+	// MaxUL is the maximum uplink bandwidth within the window, in kbit/s.  If
+	// zero, the maximum of the controller is used.
+	MaxUL float64 `yaml:"max_ul"`
+
+	// MaxDL is the maximum downlink bandwidth within the window, in kbit/s.
+	// If zero, the maximum of the controller is used.
+	MaxDL float64 `yaml:"max_dl"`
+
+	// MinUL is the uplink bandwidth the controller never goes below within the
+	// window, in kbit/s.  If zero, the minimum of the controller is used.
+	MinUL float64 `yaml:"min_ul"`
+
+	// MinDL is the downlink bandwidth the controller never goes below within
+	// the window, in kbit/s.  If zero, the minimum of the controller is used.
+	MinDL float64 `yaml:"min_dl"`
+
+	// Aggressiveness is the fraction of the bandwidth removed when a
+	// bufferbloat is detected, from 0 to 1 exclusive.  If zero,
+	// defaultAggressiveness is used.
+	Aggressiveness float64 `yaml:"aggressiveness"`
+}
+
+// validate returns an error if w is not valid.
+func (w *ShapingWindow) validate() (err error) {
+	switch {
+	case w == nil:
+		return errors.Error("no window")
+	case w.Schedule == nil:
+		return errors.Error("no schedule")
+	case w.MaxUL < 0:
+		return fmt.Errorf("max_ul: must not be negative, got %v", w.MaxUL)
+	case w.MaxDL < 0:
+		return fmt.Errorf("max_dl: must not be negative, got %v", w.MaxDL)
+	case w.MinUL < 0:
+		return fmt.Errorf("min_ul: must not be negative, got %v", w.MinUL)
+	case w.MinDL < 0:
+		return fmt.Errorf("min_dl: must not be negative, got %v", w.MinDL)
+	case w.MaxUL > 0 && w.MinUL > w.MaxUL:
+		return fmt.Errorf("min_ul: must not be greater than max_ul %v, got %v", w.MaxUL, w.MinUL)
+	case w.MaxDL > 0 && w.MinDL > w.MaxDL:
+		return fmt.Errorf("min_dl: must not be greater than max_dl %v, got %v", w.MaxDL, w.MinDL)
+	case w.Aggressiveness < 0 || w.Aggressiveness >= 1:
+		return fmt.Errorf("aggressiveness: must be from 0 to 1 exclusive, got %v", w.Aggressiveness)
+	default:
+		return nil
+	}
+}
+
+// name returns the name of w for the journal.  w may be nil.
+func (w *ShapingWindow) name() (n string) {
+	if w == nil {
+		return windowNameDefault
+	} else if w.Name == "" {
+		return "unnamed"
+	}
+
+	return w.Name
+}
+
+// limits are the bandwidth limits and the aggressiveness of the controller.
+// All bandwidth values are in kbit/s.
+type limits struct {
+	maxUL          float64
+	maxDL          float64
+	minUL          float64
+	minDL          float64
+	aggressiveness float64
+}
+
+// lerp returns the limits between l and to at progress, which is from 0 to 1.
+func (l limits) lerp(to limits, progress float64) (res limits) {
+	mix := func(a, b float64) (v float64) { return a + (b-a)*progress }
+
+	return limits{
+		maxUL:          mix(l.maxUL, to.maxUL),
+		maxDL:          mix(l.maxDL, to.maxDL),
+		minUL:          mix(l.minUL, to.minUL),
+		minDL:          mix(l.minDL, to.minDL),
+		aggressiveness: mix(l.aggressiveness, to.aggressiveness),
+	}
+}
+
+// activeWindow returns the first shaping window active at now or nil if there
+// is none.
+func (c *Controller) activeWindow(now time.Time) (w *ShapingWindow) {
+	for _, w = range c.conf.Windows {
+		if w.Schedule.Contains(now) {
+			return w
+		}
+	}
+
+	return nil
+}
+
+// windowLimits returns the limits within w, which may be nil.  c.mu is
+// expected to be locked.
+func (c *Controller) windowLimits(w *ShapingWindow) (l limits) {
+	l = limits{
+		maxUL:          c.maxUL,
+		maxDL:          c.maxDL,
+		minUL:          min(c.conf.MinUL, c.maxUL),
+		minDL:          min(c.conf.MinDL, c.maxDL),
+		aggressiveness: defaultAggressiveness,
+	}
+
+	if w == nil {
+		return l
+	}
+
+	if w.MaxUL > 0 {
+		l.maxUL = w.MaxUL
+	}
+
+	if w.MaxDL > 0 {
+		l.maxDL = w.MaxDL
+	}
+
+	if w.Aggressiveness > 0 {
+		l.aggressiveness = w.Aggressiveness
+	}
+
+	if w.MinUL > 0 {
+		l.minUL = w.MinUL
+	}
+
+	if w.MinDL > 0 {
+		l.minDL = w.MinDL
+	}
+
+	l.minUL = min(l.minUL, l.maxUL)
+	l.minDL = min(l.minDL, l.maxDL)
+
+	return l
+}
+
+// limitsAt returns the limits at now, moving them from the previous ones to
+// the target ones during the transition.  c.mu is expected to be locked.
+func (c *Controller) limitsAt(now time.Time) (l limits) {
+	transition := c.conf.WindowTransition.Duration
+	if transition == 0 {
+		transition = defaultWindowTransition
+	}
+
+	elapsed := now.Sub(c.switchedAt)
+	if elapsed >= transition || elapsed < 0 {
+		return c.limTo
+	}
+
+	return c.limFrom.lerp(c.limTo, float64(elapsed)/float64(transition))
+}
+
+// updateLimits switches to the shaping window active at now, if necessary, and
+// returns the current limits.  c.mu is expected to be locked.
+func (c *Controller) updateLimits(now time.Time) (l limits) {
+	w := c.activeWindow(now)
+	if w != c.window {
+		c.window = w
+		c.addJournal(JournalEventWindow, w.name())
+	}
+
+	// The target limits also change when the probe replaces the maximums.
+	target := c.windowLimits(w)
+	if target != c.limTo {
+		c.limFrom = c.limitsAt(now)
+		c.limTo = target
+		c.switchedAt = now
+	}
+
+	c.lim = c.limitsAt(now)
+
+	return c.lim
+}
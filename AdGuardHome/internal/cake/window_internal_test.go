@@ -0,0 +1,182 @@
+package cake
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// newTestWeekly returns a weekly schedule active on Mondays from 18:00 to
+// 23:00 UTC.
+func newTestWeekly(t *testing.T) (w *schedule.Weekly) {
+	t.Helper()
+
+	w = &schedule.Weekly{}
+	err := yaml.Unmarshal([]byte(`
+time_zone: UTC
+mon:
+  start: 18h
+  end: 23h
+`), w)
+	require.NoError(t, err)
+
+	return w
+}
+
+// testMonday is a Monday used in the window tests.
+var testMonday = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestShapingWindow_validate(t *testing.T) {
+	sched := newTestWeekly(t)
+
+	testCases := []struct {
+		window     *ShapingWindow
+		name       string
+		wantErrMsg string
+	}{{
+		window:     &ShapingWindow{Schedule: sched, MaxUL: 10 * Mbit, MinUL: 5 * Mbit},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		window:     nil,
+		name:       "nil",
+		wantErrMsg: "no window",
+	}, {
+		window:     &ShapingWindow{MaxUL: 10 * Mbit},
+		name:       "no_schedule",
+		wantErrMsg: "no schedule",
+	}, {
+		window:     &ShapingWindow{Schedule: sched, MaxDL: -1},
+		name:       "negative_max",
+		wantErrMsg: "max_dl: must not be negative, got -1",
+	}, {
+		window:     &ShapingWindow{Schedule: sched, MaxUL: 5 * Mbit, MinUL: 10 * Mbit},
+		name:       "min_above_max",
+		wantErrMsg: "min_ul: must not be greater than max_ul 5000, got 10000",
+	}, {
+		window:     &ShapingWindow{Schedule: sched, Aggressiveness: 1},
+		name:       "bad_aggressiveness",
+		wantErrMsg: "aggressiveness: must be from 0 to 1 exclusive, got 1",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.window.validate())
+		})
+	}
+}
+
+func TestController_updateLimits(t *testing.T) {
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+		Windows: []*ShapingWindow{{
+			Schedule:       newTestWeekly(t),
+			Name:           "evening",
+			MaxUL:          testMaxBW / 2,
+			MinDL:          10 * Mbit,
+			Aggressiveness: 0.25,
+		}},
+		WindowTransition: timeutil.Duration{Duration: time.Minute},
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := testMonday.Add(18 * time.Hour)
+	base := limits{
+		maxUL:          testMaxBW,
+		maxDL:          testMaxBW,
+		aggressiveness: defaultAggressiveness,
+	}
+	evening := limits{
+		maxUL:          testMaxBW / 2,
+		maxDL:          testMaxBW,
+		minDL:          10 * Mbit,
+		aggressiveness: 0.25,
+	}
+
+	c.window = nil
+	c.limFrom, c.limTo, c.switchedAt = base, base, time.Time{}
+
+	testCases := []struct {
+		now        time.Time
+		want       limits
+		name       string
+		wantWindow string
+	}{{
+		now:        start.Add(-time.Minute),
+		want:       base,
+		name:       "before",
+		wantWindow: windowNameDefault,
+	}, {
+		now:        start,
+		want:       base,
+		name:       "began",
+		wantWindow: "evening",
+	}, {
+		now:        start.Add(30 * time.Second),
+		want:       base.lerp(evening, 0.5),
+		name:       "halfway",
+		wantWindow: "evening",
+	}, {
+		now:        start.Add(2 * time.Minute),
+		want:       evening,
+		name:       "switched",
+		wantWindow: "evening",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, c.updateLimits(tc.now))
+			assert.Equal(t, tc.wantWindow, c.window.name())
+		})
+	}
+
+	require.NotEmpty(t, c.journal)
+
+	last := c.journal[len(c.journal)-1]
+	assert.Equal(t, JournalEventWindow, last.Event)
+	assert.Equal(t, "evening", last.Message)
+}
+
+func TestController_adjust_window(t *testing.T) {
+	sched := newTestWeekly(t)
+	c, _ := newTestController(t, &Config{
+		UplinkInterface: "eth0",
+		MaxUL:           testMaxBW,
+		MaxDL:           testMaxBW,
+		Windows: []*ShapingWindow{{
+			Schedule:       sched,
+			MinUL:          80 * Mbit,
+			Aggressiveness: 0.1,
+		}},
+	})
+
+	now := testMonday.Add(20 * time.Hour)
+	c.mu.Lock()
+	c.window = c.conf.Windows[0]
+	c.limTo = c.windowLimits(c.window)
+	c.limFrom = c.limTo
+	c.mu.Unlock()
+
+	c.adjust(now)
+	assert.Equal(t, testMaxBW*0.9, c.bwUL)
+
+	// The bandwidth is reduced by the aggressiveness but not below the
+	// minimum.
+	c.ObserveLatency("1.1.1.1:53", 200*time.Millisecond, false)
+	c.adjust(now)
+	assert.Equal(t, testMaxBW*0.9*0.9+Mbit, c.bwDL)
+
+	c.ObserveLatency("1.1.1.1:53", 300*time.Millisecond, false)
+	c.adjust(now)
+	assert.Equal(t, 80*Mbit, c.bwUL)
+}
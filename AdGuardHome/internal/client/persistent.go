@@ -11,6 +11,7 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
+	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/errors"
@@ -82,6 +83,10 @@ type Persistent struct {
 	MACs      []net.HardwareAddr
 	ClientIDs []string
 
+	// QueryLogPrivacy is the client's override of the query log privacy
+	// settings.
+	QueryLogPrivacy querylog.ClientPrivacy
+
 	// UID is the unique identifier of the persistent client.
 	UID UID
 
@@ -253,6 +258,8 @@ func (c *Persistent) ShallowClone() (clone *Persistent) {
 	clone.MACs = slices.Clone(c.MACs)
 	clone.ClientIDs = slices.Clone(c.ClientIDs)
 
+	clone.QueryLogPrivacy = c.QueryLogPrivacy.Clone()
+
 	return clone
 }
 
@@ -0,0 +1,342 @@
+package dnsforward
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Parameters of the adaptive upstream mode.
+const (
+	// adaptiveAlpha is the smoothing factor of the moving averages of the
+	// latency and the error rate.
+	adaptiveAlpha = 0.1
+
+	// adaptiveProbeShare is the share of the requests sent to the upstreams
+	// other than the best one to keep their scores up to date.
+	adaptiveProbeShare = 0.05
+
+	// adaptiveQuarantine is how long an upstream that has timed out isn't
+	// used.
+	adaptiveQuarantine = 30 * time.Second
+)
+
+// adaptiveAddress is the address of the adaptive upstream as seen by the
+// proxy, e.g. in the cached responses.
+const adaptiveAddress = "adaptive"
+
+// adaptiveUpstream is a wrapped upstream with its moving score.  The fields are
+// protected by the mu of the selector.
+type adaptiveUpstream struct {
+	upstream.Upstream
+
+	// quarantinedUntil is the time until which the upstream isn't used after a
+	// timeout.
+	quarantinedUntil time.Time
+
+	// latency is the moving average of the latency of the successful
+	// requests.
+	latency time.Duration
+
+	// errRate is the moving average of the share of the failed requests.
+	errRate float64
+
+	// requests is the number of the requests sent to the upstream.
+	requests uint64
+}
+
+// score returns the cost of the upstream, lower is better.  The failures are
+// counted as the requests that have taken the whole timeout.  The mu of the
+// selector is expected to be locked.
+func (u *adaptiveUpstream) score(timeout time.Duration) (cost float64) {
+	return float64(u.latency) + u.errRate*float64(timeout)
+}
+
+// adaptiveSelector is the upstream that sends most requests to the wrapped
+// upstream with the best score, probes the others, and fails over to the next
+// best one on any error.  It's used as the only general upstream of the proxy,
+// so that the load balancing of the proxy doesn't interfere with the
+// selection.
+type adaptiveSelector struct {
+	// now returns the current time.
+	now func() (t time.Time)
+
+	// rand returns a random number from 0 to 1.
+	rand func() (f float64)
+
+	// mu protects the fields below and the scores of the upstreams.
+	mu *sync.Mutex
+
+	// resolved are the wrapped upstreams that have resolved the tracked
+	// requests, or nil for the tracked requests not resolved yet.  See
+	// [adaptiveSelector.track].
+	resolved map[*dns.Msg]*adaptiveUpstream
+
+	// ups are the wrapped upstreams.
+	ups []*adaptiveUpstream
+
+	// timeout is the timeout of the upstreams.
+	timeout time.Duration
+}
+
+// type check
+var _ upstream.Upstream = (*adaptiveSelector)(nil)
+
+// newAdaptiveSelector wraps ups into the upstream selecting them adaptively.
+func newAdaptiveSelector(ups []upstream.Upstream, timeout time.Duration) (sel *adaptiveSelector) {
+	sel = &adaptiveSelector{
+		now:      time.Now,
+		rand:     rand.Float64,
+		mu:       &sync.Mutex{},
+		resolved: map[*dns.Msg]*adaptiveUpstream{},
+		ups:      make([]*adaptiveUpstream, 0, len(ups)),
+		timeout:  timeout,
+	}
+
+	for _, u := range ups {
+		sel.ups = append(sel.ups, &adaptiveUpstream{
+			Upstream: u,
+		})
+	}
+
+	return sel
+}
+
+// Address implements the [upstream.Upstream] interface for *adaptiveSelector.
+func (sel *adaptiveSelector) Address() (addr string) {
+	return adaptiveAddress
+}
+
+// Exchange implements the [upstream.Upstream] interface for *adaptiveSelector.
+// It tries the wrapped upstreams in the order of [adaptiveSelector.order] until
+// one of them succeeds.
+func (sel *adaptiveSelector) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	var errs []error
+	for _, u := range sel.order() {
+		start := sel.now()
+		resp, err = u.Exchange(req)
+		sel.update(u, req, sel.now().Sub(start), err)
+		if err == nil {
+			return resp, nil
+		}
+
+		log.Debug("dnsforward: adaptive: upstream %s failed, trying next: %s", u.Address(), err)
+
+		errs = append(errs, err)
+	}
+
+	return nil, fmt.Errorf("all upstreams failed to exchange request: %w", errors.Join(errs...))
+}
+
+// Close implements the [upstream.Upstream] interface for *adaptiveSelector.
+func (sel *adaptiveSelector) Close() (err error) {
+	var errs []error
+	for _, u := range sel.ups {
+		errs = append(errs, u.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// order returns the wrapped upstreams in the order they should be tried for a
+// request.  The upstreams that aren't quarantined go first, from the best to
+// the worst, except that from time to time one of the others goes before the
+// best one to keep its score up to date.  The quarantined upstreams go last,
+// so that they're still tried when all others fail.
+func (sel *adaptiveSelector) order() (ordered []*adaptiveUpstream) {
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+
+	now := sel.now()
+	ordered = slices.Clone(sel.ups)
+	slices.SortStableFunc(ordered, func(a, b *adaptiveUpstream) (res int) {
+		aQuar, bQuar := now.Before(a.quarantinedUntil), now.Before(b.quarantinedUntil)
+		if aQuar != bQuar {
+			if aQuar {
+				return 1
+			}
+
+			return -1
+		} else if aQuar {
+			return a.quarantinedUntil.Compare(b.quarantinedUntil)
+		}
+
+		return cmp.Compare(a.score(sel.timeout), b.score(sel.timeout))
+	})
+
+	active := slices.IndexFunc(ordered, func(u *adaptiveUpstream) (ok bool) {
+		return now.Before(u.quarantinedUntil)
+	})
+	if active == -1 {
+		active = len(ordered)
+	}
+
+	if active > 1 && sel.rand() < adaptiveProbeShare {
+		// Let another upstream resolve the request first.
+		i := 1 + min(int(sel.rand()*float64(active-1)), active-2)
+		probed := ordered[i]
+		copy(ordered[1:i+1], ordered[:i])
+		ordered[0] = probed
+	}
+
+	return ordered
+}
+
+// best returns the upstream with the best score that isn't quarantined at now.
+// It returns nil if all of them are quarantined.  sel.mu is expected to be
+// locked.
+func (sel *adaptiveSelector) best(now time.Time) (best *adaptiveUpstream) {
+	for _, u := range sel.ups {
+		if now.Before(u.quarantinedUntil) {
+			continue
+		}
+
+		if best == nil || u.score(sel.timeout) < best.score(sel.timeout) {
+			best = u
+		}
+	}
+
+	return best
+}
+
+// update updates the score of u with the result of the request req and records
+// u as the upstream resolved req, if req is tracked.  Any error moves the
+// selection to the next upstream, but only timeouts quarantine u.
+func (sel *adaptiveSelector) update(
+	u *adaptiveUpstream,
+	req *dns.Msg,
+	elapsed time.Duration,
+	err error,
+) {
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+
+	u.requests++
+
+	if err != nil {
+		u.errRate += adaptiveAlpha * (1 - u.errRate)
+		if isTimeout(err) {
+			log.Debug("dnsforward: adaptive: quarantining %s after timeout", u.Address())
+			u.quarantinedUntil = sel.now().Add(adaptiveQuarantine)
+		}
+
+		return
+	}
+
+	if _, ok := sel.resolved[req]; ok {
+		sel.resolved[req] = u
+	}
+
+	u.errRate -= adaptiveAlpha * u.errRate
+	if u.latency == 0 {
+		u.latency = elapsed
+	} else {
+		u.latency += time.Duration(adaptiveAlpha * float64(elapsed-u.latency))
+	}
+}
+
+// track starts recording the wrapped upstream resolving req.  The caller must
+// call [adaptiveSelector.untrack] with the same request.
+func (sel *adaptiveSelector) track(req *dns.Msg) {
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+
+	sel.resolved[req] = nil
+}
+
+// untrack stops recording the upstream resolving pctx.Req.  If pctx has been
+// resolved by sel, it replaces sel with the actual upstream in pctx, so that the
+// statistics and the query log contain it.
+func (sel *adaptiveSelector) untrack(pctx *proxy.DNSContext) {
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+
+	u := sel.resolved[pctx.Req]
+	delete(sel.resolved, pctx.Req)
+
+	if u != nil && pctx.Upstream == upstream.Upstream(sel) {
+		pctx.Upstream = u.Upstream
+	}
+}
+
+// isTimeout returns true if err is a timeout error.
+func isTimeout(err error) (ok bool) {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+
+	var te interface{ Timeout() (ok bool) }
+
+	return errors.As(err, &te) && te.Timeout()
+}
+
+// upstreamScoreState is the state of an upstream in the adaptive mode.
+type upstreamScoreState string
+
+// Upstream states in the adaptive mode.
+const (
+	upstreamScoreStateBest        upstreamScoreState = "best"
+	upstreamScoreStateProbed      upstreamScoreState = "probed"
+	upstreamScoreStateQuarantined upstreamScoreState = "quarantined"
+)
+
+// jsonUpstreamScore is the score of an upstream in the adaptive mode.
+type jsonUpstreamScore struct {
+	// Address is the address of the upstream.
+	Address string `json:"address"`
+
+	// State is the state of the upstream.
+	State upstreamScoreState `json:"state"`
+
+	// Score is the cost of the upstream in milliseconds, lower is better.
+	Score float64 `json:"score"`
+
+	// Latency is the moving average of the latency in milliseconds.
+	Latency float64 `json:"latency"`
+
+	// ErrorRate is the moving average of the share of the failed requests.
+	ErrorRate float64 `json:"error_rate"`
+
+	// Requests is the number of the requests sent to the upstream.
+	Requests uint64 `json:"requests"`
+}
+
+// scores returns the scores of the upstreams in the configured order.
+func (sel *adaptiveSelector) scores() (scores []*jsonUpstreamScore) {
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+
+	now := sel.now()
+	best := sel.best(now)
+
+	scores = make([]*jsonUpstreamScore, 0, len(sel.ups))
+	for _, u := range sel.ups {
+		state := upstreamScoreStateProbed
+		if now.Before(u.quarantinedUntil) {
+			state = upstreamScoreStateQuarantined
+		} else if u == best {
+			state = upstreamScoreStateBest
+		}
+
+		scores = append(scores, &jsonUpstreamScore{
+			Address:   u.Address(),
+			State:     state,
+			Score:     u.score(sel.timeout) / float64(time.Millisecond),
+			Latency:   float64(u.latency) / float64(time.Millisecond),
+			ErrorRate: u.errRate,
+			Requests:  u.requests,
+		})
+	}
+
+	return scores
+}
@@ -0,0 +1,219 @@
+package dnsforward
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAdaptiveTestUpstream returns a mock upstream with addr taking latency to
+// respond with err, advancing now.
+func newAdaptiveTestUpstream(
+	addr string,
+	now *time.Time,
+	latency time.Duration,
+	err error,
+) (u *aghtest.UpstreamMock) {
+	return &aghtest.UpstreamMock{
+		OnAddress: func() (a string) { return addr },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, respErr error) {
+			*now = now.Add(latency)
+			if err != nil {
+				return nil, err
+			}
+
+			return (&dns.Msg{}).SetReply(req), nil
+		},
+		OnClose: func() (closeErr error) { return nil },
+	}
+}
+
+// newAdaptiveTestReq returns a new request for the adaptive selector tests.
+func newAdaptiveTestReq() (req *dns.Msg) {
+	return (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+}
+
+func TestAdaptiveSelector(t *testing.T) {
+	const (
+		fastAddr    = "fast:53"
+		slowAddr    = "slow:53"
+		timeoutAddr = "timeout:53"
+	)
+
+	now := time.Now()
+	sel := newAdaptiveSelector([]upstream.Upstream{
+		newAdaptiveTestUpstream(timeoutAddr, &now, time.Second, os.ErrDeadlineExceeded),
+		newAdaptiveTestUpstream(slowAddr, &now, 50*time.Millisecond, nil),
+		newAdaptiveTestUpstream(fastAddr, &now, 10*time.Millisecond, nil),
+	}, time.Second)
+	sel.now = func() (t time.Time) { return now }
+
+	// Probe every tenth request with the second best upstream.
+	var n int
+	isProbe := false
+	sel.rand = func() (f float64) {
+		if isProbe {
+			isProbe = false
+
+			return 0
+		}
+
+		n++
+		isProbe = n%10 == 0
+		if isProbe {
+			return 0
+		}
+
+		return 1
+	}
+
+	for range 300 {
+		_, err := sel.Exchange(newAdaptiveTestReq())
+		require.NoError(t, err)
+	}
+
+	scores := sel.scores()
+	require.Len(t, scores, 3)
+
+	timeoutScore, slowScore, fastScore := scores[0], scores[1], scores[2]
+
+	assert.Equal(t, timeoutAddr, timeoutScore.Address)
+	assert.Equal(t, upstreamScoreStateQuarantined, timeoutScore.State)
+	assert.Positive(t, timeoutScore.ErrorRate)
+	assert.Less(t, timeoutScore.Requests, uint64(3))
+
+	assert.Equal(t, slowAddr, slowScore.Address)
+	assert.Equal(t, upstreamScoreStateProbed, slowScore.State)
+	assert.InDelta(t, 50, slowScore.Latency, 1)
+	assert.Positive(t, slowScore.Requests)
+
+	assert.Equal(t, fastAddr, fastScore.Address)
+	assert.Equal(t, upstreamScoreStateBest, fastScore.State)
+	assert.InDelta(t, 10, fastScore.Latency, 1)
+	assert.Greater(t, fastScore.Requests, 5*slowScore.Requests)
+	assert.Less(t, fastScore.Score, slowScore.Score)
+
+	// The quarantine ends.
+	now = now.Add(adaptiveQuarantine)
+	assert.Equal(t, upstreamScoreStateProbed, sel.scores()[0].State)
+}
+
+func TestAdaptiveSelector_failover(t *testing.T) {
+	const (
+		refusedAddr = "refused:53"
+		brokenAddr  = "broken:53"
+		goodAddr    = "good:53"
+
+		errRefused errors.Error = "refused"
+	)
+
+	now := time.Now()
+	good := newAdaptiveTestUpstream(goodAddr, &now, 30*time.Millisecond, nil)
+	sel := newAdaptiveSelector([]upstream.Upstream{
+		newAdaptiveTestUpstream(refusedAddr, &now, time.Millisecond, errRefused),
+		newAdaptiveTestUpstream(brokenAddr, &now, time.Millisecond, errRefused),
+		good,
+	}, time.Second)
+	sel.now = func() (t time.Time) { return now }
+	sel.rand = func() (f float64) { return 1 }
+
+	for range 10 {
+		pctx := &proxy.DNSContext{Req: newAdaptiveTestReq()}
+		sel.track(pctx.Req)
+
+		resp, err := sel.Exchange(pctx.Req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+
+		pctx.Upstream = sel
+		sel.untrack(pctx)
+
+		assert.Same(t, good, pctx.Upstream)
+	}
+
+	assert.Empty(t, sel.resolved)
+
+	scores := sel.scores()
+	require.Len(t, scores, 3)
+
+	// The upstreams failed with errors other than timeouts aren't quarantined,
+	// but are tried after the good one once their scores are worse.
+	for _, s := range scores[:2] {
+		assert.Equal(t, upstreamScoreStateProbed, s.State)
+		assert.Positive(t, s.ErrorRate)
+		assert.Less(t, s.Requests, uint64(10))
+	}
+
+	assert.Equal(t, upstreamScoreStateBest, scores[2].State)
+	assert.Equal(t, uint64(10), scores[2].Requests)
+}
+
+func TestAdaptiveSelector_allQuarantined(t *testing.T) {
+	now := time.Now()
+	sel := newAdaptiveSelector([]upstream.Upstream{
+		newAdaptiveTestUpstream("a:53", &now, time.Second, os.ErrDeadlineExceeded),
+		newAdaptiveTestUpstream("b:53", &now, time.Second, os.ErrDeadlineExceeded),
+	}, time.Second)
+	sel.now = func() (t time.Time) { return now }
+	sel.rand = func() (f float64) { return 1 }
+
+	_, err := sel.Exchange(newAdaptiveTestReq())
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	for _, s := range sel.scores() {
+		assert.Equal(t, upstreamScoreStateQuarantined, s.State)
+		assert.Equal(t, uint64(1), s.Requests)
+	}
+
+	// All upstreams are tried, since all of them are quarantined.
+	_, err = sel.Exchange(newAdaptiveTestReq())
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	for _, s := range sel.scores() {
+		assert.Equal(t, uint64(2), s.Requests)
+	}
+}
+
+// testTimeoutError is an error with the Timeout method for tests.
+type testTimeoutError struct{}
+
+// Error implements the error interface for testTimeoutError.
+func (testTimeoutError) Error() (msg string) { return "i/o timeout" }
+
+// Timeout returns true.
+func (testTimeoutError) Timeout() (ok bool) { return true }
+
+func TestIsTimeout(t *testing.T) {
+	testCases := []struct {
+		err  error
+		name string
+		want bool
+	}{{
+		err:  os.ErrDeadlineExceeded,
+		name: "deadline",
+		want: true,
+	}, {
+		err:  fmt.Errorf("exchanging: %w", testTimeoutError{}),
+		name: "wrapped_timeout",
+		want: true,
+	}, {
+		err:  errors.Error("refused"),
+		name: "other",
+		want: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, isTimeout(tc.err))
+		})
+	}
+}
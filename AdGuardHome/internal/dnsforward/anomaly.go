@@ -0,0 +1,81 @@
+package dnsforward
+
+import (
+	"cmp"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/anomaly"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// AnomalyDetector detects the anomalies in the DNS traffic of the clients.
+type AnomalyDetector interface {
+	// Check counts the query for host from the client with the specified ID
+	// and returns the detected anomaly, if any.  Implementations must be safe
+	// for concurrent use.
+	Check(client, host string, now time.Time) (det *anomaly.Detection)
+
+	// Observe counts the response with the specified code to the client with
+	// the specified ID.  Implementations must be safe for concurrent use.
+	Observe(client string, rcode int, now time.Time)
+}
+
+// anomalyClientID returns the ID of the client of dctx used by the anomaly
+// detector.
+func anomalyClientID(dctx *dnsContext) (id string) {
+	return cmp.Or(dctx.clientID, dctx.proxyCtx.Addr.Addr().String())
+}
+
+// processAnomalyCheck checks the request that hasn't been filtered, rewritten,
+// or allowed for the anomalies.  The anomalous request is blocked if it's
+// configured and the protection is enabled.
+func (s *Server) processAnomalyCheck(dctx *dnsContext) (rc resultCode) {
+	pctx := dctx.proxyCtx
+	if s.anomalies == nil || pctx.Res != nil {
+		return resultCodeSuccess
+	}
+
+	if dctx.result != nil && dctx.result.Reason != filtering.NotFilteredNotFound {
+		return resultCodeSuccess
+	}
+
+	host := aghnet.NormalizeDomain(pctx.Req.Question[0].Name)
+	det := s.anomalies.Check(anomalyClientID(dctx), host, dctx.startTime)
+	if det == nil {
+		return resultCodeSuccess
+	}
+
+	block := det.Block && dctx.protectionEnabled
+	dctx.result = &filtering.Result{
+		Rules: []*filtering.ResultRule{{
+			Text:         det.String(),
+			FilterListID: rulelist.URLFilterIDAnomaly,
+		}},
+		Reason:     filtering.FilteredAnomaly,
+		IsFiltered: block,
+	}
+
+	if block {
+		log.Debug("dnsforward: host %q is blocked as anomalous: %s", host, det)
+
+		pctx.Res = s.genDNSFilterMessage(pctx, dctx.result)
+	}
+
+	return resultCodeSuccess
+}
+
+// processAnomalyObserve passes the code of the response from the upstream to
+// the anomaly detector.
+func (s *Server) processAnomalyObserve(dctx *dnsContext) (rc resultCode) {
+	pctx := dctx.proxyCtx
+	if s.anomalies == nil || !dctx.responseFromUpstream || pctx.Res == nil {
+		return resultCodeSuccess
+	}
+
+	s.anomalies.Observe(anomalyClientID(dctx), pctx.Res.Rcode, time.Now())
+
+	return resultCodeSuccess
+}
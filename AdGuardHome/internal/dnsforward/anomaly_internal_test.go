@@ -0,0 +1,160 @@
+package dnsforward
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/anomaly"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAnomalyDetector is a fake [AnomalyDetector] implementation for tests.
+type fakeAnomalyDetector struct {
+	onCheck   func(client, host string, now time.Time) (det *anomaly.Detection)
+	onObserve func(client string, rcode int, now time.Time)
+}
+
+// type check
+var _ AnomalyDetector = (*fakeAnomalyDetector)(nil)
+
+// Check implements the [AnomalyDetector] interface for *fakeAnomalyDetector.
+func (d *fakeAnomalyDetector) Check(client, host string, now time.Time) (det *anomaly.Detection) {
+	return d.onCheck(client, host, now)
+}
+
+// Observe implements the [AnomalyDetector] interface for
+// *fakeAnomalyDetector.
+func (d *fakeAnomalyDetector) Observe(client string, rcode int, now time.Time) {
+	d.onObserve(client, rcode, now)
+}
+
+func TestServer_ProcessAnomalyCheck(t *testing.T) {
+	tunnelDet := &anomaly.Detection{
+		Kind:   anomaly.KindTunnel,
+		Domain: "example.org",
+		Count:  100,
+		Score:  4,
+	}
+
+	testCases := []struct {
+		det            *anomaly.Detection
+		result         *filtering.Result
+		name           string
+		wantReason     filtering.Reason
+		block          bool
+		protection     bool
+		wantIsFiltered bool
+		wantResp       bool
+	}{{
+		det:            nil,
+		result:         &filtering.Result{},
+		name:           "none",
+		wantReason:     filtering.NotFilteredNotFound,
+		block:          true,
+		protection:     true,
+		wantIsFiltered: false,
+		wantResp:       false,
+	}, {
+		det:            tunnelDet,
+		result:         &filtering.Result{},
+		name:           "log_only",
+		wantReason:     filtering.FilteredAnomaly,
+		block:          false,
+		protection:     true,
+		wantIsFiltered: false,
+		wantResp:       false,
+	}, {
+		det:            tunnelDet,
+		result:         &filtering.Result{},
+		name:           "block",
+		wantReason:     filtering.FilteredAnomaly,
+		block:          true,
+		protection:     true,
+		wantIsFiltered: true,
+		wantResp:       true,
+	}, {
+		det:            tunnelDet,
+		result:         &filtering.Result{},
+		name:           "block_protection_disabled",
+		wantReason:     filtering.FilteredAnomaly,
+		block:          true,
+		protection:     false,
+		wantIsFiltered: false,
+		wantResp:       false,
+	}, {
+		det:            tunnelDet,
+		result:         &filtering.Result{Reason: filtering.NotFilteredAllowList},
+		name:           "allowed",
+		wantReason:     filtering.NotFilteredAllowList,
+		block:          true,
+		protection:     true,
+		wantIsFiltered: false,
+		wantResp:       false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := createTestServer(t, &filtering.Config{
+				BlockingMode: filtering.BlockingModeDefault,
+			}, ServerConfig{
+				Config: Config{
+					UpstreamMode:     UpstreamModeLoadBalance,
+					EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+				},
+				ServePlainDNS: true,
+			})
+
+			var gotClient, gotHost string
+			s.anomalies = &fakeAnomalyDetector{
+				onCheck: func(client, host string, _ time.Time) (det *anomaly.Detection) {
+					gotClient, gotHost = client, host
+					if tc.det == nil {
+						return nil
+					}
+
+					det = &anomaly.Detection{}
+					*det = *tc.det
+					det.Block = tc.block
+
+					return det
+				},
+				onObserve: func(_ string, _ int, _ time.Time) { panic("not implemented") },
+			}
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Req:  createTestMessageWithType("q1.example.org.", dns.TypeA),
+					Addr: testClientAddrPort,
+				},
+				result:            tc.result,
+				protectionEnabled: tc.protection,
+			}
+
+			rc := s.processAnomalyCheck(dctx)
+			assert.Equal(t, resultCodeSuccess, rc)
+
+			require.NotNil(t, dctx.result)
+
+			assert.Equal(t, tc.wantReason, dctx.result.Reason)
+			assert.Equal(t, tc.wantIsFiltered, dctx.result.IsFiltered)
+			assert.Equal(t, tc.wantResp, dctx.proxyCtx.Res != nil)
+
+			if tc.result.Reason == filtering.NotFilteredNotFound {
+				assert.Equal(t, testClientAddrPort.Addr().String(), gotClient)
+				assert.Equal(t, "q1.example.org", gotHost)
+			}
+
+			if tc.wantReason == filtering.FilteredAnomaly {
+				require.Len(t, dctx.result.Rules, 1)
+
+				assert.Equal(t, tc.det.String(), dctx.result.Rules[0].Text)
+				assert.Equal(t, rulelist.URLFilterIDAnomaly, dctx.result.Rules[0].FilterListID)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package dnsforward
+
+import (
+	"math"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// processAnswerObserver notifies the answer observer about the addresses
+// resolved by the upstream, if any.
+func (s *Server) processAnswerObserver(dctx *dnsContext) (rc resultCode) {
+	pctx := dctx.proxyCtx
+	if !dctx.responseFromUpstream || pctx.Res == nil {
+		return resultCodeSuccess
+	}
+
+	qtype := pctx.Req.Question[0].Qtype
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA && qtype != dns.TypeANY {
+		return resultCodeSuccess
+	}
+
+	ips, ttl := addrsFromAnswer(pctx.Res.Answer)
+	if len(ips) == 0 {
+		return resultCodeSuccess
+	}
+
+	// Synchronize access to s.answerObserver, see processQueryLogsAndStats.
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	if s.answerObserver == nil {
+		return resultCodeSuccess
+	}
+
+	host := aghnet.NormalizeDomain(pctx.Req.Question[0].Name)
+	log.Debug("dnsforward: observing %d addrs for %q", len(ips), host)
+
+	s.answerObserver.ObserveAnswer(host, ips, ttl)
+
+	return resultCodeSuccess
+}
+
+// addrsFromAnswer returns the IP addresses from the A and AAAA records of ans
+// and the smallest TTL among them.
+func addrsFromAnswer(ans []dns.RR) (ips []netip.Addr, ttl time.Duration) {
+	minTTL := uint32(math.MaxUint32)
+	for _, rr := range ans {
+		ip, ok := netip.AddrFromSlice(ipFromRR(rr))
+		if !ok {
+			continue
+		}
+
+		ips = append(ips, ip.Unmap())
+		minTTL = min(minTTL, rr.Header().Ttl)
+	}
+
+	if len(ips) == 0 {
+		return nil, 0
+	}
+
+	return ips, time.Duration(minTTL) * time.Second
+}
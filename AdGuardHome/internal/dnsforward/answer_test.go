@@ -0,0 +1,35 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddrsFromAnswer(t *testing.T) {
+	ans := []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Rrtype: dns.TypeA, Ttl: 300},
+		A:   net.IP{1, 2, 3, 4},
+	}, &dns.CNAME{
+		Hdr:    dns.RR_Header{Rrtype: dns.TypeCNAME, Ttl: 10},
+		Target: "example.org.",
+	}, &dns.AAAA{
+		Hdr:  dns.RR_Header{Rrtype: dns.TypeAAAA, Ttl: 60},
+		AAAA: net.ParseIP("1234::5678"),
+	}}
+
+	ips, ttl := addrsFromAnswer(ans)
+	assert.Equal(t, []netip.Addr{
+		netip.MustParseAddr("1.2.3.4"),
+		netip.MustParseAddr("1234::5678"),
+	}, ips)
+	assert.Equal(t, time.Minute, ttl)
+
+	ips, ttl = addrsFromAnswer(ans[1:2])
+	assert.Empty(t, ips)
+	assert.Zero(t, ttl)
+}
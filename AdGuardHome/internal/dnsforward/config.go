@@ -156,9 +156,11 @@ type Config struct {
 	HandleDDR bool `yaml:"handle_ddr"`
 
 	// IpsetList is the ipset configuration that allows AdGuard Home to add IP
-	// addresses of the specified domain names to an ipset list.  Syntax:
+	// addresses of the specified domain names to an ipset list or an nftables
+	// set.  Syntax:
 	//
 	//	DOMAIN[,DOMAIN].../IPSET_NAME
+	//	DOMAIN[,DOMAIN].../FAMILY#TABLE#SET
 	//
 	// This field is ignored if [IpsetListFileName] is set.
 	IpsetList []string `yaml:"ipset"`
@@ -307,6 +309,7 @@ const (
 	UpstreamModeLoadBalance UpstreamMode = "load_balance"
 	UpstreamModeParallel    UpstreamMode = "parallel"
 	UpstreamModeFastestAddr UpstreamMode = "fastest_addr"
+	UpstreamModeAdaptive    UpstreamMode = "adaptive"
 )
 
 // newProxyConfig creates and validates configuration for the main proxy.
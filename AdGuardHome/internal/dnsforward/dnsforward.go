@@ -81,6 +81,28 @@ type DHCP interface {
 	Enabled() (ok bool)
 }
 
+// LatencyObserver is notified about the latency of every DNS request processed
+// by the server.
+type LatencyObserver interface {
+	// ObserveLatency is called once the request has been processed.  upstream
+	// is the address of the upstream that has resolved the request, if any.
+	// elapsed is the total processing time of the request, and cached is true
+	// if the response has been taken from the cache.  Implementations must be
+	// safe for concurrent use and must not block.
+	ObserveLatency(upstream string, elapsed time.Duration, cached bool)
+}
+
+// AnswerObserver is notified about the addresses resolved by the upstreams for
+// the A and AAAA requests.
+type AnswerObserver interface {
+	// ObserveAnswer is called once the response has been received.  host is
+	// the normalized requested hostname, ips are the addresses from the
+	// answer, and ttl is the smallest TTL of the address records.
+	// Implementations must be safe for concurrent use and must not block for
+	// long.
+	ObserveAnswer(host string, ips []netip.Addr, ttl time.Duration)
+}
+
 // SystemResolvers is an interface for accessing the OS-provided resolvers.
 type SystemResolvers interface {
 	// Addrs returns the list of system resolvers' addresses.  Callers must
@@ -118,6 +140,26 @@ type Server struct {
 	// stats is the statistics collector for client's DNS usage data.
 	stats stats.Interface
 
+	// latencyObserver, if not nil, is notified about the latency of every
+	// processed request.
+	latencyObserver LatencyObserver
+
+	// answerObserver, if not nil, is notified about the addresses resolved by
+	// the upstreams.
+	answerObserver AnswerObserver
+
+	// dnstap, if not nil, is used to write the dnstap messages.  It's not
+	// reset on Close, since the upstreams may still use it.
+	dnstap DnstapWriter
+
+	// anomalies, if not nil, detects the anomalies in the traffic of the
+	// clients.
+	anomalies AnomalyDetector
+
+	// adaptive, if not nil, selects the general upstreams in the adaptive
+	// upstream mode.
+	adaptive *adaptiveSelector
+
 	// access drops disallowed clients.
 	access *accessManager
 
@@ -198,6 +240,22 @@ type DNSCreateParams struct {
 	Anonymizer  *aghnet.IPMut
 	EtcHosts    *aghnet.HostsContainer
 	LocalDomain string
+
+	// LatencyObserver, if not nil, is notified about the latency of every
+	// processed request.
+	LatencyObserver LatencyObserver
+
+	// AnswerObserver, if not nil, is notified about the addresses resolved by
+	// the upstreams.
+	AnswerObserver AnswerObserver
+
+	// DnstapWriter, if not nil, is used to write the dnstap messages about the
+	// queries and responses of the clients and the upstreams.
+	DnstapWriter DnstapWriter
+
+	// AnomalyDetector, if not nil, detects the anomalies in the traffic of the
+	// clients.
+	AnomalyDetector AnomalyDetector
 }
 
 // NewServer creates a new instance of the dnsforward.Server
@@ -233,6 +291,11 @@ func NewServer(p DNSCreateParams) (s *Server, err error) {
 		stats:       p.Stats,
 		queryLog:    p.QueryLog,
 		privateNets: p.PrivateNets,
+
+		latencyObserver: p.LatencyObserver,
+		answerObserver:  p.AnswerObserver,
+		dnstap:          p.DnstapWriter,
+		anomalies:       p.AnomalyDetector,
 		// TODO(e.burkov):  Use some case-insensitive string comparison.
 		localDomainSuffix: strings.ToLower(localDomainSuffix),
 		etcHosts:          etcHosts,
@@ -272,6 +335,8 @@ func (s *Server) Close() {
 	// TODO(s.chzhen):  Remove it.
 	s.stats = nil
 	s.queryLog = nil
+	s.latencyObserver = nil
+	s.answerObserver = nil
 	s.dnsProxy = nil
 
 	if err := s.ipset.close(); err != nil {
@@ -536,6 +601,16 @@ func (s *Server) prepareUpstreamSettings(boot upstream.Resolver) (err error) {
 		return fmt.Errorf("preparing upstream config: %w", err)
 	}
 
+	if s.dnstap != nil {
+		tapUpstreamConfig(uc, s.dnstap)
+	}
+
+	s.adaptive = nil
+	if s.conf.UpstreamMode == UpstreamModeAdaptive {
+		s.adaptive = newAdaptiveSelector(uc.Upstreams, s.conf.UpstreamTimeout)
+		uc.Upstreams = []upstream.Upstream{s.adaptive}
+	}
+
 	s.conf.UpstreamConfig = uc
 
 	return nil
@@ -796,6 +871,15 @@ func (s *Server) proxy() (p *proxy.Proxy) {
 	return s.dnsProxy
 }
 
+// proxyAndAdaptive is like [Server.proxy] but also returns the adaptive
+// selector of the general upstreams, if the adaptive upstream mode is used.
+func (s *Server) proxyAndAdaptive() (p *proxy.Proxy, sel *adaptiveSelector) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	return s.dnsProxy, s.adaptive
+}
+
 // Reconfigure applies the new configuration to the DNS server.
 func (s *Server) Reconfigure(conf *ServerConfig) error {
 	s.serverLock.Lock()
@@ -86,7 +86,8 @@ func (s *Server) checkHostRules(
 // filterDNSResponse checks each resource record of answer section of
 // dctx.proxyCtx.Res.  It sets dctx.result and dctx.origResp if at least one of
 // canonical names, IP addresses, or HTTPS RR hints in it matches the filtering
-// rules, as well as sets dctx.proxyCtx.Res to the filtered response.
+// rules, as well as sets dctx.proxyCtx.Res to the filtered response.  If none
+// of them match, the response rules are applied.
 func (s *Server) filterDNSResponse(dctx *dnsContext) (err error) {
 	setts := dctx.setts
 	if !setts.FilteringEnabled {
@@ -131,10 +132,12 @@ func (s *Server) filterDNSResponse(dctx *dnsContext) (err error) {
 
 			log.Debug("dnsforward: matched %q by response: %q", pctx.Req.Question[0].Name, host)
 
-			break
+			return nil
 		}
 	}
 
+	s.filterResponseRules(dctx)
+
 	return nil
 }
 
@@ -114,6 +114,10 @@ type jsonDNSConfig struct {
 	// systemResolvers to the front-end.  It's not a pointer to the slice since
 	// there is no need to omit it while decoding from JSON.
 	DefaultLocalPTRUpstreams []string `json:"default_local_ptr_upstreams,omitempty"`
+
+	// UpstreamsScores are the scores of the upstreams in the adaptive upstream
+	// mode.  It's only used to pass the scores to the front-end.
+	UpstreamsScores []*jsonUpstreamScore `json:"upstreams_scores,omitempty"`
 }
 
 // jsonUpstreamMode is a enumeration of upstream modes.
@@ -129,6 +133,7 @@ const (
 	jsonUpstreamModeLoadBalance jsonUpstreamMode = "load_balance"
 	jsonUpstreamModeParallel    jsonUpstreamMode = "parallel"
 	jsonUpstreamModeFastestAddr jsonUpstreamMode = "fastest_addr"
+	jsonUpstreamModeAdaptive    jsonUpstreamMode = "adaptive"
 )
 
 func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
@@ -172,6 +177,13 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 		upstreamMode = jsonUpstreamModeParallel
 	case UpstreamModeFastestAddr:
 		upstreamMode = jsonUpstreamModeFastestAddr
+	case UpstreamModeAdaptive:
+		upstreamMode = jsonUpstreamModeAdaptive
+	}
+
+	var upstreamsScores []*jsonUpstreamScore
+	if s.adaptive != nil {
+		upstreamsScores = s.adaptive.scores()
 	}
 
 	defPTRUps, err := s.defaultLocalPTRUpstreams()
@@ -208,6 +220,7 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 		LocalPTRUpstreams:        &localPTRUpstreams,
 		DefaultLocalPTRUpstreams: defPTRUps,
 		DisabledUntil:            protectionDisabledUntil,
+		UpstreamsScores:          upstreamsScores,
 	}
 }
 
@@ -255,7 +268,8 @@ func (req *jsonDNSConfig) checkUpstreamMode() (err error) {
 		jsonUpstreamModeEmpty,
 		jsonUpstreamModeLoadBalance,
 		jsonUpstreamModeParallel,
-		jsonUpstreamModeFastestAddr:
+		jsonUpstreamModeFastestAddr,
+		jsonUpstreamModeAdaptive:
 		return nil
 	default:
 		return fmt.Errorf("upstream_mode: incorrect value %q", um)
@@ -527,6 +541,8 @@ func mustParseUpstreamMode(mode jsonUpstreamMode) (um UpstreamMode) {
 		return UpstreamModeParallel
 	case jsonUpstreamModeFastestAddr:
 		return UpstreamModeFastestAddr
+	case jsonUpstreamModeAdaptive:
+		return UpstreamModeAdaptive
 	default:
 		// Should never happen, since the value should be validated.
 		panic(fmt.Errorf("unexpected upstream mode: %q", mode))
@@ -124,8 +124,10 @@ func (c *ipsetCtx) process(dctx *dnsContext) (rc resultCode) {
 	host = strings.TrimSuffix(host, ".")
 	host = strings.ToLower(host)
 
-	ip4s, ip6s := ipsFromAnswer(dctx.proxyCtx.Res.Answer)
-	n, err := c.ipsetMgr.Add(host, ip4s, ip6s)
+	ans := dctx.proxyCtx.Res.Answer
+	ip4s, ip6s := ipsFromAnswer(ans)
+	_, ttl := addrsFromAnswer(ans)
+	n, err := c.ipsetMgr.Add(host, ip4s, ip6s, ttl)
 	if err != nil {
 		// Consider ipset errors non-critical to the request.
 		log.Error("dnsforward: ipset: adding host ips: %s", err)
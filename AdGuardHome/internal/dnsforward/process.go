@@ -89,6 +89,9 @@ func (s *Server) handleDNSRequest(_ *proxy.Proxy, pctx *proxy.DNSContext) error
 		startTime: time.Now(),
 	}
 
+	s.tapClientQuery(dctx)
+	defer s.tapClientResponse(dctx)
+
 	type modProcessFunc func(ctx *dnsContext) (rc resultCode)
 
 	// Since (*dnsforward.Server).handleDNSRequest(...) is used as
@@ -102,9 +105,12 @@ func (s *Server) handleDNSRequest(_ *proxy.Proxy, pctx *proxy.DNSContext) error
 		s.processDHCPHosts,
 		s.processDHCPAddrs,
 		s.processFilteringBeforeRequest,
+		s.processAnomalyCheck,
 		s.processUpstream,
+		s.processAnomalyObserve,
 		s.processFilteringAfterResponse,
 		s.ipset.process,
+		s.processAnswerObserver,
 		s.processQueryLogsAndStats,
 	}
 	for _, process := range mods {
@@ -482,13 +488,18 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 	reqWantsDNSSEC := s.setReqAD(req)
 
 	// Process the request further since it wasn't filtered.
-	prx := s.proxy()
+	prx, sel := s.proxyAndAdaptive()
 	if prx == nil {
 		dctx.err = srvClosedErr
 
 		return resultCodeError
 	}
 
+	if sel != nil {
+		sel.track(req)
+		defer sel.untrack(pctx)
+	}
+
 	if dctx.err = prx.Resolve(pctx); dctx.err != nil {
 		return resultCodeError
 	}
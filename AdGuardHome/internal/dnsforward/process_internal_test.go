@@ -826,6 +826,61 @@ func TestServer_ProcessUpstream_localPTR(t *testing.T) {
 	})
 }
 
+func TestServer_ProcessUpstream_adaptive(t *testing.T) {
+	const reqFQDN = "example.org."
+
+	upsHdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := aghtest.MatchedResponse(req, dns.TypeA, reqFQDN, "192.0.2.1")
+		require.NoError(testutil.PanicT{}, w.WriteMsg(resp))
+	})
+	goodAddr := aghtest.StartLocalhostUpstream(t, upsHdlr).String()
+
+	// Use the closed ports to make the upstreams fail with the errors other
+	// than timeouts.
+	closedAddrs := make([]string, 0, 2)
+	for range 2 {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		closedAddrs = append(closedAddrs, conn.LocalAddr().String())
+		require.NoError(t, conn.Close())
+	}
+
+	s := createTestServer(
+		t,
+		&filtering.Config{
+			BlockingMode: filtering.BlockingModeDefault,
+		},
+		ServerConfig{
+			UDPListenAddrs: []*net.UDPAddr{{}},
+			TCPListenAddrs: []*net.TCPAddr{{}},
+			Config: Config{
+				UpstreamDNS:      append(closedAddrs, goodAddr),
+				UpstreamMode:     UpstreamModeAdaptive,
+				EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			},
+			ServePlainDNS: true,
+		},
+	)
+	require.NotNil(t, s.adaptive)
+
+	for range 3 {
+		pctx := &proxy.DNSContext{
+			Addr: testClientAddrPort,
+			Req:  createTestMessageWithType(reqFQDN, dns.TypeA),
+		}
+
+		rc := s.processUpstream(&dnsContext{proxyCtx: pctx})
+		require.Equal(t, resultCodeSuccess, rc)
+		require.NotEmpty(t, pctx.Res.Answer)
+		require.NotNil(t, pctx.Upstream)
+
+		assert.Equal(t, goodAddr, pctx.Upstream.Address())
+	}
+
+	assert.Empty(t, s.adaptive.resolved)
+}
+
 func TestIPStringFromAddr(t *testing.T) {
 	t.Run("not_nil", func(t *testing.T) {
 		addr := net.UDPAddr{
@@ -0,0 +1,179 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// filterResponseRules applies the response rules to dctx.proxyCtx.Res.  If any
+// rule matches, it sets dctx.result and dctx.origResp as well as sets
+// dctx.proxyCtx.Res to either the blocked response or the response with the
+// matched records stripped or rewritten.
+func (s *Server) filterResponseRules(dctx *dnsContext) {
+	pctx := dctx.proxyCtx
+	resp := pctx.Res
+
+	if rule := s.matchNSRules(resp); rule != nil {
+		s.blockByResponseRule(dctx, rule)
+
+		return
+	}
+
+	var matched *filtering.ResponseRule
+	answer := make([]dns.RR, 0, len(resp.Answer))
+	for _, rr := range resp.Answer {
+		rule := s.matchAnswerRule(rr)
+		if rule == nil {
+			answer = append(answer, rr)
+
+			continue
+		}
+
+		if rule.Action == filtering.ResponseActionBlock {
+			s.blockByResponseRule(dctx, rule)
+
+			return
+		}
+
+		if matched == nil {
+			matched = rule
+		}
+
+		if rule.Action == filtering.ResponseActionRewrite {
+			if rewritten := rewriteAnswerIP(rr, rule.IP); rewritten != nil {
+				answer = append(answer, rewritten)
+			}
+		}
+	}
+
+	if matched == nil {
+		return
+	}
+
+	log.Debug("dnsforward: response for %q modified by rule %q", resp.Question[0].Name, matched)
+
+	dctx.origResp = resp
+	dctx.result = matched.Result()
+
+	pctx.Res = resp.Copy()
+	pctx.Res.Answer = answer
+}
+
+// blockByResponseRule sets the blocked response for dctx matched by rule.
+func (s *Server) blockByResponseRule(dctx *dnsContext, rule *filtering.ResponseRule) {
+	pctx := dctx.proxyCtx
+
+	log.Debug("dnsforward: response for %q blocked by rule %q", pctx.Req.Question[0].Name, rule)
+
+	dctx.result = rule.Result()
+	dctx.origResp = pctx.Res
+	pctx.Res = s.genDNSFilterMessage(pctx, dctx.result)
+}
+
+// matchAnswerRule returns the response rule matching the answer record rr, if
+// any.
+func (s *Server) matchAnswerRule(rr dns.RR) (rule *filtering.ResponseRule) {
+	switch rr := rr.(type) {
+	case *dns.CNAME:
+		return s.dnsFilter.MatchResponseDomain(filtering.ResponseTriggerCNAME, rr.Target)
+	case *dns.A, *dns.AAAA:
+		ip, ok := rrIP(rr)
+		if !ok {
+			return nil
+		}
+
+		return s.dnsFilter.MatchResponseIP(filtering.ResponseTriggerIP, ip)
+	default:
+		return nil
+	}
+}
+
+// matchNSRules returns the response rule matching the name servers in resp, if
+// any.  The names are taken from the NS records of the answer and authority
+// sections and their addresses are taken from the glue records of the
+// additional section.
+//
+// Note that, unlike a recursive resolver, a forwarder only sees the name
+// servers the upstream chooses to include into the response.
+func (s *Server) matchNSRules(resp *dns.Msg) (rule *filtering.ResponseRule) {
+	nsNames := container.NewMapSet[string]()
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns} {
+		for _, rr := range rrs {
+			ns, ok := rr.(*dns.NS)
+			if !ok {
+				continue
+			}
+
+			rule = s.dnsFilter.MatchResponseDomain(filtering.ResponseTriggerNSDName, ns.Ns)
+			if rule != nil {
+				return rule
+			}
+
+			nsNames.Add(dns.CanonicalName(ns.Ns))
+		}
+	}
+
+	if nsNames.Len() == 0 {
+		return nil
+	}
+
+	for _, rr := range resp.Extra {
+		if !nsNames.Has(dns.CanonicalName(rr.Header().Name)) {
+			continue
+		}
+
+		ip, ok := rrIP(rr)
+		if !ok {
+			continue
+		}
+
+		rule = s.dnsFilter.MatchResponseIP(filtering.ResponseTriggerNSIP, ip)
+		if rule != nil {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// rrIP returns the IP address of rr if it's an A or AAAA record.
+func rrIP(rr dns.RR) (ip netip.Addr, ok bool) {
+	var netIP net.IP
+	switch rr := rr.(type) {
+	case *dns.A:
+		netIP = rr.A.To4()
+	case *dns.AAAA:
+		netIP = rr.AAAA
+	default:
+		return netip.Addr{}, false
+	}
+
+	return netip.AddrFromSlice(netIP)
+}
+
+// rewriteAnswerIP returns a copy of the A or AAAA record rr with the address
+// replaced by ip.  It returns nil if the IP version of ip doesn't match the
+// type of rr.
+func rewriteAnswerIP(rr dns.RR, ip netip.Addr) (rewritten dns.RR) {
+	switch rr := rr.(type) {
+	case *dns.A:
+		if !ip.Is4() {
+			return nil
+		}
+
+		return &dns.A{Hdr: rr.Hdr, A: ip.AsSlice()}
+	case *dns.AAAA:
+		if !ip.Is6() {
+			return nil
+		}
+
+		return &dns.AAAA{Hdr: rr.Hdr, AAAA: ip.AsSlice()}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,211 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestA returns a new A record for name with the address ip.
+func newTestA(name, ip string) (rr *dns.A) {
+	return &dns.A{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    60,
+		},
+		A: net.IP(netip.MustParseAddr(ip).AsSlice()),
+	}
+}
+
+func TestServer_FilterResponseRules(t *testing.T) {
+	const (
+		reqFQDN = "www.example.com."
+
+		passedIP    = "203.0.113.1"
+		blockedIP   = "192.0.2.1"
+		strippedIP  = "198.51.100.1"
+		rewrittenIP = "198.51.100.200"
+		rewriteIP   = "127.0.0.2"
+	)
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+		ResponseRules: []*filtering.ResponseRule{{
+			Trigger: filtering.ResponseTriggerIP,
+			Value:   "192.0.2.0/24",
+			Action:  filtering.ResponseActionBlock,
+		}, {
+			Trigger: filtering.ResponseTriggerIP,
+			Value:   rewrittenIP,
+			Action:  filtering.ResponseActionRewrite,
+			Rewrite: rewriteIP,
+		}, {
+			Trigger: filtering.ResponseTriggerIP,
+			Value:   "198.51.100.0/24",
+			Action:  filtering.ResponseActionStrip,
+		}, {
+			Trigger: filtering.ResponseTriggerCNAME,
+			Value:   "*.tracker.example",
+			Action:  filtering.ResponseActionBlock,
+		}, {
+			Trigger: filtering.ResponseTriggerNSDName,
+			Value:   "ns.bad.example",
+			Action:  filtering.ResponseActionBlock,
+		}, {
+			Trigger: filtering.ResponseTriggerNSIP,
+			Value:   "198.18.0.0/15",
+			Action:  filtering.ResponseActionBlock,
+		}},
+	}, ServerConfig{
+		Config: Config{
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+		},
+		ServePlainDNS: true,
+	})
+
+	cname := &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   reqFQDN,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+		},
+		Target: "cdn.tracker.example.",
+	}
+
+	newNS := func(ns string) (rr dns.RR) {
+		return &dns.NS{
+			Hdr: dns.RR_Header{
+				Name:   "example.com.",
+				Rrtype: dns.TypeNS,
+				Class:  dns.ClassINET,
+			},
+			Ns: ns,
+		}
+	}
+
+	testCases := []struct {
+		name         string
+		wantRule     string
+		answer       []dns.RR
+		ns           []dns.RR
+		extra        []dns.RR
+		wantAnswer   []dns.RR
+		wantFiltered bool
+	}{{
+		name:         "pass",
+		wantRule:     "",
+		answer:       []dns.RR{newTestA(reqFQDN, passedIP)},
+		wantAnswer:   []dns.RR{newTestA(reqFQDN, passedIP)},
+		wantFiltered: false,
+	}, {
+		name:         "block_ip",
+		wantRule:     "ip 192.0.2.0/24 block",
+		answer:       []dns.RR{newTestA(reqFQDN, passedIP), newTestA(reqFQDN, blockedIP)},
+		wantAnswer:   nil,
+		wantFiltered: true,
+	}, {
+		name:         "strip",
+		wantRule:     "ip 198.51.100.0/24 strip",
+		answer:       []dns.RR{newTestA(reqFQDN, strippedIP), newTestA(reqFQDN, passedIP)},
+		wantAnswer:   []dns.RR{newTestA(reqFQDN, passedIP)},
+		wantFiltered: false,
+	}, {
+		name:         "rewrite",
+		wantRule:     "ip " + rewrittenIP + "/32 rewrite " + rewriteIP,
+		answer:       []dns.RR{newTestA(reqFQDN, rewrittenIP), newTestA(reqFQDN, strippedIP)},
+		wantAnswer:   []dns.RR{newTestA(reqFQDN, rewriteIP)},
+		wantFiltered: false,
+	}, {
+		name:         "cname",
+		wantRule:     "cname *.tracker.example block",
+		answer:       []dns.RR{cname, newTestA("cdn.tracker.example.", passedIP)},
+		wantAnswer:   nil,
+		wantFiltered: true,
+	}, {
+		name:         "nsdname",
+		wantRule:     "nsdname ns.bad.example block",
+		answer:       []dns.RR{newTestA(reqFQDN, passedIP)},
+		ns:           []dns.RR{newNS("NS.Bad.Example.")},
+		wantAnswer:   nil,
+		wantFiltered: true,
+	}, {
+		name:         "nsip",
+		wantRule:     "nsip 198.18.0.0/15 block",
+		answer:       []dns.RR{newTestA(reqFQDN, passedIP)},
+		ns:           []dns.RR{newNS("ns1.example.net.")},
+		extra:        []dns.RR{newTestA("ns1.example.net.", "198.19.0.1")},
+		wantAnswer:   nil,
+		wantFiltered: true,
+	}, {
+		name:         "nsip_not_glue",
+		wantRule:     "",
+		answer:       []dns.RR{newTestA(reqFQDN, passedIP)},
+		ns:           []dns.RR{newNS("ns1.example.net.")},
+		extra:        []dns.RR{newTestA("other.example.net.", "198.19.0.1")},
+		wantAnswer:   []dns.RR{newTestA(reqFQDN, passedIP)},
+		wantFiltered: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := createTestMessageWithType(reqFQDN, dns.TypeA)
+			resp := newResp(dns.RcodeSuccess, req, tc.answer)
+			resp.Ns, resp.Extra = tc.ns, tc.extra
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Proto: proxy.ProtoUDP,
+					Req:   req,
+					Res:   resp,
+					Addr:  testClientAddrPort,
+				},
+				setts: &filtering.Settings{
+					ProtectionEnabled: true,
+					FilteringEnabled:  true,
+				},
+			}
+
+			err := s.filterDNSResponse(dctx)
+			require.NoError(t, err)
+
+			gotResp := dctx.proxyCtx.Res
+			require.NotNil(t, gotResp)
+
+			if tc.wantRule == "" {
+				assert.Nil(t, dctx.result)
+				assert.Same(t, resp, gotResp)
+
+				return
+			}
+
+			require.NotNil(t, dctx.result)
+			require.Len(t, dctx.result.Rules, 1)
+
+			assert.Equal(t, filtering.FilteredResponseRule, dctx.result.Reason)
+			assert.Equal(t, tc.wantFiltered, dctx.result.IsFiltered)
+			assert.Equal(t, tc.wantRule, dctx.result.Rules[0].Text)
+			assert.Same(t, resp, dctx.origResp)
+
+			if tc.wantFiltered {
+				require.Len(t, gotResp.Answer, 1)
+
+				a := testutil.RequireTypeAssert[*dns.A](t, gotResp.Answer[0])
+				assert.True(t, a.A.IsUnspecified())
+
+				return
+			}
+
+			assert.Equal(t, tc.wantAnswer, gotResp.Answer)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package dnsforward
 
 import (
 	"net"
+	"slices"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
@@ -23,28 +24,28 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 	host := aghnet.NormalizeDomain(q.Name)
 	processingTime := time.Since(dctx.startTime)
 
+	// Keep the original address for the query log, since it looks up the
+	// clients and applies their privacy settings, which can override the
+	// global anonymization.
 	ip := pctx.Addr.Addr().AsSlice()
-	s.anonymizer.Load()(ip)
-	ipStr := net.IP(ip).String()
+	anonIP := slices.Clone(ip)
+	s.anonymizer.Load()(anonIP)
+	ipStr := net.IP(anonIP).String()
 
 	log.Debug("dnsforward: client ip for stats and querylog: %s", ipStr)
 
-	ids := []string{ipStr}
-	if dctx.clientID != "" {
-		// Use the ClientID first because it has a higher priority.  Filters
-		// have the same priority, see applyAdditionalFiltering.
-		ids = []string{dctx.clientID, ipStr}
-	}
+	logIDs := lookupIDs(dctx.clientID, pctx.Addr.Addr().String())
+	ids := lookupIDs(dctx.clientID, ipStr)
 
 	qt, cl := q.Qtype, q.Qclass
 
-	// Synchronize access to s.queryLog and s.stats so they won't be suddenly
-	// uninitialized while in use.  This can happen after proxy server has been
-	// stopped, but its workers haven't yet exited.
+	// Synchronize access to s.queryLog, s.stats, and s.latencyObserver so they
+	// won't be suddenly uninitialized while in use.  This can happen after
+	// proxy server has been stopped, but its workers haven't yet exited.
 	s.serverLock.RLock()
 	defer s.serverLock.RUnlock()
 
-	if s.shouldLog(host, qt, cl, ids) {
+	if s.shouldLog(host, qt, cl, logIDs) {
 		s.logQuery(dctx, ip, processingTime)
 	} else {
 		log.Debug(
@@ -68,9 +69,42 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 		)
 	}
 
+	if s.latencyObserver != nil {
+		s.observeLatency(dctx, processingTime)
+	}
+
 	return resultCodeSuccess
 }
 
+// lookupIDs returns the IDs to look up the client by in the query log and
+// statistics.
+func lookupIDs(clientID, ipStr string) (ids []string) {
+	if clientID != "" {
+		// Use the ClientID first because it has a higher priority.  Filters
+		// have the same priority, see applyAdditionalFiltering.
+		return []string{clientID, ipStr}
+	}
+
+	return []string{ipStr}
+}
+
+// observeLatency notifies the latency observer about the request.
+// s.serverLock is expected to be locked.
+func (s *Server) observeLatency(dctx *dnsContext, processingTime time.Duration) {
+	pctx := dctx.proxyCtx
+
+	var upstream string
+	var cached bool
+	if pctx.Upstream != nil {
+		upstream = pctx.Upstream.Address()
+	} else if cachedUps := pctx.CachedUpstreamAddr; cachedUps != "" {
+		upstream = cachedUps
+		cached = true
+	}
+
+	s.latencyObserver.ObserveLatency(upstream, processingTime, cached)
+}
+
 // shouldLog returns true if the query with the given data should be logged in
 // the query log.  s.serverLock is expected to be locked.
 func (s *Server) shouldLog(host string, qt, cl uint16, ids []string) (ok bool) {
@@ -134,11 +168,18 @@ func (s *Server) logQuery(dctx *dnsContext, ip net.IP, processingTime time.Durat
 func (s *Server) updateStats(dctx *dnsContext, clientIP string, processingTime time.Duration) {
 	pctx := dctx.proxyCtx
 
+	q := pctx.Req.Question[0]
 	e := &stats.Entry{
-		Domain:         aghnet.NormalizeDomain(pctx.Req.Question[0].Name),
+		Domain:         aghnet.NormalizeDomain(q.Name),
 		Result:         stats.RNotFiltered,
 		ProcessingTime: processingTime,
 		UpstreamTime:   pctx.QueryDuration,
+		QType:          q.Qtype,
+		RCode:          dns.RcodeServerFailure,
+	}
+
+	if pctx.Res != nil {
+		e.RCode = pctx.Res.Rcode
 	}
 
 	if pctx.Upstream != nil {
@@ -163,6 +204,10 @@ func (s *Server) updateStats(dctx *dnsContext, clientIP string, processingTime t
 		filtering.FilteredInvalid,
 		filtering.FilteredBlockedService:
 		e.Result = stats.RFiltered
+	case filtering.FilteredAnomaly, filtering.FilteredResponseRule:
+		if dctx.result.IsFiltered {
+			e.Result = stats.RFiltered
+		}
 	}
 
 	s.stats.Update(e)
@@ -1,6 +1,9 @@
 package dnsforward
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"testing"
 	"time"
@@ -11,6 +14,8 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/stats"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -58,6 +63,19 @@ func (l *testStats) ShouldCount(string, uint16, uint16, []string) bool {
 	return true
 }
 
+// testLatencyObserver is a simple [LatencyObserver] implementation for tests.
+type testLatencyObserver struct {
+	lastUpstream string
+	lastElapsed  time.Duration
+	lastCached   bool
+}
+
+// ObserveLatency implements the [LatencyObserver] interface for
+// *testLatencyObserver.
+func (o *testLatencyObserver) ObserveLatency(upstream string, elapsed time.Duration, cached bool) {
+	o.lastUpstream, o.lastElapsed, o.lastCached = upstream, elapsed, cached
+}
+
 func TestServer_ProcessQueryLogsAndStats(t *testing.T) {
 	const domain = "example.com."
 
@@ -201,10 +219,12 @@ func TestServer_ProcessQueryLogsAndStats(t *testing.T) {
 	for _, tc := range testCases {
 		ql := &testQueryLog{}
 		st := &testStats{}
+		lo := &testLatencyObserver{}
 		srv := &Server{
-			queryLog:   ql,
-			stats:      st,
-			anonymizer: aghnet.NewIPMut(nil),
+			queryLog:        ql,
+			stats:           st,
+			latencyObserver: lo,
+			anonymizer:      aghnet.NewIPMut(nil),
 		}
 		t.Run(tc.name, func(t *testing.T) {
 			req := &dns.Msg{
@@ -233,6 +253,102 @@ func TestServer_ProcessQueryLogsAndStats(t *testing.T) {
 			assert.Equal(t, tc.wantLogProto, ql.lastParams.ClientProto)
 			assert.Equal(t, tc.wantStatClient, st.lastEntry.Client)
 			assert.Equal(t, tc.wantStatResult, st.lastEntry.Result)
+			assert.Equal(t, ups.Address(), lo.lastUpstream)
+			assert.Positive(t, lo.lastElapsed)
+			assert.False(t, lo.lastCached)
 		})
 	}
 }
+
+func TestServer_ProcessQueryLogsAndStats_clientPrivacy(t *testing.T) {
+	keep := false
+	staffAddr := netip.MustParseAddrPort("192.0.2.1:1234")
+	otherAddr := netip.MustParseAddrPort("192.0.2.2:1234")
+
+	var gotIDs [][]string
+	anonymizer := aghnet.NewIPMut(querylog.AnonymizeIP)
+	handlers := map[string]http.HandlerFunc{}
+	ql, err := querylog.New(querylog.Config{
+		Anonymizer: anonymizer,
+		HTTPRegister: func(_, url string, handler http.HandlerFunc) {
+			handlers[url] = handler
+		},
+		FindClient: func(ids []string) (c *querylog.Client, err error) {
+			gotIDs = append(gotIDs, ids)
+			if ids[len(ids)-1] != staffAddr.Addr().String() {
+				return nil, nil
+			}
+
+			return &querylog.Client{
+				Name: "staff",
+				Privacy: querylog.ClientPrivacy{
+					AnonymizeClientIP: &keep,
+				},
+			}, nil
+		},
+		Enabled:           true,
+		RotationIvl:       timeutil.Day,
+		MemSize:           100,
+		BaseDir:           t.TempDir(),
+		AnonymizeClientIP: true,
+	})
+	require.NoError(t, err)
+
+	ql.Start()
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		ql.Close()
+
+		return nil
+	})
+
+	st := &testStats{}
+	srv := &Server{
+		queryLog:   ql,
+		stats:      st,
+		anonymizer: anonymizer,
+	}
+
+	for _, addr := range []netip.AddrPort{staffAddr, otherAddr} {
+		dctx := &dnsContext{
+			proxyCtx: &proxy.DNSContext{
+				Proto: proxy.ProtoUDP,
+				Req:   (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA),
+				Res:   &dns.Msg{},
+				Addr:  addr,
+			},
+			startTime: time.Now(),
+			result:    &filtering.Result{},
+		}
+
+		code := srv.processQueryLogsAndStats(dctx)
+		require.Equal(t, resultCodeSuccess, code)
+
+		// The statistics still use the globally anonymized address.
+		assert.Equal(t, "192.0.0.0", st.lastEntry.Client)
+	}
+
+	// The clients are looked up by their original addresses both to check if
+	// the request should be logged and to apply the privacy settings.
+	staffIDs, otherIDs := []string{staffAddr.Addr().String()}, []string{otherAddr.Addr().String()}
+	assert.Equal(t, [][]string{staffIDs, staffIDs, otherIDs, otherIDs}, gotIDs)
+
+	handler := handlers["/control/querylog"]
+	require.NotNil(t, handler)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/control/querylog", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := struct {
+		Data []struct {
+			Client string `json:"client"`
+		} `json:"data"`
+	}{}
+	err = json.NewDecoder(w.Body).Decode(&resp)
+	require.NoError(t, err)
+	require.Len(t, resp.Data, 2)
+
+	// The newest entry goes first.
+	assert.Equal(t, "192.0.0.0", resp.Data[0].Client)
+	assert.Equal(t, staffAddr.Addr().String(), resp.Data[1].Client)
+}
@@ -0,0 +1,238 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dnstap"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// DnstapWriter writes the dnstap messages about the DNS queries and responses.
+type DnstapWriter interface {
+	// WriteMessage queues msg for writing.  msg must not be modified after
+	// the call.  Implementations must be safe for concurrent use and must not
+	// block.
+	WriteMessage(msg *dnstap.Message)
+}
+
+// tapClientQuery writes the CLIENT_QUERY message about the request of dctx, if
+// dnstap is enabled.
+func (s *Server) tapClientQuery(dctx *dnsContext) {
+	if s.dnstap == nil {
+		return
+	}
+
+	pctx := dctx.proxyCtx
+	s.dnstap.WriteMessage(&dnstap.Message{
+		QueryTime:    dctx.startTime,
+		QueryAddr:    pctx.Addr,
+		QueryMessage: packTapMsg(pctx.Req),
+		Type:         dnstap.MessageTypeClientQuery,
+		Protocol:     clientTapProtocol(pctx),
+	})
+}
+
+// tapClientResponse writes the CLIENT_RESPONSE message about the response of
+// dctx, if dnstap is enabled and there is a response.
+func (s *Server) tapClientResponse(dctx *dnsContext) {
+	pctx := dctx.proxyCtx
+	if s.dnstap == nil || pctx.Res == nil {
+		return
+	}
+
+	s.dnstap.WriteMessage(&dnstap.Message{
+		QueryTime:       dctx.startTime,
+		ResponseTime:    time.Now(),
+		QueryAddr:       pctx.Addr,
+		ResponseMessage: packTapMsg(pctx.Res),
+		Type:            dnstap.MessageTypeClientResponse,
+		Protocol:        clientTapProtocol(pctx),
+	})
+}
+
+// clientTapProtocol returns the dnstap socket protocol of the client's
+// request.
+func clientTapProtocol(pctx *proxy.DNSContext) (p dnstap.SocketProtocol) {
+	switch pctx.Proto {
+	case proxy.ProtoUDP:
+		return dnstap.SocketProtocolUDP
+	case proxy.ProtoTCP:
+		return dnstap.SocketProtocolTCP
+	case proxy.ProtoTLS:
+		return dnstap.SocketProtocolDOT
+	case proxy.ProtoHTTPS:
+		return dnstap.SocketProtocolDOH
+	case proxy.ProtoQUIC:
+		return dnstap.SocketProtocolDOQ
+	case proxy.ProtoDNSCrypt:
+		if _, ok := pctx.Conn.(*net.TCPConn); ok {
+			return dnstap.SocketProtocolDNSCryptTCP
+		}
+
+		return dnstap.SocketProtocolDNSCryptUDP
+	default:
+		return dnstap.SocketProtocolNone
+	}
+}
+
+// packTapMsg returns the wire format of msg or nil if it can't be packed.
+func packTapMsg(msg *dns.Msg) (b []byte) {
+	b, err := msg.Pack()
+	if err != nil {
+		log.Debug("dnstap: packing message: %s", err)
+
+		return nil
+	}
+
+	return b
+}
+
+// tapUpstream is an upstream that writes the FORWARDER_QUERY and
+// FORWARDER_RESPONSE messages about the exchanges with the wrapped upstream.
+type tapUpstream struct {
+	upstream.Upstream
+
+	w DnstapWriter
+
+	// addr is the address of the upstream, if it's an IP address.
+	addr netip.AddrPort
+
+	proto dnstap.SocketProtocol
+}
+
+// type check
+var _ upstream.Upstream = (*tapUpstream)(nil)
+
+// newTapUpstream returns a new tapUpstream wrapping u.
+func newTapUpstream(u upstream.Upstream, w DnstapWriter) (tu *tapUpstream) {
+	addr, proto := upstreamTapAddr(u.Address())
+
+	return &tapUpstream{
+		Upstream: u,
+		w:        w,
+		addr:     addr,
+		proto:    proto,
+	}
+}
+
+// Exchange implements the [upstream.Upstream] interface for *tapUpstream.
+func (u *tapUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	start := time.Now()
+	u.w.WriteMessage(&dnstap.Message{
+		QueryTime:    start,
+		ResponseAddr: u.addr,
+		QueryMessage: packTapMsg(req),
+		Type:         dnstap.MessageTypeForwarderQuery,
+		Protocol:     u.proto,
+	})
+
+	resp, err = u.Upstream.Exchange(req)
+	if resp != nil {
+		u.w.WriteMessage(&dnstap.Message{
+			QueryTime:       start,
+			ResponseTime:    time.Now(),
+			ResponseAddr:    u.addr,
+			ResponseMessage: packTapMsg(resp),
+			Type:            dnstap.MessageTypeForwarderResponse,
+			Protocol:        u.proto,
+		})
+	}
+
+	return resp, err
+}
+
+// Default ports of the encrypted DNS protocols.
+const (
+	defaultTLSPort   uint16 = 853
+	defaultHTTPSPort uint16 = 443
+	defaultQUICPort  uint16 = 853
+)
+
+// upstreamTapAddr returns the address and the dnstap socket protocol of the
+// upstream with the address as returned by [upstream.Upstream.Address].  addr
+// is invalid if the upstream is set by a hostname.
+func upstreamTapAddr(upsAddr string) (addr netip.AddrPort, proto dnstap.SocketProtocol) {
+	scheme, _, ok := strings.Cut(upsAddr, "://")
+	if !ok {
+		scheme = "udp"
+	}
+
+	var port uint16
+	switch scheme {
+	case "udp":
+		proto, port = dnstap.SocketProtocolUDP, defaultPlainDNSPort
+	case "tcp":
+		proto, port = dnstap.SocketProtocolTCP, defaultPlainDNSPort
+	case "tls":
+		proto, port = dnstap.SocketProtocolDOT, defaultTLSPort
+	case "https", "h3":
+		proto, port = dnstap.SocketProtocolDOH, defaultHTTPSPort
+	case "quic":
+		proto, port = dnstap.SocketProtocolDOQ, defaultQUICPort
+	case "sdns":
+		return netip.AddrPort{}, dnstap.SocketProtocolDNSCryptUDP
+	default:
+		return netip.AddrPort{}, dnstap.SocketProtocolNone
+	}
+
+	hostPort := upsAddr
+	if u, err := url.Parse(upsAddr); ok && err == nil {
+		hostPort = u.Host
+	}
+
+	addr, err := netip.ParseAddrPort(hostPort)
+	if err == nil {
+		return addr, proto
+	}
+
+	// Unlike [aghnet.ParseAddrPort], support the IPv6 addresses in brackets
+	// without a port, as in "https://[2001:db8::1]/dns-query".
+	ip, err := netip.ParseAddr(strings.Trim(hostPort, "[]"))
+	if err != nil {
+		// The upstream is set by a hostname.
+		return netip.AddrPort{}, proto
+	}
+
+	return netip.AddrPortFrom(ip, port), proto
+}
+
+// tapUpstreamConfig wraps the upstreams of uc into tapUpstreams writing to w.
+// The same upstream is wrapped only once.
+func tapUpstreamConfig(uc *proxy.UpstreamConfig, w DnstapWriter) {
+	wrapped := map[upstream.Upstream]upstream.Upstream{}
+	wrapAll := func(ups []upstream.Upstream) (res []upstream.Upstream) {
+		if ups == nil {
+			// Keep the domains resolved by the general upstreams as is.
+			return nil
+		}
+
+		res = make([]upstream.Upstream, 0, len(ups))
+		for _, u := range ups {
+			tu, ok := wrapped[u]
+			if !ok {
+				tu = newTapUpstream(u, w)
+				wrapped[u] = tu
+			}
+
+			res = append(res, tu)
+		}
+
+		return res
+	}
+
+	uc.Upstreams = wrapAll(uc.Upstreams)
+	for d, ups := range uc.DomainReservedUpstreams {
+		uc.DomainReservedUpstreams[d] = wrapAll(ups)
+	}
+
+	for d, ups := range uc.SpecifiedDomainUpstreams {
+		uc.SpecifiedDomainUpstreams[d] = wrapAll(ups)
+	}
+}
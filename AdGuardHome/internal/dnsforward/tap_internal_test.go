@@ -0,0 +1,119 @@
+package dnsforward
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/dnstap"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDnstapWriter is a [DnstapWriter] that records the messages.
+type testDnstapWriter struct {
+	mu   *sync.Mutex
+	msgs []*dnstap.Message
+}
+
+// type check
+var _ DnstapWriter = (*testDnstapWriter)(nil)
+
+// WriteMessage implements the [DnstapWriter] interface for *testDnstapWriter.
+func (w *testDnstapWriter) WriteMessage(msg *dnstap.Message) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.msgs = append(w.msgs, msg)
+}
+
+func TestTapUpstream_Exchange(t *testing.T) {
+	w := &testDnstapWriter{mu: &sync.Mutex{}}
+	ups := &aghtest.UpstreamMock{
+		OnAddress: func() (a string) { return "tls://192.0.2.1" },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			return (&dns.Msg{}).SetReply(req), nil
+		},
+		OnClose: func() (err error) { return nil },
+	}
+
+	uc := &proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{ups},
+		DomainReservedUpstreams: map[string][]upstream.Upstream{
+			"example.org.": {ups},
+			"example.com.": nil,
+		},
+	}
+
+	tapUpstreamConfig(uc, w)
+
+	require.Len(t, uc.Upstreams, 1)
+	assert.Same(t, uc.Upstreams[0], uc.DomainReservedUpstreams["example.org."][0])
+	assert.Nil(t, uc.DomainReservedUpstreams["example.com."])
+
+	req := (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+	_, err := uc.Upstreams[0].Exchange(req)
+	require.NoError(t, err)
+
+	require.Len(t, w.msgs, 2)
+
+	wantAddr := netip.MustParseAddrPort("192.0.2.1:853")
+
+	query := w.msgs[0]
+	assert.Equal(t, dnstap.MessageTypeForwarderQuery, query.Type)
+	assert.Equal(t, dnstap.SocketProtocolDOT, query.Protocol)
+	assert.Equal(t, wantAddr, query.ResponseAddr)
+	assert.NotEmpty(t, query.QueryMessage)
+
+	resp := w.msgs[1]
+	assert.Equal(t, dnstap.MessageTypeForwarderResponse, resp.Type)
+	assert.Equal(t, wantAddr, resp.ResponseAddr)
+	assert.NotEmpty(t, resp.ResponseMessage)
+	assert.False(t, resp.ResponseTime.Before(resp.QueryTime))
+}
+
+func TestUpstreamTapAddr(t *testing.T) {
+	testCases := []struct {
+		wantAddr  netip.AddrPort
+		name      string
+		addr      string
+		wantProto dnstap.SocketProtocol
+	}{{
+		wantAddr:  netip.MustParseAddrPort("192.0.2.1:53"),
+		name:      "plain",
+		addr:      "192.0.2.1:53",
+		wantProto: dnstap.SocketProtocolUDP,
+	}, {
+		wantAddr:  netip.MustParseAddrPort("192.0.2.1:5353"),
+		name:      "tcp",
+		addr:      "tcp://192.0.2.1:5353",
+		wantProto: dnstap.SocketProtocolTCP,
+	}, {
+		wantAddr:  netip.MustParseAddrPort("[2001:db8::1]:443"),
+		name:      "https_default_port",
+		addr:      "https://[2001:db8::1]/dns-query",
+		wantProto: dnstap.SocketProtocolDOH,
+	}, {
+		wantAddr:  netip.AddrPort{},
+		name:      "quic_hostname",
+		addr:      "quic://dns.example:853",
+		wantProto: dnstap.SocketProtocolDOQ,
+	}, {
+		wantAddr:  netip.AddrPort{},
+		name:      "dnscrypt",
+		addr:      "sdns://AQcAAAAAAAAAFDE3Ni4xMDMuMTMwLjEzMDo1NDQz",
+		wantProto: dnstap.SocketProtocolDNSCryptUDP,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, proto := upstreamTapAddr(tc.addr)
+			assert.Equal(t, tc.wantAddr, addr)
+			assert.Equal(t, tc.wantProto, proto)
+		})
+	}
+}
@@ -156,7 +156,9 @@ func setProxyUpstreamMode(
 	case UpstreamModeFastestAddr:
 		conf.UpstreamMode = proxy.UModeFastestAddr
 		conf.FastestPingTimeout = fastestTimeout
-	case UpstreamModeLoadBalance:
+	case UpstreamModeLoadBalance, UpstreamModeAdaptive:
+		// In the adaptive mode, the general upstreams are wrapped into a
+		// single [adaptiveSelector], so the proxy doesn't balance them.
 		conf.UpstreamMode = proxy.UModeLoadBalance
 	default:
 		return fmt.Errorf("unexpected value %q", upstreamMode)
@@ -0,0 +1,363 @@
+// Package dnstap contains the writer of the dnstap messages about the DNS
+// queries and responses over the Frame Streams protocol.
+//
+// See https://dnstap.info and https://farsightsec.github.io/fstrm.
+package dnstap
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/version"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// Supported networks of the receiver.
+const (
+	NetworkUnix = "unix"
+	NetworkTCP  = "tcp"
+)
+
+// Default values of the configuration.
+const (
+	defaultBufferSize        = 10_000
+	defaultReconnectInterval = 1 * time.Second
+	defaultTimeout           = 5 * time.Second
+
+	// maxReconnectInterval is the maximum pause between the attempts to
+	// connect to the receiver.
+	maxReconnectInterval = 1 * time.Minute
+
+	// closeTimeout is the maximum time to wait for the queued messages to be
+	// written on shutdown.
+	closeTimeout = 5 * time.Second
+)
+
+// Config is the configuration of the dnstap writer.
+type Config struct {
+	// Network is the network of the receiver, either "unix" or "tcp".
+	Network string `yaml:"network"`
+
+	// Address is the path of the Unix socket or the host and port of the TCP
+	// receiver.
+	Address string `yaml:"address"`
+
+	// Identity is the name of the server sent in every message.  If empty,
+	// the hostname is used.
+	Identity string `yaml:"identity"`
+
+	// Version is the version of the server sent in every message.  If empty,
+	// "AdGuard Home" followed by the version is used.
+	Version string `yaml:"version"`
+
+	// BufferSize is the maximum number of messages waiting to be written.  The
+	// new messages are dropped when the buffer is full, for example while the
+	// receiver is unavailable.  If zero, 10000 is used.
+	BufferSize int `yaml:"buffer_size"`
+
+	// ReconnectInterval is the pause before the first attempt to reconnect to
+	// the receiver, doubled with each failed attempt up to one minute.  If
+	// zero, one second is used.
+	ReconnectInterval timeutil.Duration `yaml:"reconnect_interval"`
+
+	// Timeout is the timeout of connecting to the receiver and writing to it.
+	// If zero, five seconds are used.
+	Timeout timeutil.Duration `yaml:"timeout"`
+
+	// Enabled defines if the dnstap messages are written.
+	Enabled bool `yaml:"enabled"`
+}
+
+// validate returns an error if c is not valid.
+func (c *Config) validate() (err error) {
+	switch {
+	case c.Network != NetworkUnix && c.Network != NetworkTCP:
+		return fmt.Errorf("network: unsupported value %q", c.Network)
+	case c.Address == "":
+		return errors.Error("empty address")
+	case c.BufferSize < 0:
+		return fmt.Errorf("buffer_size: negative value %d", c.BufferSize)
+	case c.ReconnectInterval.Duration < 0:
+		return fmt.Errorf("reconnect_interval: negative value %s", c.ReconnectInterval)
+	case c.Timeout.Duration < 0:
+		return fmt.Errorf("timeout: negative value %s", c.Timeout)
+	default:
+		return nil
+	}
+}
+
+// Writer writes the dnstap messages to a Frame Streams receiver in the
+// background, reconnecting to it when the connection is lost.
+type Writer struct {
+	// msgs are the messages waiting to be written.  It's never closed, so that
+	// WriteMessage doesn't panic during the shutdown.
+	msgs chan *Message
+
+	// stop is closed to stop the writing goroutine.
+	stop chan struct{}
+
+	// done is closed when the writing goroutine exits.
+	done chan struct{}
+
+	// closeOnce makes sure that Close only stops the writer once.
+	closeOnce *sync.Once
+
+	network string
+	addr    string
+
+	identity []byte
+	version  []byte
+
+	reconnectIvl time.Duration
+	timeout      time.Duration
+
+	// started is true if the writing goroutine has been started.
+	started atomic.Bool
+
+	// dropped is the number of the messages dropped because the buffer was
+	// full or the connection was lost.
+	dropped atomic.Uint64
+}
+
+// New returns a new dnstap writer.  c must not be nil and must be enabled.
+func New(c *Config) (w *Writer, err error) {
+	err = c.validate()
+	if err != nil {
+		return nil, fmt.Errorf("dnstap: %w", err)
+	}
+
+	identity := c.Identity
+	if identity == "" {
+		identity, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("dnstap: getting hostname: %w", err)
+		}
+	}
+
+	return &Writer{
+		msgs:         make(chan *Message, cmp.Or(c.BufferSize, defaultBufferSize)),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+		closeOnce:    &sync.Once{},
+		network:      c.Network,
+		addr:         c.Address,
+		identity:     []byte(identity),
+		version:      []byte(cmp.Or(c.Version, "AdGuard Home "+version.Version())),
+		reconnectIvl: cmp.Or(c.ReconnectInterval.Duration, defaultReconnectInterval),
+		timeout:      cmp.Or(c.Timeout.Duration, defaultTimeout),
+	}, nil
+}
+
+// WriteMessage queues msg for writing.  It never blocks and drops msg if the
+// buffer is full.  msg must not be modified after the call.  It's safe for
+// concurrent use.
+func (w *Writer) WriteMessage(msg *Message) {
+	select {
+	case w.msgs <- msg:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Start starts the writing goroutine.
+func (w *Writer) Start() {
+	w.started.Store(true)
+
+	go w.run()
+}
+
+// Close stops the writing goroutine, waiting until the queued messages are
+// written or the timeout expires.  Only the first call has any effect.
+func (w *Writer) Close() (err error) {
+	w.closeOnce.Do(func() {
+		if !w.started.Load() {
+			return
+		}
+
+		close(w.stop)
+
+		select {
+		case <-w.done:
+		case <-time.After(closeTimeout):
+			err = errors.Error("timed out writing queued messages")
+		}
+
+		if dropped := w.dropped.Load(); dropped > 0 {
+			log.Info("dnstap: %d messages have been dropped", dropped)
+		}
+	})
+
+	return err
+}
+
+// run connects to the receiver and writes the messages to it until stop is
+// closed.  It's intended to be used as a goroutine.
+func (w *Writer) run() {
+	defer log.OnPanic("dnstap")
+	defer close(w.done)
+
+	delay := w.reconnectIvl
+	for {
+		conn, err := w.connect()
+		if err != nil {
+			log.Error("dnstap: connecting to %s: %s; retrying in %s", w.addr, err, delay)
+
+			select {
+			case <-time.After(delay):
+				delay = min(delay*2, maxReconnectInterval)
+
+				continue
+			case <-w.stop:
+				return
+			}
+		}
+
+		log.Info("dnstap: connected to %s", w.addr)
+		delay = w.reconnectIvl
+
+		stopped, err := w.serve(conn)
+		err = errors.WithDeferred(err, conn.Close())
+		if stopped {
+			if err != nil {
+				log.Error("dnstap: closing connection: %s", err)
+			}
+
+			return
+		}
+
+		log.Error("dnstap: connection to %s lost: %s; reconnecting", w.addr, err)
+	}
+}
+
+// connect connects to the receiver and performs the Frame Streams handshake.
+func (w *Writer) connect() (conn net.Conn, err error) {
+	c, err := net.DialTimeout(w.network, w.addr, w.timeout)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			err = errors.WithDeferred(err, c.Close())
+		}
+	}()
+
+	err = c.SetDeadline(time.Now().Add(w.timeout))
+	if err != nil {
+		return nil, fmt.Errorf("setting deadline: %w", err)
+	}
+
+	_, err = c.Write(appendControlFrame(nil, controlReady))
+	if err != nil {
+		return nil, fmt.Errorf("writing %s: %w", controlReady, err)
+	}
+
+	err = expectControlFrame(c, controlAccept)
+	if err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+
+	_, err = c.Write(appendControlFrame(nil, controlStart))
+	if err != nil {
+		return nil, fmt.Errorf("writing %s: %w", controlStart, err)
+	}
+
+	err = c.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("resetting deadline: %w", err)
+	}
+
+	return c, nil
+}
+
+// serve writes the messages to conn until stop is closed or an error occurs.
+// stopped is true if the stream has been stopped because of stop.
+func (w *Writer) serve(conn net.Conn) (stopped bool, err error) {
+	var buf []byte
+	var n int
+	for {
+		select {
+		case msg := <-w.msgs:
+			buf, n = w.appendPending(buf[:0], msg)
+		case <-w.stop:
+			buf, n = w.appendPending(buf[:0], nil)
+			err = w.finish(conn, buf)
+			if err != nil {
+				w.dropped.Add(uint64(n))
+			}
+
+			return true, err
+		}
+
+		err = w.write(conn, buf)
+		if err != nil {
+			w.dropped.Add(uint64(n))
+
+			return false, err
+		}
+	}
+}
+
+// appendPending appends the data frames of msg, if not nil, and of the other
+// messages currently in the buffer to b.  n is the number of the appended
+// frames.
+func (w *Writer) appendPending(b []byte, msg *Message) (res []byte, n int) {
+	if msg != nil {
+		b = appendDataFrame(b, appendDnstap(nil, w.identity, w.version, msg))
+		n++
+	}
+
+	for {
+		select {
+		case msg = <-w.msgs:
+			b = appendDataFrame(b, appendDnstap(nil, w.identity, w.version, msg))
+			n++
+		default:
+			return b, n
+		}
+	}
+}
+
+// write writes the frames to conn within the timeout.
+func (w *Writer) write(conn net.Conn, frames []byte) (err error) {
+	err = conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	if err != nil {
+		return fmt.Errorf("setting deadline: %w", err)
+	}
+
+	_, err = conn.Write(frames)
+	if err != nil {
+		return fmt.Errorf("writing: %w", err)
+	}
+
+	return nil
+}
+
+// finish writes the remaining frames and stops the stream.
+func (w *Writer) finish(conn net.Conn, frames []byte) (err error) {
+	err = w.write(conn, appendControlFrame(frames, controlStop))
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(w.timeout))
+	if err != nil {
+		return fmt.Errorf("setting deadline: %w", err)
+	}
+
+	err = expectControlFrame(conn, controlFinish)
+	if err != nil {
+		return fmt.Errorf("stopping: %w", err)
+	}
+
+	return nil
+}
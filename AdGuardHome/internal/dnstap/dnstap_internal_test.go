@@ -0,0 +1,282 @@
+package dnstap
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// protoFields are the decoded fields of a protobuf message by their numbers.
+// The values are uint64 for varints, uint32 for fixed32, and []byte for
+// length-delimited fields.
+type protoFields map[uint64][]any
+
+// decodeProto decodes the protobuf message b.
+func decodeProto(t *testing.T, b []byte) (fields protoFields) {
+	t.Helper()
+
+	fields = protoFields{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		require.Positive(t, n)
+
+		b = b[n:]
+
+		var val any
+		switch wireType := tag & (1<<protoWireTypeBits - 1); wireType {
+		case protoWireTypeVarint:
+			v, vn := binary.Uvarint(b)
+			require.Positive(t, vn)
+
+			val, b = v, b[vn:]
+		case protoWireTypeFixed32:
+			require.GreaterOrEqual(t, len(b), 4)
+
+			val, b = binary.LittleEndian.Uint32(b), b[4:]
+		case protoWireTypeBytes:
+			l, ln := binary.Uvarint(b)
+			require.Positive(t, ln)
+			require.GreaterOrEqual(t, uint64(len(b)-ln), l)
+
+			val, b = b[ln:ln+int(l)], b[ln+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", wireType)
+		}
+
+		field := tag >> protoWireTypeBits
+		fields[field] = append(fields[field], val)
+	}
+
+	return fields
+}
+
+// receiver is a test Frame Streams receiver.  It accepts a single connection,
+// performs the handshake, and sends the payloads of the data frames to frames.
+func receiver(lsn net.Listener, frames chan<- []byte) {
+	pt := testutil.PanicT{}
+
+	conn, err := lsn.Accept()
+	require.NoError(pt, err)
+
+	defer func() { require.NoError(pt, conn.Close()) }()
+
+	require.NoError(pt, expectControlFrame(conn, controlReady))
+
+	_, err = conn.Write(appendControlFrame(nil, controlAccept))
+	require.NoError(pt, err)
+
+	require.NoError(pt, expectControlFrame(conn, controlStart))
+
+	for {
+		var hdr [4]byte
+		_, err = io.ReadFull(conn, hdr[:])
+		require.NoError(pt, err)
+
+		frameLen := binary.BigEndian.Uint32(hdr[:])
+		if frameLen == 0 {
+			break
+		}
+
+		frame := make([]byte, frameLen)
+		_, err = io.ReadFull(conn, frame)
+		require.NoError(pt, err)
+
+		frames <- frame
+	}
+
+	// Read the rest of the STOP control frame.
+	var stop [8]byte
+	_, err = io.ReadFull(conn, stop[:])
+	require.NoError(pt, err)
+	require.Equal(pt, uint32(controlStop), binary.BigEndian.Uint32(stop[4:]))
+
+	_, err = conn.Write(appendControlFrame(nil, controlFinish))
+	require.NoError(pt, err)
+
+	close(frames)
+}
+
+func TestWriter(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, lsn.Close)
+
+	frames := make(chan []byte, 10)
+	go receiver(lsn, frames)
+
+	w, err := New(&Config{
+		Network:  NetworkTCP,
+		Address:  lsn.Addr().String(),
+		Identity: "resolver-1",
+		Version:  "test",
+		Enabled:  true,
+	})
+	require.NoError(t, err)
+
+	w.Start()
+
+	queryTime := time.Unix(1_700_000_000, 123_456_789)
+	w.WriteMessage(&Message{
+		QueryTime:    queryTime,
+		QueryAddr:    netip.MustParseAddrPort("192.0.2.1:53535"),
+		QueryMessage: []byte{1, 2, 3},
+		Type:         MessageTypeClientQuery,
+		Protocol:     SocketProtocolUDP,
+	})
+	w.WriteMessage(&Message{
+		QueryTime:       queryTime,
+		ResponseTime:    queryTime.Add(time.Millisecond),
+		ResponseAddr:    netip.MustParseAddrPort("[2001:db8::1]:853"),
+		ResponseMessage: []byte{4, 5, 6},
+		Type:            MessageTypeForwarderResponse,
+		Protocol:        SocketProtocolDOT,
+	})
+
+	require.NoError(t, w.Close())
+
+	var got []protoFields
+	for frame := range frames {
+		got = append(got, decodeProto(t, frame))
+	}
+
+	require.Len(t, got, 2)
+
+	for _, env := range got {
+		assert.Equal(t, []any{[]byte("resolver-1")}, env[fieldDnstapIdentity])
+		assert.Equal(t, []any{[]byte("test")}, env[fieldDnstapVersion])
+		assert.Equal(t, []any{uint64(dnstapTypeMessage)}, env[fieldDnstapType])
+		require.Len(t, env[fieldDnstapMessage], 1)
+	}
+
+	query := decodeProto(t, got[0][fieldDnstapMessage][0].([]byte))
+	assert.Equal(t, protoFields{
+		fieldMsgType:           {uint64(MessageTypeClientQuery)},
+		fieldMsgSocketFamily:   {uint64(socketFamilyINET)},
+		fieldMsgSocketProtocol: {uint64(SocketProtocolUDP)},
+		fieldMsgQueryAddress:   {[]byte{192, 0, 2, 1}},
+		fieldMsgQueryPort:      {uint64(53535)},
+		fieldMsgQueryTimeSec:   {uint64(1_700_000_000)},
+		fieldMsgQueryTimeNsec:  {uint32(123_456_789)},
+		fieldMsgQueryMessage:   {[]byte{1, 2, 3}},
+	}, query)
+
+	resp := decodeProto(t, got[1][fieldDnstapMessage][0].([]byte))
+	assert.Equal(t, []any{uint64(MessageTypeForwarderResponse)}, resp[fieldMsgType])
+	assert.Equal(t, []any{uint64(socketFamilyINET6)}, resp[fieldMsgSocketFamily])
+	assert.Equal(t, []any{uint64(SocketProtocolDOT)}, resp[fieldMsgSocketProtocol])
+	assert.Equal(t, []any{uint64(853)}, resp[fieldMsgResponsePort])
+	assert.Equal(t, []any{uint32(124_456_789)}, resp[fieldMsgResponseTimeNsec])
+	assert.Equal(t, []any{[]byte{4, 5, 6}}, resp[fieldMsgResponseMessage])
+	assert.NotContains(t, resp, fieldMsgQueryAddress)
+}
+
+func TestWriter_reconnect(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "dnstap.sock")
+
+	w, err := New(&Config{
+		Network:           NetworkUnix,
+		Address:           sockPath,
+		Identity:          "resolver-1",
+		ReconnectInterval: timeutil.Duration{Duration: 10 * time.Millisecond},
+		Enabled:           true,
+	})
+	require.NoError(t, err)
+
+	// Start the writer before the receiver, so that the first attempts to
+	// connect fail and the message waits in the buffer.
+	w.Start()
+	w.WriteMessage(&Message{
+		Type:     MessageTypeClientQuery,
+		Protocol: SocketProtocolTCP,
+	})
+
+	time.Sleep(50 * time.Millisecond)
+
+	lsn, err := net.Listen(NetworkUnix, sockPath)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, lsn.Close)
+
+	frames := make(chan []byte, 10)
+	go receiver(lsn, frames)
+
+	frame, ok := testutil.RequireReceive(t, frames, testTimeout)
+	require.True(t, ok)
+
+	env := decodeProto(t, frame)
+	msg := decodeProto(t, env[fieldDnstapMessage][0].([]byte))
+	assert.Equal(t, []any{uint64(SocketProtocolTCP)}, msg[fieldMsgSocketProtocol])
+
+	require.NoError(t, w.Close())
+}
+
+func TestWriter_WriteMessage_drop(t *testing.T) {
+	w, err := New(&Config{
+		Network:    NetworkTCP,
+		Address:    "127.0.0.1:6000",
+		Identity:   "resolver-1",
+		BufferSize: 1,
+		Enabled:    true,
+	})
+	require.NoError(t, err)
+
+	// Don't start the writer, so that the messages stay in the buffer.
+	for range 3 {
+		w.WriteMessage(&Message{Type: MessageTypeClientQuery})
+	}
+
+	assert.Len(t, w.msgs, 1)
+	assert.Equal(t, uint64(2), w.dropped.Load())
+
+	require.NoError(t, w.Close())
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		conf       *Config
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       &Config{Network: "udp", Address: "127.0.0.1:6000"},
+		name:       "bad_network",
+		wantErrMsg: `dnstap: network: unsupported value "udp"`,
+	}, {
+		conf:       &Config{Network: NetworkUnix},
+		name:       "no_address",
+		wantErrMsg: "dnstap: empty address",
+	}, {
+		conf: &Config{
+			Network:    NetworkTCP,
+			Address:    "127.0.0.1:6000",
+			BufferSize: -1,
+		},
+		name:       "negative_buffer_size",
+		wantErrMsg: "dnstap: buffer_size: negative value -1",
+	}, {
+		conf:       &Config{Network: NetworkUnix, Address: "/run/dnstap.sock"},
+		name:       "success",
+		wantErrMsg: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
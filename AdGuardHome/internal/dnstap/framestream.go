@@ -0,0 +1,166 @@
+package dnstap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// contentType is the Frame Streams content type of the dnstap data frames.
+const contentType = "protobuf:dnstap.Dnstap"
+
+// controlType is the type of a Frame Streams control frame.
+type controlType uint32
+
+// Frame Streams control frame types.
+const (
+	controlAccept controlType = 0x01
+	controlStart  controlType = 0x02
+	controlStop   controlType = 0x03
+	controlReady  controlType = 0x04
+	controlFinish controlType = 0x05
+)
+
+// String implements the [fmt.Stringer] interface for controlType.
+func (t controlType) String() (s string) {
+	switch t {
+	case controlAccept:
+		return "ACCEPT"
+	case controlStart:
+		return "START"
+	case controlStop:
+		return "STOP"
+	case controlReady:
+		return "READY"
+	case controlFinish:
+		return "FINISH"
+	default:
+		return fmt.Sprintf("!bad_control_type_%d", uint32(t))
+	}
+}
+
+// controlFieldContentType is the type of the content type field of the
+// control frames.
+const controlFieldContentType = 0x01
+
+// maxControlFrameLen is the maximum length of a control frame accepted from
+// the receiver.
+const maxControlFrameLen = 512
+
+// controlFrame is a decoded Frame Streams control frame.
+type controlFrame struct {
+	// contentTypes are the values of the content type fields.
+	contentTypes [][]byte
+
+	typ controlType
+}
+
+// hasContentType returns true if the frame doesn't restrict the content types
+// or contains the dnstap one.
+func (f *controlFrame) hasContentType() (ok bool) {
+	if len(f.contentTypes) == 0 {
+		return true
+	}
+
+	for _, ct := range f.contentTypes {
+		if string(ct) == contentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appendControlFrame appends the control frame of the type with the dnstap
+// content type field to b.  The STOP and FINISH frames have no fields.
+func appendControlFrame(b []byte, typ controlType) (res []byte) {
+	var fields []byte
+	if typ != controlStop && typ != controlFinish {
+		fields = binary.BigEndian.AppendUint32(fields, controlFieldContentType)
+		fields = binary.BigEndian.AppendUint32(fields, uint32(len(contentType)))
+		fields = append(fields, contentType...)
+	}
+
+	// The escape sequence, which is a zero data frame length.
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(4+len(fields)))
+	b = binary.BigEndian.AppendUint32(b, uint32(typ))
+
+	return append(b, fields...)
+}
+
+// appendDataFrame appends the data frame with payload to b.
+func appendDataFrame(b, payload []byte) (res []byte) {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+
+	return append(b, payload...)
+}
+
+// readControlFrame reads and decodes a control frame from r.
+func readControlFrame(r io.Reader) (f *controlFrame, err error) {
+	var hdr [8]byte
+	_, err = io.ReadFull(r, hdr[:])
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	if escape := binary.BigEndian.Uint32(hdr[:4]); escape != 0 {
+		return nil, fmt.Errorf("expected control frame, got data frame of length %d", escape)
+	}
+
+	frameLen := binary.BigEndian.Uint32(hdr[4:])
+	if frameLen < 4 || frameLen > maxControlFrameLen {
+		return nil, fmt.Errorf("bad control frame length %d", frameLen)
+	}
+
+	data := make([]byte, frameLen)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, fmt.Errorf("reading control frame: %w", err)
+	}
+
+	f = &controlFrame{
+		typ: controlType(binary.BigEndian.Uint32(data)),
+	}
+
+	fields := bytes.NewReader(data[4:])
+	for fields.Len() > 0 {
+		var fieldHdr [8]byte
+		_, err = io.ReadFull(fields, fieldHdr[:])
+		if err != nil {
+			return nil, fmt.Errorf("reading field header: %w", err)
+		}
+
+		fieldLen := binary.BigEndian.Uint32(fieldHdr[4:])
+		if int64(fieldLen) > int64(fields.Len()) {
+			return nil, fmt.Errorf("field length %d exceeds frame", fieldLen)
+		}
+
+		val := make([]byte, fieldLen)
+		_, _ = fields.Read(val)
+
+		if binary.BigEndian.Uint32(fieldHdr[:4]) == controlFieldContentType {
+			f.contentTypes = append(f.contentTypes, val)
+		}
+	}
+
+	return f, nil
+}
+
+// expectControlFrame reads a control frame of the type from r.
+func expectControlFrame(r io.Reader, typ controlType) (err error) {
+	f, err := readControlFrame(r)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if f.typ != typ {
+		return fmt.Errorf("expected %s control frame, got %s", typ, f.typ)
+	} else if !f.hasContentType() {
+		return errors.Error("receiver doesn't support dnstap content type")
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package dnstap
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+// MessageType is the type of a dnstap message.  See the Message.Type
+// enumeration in dnstap.proto.
+type MessageType uint8
+
+// Message types produced by the DNS server.
+const (
+	MessageTypeClientQuery       MessageType = 5
+	MessageTypeClientResponse    MessageType = 6
+	MessageTypeForwarderQuery    MessageType = 7
+	MessageTypeForwarderResponse MessageType = 8
+)
+
+// String implements the [fmt.Stringer] interface for MessageType.
+func (t MessageType) String() (s string) {
+	switch t {
+	case MessageTypeClientQuery:
+		return "CLIENT_QUERY"
+	case MessageTypeClientResponse:
+		return "CLIENT_RESPONSE"
+	case MessageTypeForwarderQuery:
+		return "FORWARDER_QUERY"
+	case MessageTypeForwarderResponse:
+		return "FORWARDER_RESPONSE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// SocketProtocol is the transport protocol of a DNS message.  See the
+// SocketProtocol enumeration in dnstap.proto.
+type SocketProtocol uint8
+
+// Socket protocols.
+const (
+	SocketProtocolNone        SocketProtocol = 0
+	SocketProtocolUDP         SocketProtocol = 1
+	SocketProtocolTCP         SocketProtocol = 2
+	SocketProtocolDOT         SocketProtocol = 3
+	SocketProtocolDOH         SocketProtocol = 4
+	SocketProtocolDNSCryptUDP SocketProtocol = 5
+	SocketProtocolDNSCryptTCP SocketProtocol = 6
+	SocketProtocolDOQ         SocketProtocol = 7
+)
+
+// Socket families.  See the SocketFamily enumeration in dnstap.proto.
+const (
+	socketFamilyINET  = 1
+	socketFamilyINET6 = 2
+)
+
+// dnstapTypeMessage is the type of the Dnstap envelope carrying a Message.
+const dnstapTypeMessage = 1
+
+// Field numbers of the Dnstap envelope.
+const (
+	fieldDnstapIdentity = 1
+	fieldDnstapVersion  = 2
+	fieldDnstapMessage  = 14
+	fieldDnstapType     = 15
+)
+
+// Field numbers of the Message.
+const (
+	fieldMsgType             = 1
+	fieldMsgSocketFamily     = 2
+	fieldMsgSocketProtocol   = 3
+	fieldMsgQueryAddress     = 4
+	fieldMsgResponseAddress  = 5
+	fieldMsgQueryPort        = 6
+	fieldMsgResponsePort     = 7
+	fieldMsgQueryTimeSec     = 8
+	fieldMsgQueryTimeNsec    = 9
+	fieldMsgQueryMessage     = 10
+	fieldMsgResponseTimeSec  = 12
+	fieldMsgResponseTimeNsec = 13
+	fieldMsgResponseMessage  = 14
+)
+
+// Protobuf wire types.
+const (
+	protoWireTypeVarint  = 0
+	protoWireTypeBytes   = 2
+	protoWireTypeFixed32 = 5
+
+	// protoWireTypeBits is the number of the bits of the wire type in a tag.
+	protoWireTypeBits = 3
+)
+
+// Message is a single dnstap message about a DNS query or response.
+type Message struct {
+	// QueryTime is the time the query was received or sent.  It's not
+	// encoded if zero.
+	QueryTime time.Time
+
+	// ResponseTime is the time the response was received or sent.  It's not
+	// encoded if zero.
+	ResponseTime time.Time
+
+	// QueryAddr is the address of the initiator of the query.  It's not
+	// encoded if invalid.
+	QueryAddr netip.AddrPort
+
+	// ResponseAddr is the address of the responder.  It's not encoded if
+	// invalid.
+	ResponseAddr netip.AddrPort
+
+	// QueryMessage is the wire-format query, if any.
+	QueryMessage []byte
+
+	// ResponseMessage is the wire-format response, if any.
+	ResponseMessage []byte
+
+	// Type is the type of the message.
+	Type MessageType
+
+	// Protocol is the transport protocol of the DNS message.
+	Protocol SocketProtocol
+}
+
+// appendDnstap appends the protobuf encoding of the Dnstap envelope containing
+// msg to b.  identity and version are omitted if empty.
+func appendDnstap(b []byte, identity, version []byte, msg *Message) (res []byte) {
+	if len(identity) > 0 {
+		b = appendBytesField(b, fieldDnstapIdentity, identity)
+	}
+
+	if len(version) > 0 {
+		b = appendBytesField(b, fieldDnstapVersion, version)
+	}
+
+	b = appendBytesField(b, fieldDnstapMessage, msg.appendProto(nil))
+
+	return appendVarintField(b, fieldDnstapType, dnstapTypeMessage)
+}
+
+// appendProto appends the protobuf encoding of msg to b.
+func (msg *Message) appendProto(b []byte) (res []byte) {
+	b = appendVarintField(b, fieldMsgType, uint64(msg.Type))
+
+	if fam := socketFamily(msg.QueryAddr, msg.ResponseAddr); fam != 0 {
+		b = appendVarintField(b, fieldMsgSocketFamily, fam)
+	}
+
+	if msg.Protocol != SocketProtocolNone {
+		b = appendVarintField(b, fieldMsgSocketProtocol, uint64(msg.Protocol))
+	}
+
+	if a := msg.QueryAddr; a.IsValid() {
+		b = appendBytesField(b, fieldMsgQueryAddress, a.Addr().Unmap().AsSlice())
+		b = appendVarintField(b, fieldMsgQueryPort, uint64(a.Port()))
+	}
+
+	if a := msg.ResponseAddr; a.IsValid() {
+		b = appendBytesField(b, fieldMsgResponseAddress, a.Addr().Unmap().AsSlice())
+		b = appendVarintField(b, fieldMsgResponsePort, uint64(a.Port()))
+	}
+
+	if !msg.QueryTime.IsZero() {
+		b = appendVarintField(b, fieldMsgQueryTimeSec, uint64(msg.QueryTime.Unix()))
+		b = appendFixed32Field(b, fieldMsgQueryTimeNsec, uint32(msg.QueryTime.Nanosecond()))
+	}
+
+	if msg.QueryMessage != nil {
+		b = appendBytesField(b, fieldMsgQueryMessage, msg.QueryMessage)
+	}
+
+	if !msg.ResponseTime.IsZero() {
+		b = appendVarintField(b, fieldMsgResponseTimeSec, uint64(msg.ResponseTime.Unix()))
+		b = appendFixed32Field(b, fieldMsgResponseTimeNsec, uint32(msg.ResponseTime.Nanosecond()))
+	}
+
+	if msg.ResponseMessage != nil {
+		b = appendBytesField(b, fieldMsgResponseMessage, msg.ResponseMessage)
+	}
+
+	return b
+}
+
+// socketFamily returns the socket family of the first valid address or zero if
+// there are none.
+func socketFamily(addrs ...netip.AddrPort) (fam uint64) {
+	for _, a := range addrs {
+		if !a.IsValid() {
+			continue
+		}
+
+		if a.Addr().Unmap().Is4() {
+			return socketFamilyINET
+		}
+
+		return socketFamilyINET6
+	}
+
+	return 0
+}
+
+// appendTag appends the protobuf tag of the field to b.
+func appendTag(b []byte, field, wireType uint64) (res []byte) {
+	return binary.AppendUvarint(b, field<<protoWireTypeBits|wireType)
+}
+
+// appendVarintField appends the protobuf varint field to b.
+func appendVarintField(b []byte, field, v uint64) (res []byte) {
+	b = appendTag(b, field, protoWireTypeVarint)
+
+	return binary.AppendUvarint(b, v)
+}
+
+// appendFixed32Field appends the protobuf fixed32 field to b.
+func appendFixed32Field(b []byte, field uint64, v uint32) (res []byte) {
+	b = appendTag(b, field, protoWireTypeFixed32)
+
+	return binary.LittleEndian.AppendUint32(b, v)
+}
+
+// appendBytesField appends the protobuf length-delimited field to b.
+func appendBytesField(b []byte, field uint64, v []byte) (res []byte) {
+	b = appendTag(b, field, protoWireTypeBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+
+	return append(b, v...)
+}
@@ -82,6 +82,19 @@ func (s *BlockedServices) Validate() (err error) {
 	return nil
 }
 
+// MatchService returns true if host matches any of the rules of the blocked
+// service with id.  The package must be initialized with [InitModule].
+func MatchService(id, host string) (ok bool) {
+	req := rules.NewRequestForHostname(host)
+	for _, rule := range serviceRules[id] {
+		if rule.Match(req) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // ApplyBlockedServices - set blocked services settings for this DNS request
 func (d *DNSFilter) ApplyBlockedServices(setts *Settings) {
 	d.confMu.RLock()
@@ -1,9 +1,12 @@
 package filtering
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"slices"
@@ -22,6 +25,10 @@ import (
 // filters.
 const filterDir = "filters"
 
+// zoneTransferTimeout is the timeout for the network operations of the RPZ zone
+// transfers.
+const zoneTransferTimeout = 1 * time.Minute
+
 // FilterYAML represents a filter list in the configuration file.
 //
 // TODO(e.burkov):  Investigate if the field ordering is important.
@@ -34,6 +41,11 @@ type FilterYAML struct {
 	checksum    uint32    // checksum of the file data
 	white       bool
 
+	// PinnedVersion is the number of the saved version the filter is pinned
+	// at.  The pinned filters aren't refreshed.  Zero means that the filter
+	// isn't pinned.
+	PinnedVersion uint64 `yaml:"pinned_version,omitempty"`
+
 	Filter `yaml:",inline"`
 }
 
@@ -51,6 +63,21 @@ func (filter *FilterYAML) Path(dataDir string) string {
 		strconv.FormatInt(int64(filter.ID), 10)+".txt")
 }
 
+// zonePath returns the path to the cached RPZ zone of the filter transferred
+// from its primary server.
+func (filter *FilterYAML) zonePath(dataDir string) (p string) {
+	return filepath.Join(
+		dataDir,
+		filterDir,
+		strconv.FormatInt(int64(filter.ID), 10)+".zone")
+}
+
+// hasURL returns true if the URL of the filter is listURL or its redacted form
+// returned in the HTTP API responses, see [rulelist.RedactURL].
+func (filter *FilterYAML) hasURL(listURL string) (ok bool) {
+	return filter.URL == listURL || rulelist.RedactURL(filter.URL) == listURL
+}
+
 // ensureName sets provided title or default name for the filter if it doesn't
 // have name already.
 func (filter *FilterYAML) ensureName(title string) {
@@ -97,7 +124,7 @@ func (d *DNSFilter) filterSetProperties(
 		filters = d.conf.WhitelistFilters
 	}
 
-	i := slices.IndexFunc(filters, func(flt FilterYAML) bool { return flt.URL == listURL })
+	i := slices.IndexFunc(filters, func(flt FilterYAML) bool { return flt.hasURL(listURL) })
 	if i == -1 {
 		return false, errFilterNotExist
 	}
@@ -132,6 +159,7 @@ func (d *DNSFilter) filterSetProperties(
 
 		flt.URL = newList.URL
 		flt.LastUpdated = time.Time{}
+		flt.PinnedVersion = 0
 		flt.unload()
 	}
 
@@ -141,7 +169,10 @@ func (d *DNSFilter) filterSetProperties(
 	}
 
 	if flt.Enabled {
-		if shouldRestart {
+		if shouldRestart && flt.PinnedVersion != 0 {
+			// Keep the contents of the version the filter is pinned at.
+			err = d.load(flt)
+		} else if shouldRestart {
 			// Download the filter contents.
 			shouldRestart, err = d.update(flt)
 		}
@@ -274,7 +305,7 @@ func (d *DNSFilter) listsToUpdate(filters *[]FilterYAML, force bool) (toUpd []Fi
 	for i := range *filters {
 		flt := &(*filters)[i] // otherwise we will be operating on a copy
 
-		if !flt.Enabled {
+		if !flt.Enabled || flt.PinnedVersion != 0 {
 			continue
 		}
 
@@ -458,20 +489,38 @@ func (d *DNSFilter) updateIntl(flt *FilterYAML) (ok bool, err error) {
 	}
 	defer func() { err = d.finalizeUpdate(tmpFile, flt, res, err, ok) }()
 
-	r, err := d.reader(flt.URL)
+	r, err := d.reader(flt)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return false, err
 	}
 	defer func() { err = errors.WithDeferred(err, r.Close()) }()
 
+	res, err = d.parse(tmpFile, r)
+
+	return res.Checksum != flt.checksum && err == nil, err
+}
+
+// rpzPeekSize is the size of the beginning of the filter data used to detect
+// the RPZ zone files.
+const rpzPeekSize = 4096
+
+// parse parses the filter data from r into dst.  The data is parsed either as
+// an RPZ zone file or as a filtering-rule list depending on its beginning.  res
+// is never nil.
+func (d *DNSFilter) parse(dst io.Writer, r io.Reader) (res *rulelist.ParseResult, err error) {
+	br := bufio.NewReaderSize(r, rpzPeekSize)
+
+	// Ignore the error, since it's returned from the parsers anyway.
+	head, _ := br.Peek(rpzPeekSize)
+	if rulelist.IsRPZ(head) {
+		return rulelist.NewRPZParser().Parse(dst, br)
+	}
+
 	bufPtr := d.bufPool.Get()
 	defer d.bufPool.Put(bufPtr)
 
-	p := rulelist.NewParser()
-	res, err = p.Parse(tmpFile, r, *bufPtr)
-
-	return res.Checksum != flt.checksum && err == nil, err
+	return rulelist.NewParser().Parse(dst, br, *bufPtr)
 }
 
 // finalizeUpdate closes and gets rid of temporary file f with filter's content
@@ -495,6 +544,11 @@ func (d *DNSFilter) finalizeUpdate(
 
 	log.Info("filtering: saving contents of filter %d into %q", id, flt.Path(d.conf.DataDir))
 
+	err = d.saveInitialVersion(flt)
+	if err != nil {
+		log.Error("filtering: saving initial version of filter %d: %s", id, err)
+	}
+
 	err = file.CloseReplace()
 	if err != nil {
 		return fmt.Errorf("finalizing update: %w", err)
@@ -503,6 +557,11 @@ func (d *DNSFilter) finalizeUpdate(
 	rulesCount := res.RulesCount
 	log.Info("filtering: updated filter %d: %d bytes, %d rules", id, res.BytesWritten, rulesCount)
 
+	err = d.saveVersion(flt, res, flt.URL, time.Now())
+	if err != nil {
+		log.Error("filtering: saving version of filter %d: %s", id, err)
+	}
+
 	flt.ensureName(res.Title)
 	flt.checksum = res.Checksum
 	flt.RulesCount = rulesCount
@@ -511,8 +570,19 @@ func (d *DNSFilter) finalizeUpdate(
 }
 
 // reader returns an io.ReadCloser reading filtering-rule list data form either
-// a file on the filesystem or the filter's HTTP URL.
-func (d *DNSFilter) reader(fltURL string) (r io.ReadCloser, err error) {
+// a file on the filesystem, the filter's HTTP URL, or the primary server of the
+// filter's RPZ zone.
+func (d *DNSFilter) reader(flt *FilterYAML) (r io.ReadCloser, err error) {
+	fltURL := flt.URL
+	if strings.HasPrefix(fltURL, rulelist.ZoneTransferScheme+"://") {
+		r, err = d.readerFromZoneTransfer(flt)
+		if err != nil {
+			return nil, fmt.Errorf("transferring zone: %w", err)
+		}
+
+		return r, nil
+	}
+
 	if !filepath.IsAbs(fltURL) {
 		r, err = d.readerFromURL(fltURL)
 		if err != nil {
@@ -546,6 +616,34 @@ func (d *DNSFilter) readerFromURL(fltURL string) (r io.ReadCloser, err error) {
 	return resp.Body, nil
 }
 
+// readerFromZoneTransfer returns an io.ReadCloser reading the RPZ zone data
+// transferred from the primary server of the filter.
+func (d *DNSFilter) readerFromZoneTransfer(flt *FilterYAML) (r io.ReadCloser, err error) {
+	u, err := url.Parse(flt.URL)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	zt, err := rulelist.NewZoneTransfer(&rulelist.ZoneTransferConfig{
+		URL:       u,
+		CachePath: flt.zonePath(d.conf.DataDir),
+		Timeout:   zoneTransferTimeout,
+	})
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	data, err := zt.Refresh()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
 // loads filter contents from the file in dataDir
 func (d *DNSFilter) load(flt *FilterYAML) (err error) {
 	fileName := flt.Path(d.conf.DataDir)
@@ -3,6 +3,7 @@ package filtering
 import (
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -11,6 +12,8 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -173,3 +176,99 @@ func TestFilterYAML_EnsureName(t *testing.T) {
 		assert.Equal(t, "List 0", f.Name)
 	})
 }
+
+func TestDNSFilter_Update_rpz(t *testing.T) {
+	const content = `$ORIGIN rpz.example.
+@ 300 IN SOA ns.rpz.example. admin.rpz.example. 1 3600 600 86400 300
+@ 300 IN NS ns.rpz.example.
+nxdomain.example CNAME .
+*.nodata.example CNAME *.
+passthru.nxdomain.example CNAME rpz-passthru.
+*.nxdomain.example CNAME .
+local.example A 192.0.2.1
+local.example TXT "a,b"
+`
+
+	dnsFilter := newDNSFilter(t)
+	f := &FilterYAML{
+		URL: serveFiltersLocally(t, []byte(content)),
+	}
+
+	updateAndAssert(t, dnsFilter, f, require.True, 7)
+	assert.Equal(t, "rpz.example", f.Name)
+
+	data, err := os.ReadFile(f.Path(dnsFilter.conf.DataDir))
+	require.NoError(t, err)
+
+	d, _ := newForTest(t, nil, []Filter{{ID: 1, Data: data}})
+	setts := &Settings{
+		FilteringEnabled: true,
+	}
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantRCode  int
+		wantReason Reason
+		wantAnswer bool
+	}{{
+		name:       "nxdomain",
+		host:       "nxdomain.example",
+		wantRCode:  dns.RcodeNameError,
+		wantReason: RewrittenRule,
+		wantAnswer: false,
+	}, {
+		name:       "nxdomain_wildcard",
+		host:       "sub.nxdomain.example",
+		wantRCode:  dns.RcodeNameError,
+		wantReason: RewrittenRule,
+		wantAnswer: false,
+	}, {
+		name:       "nodata",
+		host:       "sub.nodata.example",
+		wantRCode:  dns.RcodeSuccess,
+		wantReason: RewrittenRule,
+		wantAnswer: false,
+	}, {
+		name:       "nodata_not_wildcard",
+		host:       "nodata.example",
+		wantRCode:  dns.RcodeSuccess,
+		wantReason: NotFilteredNotFound,
+		wantAnswer: false,
+	}, {
+		name:       "passthru",
+		host:       "passthru.nxdomain.example",
+		wantRCode:  dns.RcodeSuccess,
+		wantReason: NotFilteredNotFound,
+		wantAnswer: false,
+	}, {
+		name:       "local_data",
+		host:       "local.example",
+		wantRCode:  dns.RcodeSuccess,
+		wantReason: RewrittenRule,
+		wantAnswer: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, resErr := d.CheckHostRules(tc.host, dns.TypeA, setts)
+			require.NoError(t, resErr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantReason != RewrittenRule {
+				return
+			}
+
+			require.NotNil(t, res.DNSRewriteResult)
+
+			assert.Equal(t, tc.wantRCode, res.DNSRewriteResult.RCode)
+			if !tc.wantAnswer {
+				return
+			}
+
+			resp := res.DNSRewriteResult.Response
+			assert.Equal(t, []rules.RRValue{netip.MustParseAddr("192.0.2.1")}, resp[dns.TypeA])
+			assert.Equal(t, []rules.RRValue{"a,b"}, resp[dns.TypeTXT])
+		})
+	}
+}
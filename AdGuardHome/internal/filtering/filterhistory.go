@@ -0,0 +1,404 @@
+package filtering
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// errVersionNotExist is returned when there is no saved version of a filter
+// with the desired number.
+const errVersionNotExist errors.Error = "version doesn't exist"
+
+// historyDirExt is the extension of the directory within the filter directory
+// that contains the saved versions of a filter.
+const historyDirExt = ".history"
+
+// historyIndexName is the name of the file within the history directory of a
+// filter that contains the metadata of the saved versions.
+const historyIndexName = "index.json"
+
+// filterVersion is the metadata of a saved version of a filter.
+type filterVersion struct {
+	// Time is the time when the version has been downloaded.
+	Time time.Time `json:"time"`
+
+	// URL is the URL or the file path the version has been downloaded from.
+	// It's empty for the version saved from the file downloaded before the
+	// history was enabled.
+	URL string `json:"url"`
+
+	// Version is the number of the version.  The numbers start with 1 and
+	// grow with each new version of the filter.
+	Version uint64 `json:"version"`
+
+	// RulesCount is the number of rules in the version.
+	RulesCount int `json:"rules_count"`
+
+	// Checksum is the checksum of the rules of the version.
+	Checksum uint32 `json:"checksum"`
+}
+
+// historyPath returns the path to the directory containing the saved versions
+// of the filter.
+func (filter *FilterYAML) historyPath(dataDir string) (p string) {
+	return filepath.Join(
+		dataDir,
+		filterDir,
+		strconv.FormatInt(int64(filter.ID), 10)+historyDirExt)
+}
+
+// versionPath returns the path to the contents of the version v within the
+// history directory dir.
+func versionPath(dir string, v uint64) (p string) {
+	return filepath.Join(dir, strconv.FormatUint(v, 10)+".txt")
+}
+
+// readHistory returns the metadata of the versions saved in the history
+// directory dir, from the oldest to the newest.  versions are nil if there are
+// none.
+func readHistory(dir string) (versions []*filterVersion, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, historyIndexName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &versions)
+	if err != nil {
+		return nil, fmt.Errorf("decoding history index: %w", err)
+	}
+
+	return versions, nil
+}
+
+// writeHistory replaces the metadata of the versions saved in the history
+// directory dir with versions.
+func writeHistory(dir string, versions []*filterVersion) (err error) {
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return fmt.Errorf("encoding history index: %w", err)
+	}
+
+	file, err := aghrenameio.NewPendingFile(filepath.Join(dir, historyIndexName), 0o644)
+	if err != nil {
+		return fmt.Errorf("writing history index: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, file) }()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return fmt.Errorf("writing history index: %w", err)
+	}
+
+	return nil
+}
+
+// copyFile atomically replaces the file dst with the contents of the file src.
+func copyFile(dst, src string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, in.Close()) }()
+
+	out, err := aghrenameio.NewPendingFile(dst, 0o644)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, out) }()
+
+	_, err = io.Copy(out, in)
+
+	// Don't wrap the error since it's informative enough as is.
+	return err
+}
+
+// saveInitialVersion saves the current contents of flt's file as the first
+// version of flt if the history is enabled but has no versions yet, so that
+// the contents downloaded before the history was enabled could be restored.
+// It must be called before the file is replaced.
+func (d *DNSFilter) saveInitialVersion(flt *FilterYAML) (err error) {
+	if d.conf.FiltersHistorySize == 0 {
+		return nil
+	}
+
+	versions, err := readHistory(flt.historyPath(d.conf.DataDir))
+	if err != nil || len(versions) > 0 {
+		return err
+	}
+
+	file, err := os.Open(flt.Path(d.conf.DataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, file.Close()) }()
+
+	st, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("getting filter file stat: %w", err)
+	}
+
+	bufPtr := d.bufPool.Get()
+	defer d.bufPool.Put(bufPtr)
+
+	res, err := rulelist.NewParser().Parse(io.Discard, file, *bufPtr)
+	if err != nil {
+		return fmt.Errorf("parsing filter file: %w", err)
+	}
+
+	return d.saveVersion(flt, res, "", st.ModTime())
+}
+
+// saveVersion saves the current contents of flt's file described by res as the
+// newest version of flt and removes the oldest versions exceeding the history
+// size.  listURL and updated are the source and the time of the download.  It
+// does nothing if the history is disabled or the newest saved version has the
+// same checksum.
+func (d *DNSFilter) saveVersion(
+	flt *FilterYAML,
+	res *rulelist.ParseResult,
+	listURL string,
+	updated time.Time,
+) (err error) {
+	size := int(d.conf.FiltersHistorySize)
+	if size == 0 {
+		return nil
+	}
+
+	dir := flt.historyPath(d.conf.DataDir)
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating history dir: %w", err)
+	}
+
+	versions, err := readHistory(dir)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	next := uint64(1)
+	if l := len(versions); l > 0 {
+		last := versions[l-1]
+		if last.Checksum == res.Checksum {
+			return nil
+		}
+
+		next = last.Version + 1
+	}
+
+	err = copyFile(versionPath(dir, next), flt.Path(d.conf.DataDir))
+	if err != nil {
+		return fmt.Errorf("saving version %d: %w", next, err)
+	}
+
+	versions = append(versions, &filterVersion{
+		Time:       updated,
+		URL:        listURL,
+		Version:    next,
+		RulesCount: res.RulesCount,
+		Checksum:   res.Checksum,
+	})
+
+	if excess := len(versions) - size; excess > 0 {
+		for _, v := range versions[:excess] {
+			rmErr := os.Remove(versionPath(dir, v.Version))
+			if rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+				log.Info("filtering: warning: removing version %d of filter %d: %s", v.Version, flt.ID, rmErr)
+			}
+		}
+
+		versions = versions[excess:]
+	}
+
+	log.Debug("filtering: saved version %d of filter %d", next, flt.ID)
+
+	return writeHistory(dir, versions)
+}
+
+// readVersionRules returns the set of rules of the version v saved in the
+// history directory dir.
+func (d *DNSFilter) readVersionRules(dir string, v uint64) (rules *container.MapSet[string], err error) {
+	file, err := os.Open(versionPath(dir, v))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("version %d: %w", v, errVersionNotExist)
+	} else if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, file.Close()) }()
+
+	bufPtr := d.bufPool.Get()
+	defer d.bufPool.Put(bufPtr)
+
+	rules = container.NewMapSet[string]()
+
+	// The saved versions only contain the rules, one per line.  See
+	// [DNSFilter.parse].
+	s := bufio.NewScanner(file)
+	s.Buffer(*bufPtr, bufio.MaxScanTokenSize)
+	for s.Scan() {
+		rules.Add(s.Text())
+	}
+
+	return rules, errors.Annotate(s.Err(), "scanning version %d: %w", v)
+}
+
+// diffVersions returns the rules added and removed by the version to of the
+// filter with the history directory dir compared to the version from.  Both
+// slices are sorted.
+func (d *DNSFilter) diffVersions(dir string, from, to uint64) (added, removed []string, err error) {
+	fromRules, err := d.readVersionRules(dir, from)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, nil, err
+	}
+
+	toRules, err := d.readVersionRules(dir, to)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, nil, err
+	}
+
+	added, removed = []string{}, []string{}
+	toRules.Range(func(r string) (cont bool) {
+		if !fromRules.Has(r) {
+			added = append(added, r)
+		}
+
+		return true
+	})
+	fromRules.Range(func(r string) (cont bool) {
+		if !toRules.Has(r) {
+			removed = append(removed, r)
+		}
+
+		return true
+	})
+
+	slices.Sort(added)
+	slices.Sort(removed)
+
+	return added, removed, nil
+}
+
+// filterByURL returns a copy of the filter with the URL listURL, possibly
+// redacted, among either the allowlists or the blocklists.  It's safe for
+// concurrent use.
+func (d *DNSFilter) filterByURL(listURL string, isAllowlist bool) (flt FilterYAML, err error) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	filters := d.conf.Filters
+	if isAllowlist {
+		filters = d.conf.WhitelistFilters
+	}
+
+	i := slices.IndexFunc(filters, func(f FilterYAML) bool { return f.hasURL(listURL) })
+	if i == -1 {
+		return FilterYAML{}, errFilterNotExist
+	}
+
+	return filters[i], nil
+}
+
+// rollbackFilter replaces the contents of the filter with the URL listURL with
+// its saved version v.  If pin is true, the filter is pinned at v and isn't
+// refreshed until unpinned.  Otherwise, the filter is unpinned and the newest
+// version is downloaded on the next scheduled refresh.
+func (d *DNSFilter) rollbackFilter(listURL string, isAllowlist bool, v uint64, pin bool) (err error) {
+	// Make sure the filter isn't being refreshed concurrently.
+	d.refreshLock.Lock()
+	defer d.refreshLock.Unlock()
+
+	d.conf.filtersMu.Lock()
+	defer d.conf.filtersMu.Unlock()
+
+	filters := d.conf.Filters
+	if isAllowlist {
+		filters = d.conf.WhitelistFilters
+	}
+
+	i := slices.IndexFunc(filters, func(f FilterYAML) bool { return f.hasURL(listURL) })
+	if i == -1 {
+		return errFilterNotExist
+	}
+
+	flt := &filters[i]
+	dir := flt.historyPath(d.conf.DataDir)
+	versions, err := readHistory(dir)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	vi := slices.IndexFunc(versions, func(fv *filterVersion) bool { return fv.Version == v })
+	if vi == -1 {
+		return fmt.Errorf("version %d: %w", v, errVersionNotExist)
+	}
+
+	err = copyFile(flt.Path(d.conf.DataDir), versionPath(dir, v))
+	if err != nil {
+		return fmt.Errorf("restoring version %d: %w", v, err)
+	}
+
+	fv := versions[vi]
+	flt.checksum, flt.RulesCount = fv.Checksum, fv.RulesCount
+
+	// Postpone the next scheduled refresh of an unpinned filter to keep the
+	// restored version for at least the update interval.
+	flt.LastUpdated = time.Now()
+
+	flt.PinnedVersion = 0
+	if pin {
+		flt.PinnedVersion = v
+	}
+
+	log.Info("filtering: rolled back filter %d to version %d; pinned: %t", flt.ID, v, pin)
+
+	return nil
+}
+
+// unpinFilter unpins the filter with the URL listURL so that it's refreshed
+// again.
+func (d *DNSFilter) unpinFilter(listURL string, isAllowlist bool) (err error) {
+	d.conf.filtersMu.Lock()
+	defer d.conf.filtersMu.Unlock()
+
+	filters := d.conf.Filters
+	if isAllowlist {
+		filters = d.conf.WhitelistFilters
+	}
+
+	i := slices.IndexFunc(filters, func(f FilterYAML) bool { return f.hasURL(listURL) })
+	if i == -1 {
+		return errFilterNotExist
+	}
+
+	flt := &filters[i]
+	flt.PinnedVersion = 0
+
+	log.Info("filtering: unpinned filter %d", flt.ID)
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package filtering
+
+import (
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveChangingFilter is a helper that concurrently listens on a free port to
+// respond with the current value of content.
+func serveChangingFilter(t *testing.T, content *atomic.Pointer[string]) (urlStr string) {
+	t.Helper()
+
+	return serveHTTPLocally(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		pt := testutil.PanicT{}
+
+		_, werr := w.Write([]byte(*content.Load()))
+		require.NoError(pt, werr)
+	}))
+}
+
+// setContentAndUpdate sets the served filter content and updates flt.
+func setContentAndUpdate(
+	t *testing.T,
+	d *DNSFilter,
+	flt *FilterYAML,
+	content *atomic.Pointer[string],
+	newContent string,
+) {
+	t.Helper()
+
+	content.Store(&newContent)
+
+	_, err := d.update(flt)
+	require.NoError(t, err)
+}
+
+// historyVersions returns the numbers of the saved versions of flt.
+func historyVersions(t *testing.T, d *DNSFilter, flt *FilterYAML) (nums []uint64) {
+	t.Helper()
+
+	versions, err := readHistory(flt.historyPath(d.conf.DataDir))
+	require.NoError(t, err)
+
+	for _, v := range versions {
+		nums = append(nums, v.Version)
+	}
+
+	return nums
+}
+
+func TestDNSFilter_history(t *testing.T) {
+	const (
+		content1 = "! Title: Test\n||first.example^\n||common.example^\n"
+		content2 = "! Title: Test\n||common.example^\n||second.example^\n"
+		content3 = "! Title: Test\n||common.example^\n||third.example^\n"
+	)
+
+	content := &atomic.Pointer[string]{}
+	addr := serveChangingFilter(t, content)
+
+	d := newDNSFilter(t)
+	d.conf.FiltersHistorySize = 2
+	d.conf.Filters = []FilterYAML{{
+		Enabled: true,
+		URL:     addr,
+		Filter:  Filter{ID: 1},
+	}}
+
+	flt := &d.conf.Filters[0]
+	dir := flt.historyPath(d.conf.DataDir)
+
+	setContentAndUpdate(t, d, flt, content, content1)
+	assert.Equal(t, []uint64{1}, historyVersions(t, d, flt))
+
+	setContentAndUpdate(t, d, flt, content, content2)
+	assert.Equal(t, []uint64{1, 2}, historyVersions(t, d, flt))
+
+	t.Run("diff", func(t *testing.T) {
+		added, removed, err := d.diffVersions(dir, 1, 2)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"||second.example^"}, added)
+		assert.Equal(t, []string{"||first.example^"}, removed)
+
+		added, removed, err = d.diffVersions(dir, 2, 2)
+		require.NoError(t, err)
+
+		assert.Empty(t, added)
+		assert.Empty(t, removed)
+
+		_, _, err = d.diffVersions(dir, 1, 42)
+		assert.ErrorIs(t, err, errVersionNotExist)
+	})
+
+	setContentAndUpdate(t, d, flt, content, content3)
+
+	t.Run("prune", func(t *testing.T) {
+		assert.Equal(t, []uint64{2, 3}, historyVersions(t, d, flt))
+		assert.NoFileExists(t, versionPath(dir, 1))
+	})
+
+	t.Run("rollback_pin", func(t *testing.T) {
+		err := d.rollbackFilter(addr, false, 2, true)
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(flt.Path(d.conf.DataDir))
+		require.NoError(t, err)
+
+		assert.Equal(t, "||common.example^\n||second.example^\n", string(data))
+		assert.Equal(t, uint64(2), flt.PinnedVersion)
+		assert.Equal(t, 2, flt.RulesCount)
+		assert.Empty(t, d.listsToUpdate(&d.conf.Filters, true))
+	})
+
+	t.Run("unpin", func(t *testing.T) {
+		err := d.unpinFilter(addr, false)
+		require.NoError(t, err)
+
+		assert.Zero(t, flt.PinnedVersion)
+		assert.Len(t, d.listsToUpdate(&d.conf.Filters, true), 1)
+	})
+
+	t.Run("rollback_pruned", func(t *testing.T) {
+		err := d.rollbackFilter(addr, false, 1, false)
+		assert.ErrorIs(t, err, errVersionNotExist)
+	})
+
+	t.Run("rollback_no_filter", func(t *testing.T) {
+		err := d.rollbackFilter(addr, true, 2, false)
+		assert.ErrorIs(t, err, errFilterNotExist)
+	})
+
+	t.Run("same_contents", func(t *testing.T) {
+		err := d.rollbackFilter(addr, false, 2, false)
+		require.NoError(t, err)
+
+		// The newest version is downloaded again, but isn't saved twice.
+		setContentAndUpdate(t, d, flt, content, content3)
+		assert.Equal(t, []uint64{2, 3}, historyVersions(t, d, flt))
+	})
+}
+
+func TestDNSFilter_history_initialVersion(t *testing.T) {
+	content := &atomic.Pointer[string]{}
+	addr := serveChangingFilter(t, content)
+
+	d := newDNSFilter(t)
+	flt := &FilterYAML{
+		URL:    addr,
+		Filter: Filter{ID: 1},
+	}
+
+	setContentAndUpdate(t, d, flt, content, "||first.example^\n")
+	assert.Empty(t, historyVersions(t, d, flt))
+
+	d.conf.FiltersHistorySize = 5
+	setContentAndUpdate(t, d, flt, content, "||second.example^\n")
+
+	versions, err := readHistory(flt.historyPath(d.conf.DataDir))
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+
+	assert.Empty(t, versions[0].URL)
+	assert.Equal(t, addr, versions[1].URL)
+
+	added, removed, err := d.diffVersions(flt.historyPath(d.conf.DataDir), 1, 2)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"||second.example^"}, added)
+	assert.Equal(t, []string{"||first.example^"}, removed)
+}
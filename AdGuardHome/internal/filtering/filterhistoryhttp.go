@@ -0,0 +1,192 @@
+package filtering
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+)
+
+// filterVersionJSON is the JSON representation of a saved version of a filter.
+type filterVersionJSON struct {
+	Time       string `json:"time"`
+	URL        string `json:"url,omitempty"`
+	Version    uint64 `json:"version"`
+	RulesCount int    `json:"rules_count"`
+
+	// Current is true if the version is the one currently used.
+	Current bool `json:"current"`
+}
+
+// filterHistoryResp is the response to the GET /control/filtering/history HTTP
+// API.
+type filterHistoryResp struct {
+	Versions      []*filterVersionJSON `json:"versions"`
+	PinnedVersion uint64               `json:"pinned_version,omitempty"`
+}
+
+// filterHistoryDiffResp is the response to the GET
+// /control/filtering/history/diff HTTP API.
+type filterHistoryDiffResp struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	From    uint64   `json:"from"`
+	To      uint64   `json:"to"`
+}
+
+// filterHistoryRollbackReq is the request to the POST
+// /control/filtering/history/rollback HTTP API.
+type filterHistoryRollbackReq struct {
+	URL       string `json:"url"`
+	Version   uint64 `json:"version"`
+	Whitelist bool   `json:"whitelist"`
+	Pin       bool   `json:"pin"`
+}
+
+// filterHistoryUnpinReq is the request to the POST
+// /control/filtering/history/unpin HTTP API.
+type filterHistoryUnpinReq struct {
+	URL       string `json:"url"`
+	Whitelist bool   `json:"whitelist"`
+}
+
+// filterFromQuery returns the filter set by the url and whitelist query
+// parameters of q.
+func (d *DNSFilter) filterFromQuery(q url.Values) (flt FilterYAML, err error) {
+	isAllowlist := false
+	if s := q.Get("whitelist"); s != "" {
+		isAllowlist, err = strconv.ParseBool(s)
+		if err != nil {
+			return FilterYAML{}, fmt.Errorf("whitelist: %w", err)
+		}
+	}
+
+	return d.filterByURL(q.Get("url"), isAllowlist)
+}
+
+// handleFilteringHistory is the handler for the GET /control/filtering/history
+// HTTP API.
+func (d *DNSFilter) handleFilteringHistory(w http.ResponseWriter, r *http.Request) {
+	flt, err := d.filterFromQuery(r.URL.Query())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	versions, err := readHistory(flt.historyPath(d.conf.DataDir))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "reading history: %s", err)
+
+		return
+	}
+
+	resp := &filterHistoryResp{
+		Versions:      make([]*filterVersionJSON, 0, len(versions)),
+		PinnedVersion: flt.PinnedVersion,
+	}
+
+	currentFound := false
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+
+		// Mark only the newest of the versions with the same contents.
+		isCurrent := !currentFound && v.Checksum == flt.checksum
+		currentFound = currentFound || isCurrent
+
+		resp.Versions = append(resp.Versions, &filterVersionJSON{
+			Time:       v.Time.Format(time.RFC3339),
+			URL:        rulelist.RedactURL(v.URL),
+			Version:    v.Version,
+			RulesCount: v.RulesCount,
+			Current:    isCurrent,
+		})
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleFilteringHistoryDiff is the handler for the GET
+// /control/filtering/history/diff HTTP API.
+func (d *DNSFilter) handleFilteringHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	flt, err := d.filterFromQuery(q)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	resp := &filterHistoryDiffResp{}
+	resp.From, err = strconv.ParseUint(q.Get("from"), 10, 64)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "from: %s", err)
+
+		return
+	}
+
+	resp.To, err = strconv.ParseUint(q.Get("to"), 10, 64)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "to: %s", err)
+
+		return
+	}
+
+	dir := flt.historyPath(d.conf.DataDir)
+	resp.Added, resp.Removed, err = d.diffVersions(dir, resp.From, resp.To)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "comparing versions: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleFilteringHistoryRollback is the handler for the POST
+// /control/filtering/history/rollback HTTP API.
+func (d *DNSFilter) handleFilteringHistoryRollback(w http.ResponseWriter, r *http.Request) {
+	req := &filterHistoryRollbackReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+
+		return
+	}
+
+	err = d.rollbackFilter(req.URL, req.Whitelist, req.Version, req.Pin)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "rolling back filter: %s", err)
+
+		return
+	}
+
+	d.conf.ConfigModified()
+	d.EnableFilters(true)
+}
+
+// handleFilteringHistoryUnpin is the handler for the POST
+// /control/filtering/history/unpin HTTP API.
+func (d *DNSFilter) handleFilteringHistoryUnpin(w http.ResponseWriter, r *http.Request) {
+	req := &filterHistoryUnpinReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+
+		return
+	}
+
+	err = d.unpinFilter(req.URL, req.Whitelist)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "unpinning filter: %s", err)
+
+		return
+	}
+
+	d.conf.ConfigModified()
+}
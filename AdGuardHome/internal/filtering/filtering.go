@@ -121,6 +121,10 @@ type Config struct {
 
 	Rewrites []*LegacyRewrite `yaml:"rewrites"`
 
+	// ResponseRules are the rules matching the responses from the upstream
+	// servers, such as the IP addresses in the answers.
+	ResponseRules []*ResponseRule `yaml:"response_rules"`
+
 	// Filters are the blocking filter lists.
 	Filters []FilterYAML `yaml:"-"`
 
@@ -147,6 +151,11 @@ type Config struct {
 	// (in hours).
 	FiltersUpdateIntervalHours uint32 `yaml:"filters_update_interval"`
 
+	// FiltersHistorySize is the number of the previous versions of each filter
+	// list kept on disk.  Each version is a full copy of the list, so the disk
+	// usage grows accordingly.  If 0, the versions aren't kept.
+	FiltersHistorySize uint32 `yaml:"filters_history_size"`
+
 	// BlockedResponseTTL is the time-to-live value for blocked responses.  If
 	// 0, then default value is used (3600).
 	BlockedResponseTTL uint32 `yaml:"blocked_response_ttl"`
@@ -315,6 +324,16 @@ const (
 	//
 	// See https://github.com/AdguardTeam/AdGuardHome/issues/2499.
 	RewrittenRule
+
+	// FilteredAnomaly is returned when the request has been detected as
+	// anomalous, for example as DNS tunnelling.  The request is only blocked
+	// if IsFiltered is true.
+	FilteredAnomaly
+
+	// FilteredResponseRule is returned when the response has been matched by
+	// a response rule.  The response is only blocked if IsFiltered is true,
+	// otherwise the matched records have been stripped or rewritten.
+	FilteredResponseRule
 )
 
 // TODO(a.garipov): Resync with actual code names or replace completely
@@ -334,6 +353,9 @@ var reasonNames = []string{
 	Rewritten:          "Rewrite",
 	RewrittenAutoHosts: "RewriteEtcHosts",
 	RewrittenRule:      "RewriteRule",
+
+	FilteredAnomaly:      "FilteredAnomaly",
+	FilteredResponseRule: "FilteredResponseRule",
 }
 
 func (r Reason) String() string {
@@ -373,6 +395,7 @@ func (d *DNSFilter) WriteDiskConfig(c *Config) {
 
 		*c = *d.conf
 		c.Rewrites = cloneRewrites(c.Rewrites)
+		c.ResponseRules = slices.Clone(c.ResponseRules)
 	}()
 
 	d.conf.filtersMu.RLock()
@@ -1023,6 +1046,11 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 		return nil, fmt.Errorf("rewrites: preparing: %s", err)
 	}
 
+	err = d.prepareResponseRules()
+	if err != nil {
+		return nil, fmt.Errorf("response rules: %w", err)
+	}
+
 	if d.conf.BlockedServices != nil {
 		err = d.conf.BlockedServices.Validate()
 		if err != nil {
@@ -36,18 +36,25 @@ func validateFilterURL(urlStr string) (err error) {
 		return err
 	}
 
-	if s := u.Scheme; s != aghhttp.SchemeHTTP && s != aghhttp.SchemeHTTPS {
+	switch s := u.Scheme; s {
+	case aghhttp.SchemeHTTP, aghhttp.SchemeHTTPS:
+		return nil
+	case rulelist.ZoneTransferScheme:
+		_, err = rulelist.NewZoneTransfer(&rulelist.ZoneTransferConfig{URL: u})
+
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	default:
 		return &url.Error{
 			Op:  "Check scheme",
 			URL: urlStr,
 			Err: fmt.Errorf("only %v allowed", []string{
 				aghhttp.SchemeHTTP,
 				aghhttp.SchemeHTTPS,
+				rulelist.ZoneTransferScheme,
 			}),
 		}
 	}
-
-	return nil
 }
 
 type filterAddJSON struct {
@@ -161,7 +168,7 @@ func (d *DNSFilter) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 		}
 
 		delIdx := slices.IndexFunc(*filters, func(flt FilterYAML) bool {
-			return flt.URL == req.URL
+			return flt.hasURL(req.URL)
 		})
 		if delIdx == -1 {
 			log.Error("deleting filter with url %q: %s", req.URL, errFilterNotExist)
@@ -178,6 +185,18 @@ func (d *DNSFilter) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
+		zp := deleted.zonePath(d.conf.DataDir)
+		err = os.Remove(zp)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error("deleting filter %d: removing zone file %q: %s", deleted.ID, zp, err)
+		}
+
+		hp := deleted.historyPath(d.conf.DataDir)
+		err = os.RemoveAll(hp)
+		if err != nil {
+			log.Error("deleting filter %d: removing history dir %q: %s", deleted.ID, hp, err)
+		}
+
 		*filters = slices.Delete(*filters, delIdx, delIdx+1)
 
 		log.Info("deleted filter %d", deleted.ID)
@@ -225,6 +244,14 @@ func (d *DNSFilter) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// The secrets in the URLs are redacted in the responses, so keep the
+	// original URL if it's sent back unchanged.
+	if prev, prevErr := d.filterByURL(fj.URL, fj.Whitelist); prevErr == nil {
+		if fj.Data.URL == rulelist.RedactURL(prev.URL) {
+			fj.Data.URL = prev.URL
+		}
+	}
+
 	err = validateFilterURL(fj.Data.URL)
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "invalid url: %s", err)
@@ -308,12 +335,13 @@ func (d *DNSFilter) handleFilteringRefresh(w http.ResponseWriter, r *http.Reques
 }
 
 type filterJSON struct {
-	URL         string               `json:"url"`
-	Name        string               `json:"name"`
-	LastUpdated string               `json:"last_updated,omitempty"`
-	ID          rulelist.URLFilterID `json:"id"`
-	RulesCount  uint32               `json:"rules_count"`
-	Enabled     bool                 `json:"enabled"`
+	URL           string               `json:"url"`
+	Name          string               `json:"name"`
+	LastUpdated   string               `json:"last_updated,omitempty"`
+	PinnedVersion uint64               `json:"pinned_version,omitempty"`
+	ID            rulelist.URLFilterID `json:"id"`
+	RulesCount    uint32               `json:"rules_count"`
+	Enabled       bool                 `json:"enabled"`
 }
 
 type filteringConfig struct {
@@ -326,11 +354,12 @@ type filteringConfig struct {
 
 func filterToJSON(f FilterYAML) filterJSON {
 	fj := filterJSON{
-		ID:         f.ID,
-		Enabled:    f.Enabled,
-		URL:        f.URL,
-		Name:       f.Name,
-		RulesCount: uint32(f.RulesCount),
+		ID:            f.ID,
+		Enabled:       f.Enabled,
+		URL:           rulelist.RedactURL(f.URL),
+		Name:          f.Name,
+		RulesCount:    uint32(f.RulesCount),
+		PinnedVersion: f.PinnedVersion,
 	}
 
 	if !f.LastUpdated.IsZero() {
@@ -561,6 +590,10 @@ func (d *DNSFilter) RegisterFilteringHandlers() {
 	registerHTTP(http.MethodPut, "/control/rewrite/update", d.handleRewriteUpdate)
 	registerHTTP(http.MethodPost, "/control/rewrite/delete", d.handleRewriteDelete)
 
+	registerHTTP(http.MethodGet, "/control/response_rules/list", d.handleResponseRuleList)
+	registerHTTP(http.MethodPost, "/control/response_rules/add", d.handleResponseRuleAdd)
+	registerHTTP(http.MethodPost, "/control/response_rules/delete", d.handleResponseRuleDelete)
+
 	registerHTTP(http.MethodGet, "/control/blocked_services/services", d.handleBlockedServicesIDs)
 	registerHTTP(http.MethodGet, "/control/blocked_services/all", d.handleBlockedServicesAll)
 
@@ -579,6 +612,10 @@ func (d *DNSFilter) RegisterFilteringHandlers() {
 	registerHTTP(http.MethodPost, "/control/filtering/refresh", d.handleFilteringRefresh)
 	registerHTTP(http.MethodPost, "/control/filtering/set_rules", d.handleFilteringSetRules)
 	registerHTTP(http.MethodGet, "/control/filtering/check_host", d.handleCheckHost)
+	registerHTTP(http.MethodGet, "/control/filtering/history", d.handleFilteringHistory)
+	registerHTTP(http.MethodGet, "/control/filtering/history/diff", d.handleFilteringHistoryDiff)
+	registerHTTP(http.MethodPost, "/control/filtering/history/rollback", d.handleFilteringHistoryRollback)
+	registerHTTP(http.MethodPost, "/control/filtering/history/unpin", d.handleFilteringHistoryUnpin)
 }
 
 // ValidateUpdateIvl returns false if i is not a valid filters update interval.
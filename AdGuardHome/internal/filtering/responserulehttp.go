@@ -0,0 +1,122 @@
+package filtering
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// responseRuleJSON is the JSON representation of a [ResponseRule].
+type responseRuleJSON struct {
+	Trigger ResponseTrigger `json:"trigger"`
+	Value   string          `json:"value"`
+	Action  ResponseAction  `json:"action"`
+	Rewrite string          `json:"rewrite,omitempty"`
+
+	// Text is the text of the rule shown in the query log.  It's ignored in
+	// requests.
+	Text string `json:"text,omitempty"`
+}
+
+// toRule returns a new response rule from j.
+func (j *responseRuleJSON) toRule() (r *ResponseRule) {
+	return &ResponseRule{
+		Trigger: j.Trigger,
+		Value:   j.Value,
+		Action:  j.Action,
+		Rewrite: j.Rewrite,
+	}
+}
+
+// handleResponseRuleList is the handler for the GET
+// /control/response_rules/list HTTP API.
+func (d *DNSFilter) handleResponseRuleList(w http.ResponseWriter, r *http.Request) {
+	arr := []*responseRuleJSON{}
+
+	func() {
+		d.confMu.RLock()
+		defer d.confMu.RUnlock()
+
+		for _, rule := range d.conf.ResponseRules {
+			arr = append(arr, &responseRuleJSON{
+				Trigger: rule.Trigger,
+				Value:   rule.Value,
+				Action:  rule.Action,
+				Rewrite: rule.Rewrite,
+				Text:    rule.String(),
+			})
+		}
+	}()
+
+	aghhttp.WriteJSONResponseOK(w, r, arr)
+}
+
+// handleResponseRuleAdd is the handler for the POST /control/response_rules/add
+// HTTP API.
+func (d *DNSFilter) handleResponseRuleAdd(w http.ResponseWriter, r *http.Request) {
+	ruleJSON := &responseRuleJSON{}
+	err := json.NewDecoder(r.Body).Decode(ruleJSON)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+
+		return
+	}
+
+	rule := ruleJSON.toRule()
+	err = rule.normalize()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "normalizing: %s", err)
+
+		return
+	}
+
+	func() {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		d.conf.ResponseRules = append(d.conf.ResponseRules, rule)
+		log.Debug("response rules: added %q [%d]", rule, len(d.conf.ResponseRules))
+	}()
+
+	d.conf.ConfigModified()
+}
+
+// handleResponseRuleDelete is the handler for the POST
+// /control/response_rules/delete HTTP API.
+func (d *DNSFilter) handleResponseRuleDelete(w http.ResponseWriter, r *http.Request) {
+	ruleJSON := &responseRuleJSON{}
+	err := json.NewDecoder(r.Body).Decode(ruleJSON)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+
+		return
+	}
+
+	del := ruleJSON.toRule()
+	err = del.normalize()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "normalizing: %s", err)
+
+		return
+	}
+
+	text := del.String()
+
+	func() {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		// Don't modify the slice in place, since the copies of it may be in
+		// use by [DNSFilter.WriteDiskConfig].
+		d.conf.ResponseRules = slices.DeleteFunc(
+			slices.Clone(d.conf.ResponseRules),
+			func(rule *ResponseRule) (ok bool) { return rule.String() == text },
+		)
+		log.Debug("response rules: removed %q", text)
+	}()
+
+	d.conf.ConfigModified()
+}
@@ -0,0 +1,224 @@
+package filtering
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// ResponseTrigger is the part of a DNS response matched by a [ResponseRule].
+type ResponseTrigger string
+
+// Valid response triggers.
+const (
+	// ResponseTriggerIP matches the IP addresses of the A and AAAA records in
+	// the answer section.
+	ResponseTriggerIP ResponseTrigger = "ip"
+
+	// ResponseTriggerCNAME matches the targets of the CNAME records in the
+	// answer section.
+	ResponseTriggerCNAME ResponseTrigger = "cname"
+
+	// ResponseTriggerNSDName matches the names of the name servers in the NS
+	// records of the answer and authority sections, same as rpz-nsdname.
+	ResponseTriggerNSDName ResponseTrigger = "nsdname"
+
+	// ResponseTriggerNSIP matches the IP addresses of the name servers in the
+	// glue A and AAAA records of the additional section, same as rpz-nsip.
+	ResponseTriggerNSIP ResponseTrigger = "nsip"
+)
+
+// ResponseAction is the action applied to a DNS response matched by a
+// [ResponseRule].
+type ResponseAction string
+
+// Valid response actions.
+const (
+	// ResponseActionBlock blocks the whole response according to the blocking
+	// mode.
+	ResponseActionBlock ResponseAction = "block"
+
+	// ResponseActionStrip removes the matched records from the answer.  It's
+	// only valid for [ResponseTriggerIP].
+	ResponseActionStrip ResponseAction = "strip"
+
+	// ResponseActionRewrite replaces the IP addresses of the matched records
+	// with the rewrite address.  The records of the other IP version are
+	// removed.  It's only valid for [ResponseTriggerIP].
+	ResponseActionRewrite ResponseAction = "rewrite"
+)
+
+// ResponseRule is a single rule matching the DNS responses from the upstream
+// servers rather than the requests.
+//
+// Instances of *ResponseRule must never be nil.
+type ResponseRule struct {
+	// Trigger is the part of the response the rule matches.
+	Trigger ResponseTrigger `yaml:"trigger"`
+
+	// Value is either the IP address or prefix for [ResponseTriggerIP] and
+	// [ResponseTriggerNSIP] or the domain name for [ResponseTriggerCNAME] and
+	// [ResponseTriggerNSDName].  A domain name with the leading wildcard label
+	// matches its subdomains only.
+	Value string `yaml:"value"`
+
+	// Action is the action applied to the matched response.
+	Action ResponseAction `yaml:"action"`
+
+	// Rewrite is the IP address for [ResponseActionRewrite].
+	Rewrite string `yaml:"rewrite,omitempty"`
+
+	// Prefix is the IP prefix parsed from Value for the IP triggers.
+	Prefix netip.Prefix `yaml:"-"`
+
+	// IP is the IP address parsed from Rewrite.
+	IP netip.Addr `yaml:"-"`
+}
+
+// type check
+var _ fmt.Stringer = (*ResponseRule)(nil)
+
+// String implements the [fmt.Stringer] interface for *ResponseRule.  The result
+// is used as the rule text in the filtering results.
+func (r *ResponseRule) String() (s string) {
+	s = fmt.Sprintf("%s %s %s", r.Trigger, r.Value, r.Action)
+	if r.Action == ResponseActionRewrite {
+		s += " " + r.Rewrite
+	}
+
+	return s
+}
+
+// normalize validates r and parses its values.
+func (r *ResponseRule) normalize() (err error) {
+	if r == nil {
+		return errors.Error("nil response rule")
+	}
+
+	switch r.Trigger {
+	case ResponseTriggerIP, ResponseTriggerNSIP:
+		r.Prefix, err = aghnet.ParseSubnet(r.Value)
+		if err != nil {
+			return fmt.Errorf("value: %w", err)
+		}
+
+		r.Prefix = r.Prefix.Masked()
+		r.Value = r.Prefix.String()
+	case ResponseTriggerCNAME, ResponseTriggerNSDName:
+		r.Value = strings.ToLower(strings.TrimSuffix(r.Value, "."))
+		err = netutil.ValidateHostname(strings.TrimPrefix(r.Value, "*."))
+		if err != nil {
+			return fmt.Errorf("value: %w", err)
+		}
+	default:
+		return fmt.Errorf("bad trigger: %q", r.Trigger)
+	}
+
+	return r.normalizeAction()
+}
+
+// normalizeAction validates the action of r and parses the rewrite address.
+func (r *ResponseRule) normalizeAction() (err error) {
+	switch r.Action {
+	case ResponseActionBlock:
+		r.Rewrite, r.IP = "", netip.Addr{}
+
+		return nil
+	case ResponseActionStrip, ResponseActionRewrite:
+		if r.Trigger != ResponseTriggerIP {
+			return fmt.Errorf("action %q is only valid for trigger %q", r.Action, ResponseTriggerIP)
+		}
+	default:
+		return fmt.Errorf("bad action: %q", r.Action)
+	}
+
+	if r.Action == ResponseActionStrip {
+		r.Rewrite, r.IP = "", netip.Addr{}
+
+		return nil
+	}
+
+	r.IP, err = netip.ParseAddr(r.Rewrite)
+	if err != nil {
+		return fmt.Errorf("rewrite: %w", err)
+	}
+
+	return nil
+}
+
+// matchesDomain returns true if r matches the domain name host, which must be
+// lowercase and without the trailing dot.
+func (r *ResponseRule) matchesDomain(host string) (ok bool) {
+	if sub, isWildcard := strings.CutPrefix(r.Value, "*."); isWildcard {
+		return strings.HasSuffix(host, "."+sub)
+	}
+
+	return host == r.Value
+}
+
+// Result returns the filtering result for the response matched by r.  Only the
+// blocked responses are considered filtered.
+func (r *ResponseRule) Result() (res *Result) {
+	return &Result{
+		Rules: []*ResultRule{{
+			Text:         r.String(),
+			FilterListID: rulelist.URLFilterIDResponseRules,
+		}},
+		Reason:     FilteredResponseRule,
+		IsFiltered: r.Action == ResponseActionBlock,
+	}
+}
+
+// prepareResponseRules normalizes and validates the response rules.
+func (d *DNSFilter) prepareResponseRules() (err error) {
+	for i, r := range d.conf.ResponseRules {
+		err = r.normalize()
+		if err != nil {
+			return fmt.Errorf("at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// MatchResponseIP returns the first response rule with the trigger t, which
+// prefix contains ip, or nil if there is none.  t must be either
+// [ResponseTriggerIP] or [ResponseTriggerNSIP].  It's safe for concurrent use.
+func (d *DNSFilter) MatchResponseIP(t ResponseTrigger, ip netip.Addr) (r *ResponseRule) {
+	ip = ip.Unmap()
+
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	for _, r = range d.conf.ResponseRules {
+		if r.Trigger == t && r.Prefix.Contains(ip) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// MatchResponseDomain returns the first response rule with the trigger t,
+// which matches the domain name host, or nil if there is none.  t must be
+// either [ResponseTriggerCNAME] or [ResponseTriggerNSDName].  It's safe for
+// concurrent use.
+func (d *DNSFilter) MatchResponseDomain(t ResponseTrigger, host string) (r *ResponseRule) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	for _, r = range d.conf.ResponseRules {
+		if r.Trigger == t && r.matchesDomain(host) {
+			return r
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package filtering
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseRule_normalize(t *testing.T) {
+	testCases := []struct {
+		rule       *ResponseRule
+		name       string
+		wantText   string
+		wantErrMsg string
+	}{{
+		rule: &ResponseRule{
+			Trigger: ResponseTriggerIP,
+			Value:   "192.0.2.1/24",
+			Action:  ResponseActionStrip,
+		},
+		name:       "ip_strip",
+		wantText:   "ip 192.0.2.0/24 strip",
+		wantErrMsg: "",
+	}, {
+		rule: &ResponseRule{
+			Trigger: ResponseTriggerIP,
+			Value:   "2001:db8::1",
+			Action:  ResponseActionRewrite,
+			Rewrite: "::",
+		},
+		name:       "ip_rewrite",
+		wantText:   "ip 2001:db8::1/128 rewrite ::",
+		wantErrMsg: "",
+	}, {
+		rule: &ResponseRule{
+			Trigger: ResponseTriggerNSDName,
+			Value:   "*.NS.Example.",
+			Action:  ResponseActionBlock,
+		},
+		name:       "nsdname",
+		wantText:   "nsdname *.ns.example block",
+		wantErrMsg: "",
+	}, {
+		rule: &ResponseRule{
+			Trigger: ResponseTriggerIP,
+			Value:   "192.0.2.0/33",
+			Action:  ResponseActionBlock,
+		},
+		name:       "bad_prefix",
+		wantText:   "",
+		wantErrMsg: `value: netip.ParsePrefix("192.0.2.0/33"): prefix length out of range`,
+	}, {
+		rule: &ResponseRule{
+			Trigger: ResponseTriggerCNAME,
+			Value:   "example.org",
+			Action:  ResponseActionStrip,
+		},
+		name:       "strip_cname",
+		wantText:   "",
+		wantErrMsg: `action "strip" is only valid for trigger "ip"`,
+	}, {
+		rule: &ResponseRule{
+			Trigger: ResponseTriggerIP,
+			Value:   "192.0.2.0/24",
+			Action:  ResponseActionRewrite,
+		},
+		name:       "no_rewrite",
+		wantText:   "",
+		wantErrMsg: `rewrite: ParseAddr(""): unable to parse IP`,
+	}, {
+		rule: &ResponseRule{
+			Trigger: "qname",
+			Value:   "example.org",
+			Action:  ResponseActionBlock,
+		},
+		name:       "bad_trigger",
+		wantText:   "",
+		wantErrMsg: `bad trigger: "qname"`,
+	}, {
+		rule: &ResponseRule{
+			Trigger: ResponseTriggerNSIP,
+			Value:   "192.0.2.1",
+			Action:  "drop",
+		},
+		name:       "bad_action",
+		wantText:   "",
+		wantErrMsg: `bad action: "drop"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.normalize()
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			assert.Equal(t, tc.wantText, tc.rule.String())
+		})
+	}
+}
+
+func TestDNSFilter_MatchResponse(t *testing.T) {
+	ipRule := &ResponseRule{
+		Trigger: ResponseTriggerIP,
+		Value:   "192.0.2.0/24",
+		Action:  ResponseActionBlock,
+	}
+	nsIPRule := &ResponseRule{
+		Trigger: ResponseTriggerNSIP,
+		Value:   "198.51.100.1",
+		Action:  ResponseActionBlock,
+	}
+	cnameRule := &ResponseRule{
+		Trigger: ResponseTriggerCNAME,
+		Value:   "tracker.example",
+		Action:  ResponseActionBlock,
+	}
+	nsRule := &ResponseRule{
+		Trigger: ResponseTriggerNSDName,
+		Value:   "*.bad-ns.example",
+		Action:  ResponseActionBlock,
+	}
+
+	d, err := New(&Config{
+		ResponseRules: []*ResponseRule{ipRule, nsIPRule, cnameRule, nsRule},
+	}, nil)
+	require.NoError(t, err)
+
+	t.Run("ip", func(t *testing.T) {
+		assert.Same(t, ipRule, d.MatchResponseIP(
+			ResponseTriggerIP,
+			netip.MustParseAddr("::ffff:192.0.2.42"),
+		))
+		assert.Nil(t, d.MatchResponseIP(ResponseTriggerIP, netip.MustParseAddr("192.0.3.1")))
+		assert.Nil(t, d.MatchResponseIP(ResponseTriggerIP, netip.MustParseAddr("198.51.100.1")))
+
+		assert.Same(t, nsIPRule, d.MatchResponseIP(
+			ResponseTriggerNSIP,
+			netip.MustParseAddr("198.51.100.1"),
+		))
+	})
+
+	t.Run("domain", func(t *testing.T) {
+		assert.Same(t, cnameRule, d.MatchResponseDomain(ResponseTriggerCNAME, "Tracker.Example."))
+		assert.Nil(t, d.MatchResponseDomain(ResponseTriggerCNAME, "sub.tracker.example"))
+
+		assert.Same(t, nsRule, d.MatchResponseDomain(ResponseTriggerNSDName, "ns1.bad-ns.example."))
+		assert.Nil(t, d.MatchResponseDomain(ResponseTriggerNSDName, "bad-ns.example."))
+	})
+
+	t.Run("result", func(t *testing.T) {
+		res := ipRule.Result()
+		require.Len(t, res.Rules, 1)
+
+		assert.Equal(t, FilteredResponseRule, res.Reason)
+		assert.True(t, res.IsFiltered)
+		assert.Equal(t, "ip 192.0.2.0/24 block", res.Rules[0].Text)
+	})
+}
@@ -0,0 +1,249 @@
+package rulelist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// ErrRPZNoSOA is returned by [RPZParser.Parse] when the zone data doesn't start
+// with an SOA record.
+const ErrRPZNoSOA errors.Error = "rpz zone must start with an soa record"
+
+// The special CNAME targets of the Response Policy Zone policy actions.  See
+// https://datatracker.ietf.org/doc/html/draft-vixie-dnsop-dns-rpz.
+const (
+	rpzTargetNXDOMAIN = "."
+	rpzTargetNODATA   = "*."
+	rpzTargetPassthru = "rpz-passthru."
+)
+
+// rpzActionPrefix is the prefix of the labels of the special RPZ names, such as
+// the names of the policy actions and the non-QNAME trigger suffixes.
+const rpzActionPrefix = "rpz-"
+
+// RPZParser is a parser of Response Policy Zone (RPZ) data.  It converts the
+// QNAME-trigger policy records into the filtering rules with the $dnsrewrite
+// modifier and the allowlist rules, so that the existing filtering and DNS
+// rewrite machinery can apply them.  The policy actions are converted as
+// follows:
+//
+//   - CNAME . is NXDOMAIN.
+//   - CNAME *. is NODATA, that is NOERROR with no answers.
+//   - CNAME rpz-passthru. is a pair of allowlist rules.
+//   - A, AAAA, CNAME, MX, PTR, and TXT records are local data.
+//
+// The records with the other triggers and actions, such as rpz-ip, rpz-drop,
+// or rpz-tcp-only, aren't supported and are ignored.
+type RPZParser struct {
+	// apex is the lowercase FQDN of the zone taken from its SOA record.
+	apex string
+
+	rulesCount int
+	written    int
+	checksum   uint32
+}
+
+// NewRPZParser returns a new RPZ parser.
+func NewRPZParser() (p *RPZParser) {
+	return &RPZParser{}
+}
+
+// Parse parses the RPZ zone data from src and writes the converted filtering
+// rules into dst.  The title of the result is the name of the zone.  r is never
+// nil.
+func (p *RPZParser) Parse(dst io.Writer, src io.Reader) (r *ParseResult, err error) {
+	zp := dns.NewZoneParser(src, "", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		var n int
+		n, err = p.processRR(dst, rr)
+		p.written += n
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return p.result(), err
+		}
+	}
+
+	r = p.result()
+	err = zp.Err()
+
+	return r, errors.Annotate(err, "parsing rpz zone: %w")
+}
+
+// result returns the current parsing result.
+func (p *RPZParser) result() (r *ParseResult) {
+	return &ParseResult{
+		Title:        strings.TrimSuffix(p.apex, "."),
+		RulesCount:   p.rulesCount,
+		BytesWritten: p.written,
+		Checksum:     p.checksum,
+	}
+}
+
+// processRR processes a single resource record.  It may write to dst, and if it
+// does, n is the number of bytes written.
+func (p *RPZParser) processRR(dst io.Writer, rr dns.RR) (n int, err error) {
+	hdr := rr.Header()
+	if p.apex == "" {
+		if _, ok := rr.(*dns.SOA); !ok {
+			return 0, fmt.Errorf("record %q: %w", hdr.Name, ErrRPZNoSOA)
+		}
+
+		p.apex = strings.ToLower(hdr.Name)
+
+		return 0, nil
+	}
+
+	// Ignore the records of the apex itself, such as NS, as well as the
+	// out-of-zone ones.
+	trigger, ok := strings.CutSuffix(strings.ToLower(hdr.Name), "."+p.apex)
+	if !ok {
+		return 0, nil
+	}
+
+	for _, rule := range rpzRules(trigger, rr) {
+		p.rulesCount++
+		p.checksum = crc32.Update(p.checksum, crc32.IEEETable, []byte(rule))
+
+		var written int
+		written, err = io.WriteString(dst, rule+"\n")
+		n += written
+		if err != nil {
+			return n, fmt.Errorf("writing rule line: %w", err)
+		}
+	}
+
+	return n, nil
+}
+
+// rpzRules returns the filtering rules for the RPZ record rr with the QNAME
+// trigger.  rules is empty if the record isn't supported.
+func rpzRules(trigger string, rr dns.RR) (rules []string) {
+	pattern, ok := rpzPattern(trigger)
+	if !ok {
+		return nil
+	}
+
+	var value string
+	switch rr := rr.(type) {
+	case *dns.CNAME:
+		target := strings.ToLower(rr.Target)
+		switch {
+		case target == rpzTargetNXDOMAIN:
+			value = "NXDOMAIN;;"
+		case target == rpzTargetNODATA:
+			value = "NOERROR;;"
+		case target == rpzTargetPassthru:
+			// Allowlist rules don't disable the $dnsrewrite ones, so add a
+			// dedicated exception for them.
+			return []string{"@@" + pattern, "@@" + pattern + "$dnsrewrite"}
+		case strings.HasPrefix(target, rpzActionPrefix), strings.HasPrefix(target, "*."):
+			// Other policy actions and wildcard local data aren't supported.
+			return nil
+		default:
+			value = "NOERROR;CNAME;" + strings.TrimSuffix(target, ".")
+		}
+	case *dns.A:
+		value = "NOERROR;A;" + rr.A.String()
+	case *dns.AAAA:
+		value = "NOERROR;AAAA;" + rr.AAAA.String()
+	case *dns.MX:
+		if rr.Mx == "." {
+			// The null MX can't be expressed in a $dnsrewrite value.
+			return nil
+		}
+
+		value = "NOERROR;MX;" + strconv.Itoa(int(rr.Preference)) + " " + strings.TrimSuffix(rr.Mx, ".")
+	case *dns.PTR:
+		value = "NOERROR;PTR;" + rr.Ptr
+	case *dns.TXT:
+		txt := strings.Join(rr.Txt, "")
+		if strings.ContainsAny(txt, "$\\") {
+			// These characters can't be expressed in a $dnsrewrite value.
+			return nil
+		}
+
+		value = "NOERROR;TXT;" + strings.ReplaceAll(txt, ",", `\,`)
+	default:
+		return nil
+	}
+
+	return []string{pattern + "$dnsrewrite=" + value}
+}
+
+// rpzPattern returns the pattern of the filtering rule matching the QNAME
+// trigger.  A trigger with the leading wildcard label matches the subdomains
+// only, same as in RPZ.  ok is false if the trigger isn't a valid QNAME
+// trigger.
+func rpzPattern(trigger string) (pattern string, ok bool) {
+	sub, isWildcard := strings.CutPrefix(trigger, "*.")
+	if !isValidRPZName(sub) {
+		return "", false
+	}
+
+	if isWildcard {
+		return "||*." + sub + "^", true
+	}
+
+	return "|" + sub + "^", true
+}
+
+// isValidRPZName returns true if name is a non-empty domain name, which doesn't
+// contain the characters of the filtering-rule syntax and the special RPZ
+// labels, such as the suffixes of the non-QNAME triggers.
+func isValidRPZName(name string) (ok bool) {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range []byte(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+			// Go on.
+		default:
+			return false
+		}
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || strings.HasPrefix(label, rpzActionPrefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsRPZ returns true if head, the beginning of rule-list data, is likely the
+// beginning of a DNS zone file, that is the first line that isn't empty or a
+// comment is either a $ORIGIN or $TTL directive or an SOA record.
+func IsRPZ(head []byte) (ok bool) {
+	s := bufio.NewScanner(bytes.NewReader(head))
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 || line[0] == ';' {
+			continue
+		}
+
+		if hasPrefixFold(line, []byte("$ORIGIN")) || hasPrefixFold(line, []byte("$TTL")) {
+			return true
+		}
+
+		for _, f := range bytes.Fields(line) {
+			if bytes.EqualFold(f, []byte("SOA")) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return false
+}
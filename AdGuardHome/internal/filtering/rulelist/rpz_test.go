@@ -0,0 +1,145 @@
+package rulelist_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRPZSOA is the common SOA record of the RPZ zones for tests.
+const testRPZSOA = "$ORIGIN rpz.example.\n" +
+	"@ 300 IN SOA ns.rpz.example. admin.rpz.example. 1 3600 600 86400 300\n" +
+	"@ 300 IN NS ns.rpz.example.\n"
+
+func TestRPZParser_Parse(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		in           string
+		wantDst      string
+		wantErrMsg   string
+		wantRulesNum int
+	}{{
+		name:         "empty_zone",
+		in:           testRPZSOA,
+		wantDst:      "",
+		wantErrMsg:   "",
+		wantRulesNum: 0,
+	}, {
+		name:         "no_soa",
+		in:           "bad.example.rpz.example. 300 IN CNAME .\n",
+		wantDst:      "",
+		wantErrMsg:   `record "bad.example.rpz.example.": ` + rulelist.ErrRPZNoSOA.Error(),
+		wantRulesNum: 0,
+	}, {
+		name: "actions",
+		in: testRPZSOA +
+			"nxdomain.example CNAME .\n" +
+			"*.nxdomain.example CNAME .\n" +
+			"nodata.example CNAME *.\n" +
+			"passthru.example CNAME rpz-passthru.\n",
+		wantDst: "|nxdomain.example^$dnsrewrite=NXDOMAIN;;\n" +
+			"||*.nxdomain.example^$dnsrewrite=NXDOMAIN;;\n" +
+			"|nodata.example^$dnsrewrite=NOERROR;;\n" +
+			"@@|passthru.example^\n" +
+			"@@|passthru.example^$dnsrewrite\n",
+		wantErrMsg:   "",
+		wantRulesNum: 5,
+	}, {
+		name: "local_data",
+		in: testRPZSOA +
+			"local.example A 192.0.2.1\n" +
+			"local.example AAAA 2001:db8::1\n" +
+			"local.example MX 10 mail.example.\n" +
+			"local.example TXT \"a,b\"\n" +
+			"cname.example CNAME walled-garden.example.\n",
+		wantDst: "|local.example^$dnsrewrite=NOERROR;A;192.0.2.1\n" +
+			"|local.example^$dnsrewrite=NOERROR;AAAA;2001:db8::1\n" +
+			"|local.example^$dnsrewrite=NOERROR;MX;10 mail.example\n" +
+			"|local.example^$dnsrewrite=NOERROR;TXT;a\\,b\n" +
+			"|cname.example^$dnsrewrite=NOERROR;CNAME;walled-garden.example\n",
+		wantErrMsg:   "",
+		wantRulesNum: 5,
+	}, {
+		name: "unsupported",
+		in: testRPZSOA +
+			"drop.example CNAME rpz-drop.\n" +
+			"tcp.example CNAME rpz-tcp-only.\n" +
+			"24.0.2.0.192.rpz-ip CNAME .\n" +
+			"ns.example.rpz-nsdname CNAME .\n" +
+			"wild.example CNAME *.walled-garden.example.\n" +
+			"srv.example SRV 0 0 53 ns.example.\n" +
+			"out.of.zone. 300 IN A 192.0.2.1\n",
+		wantDst:      "",
+		wantErrMsg:   "",
+		wantRulesNum: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dst := &bytes.Buffer{}
+			p := rulelist.NewRPZParser()
+			r, err := p.Parse(dst, strings.NewReader(tc.in))
+			require.NotNil(t, r)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.wantDst, dst.String())
+			assert.Equal(t, tc.wantRulesNum, r.RulesCount)
+			assert.Equal(t, dst.Len(), r.BytesWritten)
+
+			if tc.wantErrMsg == "" {
+				assert.Equal(t, "rpz.example", r.Title)
+			}
+		})
+	}
+}
+
+func TestIsRPZ(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		in   string
+		want bool
+	}{{
+		name: "empty",
+		in:   "",
+		want: false,
+	}, {
+		name: "origin",
+		in:   "; Comment.\n\n$ORIGIN rpz.example.\n",
+		want: true,
+	}, {
+		name: "ttl",
+		in:   "$ttl 300\n",
+		want: true,
+	}, {
+		name: "soa",
+		in:   "rpz.example. 300 IN SOA ns.rpz.example. admin.rpz.example. (\n",
+		want: true,
+	}, {
+		name: "adblock",
+		in:   testRuleTextTitle + testRuleTextBlocked,
+		want: false,
+	}, {
+		name: "hosts",
+		in:   "# Comment.\n0.0.0.0 soa.example\n",
+		want: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, rulelist.IsRPZ([]byte(tc.in)))
+		})
+	}
+}
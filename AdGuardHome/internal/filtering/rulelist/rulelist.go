@@ -41,6 +41,8 @@ const (
 	URLFilterIDParentalControl URLFilterID = -3
 	URLFilterIDSafeBrowsing    URLFilterID = -4
 	URLFilterIDSafeSearch      URLFilterID = -5
+	URLFilterIDResponseRules   URLFilterID = -6
+	URLFilterIDAnomaly         URLFilterID = -7
 )
 
 // UID is the type for the unique IDs of filtering-rule lists.
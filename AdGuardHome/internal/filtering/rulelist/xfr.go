@@ -0,0 +1,367 @@
+package rulelist
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// ZoneTransferScheme is the URL scheme of the rule lists, which are RPZ zones
+// transferred from their primary servers.  The URLs have the following format:
+//
+//	rpz://[keyname:secret@]primary[:port]/zone[?tsig-algorithm=alg]
+//
+// The optional user information is the name and the base64-encoded secret of
+// the TSIG key.  The default port is 53 and the default TSIG algorithm is
+// hmac-sha256.
+const ZoneTransferScheme = "rpz"
+
+// RedactURL returns urlStr with the TSIG secret replaced, if it's a URL with the
+// [ZoneTransferScheme] scheme.  Otherwise, it returns urlStr as is.
+func RedactURL(urlStr string) (redacted string) {
+	u, err := url.Parse(urlStr)
+	if err != nil || u.Scheme != ZoneTransferScheme {
+		return urlStr
+	}
+
+	return u.Redacted()
+}
+
+// defaultZoneTransferPort is the default port of the primary server.
+const defaultZoneTransferPort = "53"
+
+// ZoneTransferConfig is the configuration for a [ZoneTransfer].
+type ZoneTransferConfig struct {
+	// URL is the URL of the zone with the [ZoneTransferScheme] scheme.  It must
+	// not be nil.
+	URL *url.URL
+
+	// CachePath is the path to the file with the previously transferred
+	// version of the zone.  It's used for the incremental transfers.
+	CachePath string
+
+	// Timeout is the timeout for dialing, reading, and writing to the primary
+	// server.
+	Timeout time.Duration
+}
+
+// ZoneTransfer transfers an RPZ zone from its primary server.  It keeps the
+// latest version of the zone in the cache file and uses IXFR to only transfer
+// the changes since that version, falling back to AXFR if the primary can't
+// provide them.
+type ZoneTransfer struct {
+	tsigSecret map[string]string
+	primary    string
+	zone       string
+	tsigName   string
+	tsigAlg    string
+	cachePath  string
+	timeout    time.Duration
+}
+
+// NewZoneTransfer returns a new zone transfer.  c must not be nil.
+func NewZoneTransfer(c *ZoneTransferConfig) (zt *ZoneTransfer, err error) {
+	u := c.URL
+	if s := u.Scheme; s != ZoneTransferScheme {
+		return nil, fmt.Errorf("bad url scheme: %q", s)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return nil, errors.Error("no primary server")
+	}
+
+	zone := strings.Trim(u.Path, "/")
+	if _, ok := dns.IsDomainName(zone); zone == "" || !ok {
+		return nil, fmt.Errorf("bad zone name: %q", zone)
+	}
+
+	zt = &ZoneTransfer{
+		primary:   net.JoinHostPort(host, cmp.Or(u.Port(), defaultZoneTransferPort)),
+		zone:      dns.CanonicalName(zone),
+		cachePath: c.CachePath,
+		timeout:   c.Timeout,
+	}
+
+	if u.User != nil {
+		secret, _ := u.User.Password()
+		if secret == "" {
+			return nil, errors.Error("no tsig secret")
+		}
+
+		zt.tsigName = dns.CanonicalName(u.User.Username())
+		zt.tsigAlg = dns.CanonicalName(cmp.Or(u.Query().Get("tsig-algorithm"), dns.HmacSHA256))
+		zt.tsigSecret = map[string]string{zt.tsigName: secret}
+	}
+
+	return zt, nil
+}
+
+// Refresh transfers the zone, saves it into the cache file, and returns it as
+// the zone-file data.
+func (zt *ZoneTransfer) Refresh() (data []byte, err error) {
+	prev, err := zt.loadCache()
+	if err != nil {
+		return nil, fmt.Errorf("loading cached zone: %w", err)
+	}
+
+	rrs, err := zt.transfer(prev)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	for _, rr := range rrs {
+		buf.WriteString(rr.String())
+		buf.WriteByte('\n')
+	}
+
+	data = buf.Bytes()
+	err = zt.saveCache(data)
+	if err != nil {
+		return nil, fmt.Errorf("saving cached zone: %w", err)
+	}
+
+	return data, nil
+}
+
+// loadCache returns the records of the cached version of the zone, if any.
+// The SOA record is the first one.
+func (zt *ZoneTransfer) loadCache() (rrs []dns.RR, err error) {
+	// #nosec G304 -- Assume that cachePath is always the filter directory
+	// joined with a filter ID.
+	f, err := os.Open(zt.cachePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	zp := dns.NewZoneParser(f, "", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+
+	err = zp.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rrs) == 0 || !zt.isZoneSOA(rrs[0]) {
+		// The cache is of a different zone, so just transfer the zone anew.
+		return nil, nil
+	}
+
+	return rrs, nil
+}
+
+// saveCache writes data into the cache file.
+func (zt *ZoneTransfer) saveCache(data []byte) (err error) {
+	f, err := aghrenameio.NewPendingFile(zt.cachePath, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	_, err = f.Write(data)
+
+	return err
+}
+
+// isZoneSOA returns true if rr is the SOA record of the zone.
+func (zt *ZoneTransfer) isZoneSOA(rr dns.RR) (ok bool) {
+	_, ok = rr.(*dns.SOA)
+
+	return ok && dns.CanonicalName(rr.Header().Name) == zt.zone
+}
+
+// transfer returns the current records of the zone with the SOA record first.
+// prev are the records of the previous version of the zone in the same format,
+// if any.
+func (zt *ZoneTransfer) transfer(prev []dns.RR) (rrs []dns.RR, err error) {
+	var ixfrErr error
+	if len(prev) > 0 {
+		rrs, ixfrErr = zt.ixfr(prev)
+		if ixfrErr == nil {
+			return rrs, nil
+		}
+	}
+
+	rrs, err = zt.axfr()
+	if err != nil {
+		return nil, errors.Join(ixfrErr, err)
+	}
+
+	return rrs, nil
+}
+
+// axfr performs the full transfer of the zone.
+func (zt *ZoneTransfer) axfr() (rrs []dns.RR, err error) {
+	m := &dns.Msg{}
+	m.SetAxfr(zt.zone)
+
+	rrs, err = zt.exchange(m)
+	if err != nil {
+		return nil, fmt.Errorf("axfr: %w", err)
+	}
+
+	rrs, err = zt.fullZone(rrs)
+	if err != nil {
+		return nil, fmt.Errorf("axfr: %w", err)
+	}
+
+	return rrs, nil
+}
+
+// ixfr performs the incremental transfer of the zone since the version of
+// prev.  prev must not be empty and its first record must be the SOA record.
+func (zt *ZoneTransfer) ixfr(prev []dns.RR) (rrs []dns.RR, err error) {
+	defer func() { err = errors.Annotate(err, "ixfr: %w") }()
+
+	prevSOA := prev[0].(*dns.SOA)
+
+	m := &dns.Msg{}
+	m.SetIxfr(zt.zone, prevSOA.Serial, prevSOA.Ns, prevSOA.Mbox)
+
+	rrs, err = zt.exchange(m)
+	if err != nil {
+		// Don't wrap the error, because it's annotated with the deferred
+		// helper.
+		return nil, err
+	}
+
+	switch {
+	case len(rrs) == 0 || !zt.isZoneSOA(rrs[0]):
+		return nil, ErrRPZNoSOA
+	case len(rrs) == 1:
+		// The zone hasn't changed.
+		return prev, nil
+	case !zt.isZoneSOA(rrs[1]):
+		// The primary has sent the whole zone as in AXFR.
+		return zt.fullZone(rrs)
+	default:
+		return applyIXFR(prev, rrs)
+	}
+}
+
+// fullZone validates and returns the records of the whole zone transferred
+// as in AXFR, excluding the trailing SOA record.
+func (zt *ZoneTransfer) fullZone(rrs []dns.RR) (zone []dns.RR, err error) {
+	if l := len(rrs); l < 2 || !zt.isZoneSOA(rrs[0]) || !zt.isZoneSOA(rrs[l-1]) {
+		return nil, errors.Error("transfer must start and end with the soa record")
+	}
+
+	return rrs[:len(rrs)-1], nil
+}
+
+// applyIXFR applies the differences from the IXFR response rrs to prev and
+// returns the records of the new version of the zone.  See RFC 1995.
+func applyIXFR(prev, rrs []dns.RR) (zone []dns.RR, err error) {
+	newSOA := rrs[0].(*dns.SOA)
+	if last, ok := rrs[len(rrs)-1].(*dns.SOA); !ok || last.Serial != newSOA.Serial {
+		return nil, errors.Error("incremental transfer must end with the new soa record")
+	}
+
+	prevSerial := prev[0].(*dns.SOA).Serial
+	if firstSOA := rrs[1].(*dns.SOA); firstSOA.Serial != prevSerial {
+		return nil, fmt.Errorf("got serial %d, want %d", firstSOA.Serial, prevSerial)
+	}
+
+	zone = append([]dns.RR{newSOA}, prev[1:]...)
+	indexes := make(map[string]int, len(zone))
+	for i, rr := range zone[1:] {
+		indexes[rrKey(rr)] = i + 1
+	}
+
+	// Each difference sequence starts with the old SOA record followed by the
+	// deleted records and then with the new SOA record followed by the added
+	// ones.  So each SOA record switches between deleting and adding.
+	adding := true
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if _, ok := rr.(*dns.SOA); ok {
+			adding = !adding
+
+			continue
+		}
+
+		key := rrKey(rr)
+		i, exists := indexes[key]
+		switch {
+		case adding && !exists:
+			indexes[key] = len(zone)
+			zone = append(zone, rr)
+		case !adding && exists:
+			zone[i] = nil
+			delete(indexes, key)
+		}
+	}
+
+	res := zone[:0]
+	for _, rr := range zone {
+		if rr != nil {
+			res = append(res, rr)
+		}
+	}
+
+	return res, nil
+}
+
+// rrKey returns the key identifying rr regardless of its TTL and the case of
+// its owner name.
+func rrKey(rr dns.RR) (key string) {
+	c := dns.Copy(rr)
+	hdr := c.Header()
+	hdr.Name = dns.CanonicalName(hdr.Name)
+	hdr.Ttl = 0
+
+	return c.String()
+}
+
+// exchange sends the transfer request m to the primary and returns all the
+// records of the response.
+func (zt *ZoneTransfer) exchange(m *dns.Msg) (rrs []dns.RR, err error) {
+	t := &dns.Transfer{
+		DialTimeout:  zt.timeout,
+		ReadTimeout:  zt.timeout,
+		WriteTimeout: zt.timeout,
+		TsigSecret:   zt.tsigSecret,
+	}
+
+	if zt.tsigName != "" {
+		m.SetTsig(zt.tsigName, zt.tsigAlg, 300, time.Now().Unix())
+	}
+
+	envs, err := t.In(m, zt.primary)
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s: %w", zt.primary, err)
+	}
+
+	// Read all the envelopes, since the transferring goroutine blocks until
+	// they're received.
+	for env := range envs {
+		if env.Error != nil {
+			err = env.Error
+
+			continue
+		}
+
+		rrs = append(rrs, env.RR...)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("receiving from %s: %w", zt.primary, err)
+	}
+
+	return rrs, nil
+}
@@ -7,6 +7,7 @@ import (
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
@@ -58,6 +59,10 @@ type clientsContainer struct {
 
 	allTags *container.MapSet[string]
 
+	// privacyNum is the number of persistent clients overriding the query log
+	// privacy settings.
+	privacyNum atomic.Int32
+
 	// dhcp is the DHCP service implementation.
 	dhcp DHCP
 
@@ -210,6 +215,10 @@ type clientObject struct {
 
 	IgnoreQueryLog   bool `yaml:"ignore_querylog"`
 	IgnoreStatistics bool `yaml:"ignore_statistics"`
+
+	// QueryLogPrivacy is the client's override of the query log privacy
+	// settings.
+	QueryLogPrivacy querylog.ClientPrivacy `yaml:"querylog_privacy"`
 }
 
 // toPersistent returns an initialized persistent client if there are no errors.
@@ -234,6 +243,7 @@ func (o *clientObject) toPersistent(
 		IgnoreStatistics:      o.IgnoreStatistics,
 		UpstreamsCacheEnabled: o.UpstreamsCacheEnabled,
 		UpstreamsCacheSize:    o.UpstreamsCacheSize,
+		QueryLogPrivacy:       o.QueryLogPrivacy.Clone(),
 	}
 
 	err = cli.SetIDs(o.IDs)
@@ -241,6 +251,11 @@ func (o *clientObject) toPersistent(
 		return nil, fmt.Errorf("parsing ids: %w", err)
 	}
 
+	err = cli.QueryLogPrivacy.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("querylog_privacy: %w", err)
+	}
+
 	if (cli.UID == client.UID{}) {
 		cli.UID, err = client.NewUID()
 		if err != nil {
@@ -324,6 +339,7 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 			IgnoreStatistics:         cli.IgnoreStatistics,
 			UpstreamsCacheEnabled:    cli.UpstreamsCacheEnabled,
 			UpstreamsCacheSize:       cli.UpstreamsCacheSize,
+			QueryLogPrivacy:          cli.QueryLogPrivacy.Clone(),
 		}
 
 		objs = append(objs, o)
@@ -398,6 +414,12 @@ func (clients *clientsContainer) findMultiple(ids []string) (c *querylog.Client,
 	return artClient, nil
 }
 
+// hasQueryLogPrivacy returns true if any persistent client overrides the query
+// log privacy settings.  It's safe for concurrent use.
+func (clients *clientsContainer) hasQueryLogPrivacy() (ok bool) {
+	return clients.privacyNum.Load() > 0
+}
+
 // clientOrArtificial returns information about one client.  If art is true,
 // this is an artificial client record, meaning that we currently don't have any
 // records about this client besides maybe whether or not it is blocked.  c is
@@ -418,6 +440,7 @@ func (clients *clientsContainer) clientOrArtificial(
 		return &querylog.Client{
 			Name:           cli.Name,
 			IgnoreQueryLog: cli.IgnoreQueryLog,
+			Privacy:        cli.QueryLogPrivacy.Clone(),
 		}, false
 	}
 
@@ -466,6 +489,28 @@ func (clients *clientsContainer) shouldCountClient(ids []string) (y bool) {
 	return true
 }
 
+// statsClientIDs returns the IP addresses and ClientIDs of the persistent
+// client with the specified name or ID to look up its statistics.  It returns
+// nil if there is no such client.
+func (clients *clientsContainer) statsClientIDs(id string) (ids []string) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.list[id]
+	if !ok {
+		c, ok = clients.findLocked(id)
+		if !ok {
+			return nil
+		}
+	}
+
+	for _, ip := range c.IPs {
+		ids = append(ids, ip.String())
+	}
+
+	return append(ids, c.ClientIDs...)
+}
+
 // type check
 var _ dnsforward.ClientsContainer = (*clientsContainer)(nil)
 
@@ -651,6 +696,10 @@ func (clients *clientsContainer) addLocked(c *client.Persistent) {
 
 	// update ID index
 	clients.clientIndex.Add(c)
+
+	if c.QueryLogPrivacy.IsSet() {
+		clients.privacyNum.Add(1)
+	}
 }
 
 // remove removes a client.  ok is false if there is no such client.
@@ -681,6 +730,10 @@ func (clients *clientsContainer) removeLocked(c *client.Persistent) {
 
 	// Update the ID index.
 	clients.clientIndex.Delete(c)
+
+	if c.QueryLogPrivacy.IsSet() {
+		clients.privacyNum.Add(-1)
+	}
 }
 
 // update updates a client by its name.
@@ -10,6 +10,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
 	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
 	"github.com/AdguardTeam/AdGuardHome/internal/whois"
 )
@@ -57,6 +58,10 @@ type clientJSON struct {
 	IgnoreQueryLog   aghalg.NullBool `json:"ignore_querylog"`
 	IgnoreStatistics aghalg.NullBool `json:"ignore_statistics"`
 
+	// QueryLogPrivacy is the client's override of the query log privacy
+	// settings.  If nil, the previous value is kept.
+	QueryLogPrivacy *querylog.ClientPrivacy `json:"querylog_privacy"`
+
 	UpstreamsCacheSize    uint32          `json:"upstreams_cache_size"`
 	UpstreamsCacheEnabled aghalg.NullBool `json:"upstreams_cache_enabled"`
 }
@@ -140,6 +145,7 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		ignoreStatistics bool
 		upsCacheEnabled  bool
 		upsCacheSize     uint32
+		privacy          querylog.ClientPrivacy
 	)
 
 	if prev != nil {
@@ -148,6 +154,7 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		ignoreStatistics = prev.IgnoreStatistics
 		upsCacheEnabled = prev.UpstreamsCacheEnabled
 		upsCacheSize = prev.UpstreamsCacheSize
+		privacy = prev.QueryLogPrivacy.Clone()
 	}
 
 	if cj.IgnoreQueryLog != aghalg.NBNull {
@@ -163,6 +170,15 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		upsCacheSize = cj.UpstreamsCacheSize
 	}
 
+	if cj.QueryLogPrivacy != nil {
+		privacy = cj.QueryLogPrivacy.Clone()
+
+		err = privacy.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("querylog_privacy: %w", err)
+		}
+	}
+
 	svcs, err := copyBlockedServices(cj.Schedule, cj.BlockedServices, prev)
 	if err != nil {
 		return nil, fmt.Errorf("invalid blocked services: %w", err)
@@ -182,6 +198,7 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		IgnoreStatistics:      ignoreStatistics,
 		UpstreamsCacheEnabled: upsCacheEnabled,
 		UpstreamsCacheSize:    upsCacheSize,
+		QueryLogPrivacy:       privacy,
 	}, nil
 }
 
@@ -291,6 +308,8 @@ func clientToJSON(c *client.Persistent) (cj *clientJSON) {
 	cloneVal := c.SafeSearchConf
 	safeSearchConf := &cloneVal
 
+	privacy := c.QueryLogPrivacy.Clone()
+
 	return &clientJSON{
 		Name:                c.Name,
 		IDs:                 c.IDs(),
@@ -312,6 +331,8 @@ func clientToJSON(c *client.Persistent) (cj *clientJSON) {
 		IgnoreQueryLog:   aghalg.BoolToNullBool(c.IgnoreQueryLog),
 		IgnoreStatistics: aghalg.BoolToNullBool(c.IgnoreStatistics),
 
+		QueryLogPrivacy: &privacy,
+
 		UpstreamsCacheSize:    c.UpstreamsCacheSize,
 		UpstreamsCacheEnabled: aghalg.BoolToNullBool(c.UpstreamsCacheEnabled),
 	}
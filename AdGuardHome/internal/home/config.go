@@ -10,9 +10,12 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
+	"github.com/AdguardTeam/AdGuardHome/internal/anomaly"
+	"github.com/AdguardTeam/AdGuardHome/internal/cake"
 	"github.com/AdguardTeam/AdGuardHome/internal/configmigrate"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/internal/dnstap"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
 	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
@@ -22,6 +25,7 @@ import (
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/c2h5oh/datasize"
 	"github.com/google/renameio/v2/maybe"
 	yaml "gopkg.in/yaml.v3"
 )
@@ -137,6 +141,16 @@ type configuration struct {
 	DHCP      *dhcpd.ServerConfig `yaml:"dhcp"`
 	Filtering *filtering.Config   `yaml:"filtering"`
 
+	// Cake is the configuration of the CAKE controller.
+	Cake *cake.Config `yaml:"cake"`
+
+	// Dnstap is the configuration of the dnstap output.
+	Dnstap *dnstap.Config `yaml:"dnstap"`
+
+	// Anomaly is the configuration of the detection of the anomalies in the
+	// DNS traffic of the clients.
+	Anomaly *anomaly.Config `yaml:"anomaly_detection"`
+
 	// Clients contains the YAML representations of the persistent clients.
 	// This field is only used for reading and writing persistent client data.
 	// Keep this field sorted to ensure consistent ordering.
@@ -274,6 +288,18 @@ type queryLogConfig struct {
 	// Interval is the interval for query log's files rotation.
 	Interval timeutil.Duration `yaml:"interval"`
 
+	// MaxAge is the maximum age of the rotated query log files.  If zero, the
+	// age isn't limited.
+	MaxAge timeutil.Duration `yaml:"max_age"`
+
+	// MaxSize is the maximum total size of the query log files.  If zero, the
+	// size isn't limited.
+	MaxSize datasize.ByteSize `yaml:"max_size"`
+
+	// Generations is the maximum number of the rotated query log files.  If
+	// zero, the number is only limited by MaxAge and MaxSize.
+	Generations uint `yaml:"generations"`
+
 	// MemSize is the number of entries kept in memory before they are flushed
 	// to disk.
 	MemSize uint `yaml:"size_memory"`
@@ -281,6 +307,13 @@ type queryLogConfig struct {
 	// Enabled defines if the query log is enabled.
 	Enabled bool `yaml:"enabled"`
 
+	// Sinks are the remote collectors the entries are forwarded to.
+	Sinks []*querylog.SinkConfig `yaml:"sinks"`
+
+	// Storage is the type of the storage of the entries, either "json" or
+	// "indexed".
+	Storage string `yaml:"storage"`
+
 	// FileEnabled defines, if the query log is written to the file.
 	FileEnabled bool `yaml:"file_enabled"`
 }
@@ -296,6 +329,9 @@ type statsConfig struct {
 	// Interval is the retention interval for statistics.
 	Interval timeutil.Duration `yaml:"interval"`
 
+	// Reports is the configuration of the scheduled reports.
+	Reports *stats.ReportsConfig `yaml:"reports"`
+
 	// Enabled defines if the statistics are enabled.
 	Enabled bool `yaml:"enabled"`
 }
@@ -367,13 +403,28 @@ var config = &configuration{
 		Enabled:     true,
 		FileEnabled: true,
 		Interval:    timeutil.Duration{Duration: 90 * timeutil.Day},
+		Generations: 1,
 		MemSize:     1000,
 		Ignored:     []string{},
+		Storage:     querylog.StorageJSON,
 	},
 	Stats: statsConfig{
 		Enabled:  true,
 		Interval: timeutil.Duration{Duration: 1 * timeutil.Day},
 		Ignored:  []string{},
+		Reports: &stats.ReportsConfig{
+			Formats: []string{stats.ReportFormatJSON},
+			GroupBy: []stats.ReportGroup{
+				stats.ReportGroupClient,
+				stats.ReportGroupDomain,
+				stats.ReportGroupUpstream,
+				stats.ReportGroupReason,
+			},
+			Limit:   10,
+			Enabled: false,
+			Daily:   true,
+			Weekly:  true,
+		},
 	},
 	// NOTE: Keep these parameters in sync with the one put into
 	// client/src/helpers/filters/filters.js by scripts/vetted-filters.
@@ -425,6 +476,19 @@ var config = &configuration{
 		ParentalBlockHost:     defaultParentalBlockHost,
 		SafeBrowsingBlockHost: defaultSafeBrowsingBlockHost,
 	},
+	Cake: &cake.Config{
+		Interval:       timeutil.Duration{Duration: cake.DefaultInterval},
+		MiscInterfaces: []string{},
+		Enabled:        false,
+	},
+	Dnstap: &dnstap.Config{
+		Network: dnstap.NetworkUnix,
+		Enabled: false,
+	},
+	Anomaly: &anomaly.Config{
+		Enabled: false,
+		Block:   false,
+	},
 	DHCP: &dhcpd.ServerConfig{
 		LocalDomainName: "lan",
 		Conf4: dhcpd.V4ServerConf{
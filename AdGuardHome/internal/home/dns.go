@@ -13,8 +13,11 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/anomaly"
+	"github.com/AdguardTeam/AdGuardHome/internal/cake"
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/internal/dnstap"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
 	"github.com/AdguardTeam/AdGuardHome/internal/stats"
@@ -59,7 +62,9 @@ func initDNS() (err error) {
 		ConfigModified:    onConfigModified,
 		HTTPRegister:      httpRegister,
 		Enabled:           config.Stats.Enabled,
+		Reports:           config.Stats.Reports,
 		ShouldCountClient: Context.clients.shouldCountClient,
+		ClientIDs:         Context.clients.statsClientIDs,
 	}
 
 	engine, err := aghnet.NewIgnoreEngine(config.Stats.Ignored)
@@ -78,12 +83,18 @@ func initDNS() (err error) {
 		ConfigModified:    onConfigModified,
 		HTTPRegister:      httpRegister,
 		FindClient:        Context.clients.findMultiple,
+		HasClientPrivacy:  Context.clients.hasQueryLogPrivacy,
 		BaseDir:           querylogDir,
 		AnonymizeClientIP: config.DNS.AnonymizeClientIP,
 		RotationIvl:       config.QueryLog.Interval.Duration,
+		MaxAge:            config.QueryLog.MaxAge.Duration,
+		MaxSize:           config.QueryLog.MaxSize,
+		Generations:       config.QueryLog.Generations,
 		MemSize:           config.QueryLog.MemSize,
 		Enabled:           config.QueryLog.Enabled,
 		FileEnabled:       config.QueryLog.FileEnabled,
+		Sinks:             config.QueryLog.Sinks,
+		Storage:           config.QueryLog.Storage,
 	}
 
 	engine, err = aghnet.NewIgnoreEngine(config.QueryLog.Ignored)
@@ -103,6 +114,44 @@ func initDNS() (err error) {
 		return err
 	}
 
+	if cakeConf := config.Cake; cakeConf != nil && cakeConf.SpeedtestServer {
+		cake.RegisterSpeedtest(httpRegister)
+	}
+
+	var latencyObserver dnsforward.LatencyObserver
+	var answerObserver dnsforward.AnswerObserver
+	if cakeConf := config.Cake; cakeConf != nil && cakeConf.Enabled {
+		cakeConf.HTTPRegister = httpRegister
+		Context.cake, err = cake.New(cakeConf)
+		if err != nil {
+			// Don't wrap the error, since it's informative enough as is.
+			return err
+		}
+
+		latencyObserver = Context.cake
+		answerObserver = Context.cake
+	}
+
+	var tapWriter dnsforward.DnstapWriter
+	if tapConf := config.Dnstap; tapConf != nil && tapConf.Enabled {
+		Context.dnstap, err = dnstap.New(tapConf)
+		if err != nil {
+			// Don't wrap the error, since it's informative enough as is.
+			return err
+		}
+
+		tapWriter = Context.dnstap
+	}
+
+	var anomalies dnsforward.AnomalyDetector
+	if anomalyConf := config.Anomaly; anomalyConf != nil && anomalyConf.Enabled {
+		anomalies, err = anomaly.New(anomalyConf)
+		if err != nil {
+			// Don't wrap the error, since it's informative enough as is.
+			return err
+		}
+	}
+
 	tlsConf := &tlsConfigSettings{}
 	Context.tls.WriteDiskConfig(tlsConf)
 
@@ -110,6 +159,10 @@ func initDNS() (err error) {
 		Context.filters,
 		Context.stats,
 		Context.queryLog,
+		latencyObserver,
+		answerObserver,
+		tapWriter,
+		anomalies,
 		Context.dhcpServer,
 		anonymizer,
 		httpRegister,
@@ -119,12 +172,17 @@ func initDNS() (err error) {
 
 // initDNSServer initializes the [context.dnsServer].  To only use the internal
 // proxy, none of the arguments are required, but tlsConf still must not be nil,
-// in other cases all the arguments also must not be nil.  It also must not be
-// called unless [config] and [Context] are initialized.
+// in other cases all the arguments except latObs, ansObs, tapWriter, and
+// anomalies also must not be nil.  It also must not be called unless [config] and [Context] are
+// initialized.
 func initDNSServer(
 	filters *filtering.DNSFilter,
 	sts stats.Interface,
 	qlog querylog.QueryLog,
+	latObs dnsforward.LatencyObserver,
+	ansObs dnsforward.AnswerObserver,
+	tapWriter dnsforward.DnstapWriter,
+	anomalies dnsforward.AnomalyDetector,
 	dhcpSrv dnsforward.DHCP,
 	anonymizer *aghnet.IPMut,
 	httpReg aghhttp.RegisterFunc,
@@ -139,6 +197,11 @@ func initDNSServer(
 		DHCPServer:  dhcpSrv,
 		EtcHosts:    Context.etcHosts,
 		LocalDomain: config.DHCP.LocalDomainName,
+
+		LatencyObserver: latObs,
+		AnswerObserver:  ansObs,
+		DnstapWriter:    tapWriter,
+		AnomalyDetector: anomalies,
 	})
 	defer func() {
 		if err != nil {
@@ -463,6 +526,14 @@ func startDNSServer() error {
 	Context.stats.Start()
 	Context.queryLog.Start()
 
+	if Context.cake != nil {
+		Context.cake.Start()
+	}
+
+	if Context.dnstap != nil {
+		Context.dnstap.Start()
+	}
+
 	return nil
 }
 
@@ -525,6 +596,24 @@ func closeDNSServer() {
 		Context.queryLog.Close()
 	}
 
+	if Context.cake != nil {
+		err := Context.cake.Close()
+		if err != nil {
+			log.Debug("closing cake: %s", err)
+		}
+
+		Context.cake = nil
+	}
+
+	if Context.dnstap != nil {
+		err := Context.dnstap.Close()
+		if err != nil {
+			log.Debug("closing dnstap: %s", err)
+		}
+
+		Context.dnstap = nil
+	}
+
 	log.Debug("all dns modules are closed")
 }
 
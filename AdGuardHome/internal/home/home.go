@@ -25,8 +25,10 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
 	"github.com/AdguardTeam/AdGuardHome/internal/arpdb"
+	"github.com/AdguardTeam/AdGuardHome/internal/cake"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/internal/dnstap"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/hashprefix"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
@@ -50,6 +52,8 @@ type homeContext struct {
 	clients    clientsContainer     // per-client-settings module
 	stats      stats.Interface      // statistics module
 	queryLog   querylog.QueryLog    // query log module
+	cake       *cake.Controller     // CAKE controller module
+	dnstap     *dnstap.Writer       // dnstap module
 	dnsServer  *dnsforward.Server   // DNS module
 	dhcpServer dhcpd.Interface      // DHCP module
 	auth       *Auth                // HTTP authentication module
@@ -969,7 +973,7 @@ func cmdlineUpdate(opts options, upd *updater.Updater) {
 	//
 	// TODO(e.burkov):  We could probably initialize the internal resolver
 	// separately.
-	err := initDNSServer(nil, nil, nil, nil, nil, nil, &tlsConfigSettings{})
+	err := initDNSServer(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, &tlsConfigSettings{})
 	fatalOnError(err)
 
 	log.Info("cmdline update: performing update")
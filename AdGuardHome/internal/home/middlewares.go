@@ -48,10 +48,23 @@ func expectsLargerRequests(r *http.Request) (ok bool) {
 		p == "/control/filtering/set_rules"
 }
 
+// isUnlimitedRequest shows if the body of this request must not be limited.
+// The speedtest sink receives as much data as the peer manages to send during
+// the measurement.
+func isUnlimitedRequest(r *http.Request) (ok bool) {
+	return r.Method == http.MethodPost && r.URL.Path == "/control/cake/speedtest/upload"
+}
+
 // limitRequestBody wraps underlying handler h, making it's request's body Read
 // method limited.
 func limitRequestBody(h http.Handler) (limited http.Handler) {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isUnlimitedRequest(r) {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
 		var szLim uint64 = defaultReqBodySzLim
 		if expectsLargerRequests(r) {
 			szLim = largerReqBodySzLim
@@ -1,12 +1,15 @@
 package home
 
 import (
+	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/cake"
 	"github.com/AdguardTeam/golibs/ioutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -66,3 +69,31 @@ func TestLimitRequestBody(t *testing.T) {
 		})
 	}
 }
+
+func TestLimitRequestBody_speedtestUpload(t *testing.T) {
+	mux := http.NewServeMux()
+	cake.RegisterSpeedtest(func(method, url string, handler http.HandlerFunc) {
+		mux.Handle(method+" "+url, handler)
+	})
+
+	h := withMiddlewares(mux, limitRequestBody)
+
+	const size = 4 * defaultReqBodySzLim
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/control/cake/speedtest/upload",
+		bytes.NewReader(make([]byte, size)),
+	)
+	res := httptest.NewRecorder()
+
+	h.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	resp := struct {
+		Bytes int64 `json:"bytes"`
+	}{}
+	err := json.NewDecoder(res.Body).Decode(&resp)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(size), resp.Bytes)
+}
@@ -2,7 +2,13 @@
 package ipset
 
 import (
+	"fmt"
 	"net"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/nftset"
+	"github.com/AdguardTeam/golibs/errors"
 )
 
 // Manager is the ipset manager interface.
@@ -10,7 +16,9 @@ import (
 // TODO(a.garipov): Perhaps generalize this into some kind of a NetFilter type,
 // since ipset is exclusive to Linux?
 type Manager interface {
-	Add(host string, ip4s, ip6s []net.IP) (n int, err error)
+	// Add adds the addresses resolved for host to its sets.  ttl is the
+	// smallest TTL of the address records.
+	Add(host string, ip4s, ip6s []net.IP, ttl time.Duration) (n int, err error)
 	Close() (err error)
 }
 
@@ -20,7 +28,16 @@ type Manager interface {
 //
 // The syntax of the ipsetConf is:
 //
-//	DOMAIN[,DOMAIN].../IPSET_NAME[,IPSET_NAME]...
+//	DOMAIN[,DOMAIN].../SET_NAME[,SET_NAME]...
+//
+// SET_NAME is either the name of an ipset or the name of an nftables set in
+// the following syntax:
+//
+//	FAMILY#TABLE#SET
+//
+// The addresses are added to the nftables sets with the timeout equal to the
+// TTL of the records, if the set has the timeout flag.  The nftables sets must
+// exist as well.
 //
 // If ipsetConf is empty, msg and err are nil.  The error is of type
 // *aghos.UnsupportedError if the OS is not supported.
@@ -29,5 +46,167 @@ func NewManager(ipsetConf []string) (mgr Manager, err error) {
 		return nil, nil
 	}
 
-	return newManager(ipsetConf)
+	ipsetConf, nftConf, err := splitConfig(ipsetConf)
+	if err != nil {
+		return nil, fmt.Errorf("ipset: %w", err)
+	}
+
+	var mgrs multiManager
+	if len(ipsetConf) > 0 {
+		mgr, err = newManager(ipsetConf)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return nil, err
+		} else if mgr != nil {
+			mgrs = append(mgrs, mgr)
+		}
+	}
+
+	if len(nftConf) > 0 {
+		mgr, err = newNftManager(nftConf, nftset.New)
+		if err != nil {
+			return nil, errors.WithDeferred(fmt.Errorf("ipset: %w", err), mgrs.Close())
+		}
+
+		mgrs = append(mgrs, mgr)
+	}
+
+	switch len(mgrs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return mgrs[0], nil
+	default:
+		return mgrs, nil
+	}
+}
+
+// parseIpsetConfigLine parses one ipset configuration line.
+func parseIpsetConfigLine(confStr string) (hosts, ipsetNames []string, err error) {
+	confStr = strings.TrimSpace(confStr)
+	hostsAndNames := strings.Split(confStr, "/")
+	if len(hostsAndNames) != 2 {
+		return nil, nil, fmt.Errorf("invalid value %q: expected one slash", confStr)
+	}
+
+	hosts = strings.Split(hostsAndNames[0], ",")
+	ipsetNames = strings.Split(hostsAndNames[1], ",")
+
+	if len(ipsetNames) == 0 {
+		return nil, nil, nil
+	}
+
+	for i := range ipsetNames {
+		ipsetNames[i] = strings.TrimSpace(ipsetNames[i])
+		if len(ipsetNames[i]) == 0 {
+			return nil, nil, fmt.Errorf("invalid value %q: empty ipset name", confStr)
+		}
+	}
+
+	for i := range hosts {
+		hosts[i] = strings.ToLower(strings.TrimSpace(hosts[i]))
+	}
+
+	return hosts, ipsetNames, nil
+}
+
+// splitConfig separates the nftables sets from the ipset configuration.
+// ipsetConf contains the lines with only the ipset names, and nftConf maps the
+// domains to their nftables sets.
+func splitConfig(conf []string) (ipsetConf []string, nftConf map[string][]*nftset.Set, err error) {
+	nftConf = map[string][]*nftset.Set{}
+	for i, confStr := range conf {
+		var hosts, names []string
+		hosts, names, err = parseIpsetConfigLine(confStr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config line at idx %d: %w", i, err)
+		}
+
+		var ipsetNames []string
+		for _, name := range names {
+			if !strings.Contains(name, "#") {
+				ipsetNames = append(ipsetNames, name)
+
+				continue
+			}
+
+			var set *nftset.Set
+			set, err = nftset.ParseSet(name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("config line at idx %d: %w", i, err)
+			}
+
+			for _, host := range hosts {
+				nftConf[host] = append(nftConf[host], set)
+			}
+		}
+
+		if len(ipsetNames) > 0 {
+			line := strings.Join(hosts, ",") + "/" + strings.Join(ipsetNames, ",")
+			ipsetConf = append(ipsetConf, line)
+		}
+	}
+
+	return ipsetConf, nftConf, nil
+}
+
+// lookupHost finds the sets for the host in domainToSets, taking subdomain
+// wildcards into account.
+func lookupHost[T any](domainToSets map[string][]T, host string) (sets []T) {
+	// Search for matching ipset hosts starting with most specific domain.
+	// We could use a trie here but the simple, inefficient solution isn't
+	// that expensive: ~10 ns for TLD + SLD vs. ~140 ns for 10 subdomains on
+	// an AMD Ryzen 7 PRO 4750U CPU; ~120 ns vs. ~ 1500 ns on a Raspberry
+	// Pi's ARMv7 rev 4 CPU.
+	for i := 0; ; i++ {
+		host = host[i:]
+		sets = domainToSets[host]
+		if sets != nil {
+			return sets
+		}
+
+		i = strings.Index(host, ".")
+		if i == -1 {
+			break
+		}
+	}
+
+	// Check the root catch-all one.
+	return domainToSets[""]
+}
+
+// multiManager is a [Manager] that adds the addresses using all of its
+// managers.
+type multiManager []Manager
+
+// type check
+var _ Manager = multiManager(nil)
+
+// Add implements the [Manager] interface for multiManager.
+func (mgrs multiManager) Add(host string, ip4s, ip6s []net.IP, ttl time.Duration) (n int, err error) {
+	var errs []error
+	for _, m := range mgrs {
+		var nn int
+		nn, err = m.Add(host, ip4s, ip6s, ttl)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		n += nn
+	}
+
+	return n, errors.Join(errs...)
+}
+
+// Close implements the [Manager] interface for multiManager.
+func (mgrs multiManager) Close() (err error) {
+	var errs []error
+	for _, m := range mgrs {
+		err = m.Close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
 }
@@ -6,8 +6,8 @@ import (
 	"bytes"
 	"fmt"
 	"net"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/errors"
@@ -223,35 +223,6 @@ func (m *manager) dialNetfilter(conf *netlink.Config) (err error) {
 	return nil
 }
 
-// parseIpsetConfigLine parses one ipset configuration line.
-func parseIpsetConfigLine(confStr string) (hosts, ipsetNames []string, err error) {
-	confStr = strings.TrimSpace(confStr)
-	hostsAndNames := strings.Split(confStr, "/")
-	if len(hostsAndNames) != 2 {
-		return nil, nil, fmt.Errorf("invalid value %q: expected one slash", confStr)
-	}
-
-	hosts = strings.Split(hostsAndNames[0], ",")
-	ipsetNames = strings.Split(hostsAndNames[1], ",")
-
-	if len(ipsetNames) == 0 {
-		return nil, nil, nil
-	}
-
-	for i := range ipsetNames {
-		ipsetNames[i] = strings.TrimSpace(ipsetNames[i])
-		if len(ipsetNames[i]) == 0 {
-			return nil, nil, fmt.Errorf("invalid value %q: empty ipset name", confStr)
-		}
-	}
-
-	for i := range hosts {
-		hosts[i] = strings.ToLower(strings.TrimSpace(hosts[i]))
-	}
-
-	return hosts, ipsetNames, nil
-}
-
 // parseIpsetConfig parses the ipset configuration and stores ipsets.  It
 // returns an error if the configuration can't be used.
 func (m *manager) parseIpsetConfig(ipsetConf []string) (err error) {
@@ -397,26 +368,7 @@ func newManagerWithDialer(ipsetConf []string, dial dialer) (mgr Manager, err err
 // lookupHost find the ipsets for the host, taking subdomain wildcards into
 // account.
 func (m *manager) lookupHost(host string) (sets []props) {
-	// Search for matching ipset hosts starting with most specific domain.
-	// We could use a trie here but the simple, inefficient solution isn't
-	// that expensive: ~10 ns for TLD + SLD vs. ~140 ns for 10 subdomains on
-	// an AMD Ryzen 7 PRO 4750U CPU; ~120 ns vs. ~ 1500 ns on a Raspberry
-	// Pi's ARMv7 rev 4 CPU.
-	for i := 0; ; i++ {
-		host = host[i:]
-		sets = m.domainToIpsets[host]
-		if sets != nil {
-			return sets
-		}
-
-		i = strings.Index(host, ".")
-		if i == -1 {
-			break
-		}
-	}
-
-	// Check the root catch-all one.
-	return m.domainToIpsets[""]
+	return lookupHost(m.domainToIpsets, host)
 }
 
 // addIPs adds the IP addresses for the host to the ipset.  set must be same
@@ -506,8 +458,9 @@ func (m *manager) addToSets(
 	return n, nil
 }
 
-// Add implements the [Manager] interface for *manager.
-func (m *manager) Add(host string, ip4s, ip6s []net.IP) (n int, err error) {
+// Add implements the [Manager] interface for *manager.  ttl is ignored, since
+// the entries use the default timeout of the ipset.
+func (m *manager) Add(host string, ip4s, ip6s []net.IP, _ time.Duration) (n int, err error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
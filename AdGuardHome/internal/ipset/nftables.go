@@ -0,0 +1,95 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/nftset"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// nftManager is the [Manager] that adds the addresses to the nftables sets.
+// The duplicates are suppressed by the adder.
+type nftManager struct {
+	domainToSets map[string][]*nftset.Set
+
+	// mu protects adder.
+	mu *sync.Mutex
+
+	adder nftset.Adder
+}
+
+// newNftManager returns a new nftables manager for the sets in domainToSets.
+// newAdder is used to connect to netfilter.
+func newNftManager(
+	domainToSets map[string][]*nftset.Set,
+	newAdder func() (a nftset.Adder, err error),
+) (m *nftManager, err error) {
+	adder, err := newAdder()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	log.Debug("ipset: nftables: initialized")
+
+	return &nftManager{
+		domainToSets: domainToSets,
+		mu:           &sync.Mutex{},
+		adder:        adder,
+	}, nil
+}
+
+// type check
+var _ Manager = (*nftManager)(nil)
+
+// Add implements the [Manager] interface for *nftManager.  The addresses are
+// added with ttl as the timeout.
+func (m *nftManager) Add(host string, ip4s, ip6s []net.IP, ttl time.Duration) (n int, err error) {
+	sets := lookupHost(m.domainToSets, host)
+	if len(sets) == 0 {
+		return 0, nil
+	}
+
+	ips := make([]netip.Addr, 0, len(ip4s)+len(ip6s))
+	for _, list := range [][]net.IP{ip4s, ip6s} {
+		for _, ip := range list {
+			addr, ok := netip.AddrFromSlice(ip)
+			if ok {
+				ips = append(ips, addr.Unmap())
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for _, set := range sets {
+		var nn int
+		nn, err = m.adder.Add(set, ips, ttl)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("adding %q%s: %w", host, ips, err))
+
+			continue
+		}
+
+		log.Debug("ipset: added %d ips to nftables set %s", nn, set)
+
+		n += nn
+	}
+
+	return n, errors.Join(errs...)
+}
+
+// Close implements the [Manager] interface for *nftManager.
+func (m *nftManager) Close() (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return errors.Annotate(m.adder.Close(), "closing nftables sets: %w")
+}
@@ -0,0 +1,89 @@
+package ipset
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/nftset"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAdder is a fake [nftset.Adder] for tests.
+type fakeAdder struct {
+	added   map[string][]netip.Addr
+	timeout time.Duration
+}
+
+// type check
+var _ nftset.Adder = (*fakeAdder)(nil)
+
+// Add implements the [nftset.Adder] interface for *fakeAdder.
+func (a *fakeAdder) Add(set *nftset.Set, ips []netip.Addr, timeout time.Duration) (n int, err error) {
+	a.added[set.String()] = append(a.added[set.String()], ips...)
+	a.timeout = timeout
+
+	return len(ips), nil
+}
+
+// Close implements the [nftset.Adder] interface for *fakeAdder.
+func (a *fakeAdder) Close() (err error) {
+	return nil
+}
+
+func TestSplitConfig(t *testing.T) {
+	ipsetConf, nftConf, err := splitConfig([]string{
+		"example.com,example.net/ipv4set,inet#filter#set4",
+		"example.org/ip6#filter#set6",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"example.com,example.net/ipv4set"}, ipsetConf)
+
+	set4 := &nftset.Set{Family: "inet", Table: "filter", Name: "set4"}
+	set6 := &nftset.Set{Family: "ip6", Table: "filter", Name: "set6"}
+	assert.Equal(t, map[string][]*nftset.Set{
+		"example.com": {set4},
+		"example.net": {set4},
+		"example.org": {set6},
+	}, nftConf)
+
+	_, _, err = splitConfig([]string{"example.com/inet#filter"})
+	testutil.AssertErrorMsg(
+		t,
+		`config line at idx 0: set "inet#filter": want 3 parts separated by #, got 2`,
+		err,
+	)
+}
+
+func TestNftManager_Add(t *testing.T) {
+	_, nftConf, err := splitConfig([]string{"example.com/inet#filter#set"})
+	require.NoError(t, err)
+
+	adder := &fakeAdder{added: map[string][]netip.Addr{}}
+	m, err := newNftManager(nftConf, func() (a nftset.Adder, err error) { return adder, nil })
+	require.NoError(t, err)
+
+	ip4 := net.IP{1, 2, 3, 4}
+	ip6 := net.ParseIP("1234::5678")
+
+	n, err := m.Add("sub.example.com", []net.IP{ip4}, []net.IP{ip6}, time.Minute)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, n)
+	assert.Equal(t, time.Minute, adder.timeout)
+	assert.Equal(t, map[string][]netip.Addr{
+		"inet#filter#set": {
+			netip.MustParseAddr("1.2.3.4"),
+			netip.MustParseAddr("1234::5678"),
+		},
+	}, adder.added)
+
+	n, err = m.Add("example.org", []net.IP{ip4}, nil, time.Minute)
+	require.NoError(t, err)
+
+	assert.Zero(t, n)
+}
@@ -0,0 +1,89 @@
+// Package nftset provides the functionality for adding IP addresses to
+// nftables sets.
+package nftset
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// Set is the address of an nftables set.
+type Set struct {
+	// Family is the family of the table, for example "inet".
+	Family string
+
+	// Table is the name of the table.
+	Table string
+
+	// Name is the name of the set.
+	Name string
+}
+
+// ParseSet parses a set in the following syntax:
+//
+//	FAMILY#TABLE#SET
+func ParseSet(s string) (set *Set, err error) {
+	parts := strings.Split(s, "#")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("set %q: want 3 parts separated by #, got %d", s, len(parts))
+	}
+
+	set = &Set{
+		Family: parts[0],
+		Table:  parts[1],
+		Name:   parts[2],
+	}
+
+	err = set.validate()
+	if err != nil {
+		return nil, fmt.Errorf("set %q: %w", s, err)
+	}
+
+	return set, nil
+}
+
+// validate returns an error if s is not valid.
+func (s *Set) validate() (err error) {
+	switch s.Family {
+	case "inet", "ip", "ip6", "bridge", "netdev":
+		// Go on.
+	default:
+		return fmt.Errorf("bad family %q", s.Family)
+	}
+
+	if s.Table == "" {
+		return errors.Error("no table")
+	} else if s.Name == "" {
+		return errors.Error("no set name")
+	}
+
+	return nil
+}
+
+// String implements the [fmt.Stringer] interface for *Set.
+func (s *Set) String() (str string) {
+	return s.Family + "#" + s.Table + "#" + s.Name
+}
+
+// Adder adds IP addresses to nftables sets.
+type Adder interface {
+	// Add adds the addresses of ips matching the type of set to it and returns
+	// the number of the actually added ones.  The addresses already added
+	// by this Adder and not about to expire are skipped.  timeout is the
+	// lifetime of the elements in sets with the timeout flag.  If timeout is
+	// zero, the default timeout of the set is used.
+	Add(set *Set, ips []netip.Addr, timeout time.Duration) (n int, err error)
+
+	// Close closes the netfilter connection.
+	Close() (err error)
+}
+
+// New returns a new Adder.  The sets must exist.  The error is of type
+// *aghos.UnsupportedError if the OS is not supported.
+func New() (a Adder, err error) {
+	return newAdder()
+}
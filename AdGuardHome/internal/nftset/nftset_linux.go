@@ -0,0 +1,255 @@
+//go:build linux
+
+package nftset
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/google/nftables"
+)
+
+// How to test on a real Linux machine:
+//
+//  1. Run "sudo nft add table inet filter".
+//
+//  2. Run "sudo nft add set inet filter example_set '{ type ipv4_addr; flags
+//     timeout; }'".
+//
+//  3. Add the line "example.com/inet#filter#example_set" to the ipset section
+//     of your AdGuardHome.yaml.
+//
+//  4. Start AdGuardHome and make requests to example.com.
+//
+//  5. Run "sudo nft list set inet filter example_set".  The elements should
+//     contain the resolved IP addresses along with their timeouts.
+
+// sweepInterval is the minimum interval between the removals of the expired
+// elements from the cache.
+const sweepInterval = 1 * time.Minute
+
+// nftConn is the interface of the nftables connection used by the adder.
+type nftConn interface {
+	GetSetByName(t *nftables.Table, name string) (s *nftables.Set, err error)
+	SetAddElements(s *nftables.Set, vals []nftables.SetElement) (err error)
+	Flush() (err error)
+	CloseLasting() (err error)
+}
+
+// type check
+var _ nftConn = (*nftables.Conn)(nil)
+
+// element is an IP address in a set.
+type element struct {
+	set Set
+	ip  netip.Addr
+}
+
+// adder is the Linux implementation of the [Adder] interface.
+type adder struct {
+	// mu protects all properties below.
+	mu *sync.Mutex
+
+	conn nftConn
+
+	// sets caches the properties of the sets.
+	sets map[Set]*nftables.Set
+
+	// added maps the elements added to the sets to the time they expire.  The
+	// zero time means that the element never expires.
+	added map[element]time.Time
+
+	// now returns the current time.
+	now func() (t time.Time)
+
+	// lastSweep is the time when the expired elements were last removed from
+	// added.
+	lastSweep time.Time
+}
+
+// newAdder returns a new Linux [Adder].
+func newAdder() (a Adder, err error) {
+	conn, err := nftables.New(nftables.AsLasting())
+	if err != nil {
+		return nil, fmt.Errorf("dialing nftables: %w", err)
+	}
+
+	return newAdderWithConn(conn), nil
+}
+
+// newAdderWithConn returns a new *adder that uses conn.
+func newAdderWithConn(conn nftConn) (a *adder) {
+	return &adder{
+		mu:    &sync.Mutex{},
+		conn:  conn,
+		sets:  map[Set]*nftables.Set{},
+		added: map[element]time.Time{},
+		now:   time.Now,
+	}
+}
+
+// type check
+var _ Adder = (*adder)(nil)
+
+// Add implements the [Adder] interface for *adder.
+func (a *adder) Add(set *Set, ips []netip.Addr, timeout time.Duration) (n int, err error) {
+	if len(ips) == 0 {
+		return 0, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, err := a.nftSet(set)
+	if err != nil {
+		return 0, err
+	}
+
+	now := a.now()
+	a.sweep(now)
+
+	if !s.HasTimeout {
+		timeout = 0
+	} else if timeout == 0 {
+		timeout = s.Timeout
+	}
+
+	var expire time.Time
+	if timeout > 0 {
+		expire = now.Add(timeout)
+	}
+
+	var elems []nftables.SetElement
+	var newAdded []element
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if !matchesKeyType(s, ip) {
+			continue
+		}
+
+		e := element{set: *set, ip: ip}
+		if a.isFresh(e, now, timeout) {
+			continue
+		}
+
+		elems = append(elems, nftables.SetElement{Key: ip.AsSlice(), Timeout: timeout})
+		newAdded = append(newAdded, e)
+	}
+
+	n = len(elems)
+	if n == 0 {
+		return 0, nil
+	}
+
+	err = a.conn.SetAddElements(s, elems)
+	if err == nil {
+		err = a.conn.Flush()
+	}
+
+	if err != nil {
+		// The set may have been recreated, so look it up again next time.
+		delete(a.sets, *set)
+
+		return 0, fmt.Errorf("adding %d elements to %s: %w", n, set, err)
+	}
+
+	// Only add these to the cache once we're sure that all of them were
+	// actually sent to the set.
+	for _, e := range newAdded {
+		a.added[e] = expire
+	}
+
+	return n, nil
+}
+
+// isFresh returns true if e has already been added and doesn't need to be
+// refreshed.  The elements with timeouts are refreshed once half of their
+// lifetime has passed.  a.mu is expected to be locked.
+func (a *adder) isFresh(e element, now time.Time, timeout time.Duration) (ok bool) {
+	expire, ok := a.added[e]
+	if !ok {
+		return false
+	}
+
+	return expire.IsZero() || expire.Sub(now) > timeout/2
+}
+
+// sweep removes the expired elements from the cache, if sweepInterval has
+// passed since the previous sweep.  a.mu is expected to be locked.
+func (a *adder) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < sweepInterval {
+		return
+	}
+
+	a.lastSweep = now
+	for e, expire := range a.added {
+		if !expire.IsZero() && now.After(expire) {
+			delete(a.added, e)
+		}
+	}
+}
+
+// nftSet returns the properties of set, querying them if necessary.  a.mu is
+// expected to be locked.
+func (a *adder) nftSet(set *Set) (s *nftables.Set, err error) {
+	s, ok := a.sets[*set]
+	if ok {
+		return s, nil
+	}
+
+	t := &nftables.Table{
+		Name:   set.Table,
+		Family: tableFamily(set.Family),
+	}
+
+	s, err = a.conn.GetSetByName(t, set.Name)
+	if err != nil {
+		return nil, fmt.Errorf("getting set %s: %w", set, err)
+	}
+
+	switch s.KeyType.Name {
+	case nftables.TypeIPAddr.Name, nftables.TypeIP6Addr.Name:
+		// Go on.
+	default:
+		return nil, fmt.Errorf("set %s: unsupported type %q", set, s.KeyType.Name)
+	}
+
+	a.sets[*set] = s
+
+	return s, nil
+}
+
+// matchesKeyType returns true if ip can be added to s.
+func matchesKeyType(s *nftables.Set, ip netip.Addr) (ok bool) {
+	if ip.Is4() {
+		return s.KeyType.Name == nftables.TypeIPAddr.Name
+	}
+
+	return s.KeyType.Name == nftables.TypeIP6Addr.Name
+}
+
+// tableFamily converts a validated family name into the nftables one.
+func tableFamily(family string) (f nftables.TableFamily) {
+	switch family {
+	case "ip":
+		return nftables.TableFamilyIPv4
+	case "ip6":
+		return nftables.TableFamilyIPv6
+	case "bridge":
+		return nftables.TableFamilyBridge
+	case "netdev":
+		return nftables.TableFamilyNetdev
+	default:
+		return nftables.TableFamilyINet
+	}
+}
+
+// Close implements the [Adder] interface for *adder.
+func (a *adder) Close() (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.conn.CloseLasting()
+}
@@ -0,0 +1,118 @@
+//go:build linux
+
+package nftset
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConn is a fake nftConn for tests.
+type fakeConn struct {
+	sets  map[string]*nftables.Set
+	added []nftables.SetElement
+}
+
+// type check
+var _ nftConn = (*fakeConn)(nil)
+
+// GetSetByName implements the [nftConn] interface for *fakeConn.
+func (c *fakeConn) GetSetByName(t *nftables.Table, name string) (s *nftables.Set, err error) {
+	s, ok := c.sets[name]
+	if !ok {
+		return nil, assert.AnError
+	}
+
+	s.Table = t
+
+	return s, nil
+}
+
+// SetAddElements implements the [nftConn] interface for *fakeConn.
+func (c *fakeConn) SetAddElements(_ *nftables.Set, vals []nftables.SetElement) (err error) {
+	c.added = append(c.added, vals...)
+
+	return nil
+}
+
+// Flush implements the [nftConn] interface for *fakeConn.
+func (c *fakeConn) Flush() (err error) {
+	return nil
+}
+
+// CloseLasting implements the [nftConn] interface for *fakeConn.
+func (c *fakeConn) CloseLasting() (err error) {
+	return nil
+}
+
+func TestAdder_Add(t *testing.T) {
+	conn := &fakeConn{
+		sets: map[string]*nftables.Set{
+			"set4": {KeyType: nftables.TypeIPAddr, HasTimeout: true},
+			"set6": {KeyType: nftables.TypeIP6Addr},
+		},
+	}
+
+	a := newAdderWithConn(conn)
+
+	now := time.Unix(0, 0)
+	a.now = func() (t time.Time) { return now }
+
+	set4 := &Set{Family: "inet", Table: "filter", Name: "set4"}
+	set6 := &Set{Family: "inet", Table: "filter", Name: "set6"}
+
+	ip4 := netip.MustParseAddr("1.2.3.4")
+	ip6 := netip.MustParseAddr("1234::5678")
+	ips := []netip.Addr{ip4, ip6}
+
+	n, err := a.Add(set4, ips, time.Minute)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, n)
+	require.Len(t, conn.added, 1)
+
+	assert.Equal(t, ip4.AsSlice(), conn.added[0].Key)
+	assert.Equal(t, time.Minute, conn.added[0].Timeout)
+
+	n, err = a.Add(set6, ips, time.Minute)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, n)
+	require.Len(t, conn.added, 2)
+
+	assert.Equal(t, ip6.AsSlice(), conn.added[1].Key)
+	assert.Zero(t, conn.added[1].Timeout)
+
+	t.Run("duplicate", func(t *testing.T) {
+		now = now.Add(time.Minute / 4)
+
+		n, err = a.Add(set4, ips, time.Minute)
+		require.NoError(t, err)
+
+		assert.Zero(t, n)
+
+		n, err = a.Add(set6, ips, time.Minute)
+		require.NoError(t, err)
+
+		assert.Zero(t, n)
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		now = now.Add(time.Minute / 2)
+
+		n, err = a.Add(set4, ips, time.Minute)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("unknown_set", func(t *testing.T) {
+		_, err = a.Add(&Set{Family: "inet", Table: "filter", Name: "none"}, ips, 0)
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package nftset
+
+import (
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+)
+
+func newAdder() (a Adder, err error) {
+	return nil, aghos.Unsupported("nftables")
+}
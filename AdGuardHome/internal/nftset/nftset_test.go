@@ -0,0 +1,47 @@
+package nftset_test
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/nftset"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSet(t *testing.T) {
+	testCases := []struct {
+		want       *nftset.Set
+		name       string
+		in         string
+		wantErrMsg string
+	}{{
+		want:       &nftset.Set{Family: "inet", Table: "filter", Name: "example"},
+		name:       "valid",
+		in:         "inet#filter#example",
+		wantErrMsg: "",
+	}, {
+		want:       nil,
+		name:       "bad_parts",
+		in:         "inet#filter",
+		wantErrMsg: `set "inet#filter": want 3 parts separated by #, got 2`,
+	}, {
+		want:       nil,
+		name:       "bad_family",
+		in:         "arp#filter#example",
+		wantErrMsg: `set "arp#filter#example": bad family "arp"`,
+	}, {
+		want:       nil,
+		name:       "no_set",
+		in:         "ip6#filter#",
+		wantErrMsg: `set "ip6#filter#": no set name`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			set, err := nftset.ParseSet(tc.in)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.want, set)
+		})
+	}
+}
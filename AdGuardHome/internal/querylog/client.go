@@ -10,6 +10,9 @@ type Client struct {
 	DisallowedRule string      `json:"disallowed_rule"`
 	Disallowed     bool        `json:"disallowed"`
 	IgnoreQueryLog bool        `json:"-"`
+
+	// Privacy is the client's override of the query log privacy settings.
+	Privacy ClientPrivacy `json:"-"`
 }
 
 // clientCacheKey is the key by which a cached client information is found.
@@ -0,0 +1,478 @@
+package querylog
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// exportFormat is the format of the exported query log.
+type exportFormat string
+
+// Supported export formats.
+const (
+	exportFormatCSV     exportFormat = "csv"
+	exportFormatNDJSON  exportFormat = "ndjson"
+	exportFormatParquet exportFormat = "parquet"
+)
+
+// contentType returns the MIME type of the format.
+func (f exportFormat) contentType() (ct string) {
+	switch f {
+	case exportFormatCSV:
+		return "text/csv"
+	case exportFormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// exportRecord is a flat representation of a log entry used in the CSV and
+// Parquet exports.
+type exportRecord struct {
+	Time         time.Time
+	Client       string
+	ClientID     string
+	ClientName   string
+	ClientProto  string
+	Name         string
+	Type         string
+	Class        string
+	Status       string
+	Reason       string
+	Rule         string
+	FilterListID int64
+	ServiceName  string
+	Upstream     string
+	Answer       string
+	ElapsedMs    float64
+	Cached       bool
+}
+
+// exportColumns are the columns of the CSV and Parquet exports in the order
+// of the values returned by [exportRecord.values].
+var exportColumns = []*parquetColumn{
+	{name: "time", typ: parquetTypeInt64, converted: parquetConvertedTimestampMicros},
+	{name: "client", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "client_id", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "client_name", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "client_proto", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "name", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "type", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "class", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "status", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "reason", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "rule", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "filter_list_id", typ: parquetTypeInt64, converted: parquetConvertedNone},
+	{name: "service_name", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "upstream", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "answer", typ: parquetTypeByteArray, converted: parquetConvertedUTF8},
+	{name: "elapsed_ms", typ: parquetTypeDouble, converted: parquetConvertedNone},
+	{name: "cached", typ: parquetTypeBoolean, converted: parquetConvertedNone},
+}
+
+// values returns the values of rec in the order of exportColumns.
+func (rec *exportRecord) values() (vals []any) {
+	return []any{
+		rec.Time,
+		rec.Client,
+		rec.ClientID,
+		rec.ClientName,
+		rec.ClientProto,
+		rec.Name,
+		rec.Type,
+		rec.Class,
+		rec.Status,
+		rec.Reason,
+		rec.Rule,
+		rec.FilterListID,
+		rec.ServiceName,
+		rec.Upstream,
+		rec.Answer,
+		rec.ElapsedMs,
+		rec.Cached,
+	}
+}
+
+// strings returns the values of rec formatted for CSV.
+func (rec *exportRecord) strings() (vals []string) {
+	vals = make([]string, 0, len(exportColumns))
+	for _, v := range rec.values() {
+		switch v := v.(type) {
+		case time.Time:
+			vals = append(vals, v.Format(time.RFC3339Nano))
+		case string:
+			vals = append(vals, v)
+		case int64:
+			vals = append(vals, strconv.FormatInt(v, 10))
+		case float64:
+			vals = append(vals, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			vals = append(vals, strconv.FormatBool(v))
+		}
+	}
+
+	return vals
+}
+
+// newExportRecord converts entry into a flat record.  The client IP address is
+// masked using anonFunc.
+func newExportRecord(entry *logEntry, anonFunc aghnet.IPMutFunc) (rec *exportRecord) {
+	entIP := slices.Clone(entry.IP)
+	entryAnonymizer(entry, anonFunc)(entIP)
+
+	rec = &exportRecord{
+		Time:        entry.Time,
+		Client:      entIP.String(),
+		ClientID:    entry.ClientID,
+		ClientProto: string(entry.ClientProto),
+		Name:        entry.QHost,
+		Type:        entry.QType,
+		Class:       entry.QClass,
+		Reason:      entry.Result.Reason.String(),
+		ServiceName: entry.Result.ServiceName,
+		Upstream:    entry.Upstream,
+		ElapsedMs:   entry.Elapsed.Seconds() * 1000,
+		Cached:      entry.Cached,
+	}
+
+	// Don't disclose the name of the client if its address is anonymized, the
+	// same way the JSON API does.
+	if entry.client != nil && entIP.Equal(entry.IP) {
+		rec.ClientName = entry.client.Name
+	}
+
+	if len(entry.Result.Rules) > 0 {
+		r := entry.Result.Rules[0]
+		rec.Rule = r.Text
+		rec.FilterListID = int64(r.FilterListID)
+	}
+
+	if len(entry.Answer) > 0 {
+		msg := &dns.Msg{}
+		if err := msg.Unpack(entry.Answer); err != nil {
+			log.Debug("querylog: export: unpacking answer: %s", err)
+		} else {
+			rec.Status = dns.RcodeToString[msg.Rcode]
+			rec.Answer = exportAnswer(msg)
+		}
+	}
+
+	return rec
+}
+
+// exportAnswer returns the answer records of msg as a single string.
+func exportAnswer(msg *dns.Msg) (s string) {
+	answers := answerToJSON(msg)
+	parts := make([]string, 0, len(answers))
+	for _, a := range answers {
+		parts = append(parts, a.Type+" "+strings.TrimSpace(a.Value))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// exportWriter writes the exported entries in a particular format.
+type exportWriter interface {
+	// write writes a single entry.
+	write(entry *logEntry) (err error)
+
+	// close writes the buffered data, if any.  It doesn't close the
+	// underlying writer.
+	close() (err error)
+}
+
+// newExportWriter returns a new writer of the exported entries in format f
+// into w.
+func newExportWriter(w io.Writer, f exportFormat, anonFunc aghnet.IPMutFunc) (ew exportWriter) {
+	switch f {
+	case exportFormatCSV:
+		return &csvExportWriter{
+			w:        csv.NewWriter(w),
+			anonFunc: anonFunc,
+		}
+	case exportFormatNDJSON:
+		buf := bufio.NewWriter(w)
+
+		return &ndjsonExportWriter{
+			buf:      buf,
+			enc:      json.NewEncoder(buf),
+			anonFunc: anonFunc,
+		}
+	default:
+		return &parquetExportWriter{
+			pw:       newParquetWriter(w, exportColumns),
+			anonFunc: anonFunc,
+		}
+	}
+}
+
+// csvExportWriter writes the entries as CSV with a header line.
+type csvExportWriter struct {
+	w        *csv.Writer
+	anonFunc aghnet.IPMutFunc
+
+	// hasHeader is true if the header has been written.
+	hasHeader bool
+}
+
+// type check
+var _ exportWriter = (*csvExportWriter)(nil)
+
+// writeHeader writes the header line, if it hasn't been written yet.
+func (ew *csvExportWriter) writeHeader() (err error) {
+	if ew.hasHeader {
+		return nil
+	}
+
+	ew.hasHeader = true
+
+	header := make([]string, 0, len(exportColumns))
+	for _, c := range exportColumns {
+		header = append(header, c.name)
+	}
+
+	return ew.w.Write(header)
+}
+
+// write implements the [exportWriter] interface for *csvExportWriter.
+func (ew *csvExportWriter) write(entry *logEntry) (err error) {
+	err = ew.writeHeader()
+	if err != nil {
+		return fmt.Errorf("writing csv header: %w", err)
+	}
+
+	return ew.w.Write(newExportRecord(entry, ew.anonFunc).strings())
+}
+
+// close implements the [exportWriter] interface for *csvExportWriter.
+func (ew *csvExportWriter) close() (err error) {
+	err = ew.writeHeader()
+	if err != nil {
+		return fmt.Errorf("writing csv header: %w", err)
+	}
+
+	ew.w.Flush()
+
+	return ew.w.Error()
+}
+
+// ndjsonExportWriter writes the entries as newline-delimited JSON objects of
+// the same form as the ones returned by the GET /control/querylog HTTP API.
+type ndjsonExportWriter struct {
+	buf      *bufio.Writer
+	enc      *json.Encoder
+	anonFunc aghnet.IPMutFunc
+}
+
+// type check
+var _ exportWriter = (*ndjsonExportWriter)(nil)
+
+// write implements the [exportWriter] interface for *ndjsonExportWriter.
+func (ew *ndjsonExportWriter) write(entry *logEntry) (err error) {
+	return ew.enc.Encode(entryToJSON(entry, ew.anonFunc))
+}
+
+// close implements the [exportWriter] interface for *ndjsonExportWriter.
+func (ew *ndjsonExportWriter) close() (err error) {
+	return ew.buf.Flush()
+}
+
+// parquetExportWriter writes the entries as a Parquet file.
+type parquetExportWriter struct {
+	pw       *parquetWriter
+	anonFunc aghnet.IPMutFunc
+}
+
+// type check
+var _ exportWriter = (*parquetExportWriter)(nil)
+
+// write implements the [exportWriter] interface for *parquetExportWriter.
+func (ew *parquetExportWriter) write(entry *logEntry) (err error) {
+	return ew.pw.writeRow(newExportRecord(entry, ew.anonFunc).values()...)
+}
+
+// close implements the [exportWriter] interface for *parquetExportWriter.
+func (ew *parquetExportWriter) close() (err error) {
+	return ew.pw.close()
+}
+
+// export calls f for each log entry matching params from the newest to the
+// oldest, first from the memory buffer and then from the log files.  Unlike
+// search, it ignores the limit and the offset and reads the files only as far
+// as required, so the entries are never loaded all at once.  l.confMu must not
+// be locked, since it's only locked for decoding a single entry at a time to
+// not block the configuration updates during a long export.
+func (l *queryLog) export(
+	ctx context.Context,
+	params *searchParams,
+	f func(e *logEntry) (err error),
+) (err error) {
+	cache := clientCache{}
+
+	var memEntries []*logEntry
+	func() {
+		l.confMu.RLock()
+		defer l.confMu.RUnlock()
+
+		memEntries, _ = l.searchMemory(params, cache)
+	}()
+
+	for _, e := range memEntries {
+		err = f(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	r, err := l.openReader(params, time.Time{})
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if r == nil {
+		return nil
+	}
+	defer func() { err = errors.WithDeferred(err, r.Close()) }()
+
+	for ctx.Err() == nil {
+		var line string
+		line, err = r.ReadNext()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+
+		var ok bool
+		ok, err = l.exportLine(line, params, cache, f)
+		if !ok || err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// exportLine calls f for the entry from line, if it matches params.  ok is
+// false if line and all the following lines are older than required.  The
+// timestamp is checked before decoding the entry, since the exports of the
+// older entries skip the newer ones.
+func (l *queryLog) exportLine(
+	line string,
+	params *searchParams,
+	cache clientCache,
+	f func(e *logEntry) (err error),
+) (ok bool, err error) {
+	ts := readQLogTimestamp(line)
+	if ts != 0 {
+		if !params.newerThan.IsZero() && ts < params.newerThan.UnixNano() {
+			return false, nil
+		}
+
+		if !params.olderThan.IsZero() && ts >= params.olderThan.UnixNano() {
+			return true, nil
+		}
+	}
+
+	l.confMu.RLock()
+	e, _, err := l.entryFromLine(line, params, cache)
+	l.confMu.RUnlock()
+	if err != nil {
+		log.Error("querylog: export: reading entry: %s", err)
+	}
+
+	if e == nil {
+		return true, nil
+	}
+
+	return true, f(e)
+}
+
+// parseExportParams parses the parameters of the export from the HTTP
+// request's query string.
+func parseExportParams(r *http.Request) (p *searchParams, f exportFormat, err error) {
+	p, err = parseSearchParams(r)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, "", err
+	}
+
+	q := r.URL.Query()
+	if newerThan := q.Get("newer_than"); newerThan != "" {
+		p.newerThan, err = time.Parse(time.RFC3339Nano, newerThan)
+		if err != nil {
+			return nil, "", fmt.Errorf("newer_than: %w", err)
+		}
+	}
+
+	switch f = exportFormat(q.Get("format")); f {
+	case exportFormatCSV, exportFormatNDJSON, exportFormatParquet:
+		// Go on.
+	case "":
+		f = exportFormatCSV
+	default:
+		return nil, "", fmt.Errorf("format: unsupported value %q", f)
+	}
+
+	return p, f, nil
+}
+
+// handleExport is the handler for the GET /control/querylog/export HTTP API.
+func (l *queryLog) handleExport(w http.ResponseWriter, r *http.Request) {
+	params, format, err := parseExportParams(r)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "parsing params: %s", err)
+
+		return
+	}
+
+	// An export of a large log may take longer than the write timeout of the
+	// server.
+	err = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		log.Debug("querylog: export: removing write deadline: %s", err)
+	}
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, format.contentType())
+	h.Set(httphdr.ContentDisposition, fmt.Sprintf(`attachment; filename="querylog.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	ew := newExportWriter(w, format, l.anonymizer.Load())
+
+	var n int
+	err = l.export(r.Context(), params, func(e *logEntry) (writeErr error) {
+		n++
+
+		return ew.write(e)
+	})
+	if err == nil {
+		err = ew.close()
+	}
+
+	if err != nil {
+		// The status has already been sent, so just log the error.
+		log.Error("querylog: export: after %d entries: %s", n, err)
+
+		return
+	}
+
+	log.Debug("querylog: exported %d entries as %s", n, format)
+}
@@ -0,0 +1,446 @@
+package querylog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newExportTestLog returns a query log with two entries in the files added
+// before mid and three entries in memory added after it.
+func newExportTestLog(t *testing.T) (l *queryLog, mid time.Time) {
+	t.Helper()
+
+	l, err := newQueryLog(Config{
+		Enabled:     true,
+		FileEnabled: true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     t.TempDir(),
+		Anonymizer:  aghnet.NewIPMut(nil),
+	})
+	require.NoError(t, err)
+
+	addEntry(l, "one.example", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	require.NoError(t, l.flushLogBuffer())
+	require.NoError(t, l.rotate())
+
+	addEntry(l, "two.example", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+	require.NoError(t, l.flushLogBuffer())
+
+	mid = time.Now()
+
+	addEntry(l, "three.example", net.IPv4(1, 1, 1, 3), net.IPv4(2, 2, 2, 3))
+	addEntry(l, "four.test", net.IPv4(1, 1, 1, 4), net.IPv4(2, 2, 2, 4))
+	addEntry(l, "five.example", net.IPv4(1, 1, 1, 5), net.IPv4(2, 2, 2, 5))
+
+	return l, mid
+}
+
+// export performs the export request with query and returns the response.
+func export(t *testing.T, l *queryLog, query url.Values) (w *httptest.ResponseRecorder) {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/control/querylog/export?"+query.Encode(), nil)
+	w = httptest.NewRecorder()
+	l.handleExport(w, r)
+
+	return w
+}
+
+func TestQueryLog_handleExport(t *testing.T) {
+	l, mid := newExportTestLog(t)
+
+	all := []string{"five.example", "four.test", "three.example", "two.example", "one.example"}
+
+	testCases := []struct {
+		query url.Values
+		name  string
+		want  []string
+	}{{
+		query: url.Values{},
+		name:  "all",
+		want:  all,
+	}, {
+		query: url.Values{
+			// The limit and the offset are ignored.
+			"limit":  {"1"},
+			"offset": {"1"},
+		},
+		name: "no_limit",
+		want: all,
+	}, {
+		query: url.Values{"search": {"example"}},
+		name:  "search",
+		want:  []string{"five.example", "three.example", "two.example", "one.example"},
+	}, {
+		query: url.Values{"newer_than": {mid.Format(time.RFC3339Nano)}},
+		name:  "newer_than",
+		want:  []string{"five.example", "four.test", "three.example"},
+	}, {
+		query: url.Values{"older_than": {mid.Format(time.RFC3339Nano)}},
+		name:  "older_than",
+		want:  []string{"two.example", "one.example"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run("csv_"+tc.name, func(t *testing.T) {
+			w := export(t, l, tc.query)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			assert.Equal(t, "text/csv", w.Header().Get(httphdr.ContentType))
+
+			records, err := csv.NewReader(w.Body).ReadAll()
+			require.NoError(t, err)
+			require.Len(t, records, len(tc.want)+1)
+
+			assert.Equal(t, "time", records[0][0])
+			for i, rec := range records[1:] {
+				assert.Equal(t, tc.want[i], rec[5])
+			}
+		})
+
+		t.Run("ndjson_"+tc.name, func(t *testing.T) {
+			q := url.Values{"format": {"ndjson"}}
+			for k, v := range tc.query {
+				q[k] = v
+			}
+
+			w := export(t, l, q)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var got []string
+			s := bufio.NewScanner(w.Body)
+			for s.Scan() {
+				var e struct {
+					Question struct {
+						Name string `json:"name"`
+					} `json:"question"`
+				}
+				require.NoError(t, json.Unmarshal(s.Bytes(), &e))
+
+				got = append(got, e.Question.Name)
+			}
+
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestQueryLog_handleExport_csvRecord(t *testing.T) {
+	l, _ := newExportTestLog(t)
+	l.anonymizer.Store(AnonymizeIP)
+
+	w := export(t, l, url.Values{"search": {`"one.example"`}})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	got := map[string]string{}
+	for i, name := range records[0] {
+		got[name] = records[1][i]
+	}
+
+	assert.Equal(t, "2.2.0.0", got["client"])
+	assert.Equal(t, "A", got["type"])
+	assert.Equal(t, "NOERROR", got["status"])
+	assert.Equal(t, "Rewrite", got["reason"])
+	assert.Equal(t, "SomeRule", got["rule"])
+	assert.Equal(t, "1", got["filter_list_id"])
+	assert.Equal(t, "SomeService", got["service_name"])
+	assert.Equal(t, "upstream", got["upstream"])
+	assert.Equal(t, "A 1.1.1.1", got["answer"])
+	assert.Equal(t, "false", got["cached"])
+}
+
+func TestQueryLog_handleExport_badParams(t *testing.T) {
+	l, _ := newExportTestLog(t)
+
+	testCases := []struct {
+		query url.Values
+		name  string
+	}{{
+		query: url.Values{"format": {"xml"}},
+		name:  "bad_format",
+	}, {
+		query: url.Values{"newer_than": {"yesterday"}},
+		name:  "bad_newer_than",
+	}, {
+		query: url.Values{"response_status": {"bad"}},
+		name:  "bad_response_status",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := export(t, l, tc.query)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Empty(t, w.Header().Get(httphdr.ContentDisposition))
+		})
+	}
+}
+
+func TestQueryLog_handleExport_parquet(t *testing.T) {
+	l, _ := newExportTestLog(t)
+
+	w := export(t, l, url.Values{"format": {"parquet"}})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	f := readTestParquet(t, w.Body.Bytes())
+	assert.Equal(t, int64(5), f.numRows)
+	require.Len(t, f.schema, len(exportColumns))
+
+	for i, c := range exportColumns {
+		assert.Equal(t, c.name, f.schema[i])
+	}
+
+	require.Len(t, f.rowGroups, 1)
+
+	names := parquetByteArrays(t, f.rowGroups[0][5])
+	assert.Equal(t, []string{
+		"five.example",
+		"four.test",
+		"three.example",
+		"two.example",
+		"one.example",
+	}, names)
+}
+
+func TestParquetWriter_rowGroups(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pw := newParquetWriter(buf, []*parquetColumn{{
+		name:      "n",
+		typ:       parquetTypeInt64,
+		converted: parquetConvertedNone,
+	}, {
+		name:      "odd",
+		typ:       parquetTypeBoolean,
+		converted: parquetConvertedNone,
+	}})
+
+	const total = parquetRowGroupSize + 3
+	for i := range int64(total) {
+		require.NoError(t, pw.writeRow(i, i%2 == 1))
+	}
+
+	require.Error(t, pw.writeRow(int64(1)))
+	require.NoError(t, pw.close())
+
+	f := readTestParquet(t, buf.Bytes())
+	assert.Equal(t, int64(total), f.numRows)
+	require.Len(t, f.rowGroups, 2)
+
+	// The boolean values of the last row group are 0, 1, 0 bit-packed.
+	assert.Equal(t, []byte{0b010}, f.rowGroups[1][1])
+
+	assert.Len(t, f.rowGroups[0][0], parquetRowGroupSize*8)
+}
+
+func TestParquetWriter_empty(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pw := newParquetWriter(buf, exportColumns)
+	require.NoError(t, pw.close())
+
+	f := readTestParquet(t, buf.Bytes())
+	assert.Zero(t, f.numRows)
+	assert.Empty(t, f.rowGroups)
+}
+
+// testParquetFile is the data read from a Parquet file in tests.
+type testParquetFile struct {
+	// schema are the names of the columns.
+	schema []string
+
+	// rowGroups are the decompressed data pages of each column of each row
+	// group.
+	rowGroups [][][]byte
+
+	numRows int64
+}
+
+// readTestParquet parses the Parquet file written by parquetWriter.
+func readTestParquet(t *testing.T, data []byte) (f *testParquetFile) {
+	t.Helper()
+
+	require.True(t, bytes.HasPrefix(data, []byte(parquetMagic)))
+	require.True(t, bytes.HasSuffix(data, []byte(parquetMagic)))
+
+	footerEnd := len(data) - len(parquetMagic) - 4
+	metaLen := int(uint32(data[footerEnd]) |
+		uint32(data[footerEnd+1])<<8 |
+		uint32(data[footerEnd+2])<<16 |
+		uint32(data[footerEnd+3])<<24)
+
+	r := &testThriftReader{data: data[footerEnd-metaLen : footerEnd]}
+	meta := r.readStruct(t)
+	assert.Equal(t, len(r.data), r.pos)
+
+	f = &testParquetFile{
+		numRows: meta[3].(int64),
+	}
+
+	schema := meta[2].([]any)
+	require.NotEmpty(t, schema)
+
+	root := schema[0].(map[int16]any)
+	assert.Equal(t, int64(len(schema)-1), root[5])
+
+	for _, el := range schema[1:] {
+		f.schema = append(f.schema, el.(map[int16]any)[4].(string))
+	}
+
+	rowGroups, _ := meta[4].([]any)
+	for _, rg := range rowGroups {
+		var cols [][]byte
+		for _, cc := range rg.(map[int16]any)[1].([]any) {
+			md := cc.(map[int16]any)[3].(map[int16]any)
+			cols = append(cols, readTestParquetPage(t, data, int(md[9].(int64))))
+		}
+
+		f.rowGroups = append(f.rowGroups, cols)
+	}
+
+	return f
+}
+
+// readTestParquetPage reads the decompressed data of the page at offset.
+func readTestParquetPage(t *testing.T, data []byte, offset int) (page []byte) {
+	t.Helper()
+
+	r := &testThriftReader{data: data[offset:]}
+	hdr := r.readStruct(t)
+
+	start := offset + r.pos
+	compressed := data[start : start+int(hdr[3].(int64))]
+
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+
+	page, err = io.ReadAll(gz)
+	require.NoError(t, err)
+	require.Len(t, page, int(hdr[2].(int64)))
+
+	return page
+}
+
+// parquetByteArrays decodes the PLAIN-encoded byte arrays.
+func parquetByteArrays(t *testing.T, page []byte) (vals []string) {
+	t.Helper()
+
+	for len(page) > 0 {
+		require.GreaterOrEqual(t, len(page), 4)
+
+		n := int(uint32(page[0]) | uint32(page[1])<<8 | uint32(page[2])<<16 | uint32(page[3])<<24)
+		vals = append(vals, string(page[4:4+n]))
+		page = page[4+n:]
+	}
+
+	return vals
+}
+
+// testThriftReader decodes the Thrift compact protocol into maps of field
+// identifiers to values.
+type testThriftReader struct {
+	data []byte
+	pos  int
+}
+
+// byte reads a single byte.
+func (r *testThriftReader) byte() (b byte) {
+	b = r.data[r.pos]
+	r.pos++
+
+	return b
+}
+
+// uvarint reads a varint.
+func (r *testThriftReader) uvarint() (v uint64) {
+	for shift := 0; ; shift += 7 {
+		b := r.byte()
+		v |= uint64(b&0x7F) << shift
+		if b < 0x80 {
+			return v
+		}
+	}
+}
+
+// varint reads a zigzag-encoded varint.
+func (r *testThriftReader) varint() (v int64) {
+	u := r.uvarint()
+
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+// readStruct reads a structure.
+func (r *testThriftReader) readStruct(t *testing.T) (s map[int16]any) {
+	t.Helper()
+
+	s = map[int16]any{}
+
+	var id int16
+	for {
+		b := r.byte()
+		if b == 0 {
+			return s
+		}
+
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.varint())
+		}
+
+		s[id] = r.readValue(t, b&0x0F)
+	}
+}
+
+// readValue reads a value of typ.
+func (r *testThriftReader) readValue(t *testing.T, typ byte) (v any) {
+	t.Helper()
+
+	switch typ {
+	case thriftTypeI32, thriftTypeI64:
+		return r.varint()
+	case thriftTypeBinary:
+		n := int(r.uvarint())
+		v = string(r.data[r.pos : r.pos+n])
+		r.pos += n
+
+		return v
+	case thriftTypeList:
+		hdr := r.byte()
+		n := int(hdr >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+
+		list := make([]any, 0, n)
+		for range n {
+			list = append(list, r.readValue(t, hdr&0x0F))
+		}
+
+		return list
+	case thriftTypeStruct:
+		return r.readStruct(t)
+	default:
+		t.Fatalf("unexpected thrift type %d", typ)
+
+		return nil
+	}
+}
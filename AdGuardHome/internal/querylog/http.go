@@ -59,6 +59,8 @@ type getConfigResp struct {
 // Register web handlers
 func (l *queryLog) initWeb() {
 	l.conf.HTTPRegister(http.MethodGet, "/control/querylog", l.handleQueryLog)
+	l.conf.HTTPRegister(http.MethodGet, "/control/querylog/export", l.handleExport)
+	l.conf.HTTPRegister(http.MethodGet, "/control/querylog/sinks", l.handleSinks)
 	l.conf.HTTPRegister(http.MethodPost, "/control/querylog_clear", l.handleQueryLogClear)
 	l.conf.HTTPRegister(http.MethodGet, "/control/querylog/config", l.handleGetQueryLogConfig)
 	l.conf.HTTPRegister(
@@ -295,18 +297,7 @@ func parseSearchCriterion(q url.Values, name string, ct criterionType) (
 	var asciiVal string
 	switch ct {
 	case ctTerm:
-		// Decode lowercased value from punycode to make EqualFold and
-		// friends work properly with IDNAs.
-		//
-		// TODO(e.burkov):  Make it work with parts of IDNAs somehow.
-		loweredVal := strings.ToLower(val)
-		if asciiVal, err = idna.ToASCII(loweredVal); err != nil {
-			log.Debug("can't convert %q to ascii: %s", val, err)
-		} else if asciiVal == loweredVal {
-			// Purge asciiVal to prevent checking the same value
-			// twice.
-			asciiVal = ""
-		}
+		asciiVal = termASCII(val)
 	case ctFilteringStatus:
 		if !slices.Contains(filteringStatusValues, val) {
 			return false, sc, fmt.Errorf("invalid value %s", val)
@@ -357,27 +348,39 @@ func parseSearchParams(r *http.Request) (p *searchParams, err error) {
 		p.maxFileScanEntries = 0
 	}
 
-	for _, v := range []struct {
-		urlField string
-		ct       criterionType
-	}{{
-		urlField: "search",
-		ct:       ctTerm,
-	}, {
-		urlField: "response_status",
-		ct:       ctFilteringStatus,
-	}} {
-		var ok bool
-		var c searchCriterion
-		ok, c, err = parseSearchCriterion(q, v.urlField, v.ct)
-		if err != nil {
-			return nil, err
-		}
+	p.query, err = parseSearchQuery(q.Get("search"))
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
 
-		if ok {
-			p.searchCriteria = append(p.searchCriteria, c)
-		}
+	ok, c, err := parseSearchCriterion(q, "response_status", ctFilteringStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		p.searchCriteria = append(p.searchCriteria, c)
 	}
 
 	return p, nil
 }
+
+// termASCII returns the punycode representation of the lowercased search term
+// val, if it differs from val, so that EqualFold and friends work properly with
+// IDNAs.
+//
+// TODO(e.burkov):  Make it work with parts of IDNAs somehow.
+func termASCII(val string) (asciiVal string) {
+	loweredVal := strings.ToLower(val)
+	asciiVal, err := idna.ToASCII(loweredVal)
+	if err != nil {
+		log.Debug("can't convert %q to ascii: %s", val, err)
+
+		return ""
+	} else if asciiVal == loweredVal {
+		// Purge asciiVal to prevent checking the same value twice.
+		return ""
+	}
+
+	return asciiVal
+}
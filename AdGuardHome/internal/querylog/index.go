@@ -0,0 +1,729 @@
+package querylog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"go.etcd.io/bbolt"
+)
+
+// Storage types of the query log.
+const (
+	// StorageJSON is the storage of the entries in the JSON files.
+	StorageJSON = "json"
+
+	// StorageIndexed is the storage of the entries in an embedded database
+	// with time-partitioned segments and secondary indexes on the domain
+	// names, the clients, and the filtering statuses.
+	StorageIndexed = "indexed"
+)
+
+// indexDBFileName is the name of the indexed storage file.
+const indexDBFileName = "querylog.db"
+
+const (
+	// indexSegmentDuration is the period of time the entries of a single
+	// segment are within.
+	indexSegmentDuration = 1 * time.Hour
+
+	// indexReadBatchSize is the maximum number of entries read within a single
+	// transaction when scanning a segment.
+	indexReadBatchSize = 1000
+)
+
+// Names of the buckets of the indexed storage.  The segments bucket contains
+// a bucket per segment keyed by the big-endian Unix time of its start.  Each
+// segment contains the entries bucket and the index buckets.
+var (
+	bucketMeta     = []byte("meta")
+	bucketSegments = []byte("segments")
+	bucketEntries  = []byte("entries")
+)
+
+// Names of the index buckets of a segment.  The keys of an index bucket are
+// the indexed value and the key of the entry separated by a zero byte, and the
+// values are empty.
+const (
+	indexDomain = "domain"
+	indexClient = "client"
+	indexStatus = "status"
+)
+
+// metaKeyLegacyImported is the key of the meta bucket set when the entries of
+// the JSON files have been imported.
+var metaKeyLegacyImported = []byte("legacy_imported")
+
+// indexKey is a key of a secondary index.
+type indexKey struct {
+	// index is the name of the index bucket.
+	index string
+
+	// value is the indexed value.
+	value string
+}
+
+// prefix returns the prefix of the keys of the index bucket for k.
+func (k indexKey) prefix() (p []byte) {
+	p = make([]byte, 0, len(k.value)+1)
+	p = append(p, k.value...)
+
+	return append(p, 0)
+}
+
+// entryIndexKeys returns the secondary index keys of e.
+func entryIndexKeys(e *logEntry) (keys []indexKey) {
+	keys = append(keys, indexKey{index: indexDomain, value: strings.ToLower(e.QHost)})
+
+	if e.IP != nil {
+		keys = append(keys, indexKey{index: indexClient, value: e.IP.String()})
+	}
+
+	if e.ClientID != "" {
+		keys = append(keys, indexKey{index: indexClient, value: strings.ToLower(e.ClientID)})
+	}
+
+	for _, status := range filteringStatusValues {
+		if status == filteringStatusAll {
+			continue
+		}
+
+		c := &searchCriterion{criterionType: ctFilteringStatus, value: status}
+		if c.match(e) {
+			keys = append(keys, indexKey{index: indexStatus, value: status})
+		}
+	}
+
+	return keys
+}
+
+// matcherIndexKeys returns the index keys one of which every entry matching m
+// has.  ok is false if m can't be narrowed down using the indexes.
+func matcherIndexKeys(m searchMatcher) (keys []indexKey, ok bool) {
+	switch m := m.(type) {
+	case andMatcher:
+		// Use the most selective indexed part, that is, the one with the
+		// fewest keys.
+		for _, sub := range m {
+			subKeys, subOK := matcherIndexKeys(sub)
+			if subOK && (!ok || len(subKeys) < len(keys)) {
+				keys, ok = subKeys, true
+			}
+		}
+
+		return keys, ok
+	case orMatcher:
+		for _, sub := range m {
+			subKeys, subOK := matcherIndexKeys(sub)
+			if !subOK {
+				return nil, false
+			}
+
+			keys = append(keys, subKeys...)
+		}
+
+		return keys, true
+	case *fieldMatcher:
+		return m.index, m.index != nil
+	case *searchCriterion:
+		if m.criterionType != ctFilteringStatus || m.value == filteringStatusAll {
+			return nil, false
+		}
+
+		return []indexKey{{index: indexStatus, value: m.value}}, true
+	default:
+		return nil, false
+	}
+}
+
+// indexKeys returns the index keys one of which every entry matching s has.
+// ok is false if the search can't be narrowed down using the indexes.
+func (s *searchParams) indexKeys() (keys []indexKey, ok bool) {
+	m := make(andMatcher, 0, len(s.searchCriteria)+1)
+	for i := range s.searchCriteria {
+		m = append(m, &s.searchCriteria[i])
+	}
+
+	if s.query != nil {
+		m = append(m, s.query)
+	}
+
+	return matcherIndexKeys(m)
+}
+
+// segmentKey returns the key of the segment containing the entries at t.
+func segmentKey(t time.Time) (k []byte) {
+	start := t.Truncate(indexSegmentDuration).Unix()
+
+	return binary.BigEndian.AppendUint64(nil, uint64(start))
+}
+
+// segmentEnd returns the end of the segment with the key k.
+func segmentEnd(k []byte) (end time.Time) {
+	return time.Unix(int64(binary.BigEndian.Uint64(k)), 0).Add(indexSegmentDuration)
+}
+
+// entryKeyPrefix returns the prefix of the keys of the entries at t.  Since the
+// keys start with it, the entries of a segment are sorted by time.
+func entryKeyPrefix(t time.Time) (k []byte) {
+	return binary.BigEndian.AppendUint64(make([]byte, 0, 16), uint64(t.UnixNano()))
+}
+
+// entryKey returns the key of the entry at t encoded as line.  The key depends
+// on the contents of the entry, so that writing the same entry twice doesn't
+// duplicate it.
+func entryKey(t time.Time, line []byte) (k []byte) {
+	h := fnv.New64a()
+
+	// Don't check the error, since it's always nil.
+	_, _ = h.Write(line)
+
+	return h.Sum(entryKeyPrefix(t))
+}
+
+// queryIndex is the indexed storage of the query log entries.  It's safe for
+// concurrent use.
+type queryIndex struct {
+	db *bbolt.DB
+}
+
+// openQueryIndex opens the indexed storage at path, creating it if needed.
+func openQueryIndex(path string) (idx *queryIndex, err error) {
+	db, err := bbolt.Open(path, 0o644, nil)
+	if err != nil {
+		return nil, fmt.Errorf("opening %q: %w", path, err)
+	}
+
+	return &queryIndex{db: db}, nil
+}
+
+// close closes the database of the storage.
+func (idx *queryIndex) close() (err error) {
+	return idx.db.Close()
+}
+
+// write saves entries to the storage.
+func (idx *queryIndex) write(entries []*logEntry) (err error) {
+	start := time.Now()
+
+	err = idx.db.Update(func(tx *bbolt.Tx) (err error) {
+		segs, err := tx.CreateBucketIfNotExists(bucketSegments)
+		if err != nil {
+			return fmt.Errorf("creating segments bucket: %w", err)
+		}
+
+		for _, e := range entries {
+			var line []byte
+			line, err = json.Marshal(e)
+			if err != nil {
+				return fmt.Errorf("encoding entry: %w", err)
+			}
+
+			err = putEntry(segs, e, line)
+			if err != nil {
+				// Don't wrap the error, because it's informative enough as is.
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("writing to index: %w", err)
+	}
+
+	log.Debug("querylog: %d entries written to index in %s", len(entries), time.Since(start))
+
+	return nil
+}
+
+// putEntry puts e encoded as line into its segment of segs and updates the
+// indexes of the segment.
+func putEntry(segs *bbolt.Bucket, e *logEntry, line []byte) (err error) {
+	seg, err := segs.CreateBucketIfNotExists(segmentKey(e.Time))
+	if err != nil {
+		return fmt.Errorf("creating segment: %w", err)
+	}
+
+	entries, err := seg.CreateBucketIfNotExists(bucketEntries)
+	if err != nil {
+		return fmt.Errorf("creating entries bucket: %w", err)
+	}
+
+	key := entryKey(e.Time, line)
+	err = entries.Put(key, line)
+	if err != nil {
+		return fmt.Errorf("putting entry: %w", err)
+	}
+
+	for _, ik := range entryIndexKeys(e) {
+		var b *bbolt.Bucket
+		b, err = seg.CreateBucketIfNotExists([]byte(ik.index))
+		if err != nil {
+			return fmt.Errorf("creating %s index: %w", ik.index, err)
+		}
+
+		err = b.Put(append(ik.prefix(), key...), nil)
+		if err != nil {
+			return fmt.Errorf("putting %s index key: %w", ik.index, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteOlder removes the segments that only contain the entries older than
+// t.
+func (idx *queryIndex) deleteOlder(t time.Time) (err error) {
+	var deleted int
+	err = idx.db.Update(func(tx *bbolt.Tx) (err error) {
+		segs := tx.Bucket(bucketSegments)
+		if segs == nil {
+			return nil
+		}
+
+		var keys [][]byte
+		c := segs.Cursor()
+		for k, _ := c.First(); k != nil && !segmentEnd(k).After(t); k, _ = c.Next() {
+			keys = append(keys, slices.Clone(k))
+		}
+
+		for _, k := range keys {
+			err = segs.DeleteBucket(k)
+			if err != nil {
+				return fmt.Errorf("deleting segment: %w", err)
+			}
+		}
+
+		deleted = len(keys)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("deleting old segments: %w", err)
+	}
+
+	log.Debug("querylog: deleted %d index segments older than %s", deleted, t)
+
+	return nil
+}
+
+// deleteExceeding removes the oldest segments once the total size of the
+// segments exceeds maxSize bytes.  The newest segment is never removed.  Note
+// that the database file doesn't shrink, but the freed pages are reused for
+// the new entries.
+func (idx *queryIndex) deleteExceeding(maxSize uint64) (err error) {
+	var deleted int
+	err = idx.db.Update(func(tx *bbolt.Tx) (err error) {
+		segs := tx.Bucket(bucketSegments)
+		if segs == nil {
+			return nil
+		}
+
+		var keys [][]byte
+		var size uint64
+		c := segs.Cursor()
+		k, _ := c.Last()
+		for isNewest := true; k != nil; k, _ = c.Prev() {
+			st := segs.Bucket(k).Stats()
+			size += uint64(st.BranchInuse + st.LeafInuse)
+			if size > maxSize && !isNewest {
+				keys = append(keys, slices.Clone(k))
+			}
+
+			isNewest = false
+		}
+
+		for _, k = range keys {
+			err = segs.DeleteBucket(k)
+			if err != nil {
+				return fmt.Errorf("deleting segment: %w", err)
+			}
+		}
+
+		deleted = len(keys)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("deleting exceeding segments: %w", err)
+	}
+
+	log.Debug("querylog: deleted %d index segments exceeding %d bytes", deleted, maxSize)
+
+	return nil
+}
+
+// clear removes all entries from the storage.
+func (idx *queryIndex) clear() (err error) {
+	return idx.db.Update(func(tx *bbolt.Tx) (err error) {
+		err = tx.DeleteBucket(bucketSegments)
+		if errors.Is(err, bbolt.ErrBucketNotFound) {
+			return nil
+		}
+
+		return err
+	})
+}
+
+// legacyImported returns true if the entries of the JSON files have already
+// been imported.
+func (idx *queryIndex) legacyImported() (ok bool, err error) {
+	err = idx.db.View(func(tx *bbolt.Tx) (err error) {
+		meta := tx.Bucket(bucketMeta)
+		ok = meta != nil && meta.Get(metaKeyLegacyImported) != nil
+
+		return nil
+	})
+
+	return ok, err
+}
+
+// importFiles imports the entries of the JSON query log files into the
+// storage, unless it's already been done.  Importing the same entries again
+// doesn't duplicate them, so an interrupted import is simply restarted.
+func (idx *queryIndex) importFiles(files []string) (err error) {
+	imported, err := idx.legacyImported()
+	if err != nil {
+		return fmt.Errorf("checking import: %w", err)
+	} else if imported {
+		return nil
+	}
+
+	r, err := newQLogReader(files)
+	if err != nil {
+		return fmt.Errorf("opening files: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, r.Close()) }()
+
+	err = r.SeekStart()
+	if err != nil {
+		return fmt.Errorf("seeking: %w", err)
+	}
+
+	var total int
+	for {
+		var n int
+		n, err = idx.importBatch(r)
+		total += n
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+	}
+
+	err = idx.db.Update(func(tx *bbolt.Tx) (err error) {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return fmt.Errorf("creating meta bucket: %w", err)
+		}
+
+		return meta.Put(metaKeyLegacyImported, []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return fmt.Errorf("marking import: %w", err)
+	}
+
+	log.Info("querylog: imported %d entries from json files into index", total)
+
+	return nil
+}
+
+// importBatch imports up to [indexReadBatchSize] entries from r within a
+// single transaction.  err is [io.EOF] if r has no more entries.
+func (idx *queryIndex) importBatch(r *qLogReader) (n int, err error) {
+	var readErr error
+	err = idx.db.Update(func(tx *bbolt.Tx) (err error) {
+		segs, err := tx.CreateBucketIfNotExists(bucketSegments)
+		if err != nil {
+			return fmt.Errorf("creating segments bucket: %w", err)
+		}
+
+		for n < indexReadBatchSize {
+			var line string
+			line, readErr = r.ReadNext()
+			if readErr != nil {
+				return nil
+			}
+
+			e := &logEntry{}
+			decodeLogEntry(e, line)
+			if e.QHost == "" || e.Time.IsZero() {
+				log.Debug("querylog: import: skipping invalid line")
+
+				continue
+			}
+
+			err = putEntry(segs, e, []byte(line))
+			if err != nil {
+				// Don't wrap the error, because it's informative enough as
+				// is.
+				return err
+			}
+
+			n++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return n, fmt.Errorf("importing entries: %w", err)
+	}
+
+	if readErr != nil && !errors.Is(readErr, io.EOF) {
+		return n, fmt.Errorf("reading entries: %w", readErr)
+	}
+
+	return n, readErr
+}
+
+// indexReader reads the encoded entries from the indexed storage from the
+// newest to the oldest.  It reads the entries in batches, each within its own
+// transaction, to not keep long transactions during the long searches and
+// exports.
+type indexReader struct {
+	idx *queryIndex
+
+	// keys are the index keys one of which the entries must have.  If nil,
+	// all entries are read.
+	keys []indexKey
+
+	// lines are the entries read but not yet returned.
+	lines []string
+
+	// upper is the exclusive upper bound of the entry keys.  If nil, there is
+	// no bound.
+	upper []byte
+
+	// seg is the key of the current segment.  If nil, the reading hasn't
+	// started yet.
+	seg []byte
+
+	// last is the key of the last entry read from seg.  If nil, seg is read
+	// from the newest entry.
+	last []byte
+
+	// lower is the time the segments ending before are not read.
+	lower time.Time
+
+	// segDone is true if all entries of seg have been read.
+	segDone bool
+
+	// done is true if all segments have been read.
+	done bool
+}
+
+// type check
+var _ lineReader = (*indexReader)(nil)
+
+// reader returns a reader of the entries older than olderThan, if not zero,
+// that may match params.
+func (idx *queryIndex) reader(params *searchParams, olderThan time.Time) (r *indexReader) {
+	r = &indexReader{
+		idx:   idx,
+		lower: params.newerThan,
+	}
+
+	if keys, ok := params.indexKeys(); ok {
+		r.keys = keys
+	}
+
+	if !olderThan.IsZero() {
+		r.upper = entryKeyPrefix(olderThan)
+	}
+
+	return r
+}
+
+// ReadNext implements the [lineReader] interface for *indexReader.
+func (r *indexReader) ReadNext() (line string, err error) {
+	for len(r.lines) == 0 {
+		if r.done {
+			return "", io.EOF
+		}
+
+		err = r.idx.db.View(r.fill)
+		if err != nil {
+			// Stop reading, since the error is most probably persistent.
+			r.done = true
+
+			return "", fmt.Errorf("reading index: %w", err)
+		}
+	}
+
+	line, r.lines = r.lines[0], r.lines[1:]
+
+	return line, nil
+}
+
+// Close implements the [lineReader] interface for *indexReader.
+func (r *indexReader) Close() (err error) {
+	return nil
+}
+
+// fill reads the next batch of entries within tx.
+func (r *indexReader) fill(tx *bbolt.Tx) (err error) {
+	segs := tx.Bucket(bucketSegments)
+	if segs == nil {
+		r.done = true
+
+		return nil
+	}
+
+	c := segs.Cursor()
+	for k := r.nextSegment(c); len(r.lines) == 0; k, _ = c.Prev() {
+		if k == nil || (!r.lower.IsZero() && !segmentEnd(k).After(r.lower)) {
+			r.done = true
+
+			return nil
+		}
+
+		if !bytes.Equal(k, r.seg) {
+			r.seg, r.last = slices.Clone(k), nil
+		}
+
+		seg := segs.Bucket(k)
+		if seg == nil {
+			// Not a bucket, go on.
+			r.segDone = true
+
+			continue
+		}
+
+		if r.keys == nil {
+			r.segDone = r.scanSegment(seg)
+		} else {
+			r.readIndexed(seg)
+			r.segDone = true
+		}
+	}
+
+	return nil
+}
+
+// nextSegment positions c to the segment to read next and returns its key.
+func (r *indexReader) nextSegment(c *bbolt.Cursor) (k []byte) {
+	if r.seg == nil {
+		if r.upper == nil {
+			k, _ = c.Last()
+
+			return k
+		}
+
+		upperTime := time.Unix(0, int64(binary.BigEndian.Uint64(r.upper)))
+
+		return seekLE(c, segmentKey(upperTime))
+	}
+
+	k = seekLE(c, r.seg)
+	if r.segDone && bytes.Equal(k, r.seg) {
+		k, _ = c.Prev()
+	}
+
+	return k
+}
+
+// seekLE positions c to the greatest key less than or equal to target and
+// returns it.
+func seekLE(c *bbolt.Cursor, target []byte) (k []byte) {
+	k, _ = c.Seek(target)
+	if k == nil {
+		k, _ = c.Last()
+	} else if bytes.Compare(k, target) > 0 {
+		k, _ = c.Prev()
+	}
+
+	return k
+}
+
+// bound returns the exclusive upper bound of the keys of the entries to read
+// from the current segment, or nil if there is none.
+func (r *indexReader) bound() (b []byte) {
+	if r.last != nil {
+		return r.last
+	}
+
+	return r.upper
+}
+
+// scanSegment reads up to [indexReadBatchSize] entries of seg older than the
+// bound.  done is true if there are no more entries in seg.
+func (r *indexReader) scanSegment(seg *bbolt.Bucket) (done bool) {
+	entries := seg.Bucket(bucketEntries)
+	if entries == nil {
+		return true
+	}
+
+	c := entries.Cursor()
+
+	var k, v []byte
+	if b := r.bound(); b == nil {
+		k, v = c.Last()
+	} else if k, _ = c.Seek(b); k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+
+	for ; k != nil; k, v = c.Prev() {
+		r.lines = append(r.lines, string(v))
+		if len(r.lines) == indexReadBatchSize {
+			r.last = slices.Clone(k)
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// readIndexed reads the entries of seg older than the bound that have any of
+// the index keys.
+func (r *indexReader) readIndexed(seg *bbolt.Bucket) {
+	entries := seg.Bucket(bucketEntries)
+	if entries == nil {
+		return
+	}
+
+	b := r.bound()
+	found := map[string]struct{}{}
+	for _, ik := range r.keys {
+		idxBucket := seg.Bucket([]byte(ik.index))
+		if idxBucket == nil {
+			continue
+		}
+
+		prefix := ik.prefix()
+		c := idxBucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			ek := k[len(prefix):]
+			if b == nil || bytes.Compare(ek, b) < 0 {
+				found[string(ek)] = struct{}{}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(found))
+	for k := range found {
+		keys = append(keys, k)
+	}
+
+	// Sort the keys from the newest to the oldest.
+	slices.SortFunc(keys, func(a, b string) (res int) { return strings.Compare(b, a) })
+
+	for _, k := range keys {
+		if v := entries.Get([]byte(k)); v != nil {
+			r.lines = append(r.lines, string(v))
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package querylog
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newIndexTestEntries returns the entries for the indexed storage tests, one
+// every ten minutes until now, from the newest to the oldest.
+func newIndexTestEntries(n int) (entries []*logEntry) {
+	now := time.Now()
+	for i := range n {
+		e := &logEntry{
+			Time:   now.Add(-time.Duration(i) * 10 * time.Minute),
+			QHost:  "example.org",
+			QType:  "A",
+			QClass: "IN",
+			IP:     net.IP{192, 0, 2, byte(i % 4)},
+		}
+
+		if i%3 == 0 {
+			e.QHost = "blocked.example"
+			e.Result = filtering.Result{
+				Reason:     filtering.FilteredBlockList,
+				IsFiltered: true,
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// newIndexTestQueryLog returns a new query log using the indexed storage with
+// entries written to it.
+func newIndexTestQueryLog(t *testing.T, entries []*logEntry) (l *queryLog) {
+	t.Helper()
+
+	l, err := newQueryLog(Config{
+		Enabled:     true,
+		FileEnabled: true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     t.TempDir(),
+		Storage:     StorageIndexed,
+	})
+	require.NoError(t, err)
+	t.Cleanup(l.Close)
+
+	require.NoError(t, l.index.write(entries))
+
+	return l
+}
+
+func TestQueryLog_search_indexed(t *testing.T) {
+	entries := newIndexTestEntries(30)
+	l := newIndexTestQueryLog(t, entries)
+
+	testCases := []struct {
+		name      string
+		query     string
+		status    string
+		wantTimes []time.Time
+	}{{
+		name:  "all",
+		query: "",
+		wantTimes: []time.Time{
+			entries[0].Time, entries[1].Time, entries[2].Time, entries[3].Time,
+			entries[4].Time, entries[5].Time,
+		},
+	}, {
+		name:  "domain",
+		query: `domain:"blocked.example"`,
+		wantTimes: []time.Time{
+			entries[0].Time, entries[3].Time, entries[6].Time, entries[9].Time,
+			entries[12].Time, entries[15].Time,
+		},
+	}, {
+		name:  "client",
+		query: "client:192.0.2.1 OR client:192.0.2.2",
+		wantTimes: []time.Time{
+			entries[1].Time, entries[2].Time, entries[5].Time, entries[6].Time,
+			entries[9].Time, entries[10].Time,
+		},
+	}, {
+		name:   "status",
+		status: filteringStatusProcessed,
+		query:  "client:192.0.2.0",
+		wantTimes: []time.Time{
+			entries[4].Time, entries[8].Time, entries[16].Time, entries[20].Time,
+			entries[28].Time,
+		},
+	}, {
+		name:  "not_indexed",
+		query: "-domain:blocked.example",
+		wantTimes: []time.Time{
+			entries[1].Time, entries[2].Time, entries[4].Time, entries[5].Time,
+			entries[7].Time, entries[8].Time,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := newSearchParams()
+			params.limit = 6
+
+			var err error
+			params.query, err = parseSearchQuery(tc.query)
+			require.NoError(t, err)
+
+			if tc.status != "" {
+				params.searchCriteria = []searchCriterion{{
+					criterionType: ctFilteringStatus,
+					value:         tc.status,
+				}}
+			}
+
+			got, _ := l.search(params)
+			require.Len(t, got, len(tc.wantTimes))
+
+			for i, e := range got {
+				assert.True(t, tc.wantTimes[i].Equal(e.Time), "entry %d", i)
+			}
+		})
+	}
+}
+
+func TestQueryLog_search_indexedPages(t *testing.T) {
+	// Use more entries than a single read batch to test the continuation
+	// within a segment.
+	const total = indexReadBatchSize + 10
+
+	now := time.Now()
+	entries := make([]*logEntry, 0, total)
+	for i := range total {
+		entries = append(entries, &logEntry{
+			Time:  now.Add(-time.Duration(i) * time.Second),
+			QHost: "example.org",
+			IP:    net.IP{192, 0, 2, 1},
+		})
+	}
+
+	l := newIndexTestQueryLog(t, entries)
+
+	var got int
+	olderThan := time.Time{}
+	for {
+		params := newSearchParams()
+		params.olderThan = olderThan
+		params.limit = 100
+
+		page, oldest := l.search(params)
+		if len(page) == 0 {
+			break
+		}
+
+		for _, e := range page {
+			require.True(t, entries[got].Time.Equal(e.Time), "entry %d", got)
+
+			got++
+		}
+
+		olderThan = oldest
+	}
+
+	assert.Equal(t, total, got)
+
+	// Read all entries at once, across the batches.
+	params := newSearchParams()
+	params.limit = total
+	params.maxFileScanEntries = 0
+
+	all, _ := l.search(params)
+	assert.Len(t, all, total)
+}
+
+func TestQueryIndex_deleteOlder(t *testing.T) {
+	entries := newIndexTestEntries(30)
+	l := newIndexTestQueryLog(t, entries)
+
+	require.NoError(t, l.index.deleteOlder(entries[12].Time))
+
+	params := newSearchParams()
+	params.limit = 100
+
+	got, _ := l.search(params)
+	require.NotEmpty(t, got)
+
+	// Only the whole segments are deleted, so some older entries may remain.
+	oldest := got[len(got)-1].Time
+	assert.True(t, oldest.After(entries[12].Time.Add(-indexSegmentDuration)))
+	assert.True(t, got[0].Time.Equal(entries[0].Time))
+}
+
+func TestQueryIndex_deleteExceeding(t *testing.T) {
+	entries := newIndexTestEntries(30)
+	l := newIndexTestQueryLog(t, entries)
+
+	params := newSearchParams()
+	params.limit = 100
+
+	all, _ := l.search(params)
+	require.Len(t, all, len(entries))
+
+	require.NoError(t, l.index.deleteExceeding(0))
+
+	// The newest segment is never deleted.
+	got, _ := l.search(params)
+	require.NotEmpty(t, got)
+	require.Less(t, len(got), len(entries))
+
+	newestSeg := segmentKey(entries[0].Time)
+	for _, e := range got {
+		assert.Equal(t, newestSeg, segmentKey(e.Time))
+	}
+}
+
+func TestQueryIndex_importFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	jsonLog, err := newQueryLog(Config{
+		Enabled:     true,
+		FileEnabled: true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     dir,
+	})
+	require.NoError(t, err)
+
+	addEntry(jsonLog, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	addEntry(jsonLog, "example.com", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+	require.NoError(t, jsonLog.flushLogBuffer())
+
+	l, err := newQueryLog(Config{
+		Enabled:     true,
+		FileEnabled: true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     dir,
+		Storage:     StorageIndexed,
+	})
+	require.NoError(t, err)
+	t.Cleanup(l.Close)
+
+	require.NoError(t, l.index.importFiles(l.logFiles()))
+
+	imported, err := l.index.legacyImported()
+	require.NoError(t, err)
+	require.True(t, imported)
+
+	require.NoError(t, l.index.clear())
+
+	// The second import does nothing, since the files have already been
+	// imported.
+	require.NoError(t, l.index.importFiles(l.logFiles()))
+
+	params := newSearchParams()
+	got, _ := l.search(params)
+	assert.Empty(t, got)
+}
+
+func TestQueryIndex_importFiles_idempotent(t *testing.T) {
+	dir := t.TempDir()
+
+	jsonLog, err := newQueryLog(Config{
+		Enabled:     true,
+		FileEnabled: true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     dir,
+	})
+	require.NoError(t, err)
+
+	addEntry(jsonLog, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	addEntry(jsonLog, "example.com", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+	require.NoError(t, jsonLog.flushLogBuffer())
+
+	l, err := newQueryLog(Config{
+		Enabled:     true,
+		FileEnabled: true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     dir,
+		Storage:     StorageIndexed,
+	})
+	require.NoError(t, err)
+	t.Cleanup(l.Close)
+
+	// Simulate an interrupted import by importing the batches without marking
+	// the import as finished.
+	for range 2 {
+		r, rErr := newQLogReader(l.logFiles())
+		require.NoError(t, rErr)
+		require.NoError(t, r.SeekStart())
+
+		_, rErr = l.index.importBatch(r)
+		require.ErrorIs(t, rErr, io.EOF)
+		require.NoError(t, r.Close())
+	}
+
+	params := newSearchParams()
+	params.query, err = parseSearchQuery("client:2.2.2.1 OR client:2.2.2.2")
+	require.NoError(t, err)
+
+	got, _ := l.search(params)
+	require.Len(t, got, 2)
+
+	assertLogEntry(t, got[0], "example.com", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+	assertLogEntry(t, got[1], "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+}
+
+func TestMatcherIndexKeys(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		wantKeys []indexKey
+		wantOK   bool
+	}{{
+		name:     "term",
+		query:    "example",
+		wantKeys: nil,
+		wantOK:   false,
+	}, {
+		name:     "domain_strict",
+		query:    `domain:"Example.org"`,
+		wantKeys: []indexKey{{index: indexDomain, value: "example.org"}},
+		wantOK:   true,
+	}, {
+		name:     "domain_glob",
+		query:    `domain:"*.example.org"`,
+		wantKeys: nil,
+		wantOK:   false,
+	}, {
+		name:     "client_cidr",
+		query:    "client:192.0.2.0/24",
+		wantKeys: nil,
+		wantOK:   false,
+	}, {
+		name:  "and_most_selective",
+		query: `status:blocked (client:192.0.2.1 OR client:192.0.2.2) qtype:A`,
+		wantKeys: []indexKey{
+			{index: indexStatus, value: filteringStatusBlocked},
+		},
+		wantOK: true,
+	}, {
+		name:  "or",
+		query: `domain:"example.org" OR client:192.0.2.1`,
+		wantKeys: []indexKey{
+			{index: indexDomain, value: "example.org"},
+			{index: indexClient, value: "192.0.2.1"},
+		},
+		wantOK: true,
+	}, {
+		name:     "or_not_indexed",
+		query:    `domain:"example.org" OR qtype:A`,
+		wantKeys: nil,
+		wantOK:   false,
+	}, {
+		name:     "not",
+		query:    `-domain:"example.org"`,
+		wantKeys: nil,
+		wantOK:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := parseSearchQuery(tc.query)
+			require.NoError(t, err)
+
+			keys, ok := matcherIndexKeys(m)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantKeys, keys)
+		})
+	}
+}
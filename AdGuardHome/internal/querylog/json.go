@@ -59,7 +59,7 @@ func entryToJSON(entry *logEntry, anonFunc aghnet.IPMutFunc) (jsonEntry jobject)
 	}
 
 	entIP := slices.Clone(entry.IP)
-	anonFunc(entIP)
+	entryAnonymizer(entry, anonFunc)(entIP)
 
 	jsonEntry = jobject{
 		"reason":       entry.Result.Reason.String(),
@@ -0,0 +1,474 @@
+package querylog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// parquetMagic is the magic number at the start and the end of a Parquet file.
+const parquetMagic = "PAR1"
+
+// parquetRowGroupSize is the number of rows buffered in memory before they are
+// written as a row group.
+const parquetRowGroupSize = 16_384
+
+// parquetType is the physical type of a Parquet column.
+type parquetType int32
+
+// Physical types of Parquet columns.  See the Type enum in parquet.thrift.
+const (
+	parquetTypeBoolean   parquetType = 0
+	parquetTypeInt64     parquetType = 2
+	parquetTypeDouble    parquetType = 5
+	parquetTypeByteArray parquetType = 6
+)
+
+// parquetConvertedType is the logical type annotation of a Parquet column.
+type parquetConvertedType int32
+
+// Logical type annotations of Parquet columns.  See the ConvertedType enum in
+// parquet.thrift.
+const (
+	parquetConvertedNone            parquetConvertedType = -1
+	parquetConvertedUTF8            parquetConvertedType = 0
+	parquetConvertedTimestampMicros parquetConvertedType = 10
+)
+
+// Other constants from parquet.thrift.
+const (
+	parquetPageTypeData         int32 = 0
+	parquetEncodingPlain        int32 = 0
+	parquetEncodingRLE          int32 = 3
+	parquetRepetitionRequired   int32 = 0
+	parquetCompressionGZIP      int32 = 2
+	parquetFileMetadataVersion1 int32 = 1
+)
+
+// parquetColumn is the description of a required flat Parquet column.
+type parquetColumn struct {
+	// name is the name of the column.
+	name string
+
+	// typ is the physical type of the values.
+	typ parquetType
+
+	// converted is the logical type of the values, if any.
+	converted parquetConvertedType
+}
+
+// parquetColumnChunk is the location of a written column chunk.
+type parquetColumnChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// parquetRowGroup is the location of a written row group.
+type parquetRowGroup struct {
+	chunks  []*parquetColumnChunk
+	numRows int64
+}
+
+// parquetWriter writes the rows into a Parquet file with a flat schema of
+// required columns.  The values are PLAIN-encoded and compressed with GZIP.
+// Only the current row group is kept in memory, so the rows are written
+// without loading all of them.
+type parquetWriter struct {
+	w io.Writer
+
+	cols []*parquetColumn
+
+	// vals are the PLAIN-encoded values of the current row group per column.
+	vals []*bytes.Buffer
+
+	// bits are the bit-packed values of the current row group per boolean
+	// column.
+	bits [][]byte
+
+	rowGroups []*parquetRowGroup
+
+	// offset is the number of bytes written to w.
+	offset int64
+
+	// rows is the number of rows in the current row group.
+	rows int64
+}
+
+// newParquetWriter returns a new writer of the rows with cols into w.
+func newParquetWriter(w io.Writer, cols []*parquetColumn) (pw *parquetWriter) {
+	pw = &parquetWriter{
+		w:    w,
+		cols: cols,
+		vals: make([]*bytes.Buffer, len(cols)),
+		bits: make([][]byte, len(cols)),
+	}
+
+	for i := range cols {
+		pw.vals[i] = &bytes.Buffer{}
+	}
+
+	return pw
+}
+
+// write writes b to the underlying writer and updates the offset.
+func (pw *parquetWriter) write(b []byte) (err error) {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+
+	return err
+}
+
+// writeRow adds a row to the file.  vals must contain a value of the matching
+// type for each column: bool, int64, float64, string, or [time.Time].
+func (pw *parquetWriter) writeRow(vals ...any) (err error) {
+	if len(vals) != len(pw.cols) {
+		return fmt.Errorf("parquet: got %d values for %d columns", len(vals), len(pw.cols))
+	}
+
+	if pw.offset == 0 {
+		err = pw.write([]byte(parquetMagic))
+		if err != nil {
+			return fmt.Errorf("parquet: writing header: %w", err)
+		}
+	}
+
+	for i, v := range vals {
+		err = pw.appendValue(i, v)
+		if err != nil {
+			return fmt.Errorf("parquet: column %q: %w", pw.cols[i].name, err)
+		}
+	}
+
+	pw.rows++
+	if pw.rows == parquetRowGroupSize {
+		return pw.flush()
+	}
+
+	return nil
+}
+
+// appendValue PLAIN-encodes v into the buffer of the column with index i.
+func (pw *parquetWriter) appendValue(i int, v any) (err error) {
+	buf := pw.vals[i]
+	switch v := v.(type) {
+	case bool:
+		n := pw.rows
+		if n%8 == 0 {
+			pw.bits[i] = append(pw.bits[i], 0)
+		}
+
+		if v {
+			pw.bits[i][n/8] |= 1 << (n % 8)
+		}
+	case int64:
+		buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+	case time.Time:
+		buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(v.UnixMicro())))
+	case float64:
+		buf.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)))
+	case string:
+		buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(v))))
+		buf.WriteString(v)
+	default:
+		return fmt.Errorf("unsupported value type %T", v)
+	}
+
+	return nil
+}
+
+// flush writes the current row group, if it isn't empty.
+func (pw *parquetWriter) flush() (err error) {
+	if pw.rows == 0 {
+		return nil
+	}
+
+	rg := &parquetRowGroup{
+		chunks:  make([]*parquetColumnChunk, 0, len(pw.cols)),
+		numRows: pw.rows,
+	}
+
+	for i, c := range pw.cols {
+		data := pw.vals[i].Bytes()
+		if c.typ == parquetTypeBoolean {
+			data = pw.bits[i]
+		}
+
+		var chunk *parquetColumnChunk
+		chunk, err = pw.writePage(data)
+		if err != nil {
+			return fmt.Errorf("parquet: writing column %q: %w", c.name, err)
+		}
+
+		rg.chunks = append(rg.chunks, chunk)
+		pw.vals[i].Reset()
+		pw.bits[i] = pw.bits[i][:0]
+	}
+
+	pw.rowGroups = append(pw.rowGroups, rg)
+	pw.rows = 0
+
+	return nil
+}
+
+// writePage writes data of the current row group as a single data page.
+func (pw *parquetWriter) writePage(data []byte) (chunk *parquetColumnChunk, err error) {
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	_, err = gz.Write(data)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	t := &thriftWriter{}
+	t.i32(1, parquetPageTypeData)
+	t.i32(2, int32(len(data)))
+	t.i32(3, int32(compressed.Len()))
+	t.structBegin(5)
+	t.i32(1, int32(pw.rows))
+	t.i32(2, parquetEncodingPlain)
+	t.i32(3, parquetEncodingRLE)
+	t.i32(4, parquetEncodingRLE)
+	t.structEnd()
+	t.stop()
+
+	chunk = &parquetColumnChunk{
+		offset:           pw.offset,
+		uncompressedSize: int64(len(t.buf) + len(data)),
+		compressedSize:   int64(len(t.buf) + compressed.Len()),
+	}
+
+	err = pw.write(t.buf)
+	if err == nil {
+		err = pw.write(compressed.Bytes())
+	}
+
+	return chunk, err
+}
+
+// close writes the buffered rows and the footer.  It doesn't close the
+// underlying writer.
+func (pw *parquetWriter) close() (err error) {
+	if pw.offset == 0 {
+		err = pw.write([]byte(parquetMagic))
+		if err != nil {
+			return fmt.Errorf("parquet: writing header: %w", err)
+		}
+	}
+
+	err = pw.flush()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	meta := pw.fileMetadata()
+	footer := binary.LittleEndian.AppendUint32(meta, uint32(len(meta)))
+	footer = append(footer, parquetMagic...)
+
+	err = pw.write(footer)
+	if err != nil {
+		return fmt.Errorf("parquet: writing footer: %w", err)
+	}
+
+	return nil
+}
+
+// fileMetadata returns the encoded FileMetaData structure.
+func (pw *parquetWriter) fileMetadata() (b []byte) {
+	var numRows int64
+	for _, rg := range pw.rowGroups {
+		numRows += rg.numRows
+	}
+
+	t := &thriftWriter{}
+	t.i32(1, parquetFileMetadataVersion1)
+
+	// The schema is the root element followed by the columns.
+	t.listBegin(2, thriftTypeStruct, len(pw.cols)+1)
+	t.elemBegin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(pw.cols)))
+	t.elemEnd()
+	for _, c := range pw.cols {
+		t.elemBegin()
+		t.i32(1, int32(c.typ))
+		t.i32(3, parquetRepetitionRequired)
+		t.binary(4, c.name)
+		if c.converted != parquetConvertedNone {
+			t.i32(6, int32(c.converted))
+		}
+		t.elemEnd()
+	}
+
+	t.i64(3, numRows)
+
+	t.listBegin(4, thriftTypeStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		pw.encodeRowGroup(t, rg)
+	}
+
+	t.binary(6, "AdGuard Home")
+	t.stop()
+
+	return t.buf
+}
+
+// encodeRowGroup encodes rg as a RowGroup structure list element.
+func (pw *parquetWriter) encodeRowGroup(t *thriftWriter, rg *parquetRowGroup) {
+	var total int64
+	for _, ch := range rg.chunks {
+		total += ch.uncompressedSize
+	}
+
+	t.elemBegin()
+	t.listBegin(1, thriftTypeStruct, len(rg.chunks))
+	for i, ch := range rg.chunks {
+		c := pw.cols[i]
+
+		t.elemBegin()
+		t.i64(2, ch.offset)
+		t.structBegin(3)
+		t.i32(1, int32(c.typ))
+		t.listBegin(2, thriftTypeI32, 1)
+		t.listI32(parquetEncodingPlain)
+		t.listBegin(3, thriftTypeBinary, 1)
+		t.listBinary(c.name)
+		t.i32(4, parquetCompressionGZIP)
+		t.i64(5, rg.numRows)
+		t.i64(6, ch.uncompressedSize)
+		t.i64(7, ch.compressedSize)
+		t.i64(9, ch.offset)
+		t.structEnd()
+		t.elemEnd()
+	}
+
+	t.i64(2, total)
+	t.i64(3, rg.numRows)
+	t.elemEnd()
+}
+
+// Types of the Thrift compact protocol.
+const (
+	thriftTypeI32    byte = 5
+	thriftTypeI64    byte = 6
+	thriftTypeBinary byte = 8
+	thriftTypeList   byte = 9
+	thriftTypeStruct byte = 12
+)
+
+// thriftWriter encodes structures using the Thrift compact protocol, which is
+// used for the Parquet metadata.
+type thriftWriter struct {
+	buf []byte
+
+	// lastIDs is the stack of the identifiers of the last written fields of
+	// the nested structures.
+	lastIDs []int16
+
+	// lastID is the identifier of the last written field of the current
+	// structure.
+	lastID int16
+}
+
+// field writes the header of the field with id and typ.
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.varint(int64(id))
+	}
+
+	t.lastID = id
+}
+
+// varint writes the zigzag-encoded v.
+func (t *thriftWriter) varint(v int64) {
+	t.uvarint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+// uvarint writes v as a varint.
+func (t *thriftWriter) uvarint(v uint64) {
+	t.buf = binary.AppendUvarint(t.buf, v)
+}
+
+// i32 writes the i32 field.
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftTypeI32)
+	t.varint(int64(v))
+}
+
+// i64 writes the i64 field.
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftTypeI64)
+	t.varint(v)
+}
+
+// binary writes the binary field.
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftTypeBinary)
+	t.listBinary(s)
+}
+
+// structBegin writes the header of the structure field and starts it.
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftTypeStruct)
+	t.elemBegin()
+}
+
+// structEnd ends the structure field.
+func (t *thriftWriter) structEnd() {
+	t.elemEnd()
+}
+
+// listBegin writes the header of the list field with n elements of elemType.
+func (t *thriftWriter) listBegin(id int16, elemType byte, n int) {
+	t.field(id, thriftTypeList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elemType)
+	} else {
+		t.buf = append(t.buf, 0xF0|elemType)
+		t.uvarint(uint64(n))
+	}
+}
+
+// listI32 writes an i32 list element.
+func (t *thriftWriter) listI32(v int32) {
+	t.varint(int64(v))
+}
+
+// listBinary writes a binary list element.
+func (t *thriftWriter) listBinary(s string) {
+	t.uvarint(uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// elemBegin starts a structure list element.
+func (t *thriftWriter) elemBegin() {
+	t.lastIDs = append(t.lastIDs, t.lastID)
+	t.lastID = 0
+}
+
+// elemEnd ends a structure list element.
+func (t *thriftWriter) elemEnd() {
+	t.stop()
+	t.lastID = t.lastIDs[len(t.lastIDs)-1]
+	t.lastIDs = t.lastIDs[:len(t.lastIDs)-1]
+}
+
+// stop writes the end of the current structure.
+func (t *thriftWriter) stop() {
+	t.buf = append(t.buf, 0)
+}
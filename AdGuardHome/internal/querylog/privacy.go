@@ -0,0 +1,101 @@
+package querylog
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// Fields of the log entries which can be omitted for a client.
+const (
+	OmitFieldAnswer   = "answer"
+	OmitFieldClientID = "client_id"
+	OmitFieldECS      = "ecs"
+	OmitFieldRules    = "rules"
+	OmitFieldUpstream = "upstream"
+)
+
+// omittableFields are the valid values of [ClientPrivacy.OmitFields].
+var omittableFields = []string{
+	OmitFieldAnswer,
+	OmitFieldClientID,
+	OmitFieldECS,
+	OmitFieldRules,
+	OmitFieldUpstream,
+}
+
+// ClientPrivacy is the per-client override of the query log privacy settings.
+type ClientPrivacy struct {
+	// AnonymizeClientIP, if not nil, overrides the global setting of the
+	// client IP anonymization.  If true, the address is anonymized before
+	// it's stored.
+	AnonymizeClientIP *bool `yaml:"anonymize_client_ip,omitempty" json:"anonymize_client_ip"`
+
+	// OmitFields are the fields of the log entries which are never stored for
+	// the client.  See the OmitField constants.
+	OmitFields []string `yaml:"omit_fields,omitempty" json:"omit_fields"`
+}
+
+// Validate returns an error if p contains unknown fields.
+func (p *ClientPrivacy) Validate() (err error) {
+	var errs []error
+	for i, f := range p.OmitFields {
+		if !slices.Contains(omittableFields, f) {
+			errs = append(errs, fmt.Errorf("omit_fields: at index %d: unknown field %q", i, f))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Clone returns a deep copy of p.
+func (p ClientPrivacy) Clone() (clone ClientPrivacy) {
+	clone.OmitFields = slices.Clone(p.OmitFields)
+	if p.AnonymizeClientIP != nil {
+		anon := *p.AnonymizeClientIP
+		clone.AnonymizeClientIP = &anon
+	}
+
+	return clone
+}
+
+// IsSet returns true if p overrides any of the global settings.
+func (p *ClientPrivacy) IsSet() (ok bool) {
+	return p.AnonymizeClientIP != nil || len(p.OmitFields) > 0
+}
+
+// apply removes the fields of entry which must not be stored according to p.
+// The client IP address is anonymized separately, see entryAnonymizer.
+func (p *ClientPrivacy) apply(entry *logEntry) {
+	for _, f := range p.OmitFields {
+		switch f {
+		case OmitFieldAnswer:
+			entry.Answer, entry.OrigAnswer = nil, nil
+		case OmitFieldClientID:
+			entry.ClientID = ""
+		case OmitFieldECS:
+			entry.ReqECS = ""
+		case OmitFieldRules:
+			entry.Result.Rules = nil
+		case OmitFieldUpstream:
+			entry.Upstream = ""
+		}
+	}
+}
+
+// entryAnonymizer returns the function to anonymize the client IP address of
+// entry.  The client's override takes precedence over global.
+func entryAnonymizer(entry *logEntry, global aghnet.IPMutFunc) (f aghnet.IPMutFunc) {
+	if entry.client == nil || entry.client.Privacy.AnonymizeClientIP == nil {
+		return global
+	}
+
+	if *entry.client.Privacy.AnonymizeClientIP {
+		return AnonymizeIP
+	}
+
+	return func(net.IP) {}
+}
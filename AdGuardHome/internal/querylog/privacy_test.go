@@ -0,0 +1,127 @@
+package querylog
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryLog_Add_privacy(t *testing.T) {
+	anonymize, keep := true, false
+
+	guestIP, staffIP, otherIP := net.IP{192, 0, 2, 1}, net.IP{192, 0, 2, 2}, net.IP{192, 0, 2, 3}
+	clients := map[string]*Client{
+		guestIP.String(): {
+			Name: "guest",
+			Privacy: ClientPrivacy{
+				AnonymizeClientIP: &anonymize,
+				OmitFields:        []string{OmitFieldAnswer, OmitFieldUpstream, OmitFieldRules},
+			},
+		},
+		staffIP.String(): {
+			Name: "staff",
+			Privacy: ClientPrivacy{
+				AnonymizeClientIP: &keep,
+			},
+		},
+	}
+
+	l, err := newQueryLog(Config{
+		Anonymizer: aghnet.NewIPMut(AnonymizeIP),
+		FindClient: func(ids []string) (c *Client, err error) {
+			return clients[ids[len(ids)-1]], nil
+		},
+		Enabled:           true,
+		RotationIvl:       timeutil.Day,
+		MemSize:           100,
+		BaseDir:           t.TempDir(),
+		AnonymizeClientIP: true,
+	})
+	require.NoError(t, err)
+
+	answer := net.IPv4(1, 1, 1, 1)
+	addEntry(l, "guest.example", answer, guestIP)
+	addEntry(l, "staff.example", answer, staffIP)
+	addEntry(l, "other.example", answer, otherIP)
+
+	params := newSearchParams()
+	entries, _ := l.search(params)
+	require.Len(t, entries, 3)
+
+	anonFunc := l.anonymizer.Load()
+
+	other := entryToJSON(entries[0], anonFunc)
+	assert.Equal(t, net.IP{192, 0, 0, 0}, other["client"])
+
+	staff := entries[1]
+	assertLogEntry(t, staff, "staff.example", answer, staffIP)
+	assert.Equal(t, "upstream", staff.Upstream)
+	assert.Equal(t, staffIP, entryToJSON(staff, anonFunc)["client"])
+
+	// The guest's data is removed before it's stored.
+	guest := entries[2]
+	assert.Equal(t, net.IP{192, 0, 0, 0}, guest.IP.To4())
+	assert.Empty(t, guest.Answer)
+	assert.Empty(t, guest.OrigAnswer)
+	assert.Empty(t, guest.Upstream)
+	assert.Empty(t, guest.Result.Rules)
+}
+
+func TestQueryLog_Add_noClientPrivacy(t *testing.T) {
+	findClientCalls := 0
+	l, err := newQueryLog(Config{
+		Anonymizer: aghnet.NewIPMut(AnonymizeIP),
+		FindClient: func(_ []string) (c *Client, err error) {
+			findClientCalls++
+
+			return nil, nil
+		},
+		HasClientPrivacy: func() (ok bool) { return false },
+		Enabled:          true,
+		RotationIvl:      timeutil.Day,
+		MemSize:          100,
+		BaseDir:          t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IP{192, 0, 2, 1})
+	assert.Zero(t, findClientCalls)
+
+	entries, _ := l.search(newSearchParams())
+	require.Len(t, entries, 1)
+
+	// The global anonymization is still applied.
+	assert.Equal(t, net.IP{192, 0, 0, 0}, entries[0].IP.To4())
+}
+
+func TestClientPrivacy_Validate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		fields     []string
+	}{{
+		name:       "empty",
+		wantErrMsg: "",
+		fields:     nil,
+	}, {
+		name:       "valid",
+		wantErrMsg: "",
+		fields:     []string{OmitFieldAnswer, OmitFieldClientID, OmitFieldECS},
+	}, {
+		name:       "unknown",
+		wantErrMsg: `omit_fields: at index 1: unknown field "question"`,
+		fields:     []string{OmitFieldRules, "question"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &ClientPrivacy{OmitFields: tc.fields}
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, p.Validate())
+		})
+	}
+}
@@ -4,6 +4,7 @@ package querylog
 import (
 	"fmt"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -30,10 +31,21 @@ type queryLog struct {
 
 	findClient func(ids []string) (c *Client, err error)
 
+	// hasClientPrivacy returns true if any client overrides the privacy
+	// settings.
+	hasClientPrivacy func() (ok bool)
+
 	// buffer contains recent log entries.  The entries in this buffer must not
 	// be modified.
 	buffer *aghalg.RingBuffer[*logEntry]
 
+	// sinks are the remote collectors the entries are forwarded to.
+	sinks []*sink
+
+	// index is the indexed storage of the entries.  If nil, the entries are
+	// stored in the JSON files.
+	index *queryIndex
+
 	// logFile is the path to the log file.
 	logFile string
 
@@ -81,19 +93,58 @@ func (l *queryLog) Start() {
 		l.initWeb()
 	}
 
+	for _, s := range l.sinks {
+		s.start()
+	}
+
+	if l.index != nil {
+		go l.importLegacyFiles()
+	}
+
 	go l.periodicRotate()
 }
 
+// importLegacyFiles imports the entries of the JSON files into the indexed
+// storage.  It's intended to be used as a goroutine.
+func (l *queryLog) importLegacyFiles() {
+	defer log.OnPanic("querylog: importing")
+
+	err := l.index.importFiles(l.logFiles())
+	if err != nil {
+		log.Error("querylog: importing json files into index: %s", err)
+	}
+}
+
+// logFiles returns the paths to the JSON files from the oldest to the newest.
+func (l *queryLog) logFiles() (files []string) {
+	return []string{
+		l.logFile + ".1",
+		l.logFile,
+	}
+}
+
 func (l *queryLog) Close() {
+	err := closeSinks(l.sinks)
+	if err != nil {
+		log.Error("querylog: closing sinks: %s", err)
+	}
+
 	l.confMu.RLock()
 	defer l.confMu.RUnlock()
 
 	if l.conf.FileEnabled {
-		err := l.flushLogBuffer()
+		err = l.flushLogBuffer()
 		if err != nil {
 			log.Error("querylog: closing: %s", err)
 		}
 	}
+
+	if l.index != nil {
+		err = l.index.close()
+		if err != nil {
+			log.Error("querylog: closing index: %s", err)
+		}
+	}
 }
 
 func checkInterval(ivl time.Duration) (ok bool) {
@@ -143,10 +194,23 @@ func (l *queryLog) clear() {
 		l.flushPending = false
 	}()
 
-	oldLogFile := l.logFile + ".1"
-	err := os.Remove(oldLogFile)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		log.Error("removing old log file %q: %s", oldLogFile, err)
+	if l.index != nil {
+		err := l.index.clear()
+		if err != nil {
+			log.Error("querylog: clearing index: %s", err)
+		}
+	}
+
+	gens, err := l.generations()
+	if err != nil {
+		log.Error("querylog: clearing: %s", err)
+	}
+
+	for _, g := range gens {
+		err = os.Remove(g.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error("removing old log file %q: %s", g.path, err)
+		}
 	}
 
 	err = os.Remove(l.logFile)
@@ -221,6 +285,10 @@ func (l *queryLog) Add(params *AddParams) {
 	}
 
 	entry := newLogEntry(params)
+	l.applyPrivacy(entry)
+	for _, s := range l.sinks {
+		s.add(entry)
+	}
 
 	l.bufferLock.Lock()
 	defer l.bufferLock.Unlock()
@@ -240,6 +308,42 @@ func (l *queryLog) Add(params *AddParams) {
 	}
 }
 
+// applyPrivacy sets the client of entry, anonymizes its IP address, and
+// removes the data which must not be stored for it.
+func (l *queryLog) applyPrivacy(entry *logEntry) {
+	if l.hasClientPrivacy() {
+		entry.client = l.entryClient(entry)
+	}
+
+	if l.anonymizer != nil && entry.IP != nil {
+		entry.IP = slices.Clone(entry.IP)
+		entryAnonymizer(entry, l.anonymizer.Load())(entry.IP)
+	}
+
+	if entry.client != nil {
+		entry.client.Privacy.apply(entry)
+	}
+}
+
+// entryClient returns the client which made the request of entry, if any.
+func (l *queryLog) entryClient(entry *logEntry) (c *Client) {
+	var ids []string
+	if entry.ClientID != "" {
+		ids = append(ids, entry.ClientID)
+	}
+
+	if entry.IP != nil {
+		ids = append(ids, entry.IP.String())
+	}
+
+	c, err := l.findClient(ids)
+	if err != nil {
+		log.Error("querylog: finding client: %s", err)
+	}
+
+	return c
+}
+
 // ShouldLog returns true if request for the host should be logged.
 func (l *queryLog) ShouldLog(host string, _, _ uint16, ids []string) bool {
 	l.confMu.RLock()
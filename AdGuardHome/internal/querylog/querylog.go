@@ -12,6 +12,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/c2h5oh/datasize"
 	"github.com/miekg/dns"
 )
 
@@ -53,14 +54,44 @@ type Config struct {
 	// FindClient returns client information by their IDs.
 	FindClient func(ids []string) (c *Client, err error)
 
+	// HasClientPrivacy returns true if any client overrides the privacy
+	// settings.  If it returns false, the clients aren't looked up when the
+	// entries are added.  If nil, the clients are always looked up.
+	HasClientPrivacy func() (ok bool)
+
+	// Sinks are the configurations of the remote collectors the entries are
+	// forwarded to.
+	Sinks []*SinkConfig
+
 	// BaseDir is the base directory for log files.
 	BaseDir string
 
+	// Storage is the type of the storage of the entries, either [StorageJSON]
+	// or [StorageIndexed].  If empty, StorageJSON is used.  With
+	// StorageIndexed, the retention limits are applied to the hourly segments
+	// of the storage instead of the rotated files.
+	Storage string
+
 	// RotationIvl is the interval for log rotation.  After that period, the old
-	// log file will be renamed, NOT deleted, so the actual log retention time
-	// is twice the interval.
+	// log file will be renamed into a rotated generation, NOT deleted, so with
+	// a single generation the actual log retention time is twice the interval.
 	RotationIvl time.Duration
 
+	// MaxAge is the maximum age of the newest entry of a rotated generation.
+	// The older generations are removed.  If zero, the age isn't limited.
+	MaxAge time.Duration
+
+	// MaxSize is the maximum total size of the query log files.  The oldest
+	// rotated generations are removed when it's exceeded.  If zero, the size
+	// isn't limited.
+	MaxSize datasize.ByteSize
+
+	// Generations is the maximum number of the rotated generations kept.  All
+	// generations except the newest one are compressed with gzip.  If zero,
+	// the number is only limited by MaxAge and MaxSize, and if those are zero
+	// as well, the rotated generations are never removed.
+	Generations uint
+
 	// MemSize is the number of entries kept in a memory buffer before they are
 	// flushed to disk.
 	MemSize uint
@@ -101,6 +132,8 @@ type AddParams struct {
 
 	ClientProto ClientProto
 
+	// ClientIP is the original address of the client.  It's anonymized
+	// according to the global and the client's settings before it's stored.
 	ClientIP net.IP
 
 	// Elapsed is the time spent for processing the request.
@@ -129,6 +162,16 @@ func (p *AddParams) validate() (err error) {
 	}
 }
 
+// validateRetention returns an error if the retention limits of conf are
+// invalid.
+func validateRetention(conf *Config) (err error) {
+	if conf.MaxAge < 0 {
+		return fmt.Errorf("max_age: negative value %s", conf.MaxAge)
+	}
+
+	return nil
+}
+
 // New creates a new instance of the query log.
 func New(conf Config) (ql QueryLog, err error) {
 	return newQueryLog(conf)
@@ -143,6 +186,11 @@ func newQueryLog(conf Config) (l *queryLog, err error) {
 		}
 	}
 
+	hasClientPrivacy := conf.HasClientPrivacy
+	if hasClientPrivacy == nil {
+		hasClientPrivacy = func() (ok bool) { return true }
+	}
+
 	memSize := conf.MemSize
 	if memSize == 0 {
 		// If query log is enabled, we still need to write entries to a file.
@@ -151,7 +199,8 @@ func newQueryLog(conf Config) (l *queryLog, err error) {
 	}
 
 	l = &queryLog{
-		findClient: findClient,
+		findClient:       findClient,
+		hasClientPrivacy: hasClientPrivacy,
 
 		buffer: aghalg.NewRingBuffer[*logEntry](memSize),
 
@@ -169,5 +218,27 @@ func newQueryLog(conf Config) (l *queryLog, err error) {
 		return nil, fmt.Errorf("unsupported interval: %w", err)
 	}
 
+	err = validateRetention(&conf)
+	if err != nil {
+		return nil, fmt.Errorf("retention: %w", err)
+	}
+
+	l.sinks, err = newSinks(conf.Sinks, conf.Anonymizer)
+	if err != nil {
+		return nil, fmt.Errorf("sinks: %w", err)
+	}
+
+	switch conf.Storage {
+	case "", StorageJSON:
+		// Go on.
+	case StorageIndexed:
+		l.index, err = openQueryIndex(filepath.Join(conf.BaseDir, indexDBFileName))
+		if err != nil {
+			return nil, fmt.Errorf("storage: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("storage: unsupported value %q", conf.Storage)
+	}
+
 	return l, nil
 }
@@ -18,6 +18,10 @@ func (l *queryLog) flushLogBuffer() (err error) {
 	l.fileFlushLock.Lock()
 	defer l.fileFlushLock.Unlock()
 
+	if l.index != nil {
+		return l.flushToIndex()
+	}
+
 	b, err := l.encodeEntries()
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
@@ -63,6 +67,32 @@ func (l *queryLog) encodeEntries() (b *bytes.Buffer, err error) {
 	return b, nil
 }
 
+// flushToIndex saves the log entries to the indexed storage and clears the log
+// buffer.
+func (l *queryLog) flushToIndex() (err error) {
+	var entries []*logEntry
+	func() {
+		l.bufferLock.Lock()
+		defer l.bufferLock.Unlock()
+
+		l.buffer.Range(func(entry *logEntry) (cont bool) {
+			entries = append(entries, entry)
+
+			return true
+		})
+
+		l.buffer.Clear()
+		l.flushPending = false
+	}()
+
+	if len(entries) == 0 {
+		return errors.Error("nothing to write to the index")
+	}
+
+	// Don't wrap the error since it's informative enough as is.
+	return l.index.write(entries)
+}
+
 // flushToFile saves the encoded log entries to the query log file.
 func (l *queryLog) flushToFile(b *bytes.Buffer) (err error) {
 	l.fileWriteLock.Lock()
@@ -87,18 +117,29 @@ func (l *queryLog) flushToFile(b *bytes.Buffer) (err error) {
 	return nil
 }
 
+// rotate renames the current file into the first rotated generation.  Unless
+// only one generation is kept, the previous generations are shifted and the
+// previous first one is compressed.
 func (l *queryLog) rotate() error {
 	from := l.logFile
-	to := l.logFile + ".1"
+	to := l.generationPath(1)
 
-	err := os.Rename(from, to)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			log.Debug("querylog: no log to rotate")
+	_, err := os.Stat(from)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Debug("querylog: no log to rotate")
 
-			return nil
+		return nil
+	}
+
+	if l.retention().generations != 1 {
+		err = l.shiftGenerations()
+		if err != nil {
+			return fmt.Errorf("shifting generations: %w", err)
 		}
+	}
 
+	err = os.Rename(from, to)
+	if err != nil {
 		return fmt.Errorf("failed to rename old file: %w", err)
 	}
 
@@ -155,7 +196,8 @@ func (l *queryLog) periodicRotate() {
 }
 
 // checkAndRotate rotates log files if those are older than the specified
-// rotation interval.
+// rotation interval and removes the rotated generations exceeding the
+// retention limits.
 func (l *queryLog) checkAndRotate() {
 	var rotationIvl time.Duration
 	func() {
@@ -165,6 +207,26 @@ func (l *queryLog) checkAndRotate() {
 		rotationIvl = l.conf.RotationIvl
 	}()
 
+	ret := l.retention()
+	if l.index != nil {
+		err := l.pruneIndex(ret, rotationIvl)
+		if err != nil {
+			log.Error("querylog: rotating index: %s", err)
+		}
+
+		return
+	}
+
+	l.rotateIfNeeded(rotationIvl)
+
+	err := l.pruneGenerations(ret)
+	if err != nil {
+		log.Error("querylog: removing old generations: %s", err)
+	}
+}
+
+// rotateIfNeeded rotates log files if those are older than rotationIvl.
+func (l *queryLog) rotateIfNeeded(rotationIvl time.Duration) {
 	oldest, err := l.readFileFirstTimeValue()
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		log.Error("querylog: reading oldest record for rotation: %s", err)
@@ -0,0 +1,435 @@
+package querylog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/c2h5oh/datasize"
+)
+
+// archiveExt is the extension of the compressed rotated generations.
+const archiveExt = ".gz"
+
+// retention is the retention policy of the rotated query log files.
+type retention struct {
+	// maxSize is the maximum total size of the query log files.  If zero, the
+	// size isn't limited.
+	maxSize datasize.ByteSize
+
+	// maxAge is the maximum age of the newest entry of a rotated generation.
+	// If zero, the age isn't limited.
+	maxAge time.Duration
+
+	// generations is the maximum number of the rotated generations.  If zero,
+	// the number isn't limited.
+	generations uint
+}
+
+// retention returns the current retention policy.
+func (l *queryLog) retention() (r retention) {
+	l.confMu.RLock()
+	defer l.confMu.RUnlock()
+
+	return retention{
+		maxSize:     l.conf.MaxSize,
+		maxAge:      l.conf.MaxAge,
+		generations: l.conf.Generations,
+	}
+}
+
+// generation is a rotated query log file.
+type generation struct {
+	// modTime is the modification time of the file, which is the time of its
+	// newest entry.
+	modTime time.Time
+
+	// path is the path to the file.
+	path string
+
+	// size is the size of the file in bytes.
+	size int64
+
+	// num is the number of the generation starting from 1 for the newest one.
+	num int
+}
+
+// generationPath returns the path to the rotated generation with the number
+// num.  All generations except the first one are compressed.
+func (l *queryLog) generationPath(num int) (path string) {
+	if num == 1 {
+		return l.logFile + ".1"
+	}
+
+	return l.logFile + "." + strconv.Itoa(num) + archiveExt
+}
+
+// generations returns the existing rotated generations sorted from the newest
+// to the oldest.
+func (l *queryLog) generations() (gens []*generation, err error) {
+	paths, err := filepath.Glob(l.logFile + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("listing generations: %w", err)
+	}
+
+	for _, path := range paths {
+		numStr := strings.TrimPrefix(path, l.logFile+".")
+		numStr = strings.TrimSuffix(numStr, archiveExt)
+
+		num, parseErr := strconv.Atoi(numStr)
+		if parseErr != nil || num < 1 || l.generationPath(num) != path {
+			// Not a generation.
+			continue
+		}
+
+		fi, statErr := os.Stat(path)
+		if statErr != nil {
+			return nil, fmt.Errorf("generation %d: %w", num, statErr)
+		}
+
+		gens = append(gens, &generation{
+			modTime: fi.ModTime(),
+			path:    path,
+			size:    fi.Size(),
+			num:     num,
+		})
+	}
+
+	slices.SortFunc(gens, func(a, b *generation) (res int) { return a.num - b.num })
+
+	return gens, nil
+}
+
+// shiftGenerations renames the compressed generations to make room for the
+// first one and compresses it into the second one.
+func (l *queryLog) shiftGenerations() (err error) {
+	gens, err := l.generations()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	// Rename the oldest generations first to not overwrite the newer ones.
+	for i := len(gens) - 1; i >= 0; i-- {
+		g := gens[i]
+		if g.num == 1 {
+			continue
+		}
+
+		err = os.Rename(g.path, l.generationPath(g.num+1))
+		if err != nil {
+			return fmt.Errorf("renaming generation %d: %w", g.num, err)
+		}
+	}
+
+	first := l.generationPath(1)
+	err = compressFile(first, l.generationPath(2))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("compressing generation: %w", err)
+	}
+
+	return os.Remove(first)
+}
+
+// compressFile writes the gzip-compressed contents of the file at src to the
+// file at dst.  The modification time of src is kept.
+func compressFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, in.Close()) }()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("getting file info: %w", err)
+	}
+
+	err = writeCompressed(dst, in)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	return os.Chtimes(dst, time.Time{}, fi.ModTime())
+}
+
+// writeCompressed writes the gzip-compressed data from r to the file at path
+// atomically.
+func writeCompressed(path string, r io.Reader) (err error) {
+	pf, err := aghrenameio.NewPendingFile(path, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, pf) }()
+
+	zw := gzip.NewWriter(pf)
+	_, err = io.Copy(zw, r)
+	if err != nil {
+		return fmt.Errorf("compressing: %w", err)
+	}
+
+	return zw.Close()
+}
+
+// pruneGenerations removes the rotated generations exceeding the limits of
+// ret.  The current file is never removed, so the total size may exceed the
+// limit until the next rotation.
+func (l *queryLog) pruneGenerations(ret retention) (err error) {
+	gens, err := l.generations()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	var size int64
+	fi, err := os.Stat(l.logFile)
+	if err == nil {
+		size = fi.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("getting size: %w", err)
+	}
+
+	now := time.Now()
+	keep := len(gens)
+	for i, g := range gens {
+		size += g.size
+
+		switch {
+		case ret.generations > 0 && uint(g.num) > ret.generations,
+			ret.maxAge > 0 && now.Sub(g.modTime) > ret.maxAge,
+			ret.maxSize > 0 && uint64(size) > ret.maxSize.Bytes():
+			keep = i
+		default:
+			continue
+		}
+
+		break
+	}
+
+	var errs []error
+	for _, g := range gens[keep:] {
+		err = os.Remove(g.path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("removing generation %d: %w", g.num, err))
+
+			continue
+		}
+
+		log.Debug("querylog: removed generation %d", g.num)
+	}
+
+	return errors.Join(errs...)
+}
+
+// indexMaxAge returns the maximum age of the entries of the indexed storage
+// according to ret.  Each rotated generation of the JSON files corresponds to
+// rotationIvl of entries, and unless any of the limits is set, the entries are
+// kept for twice the interval, as with the JSON files by default.
+func (ret retention) indexMaxAge(rotationIvl time.Duration) (maxAge time.Duration) {
+	maxAge = ret.maxAge
+	if ret.generations > 0 {
+		genAge := time.Duration(ret.generations+1) * rotationIvl
+		if maxAge == 0 || genAge < maxAge {
+			maxAge = genAge
+		}
+	}
+
+	if maxAge == 0 && ret.maxSize == 0 {
+		maxAge = 2 * rotationIvl
+	}
+
+	return maxAge
+}
+
+// pruneIndex removes the segments of the indexed storage exceeding the limits
+// of ret.  The newest segment is never removed.
+func (l *queryLog) pruneIndex(ret retention, rotationIvl time.Duration) (err error) {
+	var errs []error
+	if maxAge := ret.indexMaxAge(rotationIvl); maxAge > 0 {
+		err = l.index.deleteOlder(time.Now().Add(-maxAge))
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if ret.maxSize > 0 {
+		err = l.index.deleteExceeding(ret.maxSize.Bytes())
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// archiveReader reads the entries from the plain query log files and then from
+// the compressed generations.  Each compressed generation is decompressed into
+// a temporary file only once the reading reaches it.
+type archiveReader struct {
+	// cur is the reader of the current plain file or decompressed generation.
+	// It's nil if there is none.
+	cur lineReader
+
+	// olderThan is the time the returned entries are older than, if not zero.
+	olderThan time.Time
+
+	// tmpPath is the path to the temporary file of cur, if any.
+	tmpPath string
+
+	// archives are the paths to the compressed generations not yet read,
+	// from the newest to the oldest.
+	archives []string
+}
+
+// type check
+var _ lineReader = (*archiveReader)(nil)
+
+// newArchiveReader returns a new reader of the entries older than olderThan,
+// if not zero, from plain, if not nil, and the archives.
+func newArchiveReader(plain lineReader, archives []string, olderThan time.Time) (r *archiveReader) {
+	return &archiveReader{
+		cur:       plain,
+		olderThan: olderThan,
+		archives:  archives,
+	}
+}
+
+// ReadNext implements the [lineReader] interface for *archiveReader.
+func (r *archiveReader) ReadNext() (line string, err error) {
+	for {
+		if r.cur != nil {
+			line, err = r.cur.ReadNext()
+			if !errors.Is(err, io.EOF) {
+				return line, err
+			}
+
+			err = r.closeCurrent()
+			if err != nil {
+				return "", err
+			}
+		}
+
+		if len(r.archives) == 0 {
+			return "", io.EOF
+		}
+
+		path := r.archives[0]
+		r.archives = r.archives[1:]
+
+		err = r.openArchive(path)
+		if err != nil {
+			return "", fmt.Errorf("opening %q: %w", path, err)
+		}
+	}
+}
+
+// Close implements the [lineReader] interface for *archiveReader.
+func (r *archiveReader) Close() (err error) {
+	return r.closeCurrent()
+}
+
+// closeCurrent closes the current reader and removes its temporary file, if
+// any.
+func (r *archiveReader) closeCurrent() (err error) {
+	if r.cur == nil {
+		return nil
+	}
+
+	err = r.cur.Close()
+	r.cur = nil
+
+	if r.tmpPath != "" {
+		err = errors.WithDeferred(err, os.Remove(r.tmpPath))
+		r.tmpPath = ""
+	}
+
+	return err
+}
+
+// openArchive decompresses the generation at path into a temporary file and
+// sets the current reader to read it from the entries older than olderThan.
+// The current reader stays nil if there are no such entries.
+func (r *archiveReader) openArchive(path string) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "querylog-*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+
+	r.tmpPath = tmp.Name()
+	err = decompressTo(tmp, path)
+	err = errors.WithDeferred(err, tmp.Close())
+	if err != nil {
+		return errors.WithDeferred(err, os.Remove(r.tmpPath))
+	}
+
+	qr, err := newQLogReader([]string{r.tmpPath})
+	if err != nil {
+		return errors.WithDeferred(err, os.Remove(r.tmpPath))
+	}
+
+	r.cur = qr
+
+	err = qr.seekRecord(r.olderThan)
+	if err != nil {
+		// All entries of the generation are newer than required.
+		log.Debug("querylog: skipping %q: %s", path, err)
+
+		return r.closeCurrent()
+	}
+
+	return nil
+}
+
+// decompressTo writes the decompressed contents of the gzip file at path to w.
+func decompressTo(w io.Writer, path string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("reading gzip header: %w", err)
+	}
+
+	_, err = io.Copy(w, zr)
+	if err != nil {
+		return fmt.Errorf("decompressing: %w", err)
+	}
+
+	return zr.Close()
+}
+
+// archivePaths returns the paths to the compressed generations from the
+// newest to the oldest.
+func (l *queryLog) archivePaths() (paths []string) {
+	gens, err := l.generations()
+	if err != nil {
+		log.Error("querylog: %s", err)
+
+		return nil
+	}
+
+	for _, g := range gens {
+		if g.num > 1 {
+			paths = append(paths, g.path)
+		}
+	}
+
+	return paths
+}
@@ -0,0 +1,203 @@
+package querylog
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/c2h5oh/datasize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRotatedQueryLog returns a new query log with n rotated generations, each
+// containing a single entry for the host "host<i>.example", where i is the
+// number of the rotation.
+func newRotatedQueryLog(t *testing.T, n int) (l *queryLog) {
+	t.Helper()
+
+	l, err := newQueryLog(Config{
+		Enabled:     true,
+		FileEnabled: true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	for i := range n {
+		addEntry(l, "host"+strconv.Itoa(i)+".example", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 2))
+		require.NoError(t, l.flushLogBuffer())
+		require.NoError(t, l.rotate())
+	}
+
+	return l
+}
+
+// generationNums returns the numbers of the existing generations of l.
+func generationNums(t *testing.T, l *queryLog) (nums []int) {
+	t.Helper()
+
+	gens, err := l.generations()
+	require.NoError(t, err)
+
+	for _, g := range gens {
+		nums = append(nums, g.num)
+	}
+
+	return nums
+}
+
+func TestQueryLog_rotate_generations(t *testing.T) {
+	l := newRotatedQueryLog(t, 4)
+	require.Equal(t, []int{1, 2, 3, 4}, generationNums(t, l))
+
+	data, err := os.ReadFile(l.generationPath(1))
+	require.NoError(t, err)
+
+	assert.Contains(t, string(data), "host3.example")
+
+	// gzip magic number.
+	gzMagic := []byte{0x1f, 0x8b}
+	for num := 2; num <= 4; num++ {
+		data, err = os.ReadFile(l.generationPath(num))
+		require.NoError(t, err)
+
+		assert.True(t, bytes.HasPrefix(data, gzMagic), "generation %d", num)
+	}
+
+	l.conf.Generations = 3
+	require.NoError(t, l.pruneGenerations(l.retention()))
+	require.Equal(t, []int{1, 2, 3}, generationNums(t, l))
+
+	params := newSearchParams()
+	entries, _ := l.search(params)
+	require.Len(t, entries, 3)
+
+	for i, host := range []string{"host3.example", "host2.example", "host1.example"} {
+		assertLogEntry(t, entries[i], host, net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 2))
+	}
+
+	// The temporary files of the decompressed generations are removed.
+	gens, err := l.generations()
+	require.NoError(t, err)
+
+	files, err := os.ReadDir(l.conf.BaseDir)
+	require.NoError(t, err)
+
+	assert.Len(t, files, len(gens))
+}
+
+func TestQueryLog_rotate_singleGeneration(t *testing.T) {
+	l := newRotatedQueryLog(t, 2)
+	l.conf.Generations = 1
+
+	addEntry(l, "host2.example", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 2))
+	require.NoError(t, l.flushLogBuffer())
+	require.NoError(t, l.rotate())
+
+	// The legacy behavior overwrites the first generation.
+	require.Equal(t, []int{1, 2}, generationNums(t, l))
+
+	require.NoError(t, l.pruneGenerations(l.retention()))
+	require.Equal(t, []int{1}, generationNums(t, l))
+
+	params := newSearchParams()
+	entries, _ := l.search(params)
+	require.Len(t, entries, 1)
+
+	assertLogEntry(t, entries[0], "host2.example", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 2))
+}
+
+func TestQueryLog_pruneGenerations(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ret      func(gens []*generation) (ret retention)
+		wantNums []int
+	}{{
+		name: "unlimited",
+		ret: func(_ []*generation) (ret retention) {
+			return retention{}
+		},
+		wantNums: []int{1, 2, 3, 4},
+	}, {
+		name: "generations",
+		ret: func(_ []*generation) (ret retention) {
+			return retention{generations: 2}
+		},
+		wantNums: []int{1, 2},
+	}, {
+		name: "max_age",
+		ret: func(_ []*generation) (ret retention) {
+			return retention{maxAge: time.Hour}
+		},
+		wantNums: []int{1, 2},
+	}, {
+		name: "max_size",
+		ret: func(gens []*generation) (ret retention) {
+			size := gens[0].size + gens[1].size + gens[2].size
+
+			return retention{maxSize: datasize.ByteSize(size)}
+		},
+		wantNums: []int{1, 2, 3},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newRotatedQueryLog(t, 4)
+
+			old := time.Now().Add(-2 * time.Hour)
+			require.NoError(t, os.Chtimes(l.generationPath(3), old, old))
+
+			gens, err := l.generations()
+			require.NoError(t, err)
+
+			require.NoError(t, l.pruneGenerations(tc.ret(gens)))
+			assert.Equal(t, tc.wantNums, generationNums(t, l))
+		})
+	}
+}
+
+func TestRetention_indexMaxAge(t *testing.T) {
+	const ivl = timeutil.Day
+
+	testCases := []struct {
+		name string
+		ret  retention
+		want time.Duration
+	}{{
+		name: "default",
+		ret:  retention{},
+		want: 2 * ivl,
+	}, {
+		name: "max_age",
+		ret:  retention{maxAge: time.Hour},
+		want: time.Hour,
+	}, {
+		name: "generations",
+		ret:  retention{generations: 3},
+		want: 4 * ivl,
+	}, {
+		name: "generations_less",
+		ret:  retention{maxAge: 10 * ivl, generations: 1},
+		want: 2 * ivl,
+	}, {
+		name: "max_age_less",
+		ret:  retention{maxAge: time.Hour, generations: 1},
+		want: time.Hour,
+	}, {
+		name: "max_size",
+		ret:  retention{maxSize: datasize.MB},
+		want: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.ret.indexMaxAge(ivl))
+		})
+	}
+}
@@ -162,15 +162,52 @@ func (r *qLogReader) seekRecord(olderThan time.Time) (err error) {
 	return err
 }
 
+// lineReader reads the encoded log entries from the newest to the oldest.
+type lineReader interface {
+	// ReadNext returns the next entry.  err is [io.EOF] if there are no more
+	// entries.
+	ReadNext() (line string, err error)
+
+	// Close releases the resources of the reader.
+	Close() (err error)
+}
+
+// type check
+var _ lineReader = (*qLogReader)(nil)
+
+// openReader returns the reader of the stored entries older than olderThan,
+// if not zero, from the storage in use.  r is nil if there is nothing to read.
+// params are used to narrow down the read entries, if the storage supports
+// that, so the entries must still be matched against those.
+func (l *queryLog) openReader(params *searchParams, olderThan time.Time) (r lineReader, err error) {
+	if l.index != nil {
+		return l.index.reader(params, olderThan), nil
+	}
+
+	qr, err := l.setQLogReader(olderThan)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	var plain lineReader
+	if qr != nil {
+		plain = qr
+	}
+
+	archives := l.archivePaths()
+	if len(archives) == 0 {
+		// Return an untyped nil to not confuse the callers.
+		return plain, nil
+	}
+
+	return newArchiveReader(plain, archives, olderThan), nil
+}
+
 // setQLogReader creates a reader with the specified files and sets the
 // position to the next record older than the provided parameter.
 func (l *queryLog) setQLogReader(olderThan time.Time) (qr *qLogReader, err error) {
-	files := []string{
-		l.logFile + ".1",
-		l.logFile,
-	}
-
-	r, err := newQLogReader(files)
+	r, err := newQLogReader(l.logFiles())
 	if err != nil {
 		return nil, fmt.Errorf("opening qlog reader: %s", err)
 	}
@@ -191,7 +228,7 @@ func (l *queryLog) setQLogReader(olderThan time.Time) (qr *qLogReader, err error
 // calls faster so that the UI could handle it and show something quicker.
 // This behavior can be overridden if maxFileScanEntries is set to 0.
 func (l *queryLog) readEntries(
-	r *qLogReader,
+	r lineReader,
 	params *searchParams,
 	cache clientCache,
 	totalLimit int,
@@ -224,8 +261,8 @@ func (l *queryLog) readEntries(
 	return entries, oldestNano, total
 }
 
-// searchFiles looks up log records from all log files.  It optionally uses the
-// client cache, if provided.  searchFiles does not scan more than
+// searchFiles looks up log records from all log files or the indexed storage.
+// It optionally uses the client cache, if provided.  searchFiles does not scan more than
 // maxFileScanEntries so callers may need to call it several times to get all
 // the results.  oldest and total are the time of the oldest processed entry
 // and the total number of processed entries, including discarded ones,
@@ -234,7 +271,7 @@ func (l *queryLog) searchFiles(
 	params *searchParams,
 	cache clientCache,
 ) (entries []*logEntry, oldest time.Time, total int) {
-	r, err := l.setQLogReader(params.olderThan)
+	r, err := l.openReader(params, params.olderThan)
 	if err != nil {
 		log.Error("querylog: %s", err)
 	}
@@ -286,7 +323,7 @@ func (f quickMatchClientFinder) findClient(clientID, ip string) (c *Client) {
 // the entry doesn't match the search criteria.  ts is the timestamp of the
 // processed entry.
 func (l *queryLog) readNextEntry(
-	r *qLogReader,
+	r lineReader,
 	params *searchParams,
 	cache clientCache,
 ) (e *logEntry, ts int64, err error) {
@@ -296,6 +333,16 @@ func (l *queryLog) readNextEntry(
 		return nil, 0, err
 	}
 
+	return l.entryFromLine(line, params, cache)
+}
+
+// entryFromLine decodes the log entry from line and checks if it matches the
+// search criteria.  See [queryLog.readNextEntry].
+func (l *queryLog) entryFromLine(
+	line string,
+	params *searchParams,
+	cache clientCache,
+) (e *logEntry, ts int64, err error) {
 	clientFinder := quickMatchClientFinder{
 		client: l.client,
 		cache:  cache,
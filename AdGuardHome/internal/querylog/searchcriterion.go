@@ -33,6 +33,7 @@ const (
 	filteringStatusRewritten           = "rewritten"            // all kinds of rewrites
 	filteringStatusSafeSearch          = "safe_search"          // enforced safe search
 	filteringStatusProcessed           = "processed"            // not blocked, not white-listed entries
+	filteringStatusAnomaly             = "anomaly"              // detected as anomalous, blocked or not
 )
 
 // filteringStatusValues -- array with all possible filteringStatus values
@@ -40,7 +41,7 @@ var filteringStatusValues = []string{
 	filteringStatusAll, filteringStatusFiltered, filteringStatusBlocked,
 	filteringStatusBlockedService, filteringStatusBlockedSafebrowsing, filteringStatusBlockedParental,
 	filteringStatusWhitelisted, filteringStatusRewritten, filteringStatusSafeSearch,
-	filteringStatusProcessed,
+	filteringStatusProcessed, filteringStatusAnomaly,
 }
 
 // searchCriterion is a search criterion that is used to match a record.
@@ -156,6 +157,8 @@ func (c *searchCriterion) ctFilteringStatusCase(
 			filtering.Rewritten,
 			filtering.RewrittenAutoHosts,
 			filtering.RewrittenRule,
+			filtering.FilteredAnomaly,
+			filtering.FilteredResponseRule,
 		)
 	case
 		filteringStatusBlocked,
@@ -171,13 +174,15 @@ func (c *searchCriterion) ctFilteringStatusCase(
 			filtering.Rewritten,
 			filtering.RewrittenAutoHosts,
 			filtering.RewrittenRule,
-		)
+		) || (reason == filtering.FilteredResponseRule && !isFiltered)
 	case filteringStatusProcessed:
 		return !reason.In(
 			filtering.FilteredBlockList,
 			filtering.FilteredBlockedService,
 			filtering.NotFilteredAllowList,
-		)
+		) && !(reason.In(filtering.FilteredAnomaly, filtering.FilteredResponseRule) && isFiltered)
+	case filteringStatusAnomaly:
+		return reason == filtering.FilteredAnomaly
 	default:
 		return false
 	}
@@ -194,7 +199,11 @@ func (c *searchCriterion) ctFilteringStatusCase(
 func (c *searchCriterion) isFilteredWithReason(reason filtering.Reason) (matched bool) {
 	switch c.value {
 	case filteringStatusBlocked:
-		return reason.In(filtering.FilteredBlockList, filtering.FilteredBlockedService)
+		return reason.In(
+			filtering.FilteredBlockList,
+			filtering.FilteredBlockedService,
+			filtering.FilteredResponseRule,
+		)
 	case filteringStatusBlockedParental:
 		return reason == filtering.FilteredParental
 	case filteringStatusBlockedSafebrowsing:
@@ -0,0 +1,95 @@
+package querylog
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchCriterion_ctFilteringStatusCase_anomaly(t *testing.T) {
+	testCases := []struct {
+		name        string
+		status      string
+		wantLogged  bool
+		wantBlocked bool
+	}{{
+		name:        "anomaly",
+		status:      filteringStatusAnomaly,
+		wantLogged:  true,
+		wantBlocked: true,
+	}, {
+		name:        "filtered",
+		status:      filteringStatusFiltered,
+		wantLogged:  true,
+		wantBlocked: true,
+	}, {
+		name:        "processed",
+		status:      filteringStatusProcessed,
+		wantLogged:  true,
+		wantBlocked: false,
+	}, {
+		name:        "blocked",
+		status:      filteringStatusBlocked,
+		wantLogged:  false,
+		wantBlocked: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &searchCriterion{criterionType: ctFilteringStatus, value: tc.status}
+
+			logged := c.ctFilteringStatusCase(filtering.FilteredAnomaly, false)
+			assert.Equal(t, tc.wantLogged, logged)
+
+			blocked := c.ctFilteringStatusCase(filtering.FilteredAnomaly, true)
+			assert.Equal(t, tc.wantBlocked, blocked)
+		})
+	}
+}
+
+func TestSearchCriterion_ctFilteringStatusCase_responseRule(t *testing.T) {
+	testCases := []struct {
+		name         string
+		status       string
+		wantModified bool
+		wantBlocked  bool
+	}{{
+		name:         "filtered",
+		status:       filteringStatusFiltered,
+		wantModified: true,
+		wantBlocked:  true,
+	}, {
+		name:         "blocked",
+		status:       filteringStatusBlocked,
+		wantModified: false,
+		wantBlocked:  true,
+	}, {
+		name:         "rewritten",
+		status:       filteringStatusRewritten,
+		wantModified: true,
+		wantBlocked:  false,
+	}, {
+		name:         "processed",
+		status:       filteringStatusProcessed,
+		wantModified: true,
+		wantBlocked:  false,
+	}, {
+		name:         "anomaly",
+		status:       filteringStatusAnomaly,
+		wantModified: false,
+		wantBlocked:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &searchCriterion{criterionType: ctFilteringStatus, value: tc.status}
+
+			modified := c.ctFilteringStatusCase(filtering.FilteredResponseRule, false)
+			assert.Equal(t, tc.wantModified, modified)
+
+			blocked := c.ctFilteringStatusCase(filtering.FilteredResponseRule, true)
+			assert.Equal(t, tc.wantBlocked, blocked)
+		})
+	}
+}
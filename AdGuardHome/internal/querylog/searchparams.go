@@ -8,10 +8,17 @@ type searchParams struct {
 	// parameter value.  If not set, disregard it and return any value.
 	olderThan time.Time
 
+	// newerThan represents a parameter for entries that are newer than this
+	// parameter value.  If not set, disregard it and return any value.
+	newerThan time.Time
+
 	// searchCriteria is a list of search criteria that we use to get filter
 	// results.
 	searchCriteria []searchCriterion
 
+	// query is the compiled search query.  If nil, all entries match it.
+	query searchMatcher
+
 	// offset for the search.
 	offset int
 
@@ -47,7 +54,7 @@ func (s *searchParams) quickMatch(line string, findClient quickMatchClientFunc)
 		}
 	}
 
-	return true
+	return s.query == nil || s.query.quickMatch(line, findClient)
 }
 
 // match - checks if the logEntry matches the searchParams
@@ -57,11 +64,16 @@ func (s *searchParams) match(entry *logEntry) bool {
 		return false
 	}
 
+	if !s.newerThan.IsZero() && entry.Time.Before(s.newerThan) {
+		// Ignore entries older than what was requested.
+		return false
+	}
+
 	for _, c := range s.searchCriteria {
 		if !c.match(entry) {
 			return false
 		}
 	}
 
-	return true
+	return s.query == nil || s.query.match(entry)
 }
@@ -0,0 +1,810 @@
+package querylog
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+)
+
+// searchMatcher is a compiled search query or a part of it.
+type searchMatcher interface {
+	// quickMatch checks if the raw query log line may match.  It must not
+	// return false for the lines of the entries that match.  See
+	// [searchCriterion.quickMatch].
+	quickMatch(line string, findClient quickMatchClientFunc) (ok bool)
+
+	// match checks if the decoded log entry matches.
+	match(entry *logEntry) (ok bool)
+}
+
+// type check
+var _ searchMatcher = (*searchCriterion)(nil)
+
+// andMatcher matches the entries matching all of its matchers.
+type andMatcher []searchMatcher
+
+// type check
+var _ searchMatcher = andMatcher(nil)
+
+// quickMatch implements the [searchMatcher] interface for andMatcher.
+func (m andMatcher) quickMatch(line string, findClient quickMatchClientFunc) (ok bool) {
+	for _, sub := range m {
+		if !sub.quickMatch(line, findClient) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// match implements the [searchMatcher] interface for andMatcher.
+func (m andMatcher) match(entry *logEntry) (ok bool) {
+	for _, sub := range m {
+		if !sub.match(entry) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// orMatcher matches the entries matching any of its matchers.
+type orMatcher []searchMatcher
+
+// type check
+var _ searchMatcher = orMatcher(nil)
+
+// quickMatch implements the [searchMatcher] interface for orMatcher.
+func (m orMatcher) quickMatch(line string, findClient quickMatchClientFunc) (ok bool) {
+	for _, sub := range m {
+		if sub.quickMatch(line, findClient) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match implements the [searchMatcher] interface for orMatcher.
+func (m orMatcher) match(entry *logEntry) (ok bool) {
+	for _, sub := range m {
+		if sub.match(entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notMatcher matches the entries not matching its matcher.
+type notMatcher struct {
+	sub searchMatcher
+}
+
+// type check
+var _ searchMatcher = notMatcher{}
+
+// quickMatch implements the [searchMatcher] interface for notMatcher.  Since
+// the quick matches of the other matchers may be false positives, it can't
+// reject any lines.
+func (m notMatcher) quickMatch(_ string, _ quickMatchClientFunc) (ok bool) {
+	return true
+}
+
+// match implements the [searchMatcher] interface for notMatcher.
+func (m notMatcher) match(entry *logEntry) (ok bool) {
+	return !m.sub.match(entry)
+}
+
+// fieldMatcher matches the entries by a single field.
+type fieldMatcher struct {
+	// quick checks the raw line.  If nil, all lines pass the quick check.
+	quick func(line string, findClient quickMatchClientFunc) (ok bool)
+
+	// full checks the decoded entry.
+	full func(entry *logEntry) (ok bool)
+
+	// index are the keys of the indexed storage one of which every matching
+	// entry has.  If nil, the indexes can't be used.
+	index []indexKey
+}
+
+// type check
+var _ searchMatcher = (*fieldMatcher)(nil)
+
+// quickMatch implements the [searchMatcher] interface for *fieldMatcher.
+func (m *fieldMatcher) quickMatch(line string, findClient quickMatchClientFunc) (ok bool) {
+	return m.quick == nil || m.quick(line, findClient)
+}
+
+// match implements the [searchMatcher] interface for *fieldMatcher.
+func (m *fieldMatcher) match(entry *logEntry) (ok bool) {
+	return m.full(entry)
+}
+
+// quickLineValue returns the string value of the JSON property with prefix in
+// line.  ok is false if the value contains escape sequences and thus can't be
+// compared without decoding.
+func quickLineValue(line, prefix string) (val string, ok bool) {
+	val = readJSONValue(line, prefix)
+
+	return val, !strings.Contains(val, `\`)
+}
+
+// textPattern is the value of a textual search field.
+type textPattern struct {
+	value string
+
+	// glob is true if value contains the '*' wildcards.
+	glob bool
+
+	// strict is true if the value must match the whole field.
+	strict bool
+}
+
+// newTextPattern returns a new pattern for the field value, which may be
+// enclosed in double quotes to require the exact match.
+func newTextPattern(val string) (p textPattern) {
+	p.strict = getDoubleQuotesEnclosedValue(&val)
+	p.value = val
+	p.glob = strings.Contains(val, "*")
+
+	return p
+}
+
+// matches returns true if s matches the pattern.  The matching is
+// case-insensitive.
+func (p textPattern) matches(s string) (ok bool) {
+	switch {
+	case p.glob:
+		return matchWildcardFold(p.value, s)
+	case p.strict:
+		return strings.EqualFold(s, p.value)
+	default:
+		return stringutil.ContainsFold(s, p.value)
+	}
+}
+
+// matchWildcardFold returns true if s matches pattern, in which '*' matches
+// any sequence of characters, ignoring the case.
+func matchWildcardFold(pattern, s string) (ok bool) {
+	pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+
+	// The classic greedy algorithm with backtracking to the last star.
+	var pi, si int
+	star, starSI := -1, 0
+	for si < len(s) {
+		switch {
+		case pi < len(pattern) && pattern[pi] == '*':
+			star, starSI = pi, si
+			pi++
+		case pi < len(pattern) && pattern[pi] == s[si]:
+			pi++
+			si++
+		case star >= 0:
+			starSI++
+			pi, si = star+1, starSI
+		default:
+			return false
+		}
+	}
+
+	for pi < len(pattern) && pattern[pi] == '*' {
+		pi++
+	}
+
+	return pi == len(pattern)
+}
+
+// Limits of the search queries.
+const (
+	// maxSearchQueryLen is the maximum length of a search query.
+	maxSearchQueryLen = 1024
+
+	// maxSearchQueryDepth is the maximum nesting of the parentheses and
+	// negations in a search query.
+	maxSearchQueryDepth = 32
+)
+
+// searchTokenType is the type of a token of a search query.
+type searchTokenType uint8
+
+// Search query token types.
+const (
+	searchTokenWord searchTokenType = iota
+	searchTokenAnd
+	searchTokenOr
+	searchTokenNot
+	searchTokenOpen
+	searchTokenClose
+)
+
+// searchToken is a token of a search query.
+type searchToken struct {
+	text string
+	typ  searchTokenType
+}
+
+// tokenizeSearchQuery splits the search query into tokens.
+func tokenizeSearchQuery(query string) (tokens []searchToken, err error) {
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			tokens = append(tokens, searchToken{typ: searchTokenOpen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, searchToken{typ: searchTokenClose, text: ")"})
+			i++
+		case c == '-' && i+1 < len(query) && !strings.ContainsRune(" \t)", rune(query[i+1])):
+			tokens = append(tokens, searchToken{typ: searchTokenNot, text: "-"})
+			i++
+		default:
+			var word string
+			word, err = readSearchWord(query[i:])
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, newSearchWordToken(word))
+			i += len(word)
+		}
+	}
+
+	return tokens, nil
+}
+
+// readSearchWord returns the word at the start of s.  A word ends at a space
+// or a parenthesis outside of double quotes.
+func readSearchWord(s string) (word string, err error) {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+			// Go on.
+		case c == ' ' || c == '\t' || c == '(' || c == ')':
+			return s[:i], nil
+		}
+	}
+
+	if inQuotes {
+		return "", fmt.Errorf("unterminated quote in %q", s)
+	}
+
+	return s, nil
+}
+
+// newSearchWordToken returns the token for the word, which is either an
+// operator or a search term.
+func newSearchWordToken(word string) (t searchToken) {
+	switch word {
+	case "AND":
+		return searchToken{typ: searchTokenAnd, text: word}
+	case "OR":
+		return searchToken{typ: searchTokenOr, text: word}
+	case "NOT":
+		return searchToken{typ: searchTokenNot, text: word}
+	default:
+		return searchToken{typ: searchTokenWord, text: word}
+	}
+}
+
+// searchQueryParser is a recursive descent parser of the search queries.  The
+// grammar is:
+//
+//	query   = or ;
+//	or      = and { "OR" and } ;
+//	and     = unary { [ "AND" ] unary } ;
+//	unary   = ( "NOT" | "-" ) unary | primary ;
+//	primary = "(" or ")" | word ;
+type searchQueryParser struct {
+	tokens []searchToken
+	pos    int
+	depth  int
+}
+
+// parseSearchQuery compiles the search query into a matcher.  m is nil if the
+// query is empty.
+func parseSearchQuery(query string) (m searchMatcher, err error) {
+	if len(query) > maxSearchQueryLen {
+		return nil, fmt.Errorf("query is too long: %d bytes, max %d", len(query), maxSearchQueryLen)
+	}
+
+	tokens, err := tokenizeSearchQuery(query)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	} else if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &searchQueryParser{tokens: tokens}
+	m, err = p.parseOr()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	if t, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+
+	return m, nil
+}
+
+// peek returns the current token, if any.
+func (p *searchQueryParser) peek() (t searchToken, ok bool) {
+	if p.pos >= len(p.tokens) {
+		return searchToken{}, false
+	}
+
+	return p.tokens[p.pos], true
+}
+
+// parseOr parses the disjunction.
+func (p *searchQueryParser) parseOr() (m searchMatcher, err error) {
+	var subs orMatcher
+	for {
+		var sub searchMatcher
+		sub, err = p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		subs = append(subs, sub)
+
+		t, ok := p.peek()
+		if !ok || t.typ != searchTokenOr {
+			break
+		}
+
+		p.pos++
+	}
+
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+
+	return subs, nil
+}
+
+// parseAnd parses the conjunction.  The AND operator is optional.
+func (p *searchQueryParser) parseAnd() (m searchMatcher, err error) {
+	var subs andMatcher
+	for {
+		var sub searchMatcher
+		sub, err = p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		subs = append(subs, sub)
+
+		t, ok := p.peek()
+		if !ok || t.typ == searchTokenOr || t.typ == searchTokenClose {
+			break
+		} else if t.typ == searchTokenAnd {
+			p.pos++
+		}
+	}
+
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+
+	return subs, nil
+}
+
+// parseUnary parses the negation or the primary expression.
+func (p *searchQueryParser) parseUnary() (m searchMatcher, err error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, errors.Error("unexpected end of query")
+	}
+
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.depth > maxSearchQueryDepth {
+		return nil, fmt.Errorf("query is nested too deeply, max depth %d", maxSearchQueryDepth)
+	}
+
+	p.pos++
+	switch t.typ {
+	case searchTokenNot:
+		var sub searchMatcher
+		sub, err = p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notMatcher{sub: sub}, nil
+	case searchTokenOpen:
+		m, err = p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if t, ok = p.peek(); !ok || t.typ != searchTokenClose {
+			return nil, errors.Error("missing closing parenthesis")
+		}
+
+		p.pos++
+
+		return m, nil
+	case searchTokenWord:
+		return parseSearchWord(t.text)
+	default:
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+}
+
+// Search query fields.
+const (
+	searchFieldCached   = "cached"
+	searchFieldClient   = "client"
+	searchFieldDomain   = "domain"
+	searchFieldElapsed  = "elapsed"
+	searchFieldProto    = "proto"
+	searchFieldQType    = "qtype"
+	searchFieldRCode    = "rcode"
+	searchFieldStatus   = "status"
+	searchFieldUpstream = "upstream"
+)
+
+// searchFields are the names of the supported search query fields.
+var searchFields = []string{
+	searchFieldCached,
+	searchFieldClient,
+	searchFieldDomain,
+	searchFieldElapsed,
+	searchFieldProto,
+	searchFieldQType,
+	searchFieldRCode,
+	searchFieldStatus,
+	searchFieldUpstream,
+}
+
+// Comparison operators of the search query fields.
+const (
+	searchOpColon = ":"
+	searchOpEq    = "="
+	searchOpGt    = ">"
+	searchOpGte   = ">="
+	searchOpLt    = "<"
+	searchOpLte   = "<="
+)
+
+// splitSearchField splits the word into the field name, the operator, and the
+// value.  ok is false if the word isn't a field predicate.
+func splitSearchField(word string) (name, op, val string, ok bool) {
+	i := strings.IndexFunc(word, func(r rune) (ok bool) {
+		return !unicode.IsLetter(r)
+	})
+	if i <= 0 {
+		return "", "", "", false
+	}
+
+	name = strings.ToLower(word[:i])
+	if !slices.Contains(searchFields, name) {
+		return "", "", "", false
+	}
+
+	rest := word[i:]
+	for _, o := range []string{searchOpGte, searchOpLte, searchOpColon, searchOpEq, searchOpGt, searchOpLt} {
+		if strings.HasPrefix(rest, o) {
+			return name, o, rest[len(o):], true
+		}
+	}
+
+	return "", "", "", false
+}
+
+// parseSearchWord parses a single word of the query, which is either a field
+// predicate, the cached keyword, or a search term.
+func parseSearchWord(word string) (m searchMatcher, err error) {
+	if strings.EqualFold(word, searchFieldCached) {
+		return newCachedMatcher(true), nil
+	}
+
+	name, op, val, ok := splitSearchField(word)
+	if !ok {
+		sc := newTermCriterion(word)
+
+		return &sc, nil
+	}
+
+	if val == "" {
+		return nil, fmt.Errorf("%s: empty value", name)
+	}
+
+	if name == searchFieldElapsed {
+		m, err = newElapsedMatcher(op, val)
+	} else if op != searchOpColon && op != searchOpEq {
+		err = fmt.Errorf("unsupported operator %q", op)
+	} else {
+		m, err = newFieldMatcher(name, val)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return m, nil
+}
+
+// newFieldMatcher returns the matcher for the equality predicate on the field
+// other than elapsed.
+func newFieldMatcher(name, val string) (m searchMatcher, err error) {
+	switch name {
+	case searchFieldCached:
+		cached, parseErr := strconv.ParseBool(val)
+		if parseErr != nil {
+			return nil, fmt.Errorf("bad value %q", val)
+		}
+
+		return newCachedMatcher(cached), nil
+	case searchFieldClient:
+		return newClientMatcher(val), nil
+	case searchFieldDomain:
+		return newDomainMatcher(val), nil
+	case searchFieldProto:
+		return newProtoMatcher(val)
+	case searchFieldQType:
+		return newQTypeMatcher(val)
+	case searchFieldRCode:
+		return newRCodeMatcher(val)
+	case searchFieldStatus:
+		if !slices.Contains(filteringStatusValues, val) {
+			return nil, fmt.Errorf("bad value %q", val)
+		}
+
+		return &searchCriterion{criterionType: ctFilteringStatus, value: val}, nil
+	case searchFieldUpstream:
+		return newUpstreamMatcher(val), nil
+	default:
+		// Should never happen, since splitSearchField checks the names.
+		panic(fmt.Errorf("unexpected field %q", name))
+	}
+}
+
+// newCachedMatcher returns the matcher of the entries served from the cache,
+// if cached is true, or the other ones.
+func newCachedMatcher(cached bool) (m *fieldMatcher) {
+	return &fieldMatcher{
+		quick: func(line string, _ quickMatchClientFunc) (ok bool) {
+			return strings.Contains(line, `"Cached":true`) == cached
+		},
+		full: func(e *logEntry) (ok bool) {
+			return e.Cached == cached
+		},
+	}
+}
+
+// newClientMatcher returns the matcher of the clients.  val is either an IP
+// address, a CIDR prefix, or a pattern of the ClientID or the client name.
+func newClientMatcher(val string) (m *fieldMatcher) {
+	pref, err := netip.ParsePrefix(val)
+	if err != nil {
+		var ip netip.Addr
+		ip, err = netip.ParseAddr(val)
+		if err == nil {
+			pref = netip.PrefixFrom(ip, ip.BitLen())
+		}
+	}
+
+	if err == nil {
+		pref = pref.Masked()
+		contains := func(ipStr string) (ok bool) {
+			ip, parseErr := netip.ParseAddr(ipStr)
+
+			return parseErr == nil && pref.Contains(ip.Unmap())
+		}
+
+		m = &fieldMatcher{
+			quick: func(line string, _ quickMatchClientFunc) (ok bool) {
+				return contains(readJSONValue(line, `"IP":"`))
+			},
+			full: func(e *logEntry) (ok bool) {
+				return contains(e.IP.String())
+			},
+		}
+
+		if pref.IsSingleIP() {
+			m.index = []indexKey{{index: indexClient, value: pref.Addr().Unmap().String()}}
+		}
+
+		return m
+	}
+
+	pat := newTextPattern(val)
+	matches := func(clientID string, c *Client) (ok bool) {
+		return pat.matches(clientID) || (c != nil && pat.matches(c.Name))
+	}
+
+	return &fieldMatcher{
+		quick: func(line string, findClient quickMatchClientFunc) (ok bool) {
+			clientID, ok := quickLineValue(line, `"CID":"`)
+			if !ok {
+				return true
+			}
+
+			return matches(clientID, findClient(clientID, readJSONValue(line, `"IP":"`)))
+		},
+		full: func(e *logEntry) (ok bool) {
+			return matches(e.ClientID, e.client)
+		},
+	}
+}
+
+// newDomainMatcher returns the matcher of the requested domain names.  IDNAs
+// are matched in both Unicode and punycode forms.
+func newDomainMatcher(val string) (m *fieldMatcher) {
+	pat := newTextPattern(val)
+	asciiPat := pat
+	asciiPat.value = termASCII(pat.value)
+
+	matches := func(host string) (ok bool) {
+		return pat.matches(host) || (asciiPat.value != "" && asciiPat.matches(host))
+	}
+
+	m = &fieldMatcher{
+		quick: func(line string, _ quickMatchClientFunc) (ok bool) {
+			host, ok := quickLineValue(line, `"QH":"`)
+
+			return !ok || matches(host)
+		},
+		full: func(e *logEntry) (ok bool) {
+			return matches(e.QHost)
+		},
+	}
+
+	if pat.strict && !pat.glob {
+		m.index = []indexKey{{index: indexDomain, value: strings.ToLower(pat.value)}}
+		if asciiPat.value != "" {
+			m.index = append(m.index, indexKey{index: indexDomain, value: asciiPat.value})
+		}
+	}
+
+	return m
+}
+
+// newUpstreamMatcher returns the matcher of the upstream addresses.
+func newUpstreamMatcher(val string) (m *fieldMatcher) {
+	pat := newTextPattern(val)
+
+	return &fieldMatcher{
+		quick: func(line string, _ quickMatchClientFunc) (ok bool) {
+			ups, ok := quickLineValue(line, `"Upstream":"`)
+
+			return !ok || pat.matches(ups)
+		},
+		full: func(e *logEntry) (ok bool) {
+			return pat.matches(e.Upstream)
+		},
+	}
+}
+
+// newProtoMatcher returns the matcher of the client protocols.  "plain" is
+// accepted for the plain DNS.
+func newProtoMatcher(val string) (m *fieldMatcher, err error) {
+	val = strings.ToLower(val)
+	if val == "plain" {
+		val = string(ClientProtoPlain)
+	}
+
+	proto, err := NewClientProto(val)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return &fieldMatcher{
+		full: func(e *logEntry) (ok bool) {
+			return e.ClientProto == proto
+		},
+	}, nil
+}
+
+// newQTypeMatcher returns the matcher of the question types.
+func newQTypeMatcher(val string) (m *fieldMatcher, err error) {
+	val = strings.ToUpper(val)
+	if _, ok := dns.StringToType[val]; !ok {
+		return nil, fmt.Errorf("bad value %q", val)
+	}
+
+	return &fieldMatcher{
+		quick: func(line string, _ quickMatchClientFunc) (ok bool) {
+			return readJSONValue(line, `"QT":"`) == val
+		},
+		full: func(e *logEntry) (ok bool) {
+			return e.QType == val
+		},
+	}, nil
+}
+
+// newRCodeMatcher returns the matcher of the response codes.  The entries
+// without responses don't match.
+func newRCodeMatcher(val string) (m *fieldMatcher, err error) {
+	rcode, ok := dns.StringToRcode[strings.ToUpper(val)]
+	if !ok {
+		return nil, fmt.Errorf("bad value %q", val)
+	}
+
+	return &fieldMatcher{
+		full: func(e *logEntry) (ok bool) {
+			respRCode, ok := answerRCode(e.Answer)
+
+			return ok && respRCode == rcode
+		},
+	}, nil
+}
+
+// answerRCode returns the response code from the header of the packed
+// response.  The extended response codes from the OPT records aren't taken
+// into account.
+func answerRCode(answer []byte) (rcode int, ok bool) {
+	// The header is 12 bytes long, and RCODE is in the lower 4 bits of its
+	// fourth byte.
+	if len(answer) < 12 {
+		return 0, false
+	}
+
+	return int(answer[3] & 0x0F), true
+}
+
+// newElapsedMatcher returns the matcher comparing the processing time with
+// the duration in val.
+func newElapsedMatcher(op, val string) (m *fieldMatcher, err error) {
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	var cmp func(elapsed time.Duration) (ok bool)
+	switch op {
+	case searchOpColon, searchOpEq:
+		cmp = func(elapsed time.Duration) (ok bool) { return elapsed == d }
+	case searchOpGt:
+		cmp = func(elapsed time.Duration) (ok bool) { return elapsed > d }
+	case searchOpGte:
+		cmp = func(elapsed time.Duration) (ok bool) { return elapsed >= d }
+	case searchOpLt:
+		cmp = func(elapsed time.Duration) (ok bool) { return elapsed < d }
+	case searchOpLte:
+		cmp = func(elapsed time.Duration) (ok bool) { return elapsed <= d }
+	}
+
+	return &fieldMatcher{
+		full: func(e *logEntry) (ok bool) {
+			return cmp(e.Elapsed)
+		},
+	}, nil
+}
+
+// newTermCriterion returns the criterion of the search term matching the
+// domain name, the client's IP address, ClientID, or name.  The term may be
+// enclosed in double quotes to require the exact match.
+func newTermCriterion(val string) (sc searchCriterion) {
+	strict := getDoubleQuotesEnclosedValue(&val)
+
+	return searchCriterion{
+		criterionType: ctTerm,
+		value:         val,
+		asciiVal:      termASCII(val),
+		strict:        strict,
+	}
+}
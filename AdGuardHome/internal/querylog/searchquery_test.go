@@ -0,0 +1,354 @@
+package querylog
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSearchTestEntry returns a new log entry for the search query tests.
+func newSearchTestEntry(t *testing.T) (e *logEntry) {
+	t.Helper()
+
+	resp := (&dns.Msg{}).SetQuestion("www.example.org.", dns.TypeAAAA)
+	resp.Response = true
+	resp.Rcode = dns.RcodeServerFailure
+
+	answer, err := resp.Pack()
+	require.NoError(t, err)
+
+	return &logEntry{
+		client:      &Client{Name: "Living Room TV"},
+		Time:        time.Now(),
+		QHost:       "www.example.org",
+		QType:       "AAAA",
+		QClass:      "IN",
+		ClientID:    "tv-1",
+		ClientProto: ClientProtoDoH,
+		Upstream:    "https://dns.quad9.net:443/dns-query",
+		Answer:      answer,
+		IP:          net.IP{10, 1, 2, 3},
+		Result: filtering.Result{
+			Reason: filtering.NotFilteredNotFound,
+		},
+		Elapsed: 250 * time.Millisecond,
+		Cached:  true,
+	}
+}
+
+func TestParseSearchQuery_match(t *testing.T) {
+	e := newSearchTestEntry(t)
+
+	b, err := json.Marshal(e)
+	require.NoError(t, err)
+
+	line := string(b)
+	findClient := func(clientID, _ string) (c *Client) {
+		if clientID == e.ClientID {
+			return e.client
+		}
+
+		return nil
+	}
+
+	testCases := []struct {
+		name  string
+		query string
+		want  bool
+	}{{
+		name:  "empty",
+		query: "",
+		want:  true,
+	}, {
+		name:  "term",
+		query: "example",
+		want:  true,
+	}, {
+		name:  "term_strict",
+		query: `"example.org"`,
+		want:  false,
+	}, {
+		name:  "term_client_name",
+		query: "living",
+		want:  true,
+	}, {
+		name:  "request_example",
+		query: "qtype:AAAA client:10.0.0.0/8 upstream:*quad9* elapsed>200ms rcode:SERVFAIL -cached",
+		want:  false,
+	}, {
+		name:  "request_example_cached",
+		query: "qtype:aaaa client:10.0.0.0/8 upstream:*quad9* elapsed>200ms rcode:SERVFAIL cached",
+		want:  true,
+	}, {
+		name:  "qtype_mismatch",
+		query: "qtype:A",
+		want:  false,
+	}, {
+		name:  "client_ip",
+		query: "client:10.1.2.3",
+		want:  true,
+	}, {
+		name:  "client_cidr_mismatch",
+		query: "client:192.168.0.0/16",
+		want:  false,
+	}, {
+		name:  "client_id",
+		query: "client:tv-*",
+		want:  true,
+	}, {
+		name:  "client_name_strict",
+		query: `client:"living room tv"`,
+		want:  true,
+	}, {
+		name:  "domain_glob",
+		query: "domain:*.example.org",
+		want:  true,
+	}, {
+		name:  "domain_glob_mismatch",
+		query: "domain:*.example.com",
+		want:  false,
+	}, {
+		name:  "domain_strict",
+		query: `domain:"example.org"`,
+		want:  false,
+	}, {
+		name:  "elapsed_lte",
+		query: "elapsed<=250ms",
+		want:  true,
+	}, {
+		name:  "elapsed_lt",
+		query: "elapsed<250ms",
+		want:  false,
+	}, {
+		name:  "rcode_mismatch",
+		query: "rcode:NOERROR",
+		want:  false,
+	}, {
+		name:  "status",
+		query: "status:processed",
+		want:  true,
+	}, {
+		name:  "status_mismatch",
+		query: "status:blocked",
+		want:  false,
+	}, {
+		name:  "proto",
+		query: "proto:doh",
+		want:  true,
+	}, {
+		name:  "proto_plain",
+		query: "proto:plain",
+		want:  false,
+	}, {
+		name:  "cached_false",
+		query: "cached:false",
+		want:  false,
+	}, {
+		name:  "or",
+		query: "qtype:A OR qtype:AAAA",
+		want:  true,
+	}, {
+		name:  "and",
+		query: "qtype:A AND qtype:AAAA",
+		want:  false,
+	}, {
+		name:  "not",
+		query: "NOT qtype:A",
+		want:  true,
+	}, {
+		name:  "precedence",
+		query: "qtype:A qtype:MX OR domain:example",
+		want:  true,
+	}, {
+		name:  "parentheses",
+		query: "qtype:A (qtype:MX OR domain:example)",
+		want:  false,
+	}, {
+		name:  "negated_group",
+		query: "-(qtype:A OR rcode:NOERROR)",
+		want:  true,
+	}, {
+		name:  "unknown_field_is_term",
+		query: "www:8080",
+		want:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, parseErr := parseSearchQuery(tc.query)
+			require.NoError(t, parseErr)
+
+			p := newSearchParams()
+			p.query = m
+
+			assert.Equal(t, tc.want, p.match(e))
+			if tc.want {
+				// The quick match must never reject the matching entries.
+				assert.True(t, p.quickMatch(line, findClient))
+			}
+		})
+	}
+}
+
+func TestParseSearchQuery_quickMatch(t *testing.T) {
+	e := newSearchTestEntry(t)
+
+	b, err := json.Marshal(e)
+	require.NoError(t, err)
+
+	line := string(b)
+	findClient := func(_, _ string) (c *Client) { return nil }
+
+	testCases := []struct {
+		name  string
+		query string
+	}{{
+		name:  "qtype",
+		query: "qtype:A",
+	}, {
+		name:  "client_cidr",
+		query: "client:192.168.0.0/16",
+	}, {
+		name:  "domain",
+		query: "domain:example.com",
+	}, {
+		name:  "upstream",
+		query: "upstream:*adguard*",
+	}, {
+		name:  "negation_and_qtype",
+		query: "-cached qtype:A",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, parseErr := parseSearchQuery(tc.query)
+			require.NoError(t, parseErr)
+
+			assert.False(t, m.quickMatch(line, findClient))
+		})
+	}
+}
+
+func TestParseSearchQuery_errors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		wantErrMsg string
+	}{{
+		name:       "bad_qtype",
+		query:      "qtype:BAD",
+		wantErrMsg: `qtype: bad value "BAD"`,
+	}, {
+		name:       "bad_rcode",
+		query:      "rcode:BAD",
+		wantErrMsg: `rcode: bad value "BAD"`,
+	}, {
+		name:       "bad_status",
+		query:      "status:bad",
+		wantErrMsg: `status: bad value "bad"`,
+	}, {
+		name:       "bad_proto",
+		query:      "proto:smtp",
+		wantErrMsg: `proto: invalid client proto: "smtp"`,
+	}, {
+		name:       "bad_elapsed",
+		query:      "elapsed>fast",
+		wantErrMsg: `elapsed: time: invalid duration "fast"`,
+	}, {
+		name:       "bad_operator",
+		query:      "qtype>A",
+		wantErrMsg: `qtype: unsupported operator ">"`,
+	}, {
+		name:       "empty_value",
+		query:      "client:",
+		wantErrMsg: "client: empty value",
+	}, {
+		name:       "unterminated_quote",
+		query:      `domain:"example.org`,
+		wantErrMsg: `unterminated quote in "domain:\"example.org"`,
+	}, {
+		name:       "missing_parenthesis",
+		query:      "(qtype:A",
+		wantErrMsg: "missing closing parenthesis",
+	}, {
+		name:       "extra_parenthesis",
+		query:      "qtype:A)",
+		wantErrMsg: `unexpected ")"`,
+	}, {
+		name:       "dangling_operator",
+		query:      "qtype:A OR",
+		wantErrMsg: "unexpected end of query",
+	}, {
+		name:       "leading_operator",
+		query:      "AND qtype:A",
+		wantErrMsg: `unexpected "AND"`,
+	}, {
+		name:       "too_deep",
+		query:      strings.Repeat("(", maxSearchQueryDepth+1) + "a",
+		wantErrMsg: "query is nested too deeply, max depth 32",
+	}, {
+		name:       "too_long",
+		query:      strings.Repeat("a", maxSearchQueryLen+1),
+		wantErrMsg: "query is too long: 1025 bytes, max 1024",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseSearchQuery(tc.query)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestMatchWildcardFold(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pattern string
+		s       string
+		want    bool
+	}{{
+		name:    "exact",
+		pattern: "example.org",
+		s:       "EXAMPLE.org",
+		want:    true,
+	}, {
+		name:    "prefix",
+		pattern: "*.org",
+		s:       "www.example.org",
+		want:    true,
+	}, {
+		name:    "infix",
+		pattern: "*quad9*",
+		s:       "tls://dns.quad9.net",
+		want:    true,
+	}, {
+		name:    "backtrack",
+		pattern: "*a*b",
+		s:       "aaab_ab",
+		want:    true,
+	}, {
+		name:    "mismatch",
+		pattern: "*.com",
+		s:       "example.org",
+		want:    false,
+	}, {
+		name:    "star_only",
+		pattern: "*",
+		s:       "",
+		want:    true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, matchWildcardFold(tc.pattern, tc.s))
+		})
+	}
+}
@@ -0,0 +1,550 @@
+package querylog
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// SinkType is the type of a remote collector of the query log entries.
+type SinkType string
+
+// Supported sink types.
+const (
+	// SinkTypeSyslog sends the entries as RFC 5424 syslog messages over UDP,
+	// TCP, or TLS.
+	SinkTypeSyslog SinkType = "syslog"
+
+	// SinkTypeHTTP sends the batches of entries as NDJSON in the bodies of
+	// HTTP POST requests.
+	SinkTypeHTTP SinkType = "http"
+
+	// SinkTypeElasticsearch sends the batches of entries to the _bulk API of
+	// Elasticsearch or a compatible server.
+	SinkTypeElasticsearch SinkType = "elasticsearch"
+)
+
+// Default values of the sink configuration.
+const (
+	defaultSinkBufferSize    = 10_000
+	defaultSinkBatchSize     = 500
+	defaultSinkFlushInterval = 1 * time.Second
+	defaultSinkTimeout       = 10 * time.Second
+	defaultSinkIndex         = "adguardhome"
+
+	// sinkMaxAttempts is the number of attempts to send a batch before it's
+	// dropped.
+	sinkMaxAttempts = 3
+
+	// sinkRetryDelay is the delay before the first retry of a batch, doubled
+	// with each attempt.
+	sinkRetryDelay = 500 * time.Millisecond
+)
+
+// SinkConfig is the configuration of a remote collector of the query log
+// entries.
+type SinkConfig struct {
+	// Headers are the additional headers of the HTTP requests, for example for
+	// authorization.  Only used by the HTTP and Elasticsearch sinks.
+	Headers map[string]string `yaml:"headers"`
+
+	// Name is the unique name of the sink used in logs and statistics.
+	Name string `yaml:"name"`
+
+	// Type is the type of the sink.
+	Type SinkType `yaml:"type"`
+
+	// Address is the address of the collector.  For the syslog sink, it's a
+	// URL with the udp, tcp, or tls scheme, for example "udp://10.0.0.1:514".
+	// For the HTTP sink, it's the URL the batches are posted to.  For the
+	// Elasticsearch sink, it's the base URL of the server.
+	Address string `yaml:"address"`
+
+	// Index is the name of the Elasticsearch index.  If empty,
+	// "adguardhome" is used.
+	Index string `yaml:"index"`
+
+	// BufferSize is the maximum number of entries waiting to be sent.  The
+	// new entries are dropped when the buffer is full.  If zero, 10000 is
+	// used.
+	BufferSize int `yaml:"buffer_size"`
+
+	// BatchSize is the maximum number of entries sent at once.  If zero, 500
+	// is used.
+	BatchSize int `yaml:"batch_size"`
+
+	// FlushInterval is the maximum time an entry waits to be sent.  If zero,
+	// one second is used.
+	FlushInterval timeutil.Duration `yaml:"flush_interval"`
+
+	// Timeout is the timeout of the network operations.  If zero, ten seconds
+	// are used.
+	Timeout timeutil.Duration `yaml:"timeout"`
+
+	// TLSInsecureSkipVerify disables the verification of the server's
+	// certificate chain and host name.
+	TLSInsecureSkipVerify bool `yaml:"tls_insecure_skip_verify"`
+}
+
+// validate returns an error if the configuration isn't valid.
+func (c *SinkConfig) validate() (err error) {
+	switch {
+	case c.Name == "":
+		return errors.Error("empty name")
+	case c.Address == "":
+		return errors.Error("empty address")
+	case c.BufferSize < 0:
+		return fmt.Errorf("buffer_size: negative value %d", c.BufferSize)
+	case c.BatchSize < 0:
+		return fmt.Errorf("batch_size: negative value %d", c.BatchSize)
+	case c.FlushInterval.Duration < 0:
+		return fmt.Errorf("flush_interval: negative value %s", c.FlushInterval)
+	case c.Timeout.Duration < 0:
+		return fmt.Errorf("timeout: negative value %s", c.Timeout)
+	default:
+		return nil
+	}
+}
+
+// sinkSender sends the batches of encoded entries to a remote collector.
+type sinkSender interface {
+	// encode encodes a single entry.  The returned slice must not be retained
+	// by the caller after the batch is sent.
+	encode(entry *logEntry, anonFunc aghnet.IPMutFunc) (b []byte, err error)
+
+	// send sends the batch of the encoded entries.
+	send(ctx context.Context, batch [][]byte) (err error)
+
+	// close releases the resources of the sender.
+	close() (err error)
+}
+
+// newSinkSender returns a new sender for c, which must be valid.
+func newSinkSender(c *SinkConfig, timeout time.Duration) (s sinkSender, err error) {
+	switch c.Type {
+	case SinkTypeSyslog:
+		return newSyslogSender(c, timeout)
+	case SinkTypeHTTP:
+		return newHTTPSender(c, timeout)
+	case SinkTypeElasticsearch:
+		return newElasticsearchSender(c, timeout)
+	default:
+		return nil, fmt.Errorf("type: unsupported value %q", c.Type)
+	}
+}
+
+// sink forwards the entries to a remote collector in the background.
+type sink struct {
+	sender sinkSender
+
+	// entries are the entries waiting to be sent.  It's never closed, so that
+	// add doesn't panic during the shutdown.
+	entries chan *logEntry
+
+	// stop is closed to stop the sending goroutine.
+	stop chan struct{}
+
+	// ctx is canceled to abort sending on shutdown.
+	ctx context.Context
+
+	// cancel cancels ctx.
+	cancel context.CancelFunc
+
+	// done is closed when the sending goroutine exits.
+	done chan struct{}
+
+	// closeOnce makes sure that close only stops the sink once.
+	closeOnce *sync.Once
+
+	// anonymizer masks the client IP addresses if needed.
+	anonymizer *aghnet.IPMut
+
+	// lastErrMu protects lastErr.
+	lastErrMu *sync.Mutex
+
+	// lastErr is the last error of sending, if any.
+	lastErr error
+
+	name string
+	typ  SinkType
+
+	batchSize     int
+	flushInterval time.Duration
+	timeout       time.Duration
+
+	// started is true if the sending goroutine has been started.
+	started atomic.Bool
+
+	// sent is the number of the entries sent.
+	sent atomic.Uint64
+
+	// dropped is the number of the entries dropped because the buffer was
+	// full.
+	dropped atomic.Uint64
+
+	// failed is the number of the entries dropped because they couldn't be
+	// sent.
+	failed atomic.Uint64
+}
+
+// newSink returns a new sink for c.
+func newSink(c *SinkConfig, anonymizer *aghnet.IPMut) (s *sink, err error) {
+	err = c.validate()
+	if err != nil {
+		// Don't wrap the error, because it's wrapped by the caller.
+		return nil, err
+	}
+
+	timeout := cmp.Or(c.Timeout.Duration, defaultSinkTimeout)
+	sender, err := newSinkSender(c, timeout)
+	if err != nil {
+		// Don't wrap the error, because it's wrapped by the caller.
+		return nil, err
+	}
+
+	batchSize := cmp.Or(c.BatchSize, defaultSinkBatchSize)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &sink{
+		ctx:           ctx,
+		cancel:        cancel,
+		sender:        sender,
+		entries:       make(chan *logEntry, cmp.Or(c.BufferSize, defaultSinkBufferSize)),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+		closeOnce:     &sync.Once{},
+		anonymizer:    anonymizer,
+		lastErrMu:     &sync.Mutex{},
+		name:          c.Name,
+		typ:           c.Type,
+		batchSize:     batchSize,
+		flushInterval: cmp.Or(c.FlushInterval.Duration, defaultSinkFlushInterval),
+		timeout:       timeout,
+	}, nil
+}
+
+// add queues e for sending.  It never blocks and drops e if the buffer is
+// full.
+func (s *sink) add(e *logEntry) {
+	select {
+	case s.entries <- e:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// start starts the sending goroutine.
+func (s *sink) start() {
+	s.started.Store(true)
+
+	go s.run()
+}
+
+// run sends the queued entries in batches until stop is closed and the
+// remaining entries are sent.  It's intended to be used as a goroutine.
+func (s *sink) run() {
+	defer log.OnPanic("querylog: sink " + s.name)
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*logEntry, 0, s.batchSize)
+	for {
+		select {
+		case e := <-s.entries:
+			batch = append(batch, e)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-s.stop:
+			s.drain(batch)
+
+			return
+		}
+
+		s.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// drain sends batch and the entries remaining in the buffer.
+func (s *sink) drain(batch []*logEntry) {
+	for {
+		select {
+		case e := <-s.entries:
+			batch = append(batch, e)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		default:
+			s.flush(batch)
+
+			return
+		}
+
+		s.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush encodes and sends the batch, retrying on errors.
+func (s *sink) flush(batch []*logEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	anonFunc := s.anonymizer.Load()
+	encoded := make([][]byte, 0, len(batch))
+	for _, e := range batch {
+		b, err := s.sender.encode(e, anonFunc)
+		if err != nil {
+			log.Debug("querylog: sink %s: encoding entry: %s", s.name, err)
+			s.failed.Add(1)
+
+			continue
+		}
+
+		encoded = append(encoded, b)
+	}
+
+	if len(encoded) == 0 {
+		return
+	}
+
+	err := s.sendWithRetries(encoded)
+
+	s.lastErrMu.Lock()
+	defer s.lastErrMu.Unlock()
+
+	s.lastErr = err
+
+	var partErr *partialSendError
+	if errors.As(err, &partErr) {
+		log.Error("querylog: sink %s: dropping %d entries: %s", s.name, partErr.failed, err)
+		s.failed.Add(uint64(partErr.failed))
+		s.sent.Add(uint64(len(encoded) - partErr.failed))
+
+		return
+	} else if err != nil {
+		log.Error("querylog: sink %s: dropping %d entries: %s", s.name, len(encoded), err)
+		s.failed.Add(uint64(len(encoded)))
+
+		return
+	}
+
+	s.sent.Add(uint64(len(encoded)))
+}
+
+// sendWithRetries sends the encoded batch, retrying with increasing delays on
+// errors.
+func (s *sink) sendWithRetries(encoded [][]byte) (err error) {
+	delay := sinkRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = s.send(encoded)
+		if err == nil || attempt == sinkMaxAttempts || errors.As(err, new(*partialSendError)) {
+			return err
+		}
+
+		log.Debug("querylog: sink %s: attempt %d: %s", s.name, attempt, err)
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-s.ctx.Done():
+			return err
+		}
+	}
+}
+
+// partialSendError is returned by the senders when only a part of the batch
+// has been rejected by the collector.  Such batches aren't retried, so that
+// the accepted entries aren't duplicated.
+type partialSendError struct {
+	// err is the underlying error.
+	err error
+
+	// failed is the number of the rejected entries.
+	failed int
+}
+
+// type check
+var _ error = (*partialSendError)(nil)
+
+// Error implements the error interface for *partialSendError.
+func (err *partialSendError) Error() (msg string) {
+	return fmt.Sprintf("%d entries rejected: %s", err.failed, err.err)
+}
+
+// Unwrap implements the [errors.Wrapper] interface for *partialSendError.
+func (err *partialSendError) Unwrap() (unwrapped error) {
+	return err.err
+}
+
+// send sends the encoded batch within the timeout.
+func (s *sink) send(encoded [][]byte) (err error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+
+	return s.sender.send(ctx, encoded)
+}
+
+// close stops the sending goroutine started with start, waits until the queued
+// entries are sent or the timeout expires, and closes the sender.  The entries
+// added after close are dropped.  Only the first call has any effect.
+func (s *sink) close(timeout time.Duration) (err error) {
+	s.closeOnce.Do(func() {
+		err = s.stopAndClose(timeout)
+	})
+
+	return err
+}
+
+// stopAndClose is the implementation of close.
+func (s *sink) stopAndClose(timeout time.Duration) (err error) {
+	defer s.cancel()
+
+	if s.started.Load() {
+		close(s.stop)
+
+		select {
+		case <-s.done:
+		case <-time.After(timeout):
+			err = errors.Error("timed out sending queued entries")
+
+			// Abort the sending and wait for the goroutine to exit, so that
+			// the sender isn't closed while it's used.
+			s.cancel()
+			<-s.done
+		}
+	}
+
+	return errors.WithDeferred(err, s.sender.close())
+}
+
+// SinkStatus is the status of a remote collector of the query log entries.
+type SinkStatus struct {
+	// Name is the name of the sink.
+	Name string `json:"name"`
+
+	// Type is the type of the sink.
+	Type SinkType `json:"type"`
+
+	// LastError is the last error of sending, if any.
+	LastError string `json:"last_error,omitempty"`
+
+	// Queued is the number of the entries waiting to be sent.
+	Queued int `json:"queued"`
+
+	// Sent is the number of the entries sent.
+	Sent uint64 `json:"sent"`
+
+	// Dropped is the number of the entries dropped because the buffer was
+	// full.
+	Dropped uint64 `json:"dropped"`
+
+	// Failed is the number of the entries dropped because they couldn't be
+	// sent.
+	Failed uint64 `json:"failed"`
+}
+
+// status returns the current status of s.
+func (s *sink) status() (st *SinkStatus) {
+	st = &SinkStatus{
+		Name:    s.name,
+		Type:    s.typ,
+		Queued:  len(s.entries),
+		Sent:    s.sent.Load(),
+		Dropped: s.dropped.Load(),
+		Failed:  s.failed.Load(),
+	}
+
+	s.lastErrMu.Lock()
+	defer s.lastErrMu.Unlock()
+
+	if s.lastErr != nil {
+		st.LastError = s.lastErr.Error()
+	}
+
+	return st
+}
+
+// sinkCloseTimeout is the maximum time to wait for the queued entries to be
+// sent on shutdown.
+const sinkCloseTimeout = 5 * time.Second
+
+// newSinks returns the sinks for the configurations.
+func newSinks(confs []*SinkConfig, anonymizer *aghnet.IPMut) (sinks []*sink, err error) {
+	names := map[string]struct{}{}
+	for i, c := range confs {
+		if c == nil {
+			return nil, fmt.Errorf("sink at index %d: no configuration", i)
+		}
+
+		if _, ok := names[c.Name]; ok {
+			return nil, fmt.Errorf("sink at index %d: duplicate name %q", i, c.Name)
+		}
+
+		names[c.Name] = struct{}{}
+
+		var s *sink
+		s, err = newSink(c, anonymizer)
+		if err != nil {
+			err = fmt.Errorf("sink at index %d: %w", i, err)
+			for _, created := range sinks {
+				created.cancel()
+				err = errors.WithDeferred(err, created.sender.close())
+			}
+
+			return nil, err
+		}
+
+		sinks = append(sinks, s)
+	}
+
+	return sinks, nil
+}
+
+// closeSinks closes all sinks.
+func closeSinks(sinks []*sink) (err error) {
+	var errs []error
+	for _, s := range sinks {
+		err = s.close(sinkCloseTimeout)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", s.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// handleSinks is the handler for the GET /control/querylog/sinks HTTP API.
+func (l *queryLog) handleSinks(w http.ResponseWriter, r *http.Request) {
+	resp := make([]*SinkStatus, 0, len(l.sinks))
+	for _, s := range l.sinks {
+		resp = append(resp, s.status())
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// marshalEntry returns the JSON representation of entry used by the sinks,
+// which is the same as in the GET /control/querylog HTTP API.
+func marshalEntry(entry *logEntry, anonFunc aghnet.IPMutFunc) (b []byte, err error) {
+	return json.Marshal(entryToJSON(entry, anonFunc))
+}
@@ -0,0 +1,361 @@
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// newTestSinkLog returns a started query log with a single sink with c and
+// registers its closing in the cleanup.
+func newTestSinkLog(t *testing.T, c *SinkConfig) (l *queryLog) {
+	t.Helper()
+
+	l, err := newQueryLog(Config{
+		Anonymizer:  aghnet.NewIPMut(nil),
+		Sinks:       []*SinkConfig{c},
+		Enabled:     true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     t.TempDir(),
+	})
+	require.NoError(t, err)
+	require.Len(t, l.sinks, 1)
+
+	l.sinks[0].start()
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return closeSinks(l.sinks)
+	})
+
+	return l
+}
+
+func TestSink_http(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+
+	reqCh := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(testutil.PanicT{}, err)
+
+		reqCh <- request{header: r.Header, body: body}
+	}))
+	t.Cleanup(srv.Close)
+
+	l := newTestSinkLog(t, &SinkConfig{
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Name:    "http",
+		Type:    SinkTypeHTTP,
+		Address: srv.URL + "/ingest",
+	})
+
+	addEntry(l, "first.example", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	addEntry(l, "second.example", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+
+	// Close the sink to flush the batch.
+	require.NoError(t, closeSinks(l.sinks))
+
+	req, _ := testutil.RequireReceive(t, reqCh, testTimeout)
+	assert.Equal(t, hdrValApplicationNDJSON, req.header.Get(httphdr.ContentType))
+	assert.Equal(t, "Bearer token", req.header.Get("Authorization"))
+
+	lines := strings.Split(strings.TrimSuffix(string(req.body), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	var hosts []string
+	for _, line := range lines {
+		var v map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &v))
+
+		q := testutil.RequireTypeAssert[map[string]any](t, v["question"])
+		hosts = append(hosts, q["name"].(string))
+	}
+
+	assert.Equal(t, []string{"first.example", "second.example"}, hosts)
+
+	st := l.sinks[0].status()
+	assert.Equal(t, uint64(2), st.Sent)
+	assert.Zero(t, st.Failed)
+	assert.Zero(t, st.Dropped)
+	assert.Empty(t, st.LastError)
+}
+
+func TestSink_elasticsearch(t *testing.T) {
+	const respBody = `{"errors":true,"items":[` +
+		`{"create":{"status":201}},` +
+		`{"create":{"status":400,"error":{"type":"mapper_parsing_exception"}}}` +
+		`]}`
+
+	bodyCh := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pt := testutil.PanicT{}
+		require.Equal(pt, "/_bulk", r.URL.Path)
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(pt, err)
+
+		bodyCh <- body
+
+		_, err = io.WriteString(w, respBody)
+		require.NoError(pt, err)
+	}))
+	t.Cleanup(srv.Close)
+
+	l := newTestSinkLog(t, &SinkConfig{
+		Name:    "es",
+		Type:    SinkTypeElasticsearch,
+		Address: srv.URL,
+		Index:   "dns",
+	})
+
+	addEntry(l, "first.example", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	addEntry(l, "second.example", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+
+	require.NoError(t, closeSinks(l.sinks))
+
+	body, _ := testutil.RequireReceive(t, bodyCh, testTimeout)
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	require.Len(t, lines, 4)
+
+	for i := 0; i < len(lines); i += 2 {
+		assert.JSONEq(t, `{"create":{"_index":"dns"}}`, lines[i])
+
+		var doc map[string]any
+		require.NoError(t, json.Unmarshal([]byte(lines[i+1]), &doc))
+		assert.Contains(t, doc, "@timestamp")
+	}
+
+	// The batch mustn't be retried, since one of the documents is indexed.
+	assert.Empty(t, bodyCh)
+
+	st := l.sinks[0].status()
+	assert.Equal(t, uint64(1), st.Sent)
+	assert.Equal(t, uint64(1), st.Failed)
+	assert.Contains(t, st.LastError, "mapper_parsing_exception")
+}
+
+func TestSink_syslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	l := newTestSinkLog(t, &SinkConfig{
+		Name:    "syslog",
+		Type:    SinkTypeSyslog,
+		Address: "udp://" + conn.LocalAddr().String(),
+	})
+
+	addEntry(l, "first.example", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	require.NoError(t, closeSinks(l.sinks))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+
+	buf := make([]byte, 64*1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	assertSyslogMsg(t, string(buf[:n]), "first.example")
+}
+
+func TestSink_syslogTCP(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, lsn.Close)
+
+	msgCh := make(chan []string, 1)
+	go func() {
+		pt := testutil.PanicT{}
+
+		conn, acceptErr := lsn.Accept()
+		require.NoError(pt, acceptErr)
+
+		defer func() { require.NoError(pt, conn.Close()) }()
+
+		r := bufio.NewReader(conn)
+
+		var msgs []string
+		for range 2 {
+			lenStr, readErr := r.ReadString(' ')
+			require.NoError(pt, readErr)
+
+			msgLen, convErr := strconv.Atoi(strings.TrimSuffix(lenStr, " "))
+			require.NoError(pt, convErr)
+
+			msg := make([]byte, msgLen)
+			_, readErr = io.ReadFull(r, msg)
+			require.NoError(pt, readErr)
+
+			msgs = append(msgs, string(msg))
+		}
+
+		msgCh <- msgs
+	}()
+
+	l := newTestSinkLog(t, &SinkConfig{
+		Name:    "syslog",
+		Type:    SinkTypeSyslog,
+		Address: "tcp://" + lsn.Addr().String(),
+	})
+
+	addEntry(l, "first.example", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	addEntry(l, "second.example", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+	require.NoError(t, closeSinks(l.sinks))
+
+	msgs, _ := testutil.RequireReceive(t, msgCh, testTimeout)
+	require.Len(t, msgs, 2)
+
+	assertSyslogMsg(t, msgs[0], "first.example")
+	assertSyslogMsg(t, msgs[1], "second.example")
+}
+
+// assertSyslogMsg checks that msg is an RFC 5424 message with the entry for
+// host.
+func assertSyslogMsg(t *testing.T, msg, host string) {
+	t.Helper()
+
+	// PRI VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+	fields := strings.SplitN(msg, " ", 8)
+	require.Len(t, fields, 8)
+
+	assert.Equal(t, "<134>1", fields[0])
+	assert.Equal(t, syslogAppName, fields[3])
+	assert.Equal(t, syslogMsgID, fields[5])
+	assert.Equal(t, "-", fields[6])
+
+	_, err := time.Parse(syslogTimeFormat, fields[1])
+	require.NoError(t, err)
+
+	var v map[string]any
+	require.NoError(t, json.Unmarshal([]byte(fields[7]), &v))
+
+	q := testutil.RequireTypeAssert[map[string]any](t, v["question"])
+	assert.Equal(t, host, q["name"])
+}
+
+func TestSink_add(t *testing.T) {
+	s, err := newSink(&SinkConfig{
+		Name:       "http",
+		Type:       SinkTypeHTTP,
+		Address:    "http://collector.example/",
+		BufferSize: 1,
+	}, aghnet.NewIPMut(nil))
+	require.NoError(t, err)
+
+	// Don't start the sink, so that the entries stay in the buffer.
+	for range 3 {
+		s.add(&logEntry{})
+	}
+
+	st := s.status()
+	assert.Equal(t, 1, st.Queued)
+	assert.Equal(t, uint64(2), st.Dropped)
+
+	require.NoError(t, s.close(sinkCloseTimeout))
+}
+
+func TestNewSinks(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*SinkConfig
+	}{{
+		name:       "nil",
+		wantErrMsg: "sink at index 0: no configuration",
+		confs:      []*SinkConfig{nil},
+	}, {
+		name:       "empty_name",
+		wantErrMsg: "sink at index 0: empty name",
+		confs: []*SinkConfig{{
+			Type:    SinkTypeHTTP,
+			Address: "http://collector.example/",
+		}},
+	}, {
+		name:       "bad_type",
+		wantErrMsg: `sink at index 0: type: unsupported value "kafka"`,
+		confs: []*SinkConfig{{
+			Name:    "kafka",
+			Type:    "kafka",
+			Address: "kafka.example:9092",
+		}},
+	}, {
+		name:       "bad_syslog_scheme",
+		wantErrMsg: `sink at index 0: address: unsupported scheme "http"`,
+		confs: []*SinkConfig{{
+			Name:    "syslog",
+			Type:    SinkTypeSyslog,
+			Address: "http://collector.example:514",
+		}},
+	}, {
+		name:       "no_syslog_port",
+		wantErrMsg: `sink at index 0: address: no port in "udp://collector.example"`,
+		confs: []*SinkConfig{{
+			Name:    "syslog",
+			Type:    SinkTypeSyslog,
+			Address: "udp://collector.example",
+		}},
+	}, {
+		name:       "negative_batch_size",
+		wantErrMsg: "sink at index 0: batch_size: negative value -1",
+		confs: []*SinkConfig{{
+			Name:      "http",
+			Type:      SinkTypeHTTP,
+			Address:   "http://collector.example/",
+			BatchSize: -1,
+		}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `sink at index 1: duplicate name "http"`,
+		confs: []*SinkConfig{{
+			Name:    "http",
+			Type:    SinkTypeHTTP,
+			Address: "http://collector.example/",
+		}, {
+			Name:    "http",
+			Type:    SinkTypeElasticsearch,
+			Address: "http://es.example:9200",
+		}},
+	}, {
+		name:       "success",
+		wantErrMsg: "",
+		confs: []*SinkConfig{{
+			Name:    "http",
+			Type:    SinkTypeHTTP,
+			Address: "http://collector.example/",
+		}, {
+			Name:    "syslog",
+			Type:    SinkTypeSyslog,
+			Address: "tls://collector.example:6514",
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sinks, err := newSinks(tc.confs, aghnet.NewIPMut(nil))
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if err == nil {
+				assert.Len(t, sinks, len(tc.confs))
+				assert.NoError(t, closeSinks(sinks))
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package querylog
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/ioutil"
+)
+
+// hdrValApplicationNDJSON is the MIME type of newline-delimited JSON.
+const hdrValApplicationNDJSON = "application/x-ndjson"
+
+// maxSinkRespSize is the maximum size of the response body read from the
+// collectors.
+const maxSinkRespSize = 1024 * 1024
+
+// httpPoster posts the request bodies to a URL.
+type httpPoster struct {
+	client  *http.Client
+	headers map[string]string
+	url     string
+}
+
+// newHTTPPoster returns a new poster to u for c.
+func newHTTPPoster(c *SinkConfig, u string, timeout time.Duration) (p *httpPoster, err error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("address: %w", err)
+	} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("address: unsupported scheme %q", parsed.Scheme)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		// #nosec G402 -- The verification is only disabled on an explicit
+		// request of the user.
+		InsecureSkipVerify: c.TLSInsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	return &httpPoster{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+		headers: c.Headers,
+		url:     u,
+	}, nil
+}
+
+// post posts body and returns the response body.  Responses with statuses
+// other than 2xx are returned as errors.
+func (p *httpPoster) post(ctx context.Context, body []byte) (resp []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(httphdr.ContentType, hdrValApplicationNDJSON)
+	req.Header.Set(httphdr.UserAgent, aghhttp.UserAgent())
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+
+	r, err := p.client.Do(req)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, r.Body.Close()) }()
+
+	resp, err = io.ReadAll(ioutil.LimitReader(r.Body, maxSinkRespSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	if r.StatusCode < http.StatusOK || r.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("unexpected status %s: %.256q", r.Status, resp)
+	}
+
+	return resp, nil
+}
+
+// close closes the idle connections.
+func (p *httpPoster) close() {
+	p.client.CloseIdleConnections()
+}
+
+// joinLines joins the lines of the batch into an NDJSON body.
+func joinLines(batch [][]byte) (body []byte) {
+	var n int
+	for _, line := range batch {
+		n += len(line) + 1
+	}
+
+	body = make([]byte, 0, n)
+	for _, line := range batch {
+		body = append(body, line...)
+		body = append(body, '\n')
+	}
+
+	return body
+}
+
+// httpSender posts the batches of entries as NDJSON.  The lines are of the
+// same form as the entries of the GET /control/querylog HTTP API.
+type httpSender struct {
+	poster *httpPoster
+}
+
+// type check
+var _ sinkSender = (*httpSender)(nil)
+
+// newHTTPSender returns a new HTTP sender for c.
+func newHTTPSender(c *SinkConfig, timeout time.Duration) (s *httpSender, err error) {
+	p, err := newHTTPPoster(c, c.Address, timeout)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return &httpSender{poster: p}, nil
+}
+
+// encode implements the [sinkSender] interface for *httpSender.
+func (s *httpSender) encode(entry *logEntry, anonFunc aghnet.IPMutFunc) (b []byte, err error) {
+	return marshalEntry(entry, anonFunc)
+}
+
+// send implements the [sinkSender] interface for *httpSender.
+func (s *httpSender) send(ctx context.Context, batch [][]byte) (err error) {
+	_, err = s.poster.post(ctx, joinLines(batch))
+
+	return err
+}
+
+// close implements the [sinkSender] interface for *httpSender.
+func (s *httpSender) close() (err error) {
+	s.poster.close()
+
+	return nil
+}
+
+// elasticsearchSender sends the batches of entries to the _bulk API of
+// Elasticsearch.  The documents are the entries of the GET /control/querylog
+// HTTP API with the additional "@timestamp" field.
+type elasticsearchSender struct {
+	poster *httpPoster
+
+	// action is the action line preceding each document.
+	action []byte
+}
+
+// type check
+var _ sinkSender = (*elasticsearchSender)(nil)
+
+// newElasticsearchSender returns a new Elasticsearch sender for c.
+func newElasticsearchSender(c *SinkConfig, timeout time.Duration) (s *elasticsearchSender, err error) {
+	u, err := url.JoinPath(c.Address, "_bulk")
+	if err != nil {
+		return nil, fmt.Errorf("address: %w", err)
+	}
+
+	p, err := newHTTPPoster(c, u, timeout)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	action, err := json.Marshal(map[string]any{
+		"create": map[string]string{
+			"_index": cmp.Or(c.Index, defaultSinkIndex),
+		},
+	})
+	if err != nil {
+		// Should never happen.
+		panic(err)
+	}
+
+	return &elasticsearchSender{
+		poster: p,
+		action: action,
+	}, nil
+}
+
+// encode implements the [sinkSender] interface for *elasticsearchSender.
+func (s *elasticsearchSender) encode(
+	entry *logEntry,
+	anonFunc aghnet.IPMutFunc,
+) (b []byte, err error) {
+	doc := entryToJSON(entry, anonFunc)
+	doc["@timestamp"] = entry.Time.UTC().Format(time.RFC3339Nano)
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	b = append(append(s.action[:len(s.action):len(s.action)], '\n'), b...)
+
+	return b, nil
+}
+
+// bulkResponse is the part of the response of the _bulk API used to find the
+// failed documents.
+type bulkResponse struct {
+	Items []map[string]struct {
+		Error json.RawMessage `json:"error"`
+	} `json:"items"`
+	Errors bool `json:"errors"`
+}
+
+// send implements the [sinkSender] interface for *elasticsearchSender.
+func (s *elasticsearchSender) send(ctx context.Context, batch [][]byte) (err error) {
+	body, err := s.poster.post(ctx, joinLines(batch))
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	resp := &bulkResponse{}
+	err = json.Unmarshal(body, resp)
+	if err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	} else if !resp.Errors {
+		return nil
+	}
+
+	// Don't retry the batch, since some of the documents have been indexed
+	// and the rest are likely to be rejected again.
+	var failed int
+	var first json.RawMessage
+	for _, item := range resp.Items {
+		for _, res := range item {
+			if len(res.Error) > 0 {
+				failed++
+				if first == nil {
+					first = res.Error
+				}
+			}
+		}
+	}
+
+	return &partialSendError{
+		err:    fmt.Errorf("first error: %.256s", first),
+		failed: failed,
+	}
+}
+
+// close implements the [sinkSender] interface for *elasticsearchSender.
+func (s *elasticsearchSender) close() (err error) {
+	s.poster.close()
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package querylog
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// syslogPriority is the priority of the syslog messages: the local0 facility
+// and the informational severity.
+const syslogPriority = 16*8 + 6
+
+// syslogAppName is the APP-NAME of the syslog messages.
+const syslogAppName = "AdGuardHome"
+
+// syslogMsgID is the MSGID of the syslog messages.
+const syslogMsgID = "query"
+
+// syslogTimeFormat is the format of the TIMESTAMP of the syslog messages.  See
+// RFC 5424, section 6.2.3.
+const syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// Network schemes of the syslog sink.
+const (
+	syslogSchemeUDP = "udp"
+	syslogSchemeTCP = "tcp"
+	syslogSchemeTLS = "tls"
+)
+
+// syslogSender sends the entries as RFC 5424 syslog messages with the JSON
+// representation of the entry as the message.  The messages are sent in
+// separate datagrams over UDP and with the octet-counting framing of RFC 5425
+// over TCP and TLS.
+type syslogSender struct {
+	// conn is the current connection, if any.  It's only used by the sending
+	// goroutine.
+	conn net.Conn
+
+	// tlsConf is the TLS configuration, if the sink uses TLS.
+	tlsConf *tls.Config
+
+	scheme string
+	addr   string
+
+	// header is the part of the syslog message after the timestamp and before
+	// the message itself.
+	header string
+}
+
+// type check
+var _ sinkSender = (*syslogSender)(nil)
+
+// newSyslogSender returns a new syslog sender for c.
+func newSyslogSender(c *SinkConfig, _ time.Duration) (s *syslogSender, err error) {
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("address: %w", err)
+	}
+
+	s = &syslogSender{
+		scheme: u.Scheme,
+		addr:   u.Host,
+	}
+
+	switch u.Scheme {
+	case syslogSchemeUDP, syslogSchemeTCP:
+		// Go on.
+	case syslogSchemeTLS:
+		s.tlsConf = &tls.Config{
+			ServerName: u.Hostname(),
+			// #nosec G402 -- The verification is only disabled on an explicit
+			// request of the user.
+			InsecureSkipVerify: c.TLSInsecureSkipVerify,
+			MinVersion:         tls.VersionTLS12,
+		}
+	default:
+		return nil, fmt.Errorf("address: unsupported scheme %q", u.Scheme)
+	}
+
+	if u.Port() == "" {
+		return nil, fmt.Errorf("address: no port in %q", c.Address)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	s.header = fmt.Sprintf(" %s %s %d %s - ", hostname, syslogAppName, os.Getpid(), syslogMsgID)
+
+	return s, nil
+}
+
+// encode implements the [sinkSender] interface for *syslogSender.
+func (s *syslogSender) encode(entry *logEntry, anonFunc aghnet.IPMutFunc) (b []byte, err error) {
+	msg, err := marshalEntry(entry, anonFunc)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	b = fmt.Appendf(nil, "<%d>1 %s%s", syslogPriority, entry.Time.UTC().Format(syslogTimeFormat), s.header)
+
+	return append(b, msg...), nil
+}
+
+// send implements the [sinkSender] interface for *syslogSender.
+func (s *syslogSender) send(ctx context.Context, batch [][]byte) (err error) {
+	if s.conn == nil {
+		s.conn, err = s.dial(ctx)
+		if err != nil {
+			return fmt.Errorf("connecting: %w", err)
+		}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err = s.conn.SetWriteDeadline(deadline)
+		if err != nil {
+			return s.reset(fmt.Errorf("setting deadline: %w", err))
+		}
+	}
+
+	if s.scheme == syslogSchemeUDP {
+		for _, msg := range batch {
+			_, err = s.conn.Write(msg)
+			if err != nil {
+				return s.reset(fmt.Errorf("writing: %w", err))
+			}
+		}
+
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	for _, msg := range batch {
+		buf.WriteString(strconv.Itoa(len(msg)))
+		buf.WriteByte(' ')
+		buf.Write(msg)
+	}
+
+	_, err = buf.WriteTo(s.conn)
+	if err != nil {
+		return s.reset(fmt.Errorf("writing: %w", err))
+	}
+
+	return nil
+}
+
+// dial connects to the collector.
+func (s *syslogSender) dial(ctx context.Context) (conn net.Conn, err error) {
+	if s.tlsConf != nil {
+		d := &tls.Dialer{Config: s.tlsConf}
+
+		return d.DialContext(ctx, "tcp", s.addr)
+	}
+
+	d := &net.Dialer{}
+
+	return d.DialContext(ctx, s.scheme, s.addr)
+}
+
+// reset closes the current connection, so that the next batch is sent over a
+// new one, and returns err.
+func (s *syslogSender) reset(err error) (res error) {
+	closeErr := s.conn.Close()
+	s.conn = nil
+
+	return errors.WithDeferred(err, closeErr)
+}
+
+// close implements the [sinkSender] interface for *syslogSender.
+func (s *syslogSender) close() (err error) {
+	if s.conn == nil {
+		return nil
+	}
+
+	return s.reset(nil)
+}
@@ -0,0 +1,259 @@
+package stats
+
+import (
+	"cmp"
+	"slices"
+)
+
+const (
+	// maxTrackedClients is the maximum number of clients with the per-client
+	// top domains tracked within a single unit.  The requests from the clients
+	// beyond the limit are only counted globally.
+	maxTrackedClients = 1000
+
+	// maxStoredClientTops is the maximum number of clients with the
+	// per-client top domains stored in the database for a single unit.  The
+	// clients with the most requests are kept.
+	maxStoredClientTops = maxClients
+
+	// clientTopsCapacity is the number of domains tracked for each client.
+	clientTopsCapacity = 32
+
+	// maxStoredClientDomains is the number of the per-client top domains
+	// stored in the database.
+	maxStoredClientDomains = 20
+)
+
+// heavyHitters counts the most frequent keys within a bounded memory using the
+// Space-Saving algorithm.  When it's full, a new key replaces the one with the
+// smallest count and inherits that count, so the counts may be overestimated
+// by at most the smallest count.
+type heavyHitters struct {
+	// counts are the counts of the tracked keys.
+	counts map[string]uint64
+
+	// capacity is the maximum number of tracked keys.
+	capacity int
+}
+
+// newHeavyHitters returns a new properly initialized *heavyHitters which
+// tracks at most capacity keys.
+func newHeavyHitters(capacity int) (h *heavyHitters) {
+	return &heavyHitters{
+		counts:   make(map[string]uint64, capacity),
+		capacity: capacity,
+	}
+}
+
+// add counts a single occurrence of key.
+func (h *heavyHitters) add(key string) {
+	if _, ok := h.counts[key]; ok || len(h.counts) < h.capacity {
+		h.counts[key]++
+
+		return
+	}
+
+	var minKey string
+	var minCount uint64
+	found := false
+	for k, c := range h.counts {
+		if !found || c < minCount || (c == minCount && k < minKey) {
+			minKey, minCount, found = k, c, true
+		}
+	}
+
+	delete(h.counts, minKey)
+	h.counts[key] = minCount + 1
+}
+
+// top returns at most limit keys with the highest counts sorted by count in
+// descending order and then by key.
+func (h *heavyHitters) top(limit int) (pairs []countPair) {
+	return topPairs(h.counts, limit)
+}
+
+// topPairs returns at most limit pairs with the highest counts from m sorted by
+// count in descending order and then by name.
+func topPairs(m map[string]uint64, limit int) (pairs []countPair) {
+	pairs = make([]countPair, 0, len(m))
+	for name, c := range m {
+		pairs = append(pairs, countPair{Name: name, Count: c})
+	}
+
+	slices.SortFunc(pairs, func(a, b countPair) (res int) {
+		return cmp.Or(a.compareCount(b), cmp.Compare(a.Name, b.Name))
+	})
+
+	return pairs[:min(limit, len(pairs))]
+}
+
+// clientTops are the per-client statistics within a unit.
+type clientTops struct {
+	// domains are the top domains requested by the client.
+	domains *heavyHitters
+
+	// blockedDomains are the top domains requested by the client that have
+	// been blocked.
+	blockedDomains *heavyHitters
+
+	// nTotal is the total number of requests from the client.
+	nTotal uint64
+
+	// nBlocked is the number of requests from the client that have been
+	// blocked or replaced.
+	nBlocked uint64
+}
+
+// newClientTops returns a new properly initialized *clientTops.
+func newClientTops() (ct *clientTops) {
+	return &clientTops{
+		domains:        newHeavyHitters(clientTopsCapacity),
+		blockedDomains: newHeavyHitters(clientTopsCapacity),
+	}
+}
+
+// add counts the request described by e.
+func (ct *clientTops) add(e *Entry) {
+	ct.nTotal++
+	if e.Result == RNotFiltered {
+		ct.domains.add(e.Domain)
+
+		return
+	}
+
+	ct.nBlocked++
+	ct.blockedDomains.add(e.Domain)
+}
+
+// clientTopsDB is the structure for serializing the per-client statistics into
+// the database.
+//
+// NOTE: Do not change the names or types of fields, as this structure is used
+// for GOB encoding.
+type clientTopsDB struct {
+	// Client is the client's ID.
+	Client string
+
+	// Domains is the number of requests for each of the top domains.
+	Domains []countPair
+
+	// BlockedDomains is the number of requests blocked for each of the top
+	// domains.
+	BlockedDomains []countPair
+
+	// NTotal is the total number of requests from the client.
+	NTotal uint64
+
+	// NBlocked is the number of the blocked or replaced requests from the
+	// client.
+	NBlocked uint64
+}
+
+// serializeClientTops converts m into the per-client statistics stored in the
+// database, keeping only the clients with the most requests.
+func serializeClientTops(m map[string]*clientTops) (cts []*clientTopsDB) {
+	cts = make([]*clientTopsDB, 0, min(len(m), maxStoredClientTops))
+	for c, ct := range m {
+		cts = append(cts, &clientTopsDB{
+			Client:         c,
+			Domains:        ct.domains.top(maxStoredClientDomains),
+			BlockedDomains: ct.blockedDomains.top(maxStoredClientDomains),
+			NTotal:         ct.nTotal,
+			NBlocked:       ct.nBlocked,
+		})
+	}
+
+	slices.SortFunc(cts, func(a, b *clientTopsDB) (res int) {
+		return cmp.Or(cmp.Compare(b.NTotal, a.NTotal), cmp.Compare(a.Client, b.Client))
+	})
+
+	return cts[:min(maxStoredClientTops, len(cts))]
+}
+
+// deserializeClientTops converts the per-client statistics stored in the
+// database into a map.
+func deserializeClientTops(cts []*clientTopsDB) (m map[string]*clientTops) {
+	m = make(map[string]*clientTops, len(cts))
+	for _, ctdb := range cts {
+		ct := newClientTops()
+		ct.nTotal, ct.nBlocked = ctdb.NTotal, ctdb.NBlocked
+		for _, cp := range ctdb.Domains {
+			ct.domains.counts[cp.Name] = cp.Count
+		}
+
+		for _, cp := range ctdb.BlockedDomains {
+			ct.blockedDomains.counts[cp.Name] = cp.Count
+		}
+
+		m[ctdb.Client] = ct
+	}
+
+	return m
+}
+
+// ClientStatsResp is the response to the GET /control/stats/clients/{id} HTTP
+// API.
+type ClientStatsResp struct {
+	// TopQueried are the top domains requested by the client.
+	TopQueried []topAddrs `json:"top_queried_domains"`
+
+	// TopBlocked are the top domains requested by the client that have been
+	// blocked.
+	TopBlocked []topAddrs `json:"top_blocked_domains"`
+
+	// NumDNSQueries is the number of requests from the client.
+	NumDNSQueries uint64 `json:"num_dns_queries"`
+
+	// NumBlocked is the number of requests from the client that have been
+	// blocked or replaced.
+	NumBlocked uint64 `json:"num_blocked"`
+}
+
+// clientStats returns the merged per-client statistics of the clients with the
+// specified ids within the retention interval.  s.confMu is expected to be
+// locked.
+func (s *StatsCtx) clientStats(ids []string) (resp *ClientStatsResp, ok bool) {
+	resp = &ClientStatsResp{
+		TopQueried: []topAddrs{},
+		TopBlocked: []topAddrs{},
+	}
+
+	limit := uint32(s.limit.Hours())
+	if !s.enabled || limit == 0 {
+		return resp, true
+	}
+
+	units, _ := s.loadUnits(limit)
+	if units == nil {
+		return nil, false
+	}
+
+	domains, blocked := map[string]uint64{}, map[string]uint64{}
+	for _, u := range units {
+		for _, ct := range u.ClientTops {
+			if !slices.Contains(ids, ct.Client) {
+				continue
+			}
+
+			resp.NumDNSQueries += ct.NTotal
+			resp.NumBlocked += ct.NBlocked
+			s.addClientDomains(domains, ct.Domains)
+			s.addClientDomains(blocked, ct.BlockedDomains)
+		}
+	}
+
+	resp.TopQueried = convertTopSlice(topPairs(domains, maxStoredClientDomains))
+	resp.TopBlocked = convertTopSlice(topPairs(blocked, maxStoredClientDomains))
+
+	return resp, true
+}
+
+// addClientDomains adds the counts of the domains which aren't ignored from
+// pairs to m.  s.confMu is expected to be locked.
+func (s *StatsCtx) addClientDomains(m map[string]uint64, pairs []countPair) {
+	for _, cp := range pairs {
+		if !s.ignored.Has(cp.Name) {
+			m[cp.Name] += cp.Count
+		}
+	}
+}
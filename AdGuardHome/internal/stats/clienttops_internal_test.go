@@ -0,0 +1,162 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeavyHitters(t *testing.T) {
+	h := newHeavyHitters(3)
+
+	for _, k := range []string{"a", "a", "a", "b", "b", "c"} {
+		h.add(k)
+	}
+
+	// "c" has the smallest count, so "d" replaces it and inherits its count.
+	h.add("d")
+	assert.Equal(t, []countPair{{"a", 3}, {"b", 2}, {"d", 2}}, h.top(10))
+
+	// Of the keys with the same smallest count, the first in order is
+	// replaced.  The frequent keys are never evicted.
+	for range 10 {
+		h.add("e")
+	}
+
+	assert.Equal(t, []countPair{{"e", 12}, {"a", 3}, {"d", 2}}, h.top(10))
+	assert.Equal(t, []countPair{{"e", 12}}, h.top(1))
+}
+
+func TestClientTops_serialize(t *testing.T) {
+	u := newUnit(0)
+	for i := range maxStoredClientTops + 10 {
+		client := "client-" + strconv.Itoa(i)
+		for range i + 1 {
+			u.add(&Entry{Client: client, Domain: "example.org", Result: RNotFiltered})
+		}
+
+		u.add(&Entry{Client: client, Domain: "blocked.example", Result: RFiltered})
+	}
+
+	udb := u.serialize()
+	require.Len(t, udb.ClientTops, maxStoredClientTops)
+
+	// The clients with the most requests are stored.
+	top := udb.ClientTops[0]
+	assert.Equal(t, "client-109", top.Client)
+	assert.Equal(t, uint64(111), top.NTotal)
+	assert.Equal(t, uint64(1), top.NBlocked)
+	assert.Equal(t, []countPair{{"example.org", 110}}, top.Domains)
+	assert.Equal(t, []countPair{{"blocked.example", 1}}, top.BlockedDomains)
+
+	got := newUnit(0)
+	got.deserialize(udb)
+	require.Len(t, got.clientTops, maxStoredClientTops)
+
+	assert.Equal(t, udb.ClientTops, got.serialize().ClientTops)
+}
+
+func TestStatsCtx_handleClientStats(t *testing.T) {
+	handlers := map[string]http.Handler{}
+	s, err := New(Config{
+		ShouldCountClient: func([]string) bool { return true },
+		ClientIDs: func(id string) (ids []string) {
+			if id == "Laptop" {
+				return []string{"192.0.2.1", "laptop"}
+			}
+
+			return nil
+		},
+		HTTPRegister: func(_, url string, handler http.HandlerFunc) {
+			handlers[url] = handler
+		},
+		UnitID:   func() (id uint32) { return 0 },
+		Filename: filepath.Join(t.TempDir(), "stats.db"),
+		Limit:    timeutil.Day,
+		Enabled:  true,
+	})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, s.Close)
+
+	s.initWeb()
+
+	for _, e := range []*Entry{{
+		Client: "192.0.2.1",
+		Domain: "example.org",
+		Result: RNotFiltered,
+	}, {
+		Client: "laptop",
+		Domain: "example.org",
+		Result: RNotFiltered,
+	}, {
+		Client: "laptop",
+		Domain: "ads.example",
+		Result: RFiltered,
+	}, {
+		Client: "192.0.2.2",
+		Domain: "example.com",
+		Result: RNotFiltered,
+	}} {
+		s.Update(e)
+	}
+
+	testCases := []struct {
+		want *ClientStatsResp
+		name string
+		id   string
+	}{{
+		want: &ClientStatsResp{
+			TopQueried:    []topAddrs{{"example.org": 2}},
+			TopBlocked:    []topAddrs{{"ads.example": 1}},
+			NumDNSQueries: 3,
+			NumBlocked:    1,
+		},
+		name: "persistent",
+		id:   "Laptop",
+	}, {
+		want: &ClientStatsResp{
+			TopQueried:    []topAddrs{{"example.com": 1}},
+			TopBlocked:    []topAddrs{},
+			NumDNSQueries: 1,
+			NumBlocked:    0,
+		},
+		name: "ip",
+		id:   "192.0.2.2",
+	}, {
+		want: &ClientStatsResp{
+			TopQueried:    []topAddrs{},
+			TopBlocked:    []topAddrs{},
+			NumDNSQueries: 0,
+			NumBlocked:    0,
+		},
+		name: "unknown",
+		id:   "192.0.2.3",
+	}}
+
+	h := handlers["/control/stats/clients/{id}"]
+	require.NotNil(t, h)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/control/stats/clients/"+tc.id, nil)
+			r.SetPathValue("id", tc.id)
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			got := &ClientStatsResp{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), got))
+
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// maxSeriesCodes is the max number of the most frequent query types and
+// response codes returned as time series.
+const maxSeriesCodes = 10
+
+// qtypeName returns the name of the query type, for example "AAAA" or
+// "TYPE65534" for the unknown ones.
+func qtypeName(qtype uint16) (name string) {
+	if name, ok := dns.TypeToString[qtype]; ok {
+		return name
+	}
+
+	return "TYPE" + strconv.FormatUint(uint64(qtype), 10)
+}
+
+// rcodeName returns the name of the response code, for example "NXDOMAIN" or
+// "RCODE3841" for the unknown ones.
+func rcodeName(rcode int) (name string) {
+	if name, ok := dns.RcodeToString[rcode]; ok {
+		return name
+	}
+
+	return "RCODE" + strconv.Itoa(rcode)
+}
+
+// sumPairs returns the sums of the counts retrieved from units using pg.
+func sumPairs(units []*unitDB, pg pairsGetter) (m map[string]uint64) {
+	m = map[string]uint64{}
+	for _, u := range units {
+		for _, cp := range pg(u) {
+			m[cp.Name] += cp.Count
+		}
+	}
+
+	return m
+}
+
+// codesSeries returns the time series of the counts retrieved from units using
+// pg for each of the names in top.  Each of the size elements of a series is
+// the sum of unitsPerElem consecutive units.
+func codesSeries(
+	units []*unitDB,
+	top []topAddrs,
+	pg pairsGetter,
+	size int,
+	unitsPerElem int,
+) (series map[string][]uint64) {
+	series = make(map[string][]uint64, min(len(top), maxSeriesCodes))
+	for _, t := range top[:min(len(top), maxSeriesCodes)] {
+		for name := range t {
+			series[name] = make([]uint64, size)
+		}
+	}
+
+	for i, u := range units {
+		for _, cp := range pg(u) {
+			if s, ok := series[cp.Name]; ok {
+				s[i/unitsPerElem] += cp.Count
+			}
+		}
+	}
+
+	return series
+}
+
+// qtypePairs is a [pairsGetter] of the query types.
+func qtypePairs(u *unitDB) (pairs []countPair) { return u.QTypes }
+
+// rcodePairs is a [pairsGetter] of the response codes.
+func rcodePairs(u *unitDB) (pairs []countPair) { return u.RCodes }
@@ -0,0 +1,222 @@
+package stats
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the buckets of the latency histograms
+// in microseconds.  The last bucket of a histogram has no upper bound and
+// counts the latencies above the last bound.
+//
+// NOTE: Do not change the bounds, as the histograms are stored in the
+// database by the index of the bucket.  Only add the new bounds to the end, if
+// necessary.
+var latencyBuckets = []uint64{
+	500,
+	1_000,
+	2_000,
+	3_000,
+	5_000,
+	7_500,
+	10_000,
+	15_000,
+	20_000,
+	30_000,
+	50_000,
+	75_000,
+	100_000,
+	150_000,
+	200_000,
+	300_000,
+	500_000,
+	750_000,
+	1_000_000,
+	1_500_000,
+	2_000_000,
+	3_000_000,
+	5_000_000,
+}
+
+// numLatencyBuckets is the number of the buckets in a latency histogram,
+// including the one without an upper bound.
+var numLatencyBuckets = len(latencyBuckets) + 1
+
+// latencyHistogram is the number of latencies in each of the fixed buckets
+// defined by latencyBuckets.
+type latencyHistogram []uint64
+
+// newLatencyHistogram returns a new empty histogram.
+func newLatencyHistogram() (h latencyHistogram) {
+	return make(latencyHistogram, numLatencyBuckets)
+}
+
+// add adds d to h.
+func (h latencyHistogram) add(d time.Duration) {
+	us := uint64(max(d.Microseconds(), 0))
+	i, _ := slices.BinarySearch(latencyBuckets, us)
+	h[i]++
+}
+
+// merge adds the counts from other to h.  other may be shorter or longer than
+// h, if it has been stored by another version; the extra buckets are counted
+// in the last one.
+func (h latencyHistogram) merge(other []uint64) {
+	last := len(h) - 1
+	for i, n := range other {
+		h[min(i, last)] += n
+	}
+}
+
+// total returns the number of latencies in h.
+func (h latencyHistogram) total() (n uint64) {
+	for _, c := range h {
+		n += c
+	}
+
+	return n
+}
+
+// quantile returns the estimated q-quantile of the latencies in h in
+// microseconds, interpolating linearly within the bucket.  The values in the
+// last bucket are reported as its lower bound.  q must be from 0 to 1.
+func (h latencyHistogram) quantile(q float64) (us float64) {
+	total := h.total()
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+
+	var seen uint64
+	for i, c := range h {
+		if c == 0 || float64(seen+c) < rank {
+			seen += c
+
+			continue
+		}
+
+		var lower float64
+		if i > 0 {
+			lower = float64(latencyBuckets[i-1])
+		}
+
+		if i == len(latencyBuckets) {
+			return lower
+		}
+
+		upper := float64(latencyBuckets[i])
+
+		return lower + (upper-lower)*(rank-float64(seen))/float64(c)
+	}
+
+	return float64(latencyBuckets[len(latencyBuckets)-1])
+}
+
+// histogramPair is a single name-histogram pair for serializing the latency
+// histograms into the database.
+//
+// NOTE: Do not change the names or types of fields, as this structure is used
+// for GOB encoding.
+type histogramPair struct {
+	Name   string
+	Counts []uint64
+}
+
+// convertHistogramsToSlice converts m to the pairs with at most limit of the
+// histograms with the most latencies sorted by the total in descending order and
+// then by name.
+func convertHistogramsToSlice(m map[string]latencyHistogram, limit int) (s []histogramPair) {
+	s = make([]histogramPair, 0, len(m))
+	for k, h := range m {
+		s = append(s, histogramPair{Name: k, Counts: slices.Clone(h)})
+	}
+
+	slices.SortFunc(s, func(a, b histogramPair) (res int) {
+		return cmp.Or(
+			cmp.Compare(latencyHistogram(b.Counts).total(), latencyHistogram(a.Counts).total()),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+
+	return s[:min(limit, len(s))]
+}
+
+// convertHistogramSliceToMap converts the pairs to the map of histograms.
+func convertHistogramSliceToMap(s []histogramPair) (m map[string]latencyHistogram) {
+	m = map[string]latencyHistogram{}
+	for _, p := range s {
+		h := newLatencyHistogram()
+		h.merge(p.Counts)
+		m[p.Name] = h
+	}
+
+	return m
+}
+
+// LatencyPercentiles are the percentiles of the latency of the requests in
+// seconds estimated from the histograms.
+type LatencyPercentiles struct {
+	// Count is the number of the requests.
+	Count uint64 `json:"count"`
+
+	// P50 is the median latency.
+	P50 float64 `json:"p50"`
+
+	// P95 is the 95th percentile of the latency.
+	P95 float64 `json:"p95"`
+
+	// P99 is the 99th percentile of the latency.
+	P99 float64 `json:"p99"`
+}
+
+// percentiles returns the percentiles of the latencies in h.
+func (h latencyHistogram) percentiles() (p *LatencyPercentiles) {
+	return &LatencyPercentiles{
+		Count: h.total(),
+		P50:   microsecondsToSeconds(math.Round(h.quantile(0.50))),
+		P95:   microsecondsToSeconds(math.Round(h.quantile(0.95))),
+		P99:   microsecondsToSeconds(math.Round(h.quantile(0.99))),
+	}
+}
+
+// UpstreamLatency are the latency percentiles of a single upstream.
+type UpstreamLatency struct {
+	LatencyPercentiles
+
+	// Upstream is the address of the upstream.
+	Upstream string `json:"upstream"`
+}
+
+// upstreamsLatency returns the latency percentiles of at most maxUpstreams of
+// the upstreams with the most responses, sorted by the number of responses, and
+// the ones of all upstreams.
+func upstreamsLatency(units []*unitDB) (ups []*UpstreamLatency, overall *LatencyPercentiles) {
+	hists := map[string]latencyHistogram{}
+	all := newLatencyHistogram()
+	for _, u := range units {
+		for _, p := range u.UpstreamsLatency {
+			h := hists[p.Name]
+			if h == nil {
+				h = newLatencyHistogram()
+				hists[p.Name] = h
+			}
+
+			h.merge(p.Counts)
+			all.merge(p.Counts)
+		}
+	}
+
+	pairs := convertHistogramsToSlice(hists, maxUpstreams)
+	ups = make([]*UpstreamLatency, 0, len(pairs))
+	for _, p := range pairs {
+		ups = append(ups, &UpstreamLatency{
+			LatencyPercentiles: *latencyHistogram(p.Counts).percentiles(),
+			Upstream:           p.Name,
+		})
+	}
+
+	return ups, all.percentiles()
+}
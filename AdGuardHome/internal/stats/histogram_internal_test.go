@@ -0,0 +1,141 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyHistogram_quantile(t *testing.T) {
+	testCases := []struct {
+		name      string
+		latencies []time.Duration
+		q         float64
+		want      float64
+	}{{
+		name:      "empty",
+		latencies: nil,
+		q:         0.5,
+		want:      0,
+	}, {
+		name:      "single_bucket",
+		latencies: []time.Duration{12 * time.Millisecond, 14 * time.Millisecond},
+		q:         0.5,
+		want:      12_500,
+	}, {
+		name: "tail",
+		latencies: append(
+			repeatLatency(99, 5*time.Millisecond),
+			800*time.Millisecond,
+		),
+		q:    0.99,
+		want: 5_000,
+	}, {
+		name: "tail_above",
+		latencies: append(
+			repeatLatency(98, 5*time.Millisecond),
+			800*time.Millisecond,
+			900*time.Millisecond,
+		),
+		q:    0.99,
+		want: 875_000,
+	}, {
+		name:      "overflow",
+		latencies: []time.Duration{10 * time.Second},
+		q:         0.5,
+		want:      5_000_000,
+	}, {
+		name:      "bound",
+		latencies: []time.Duration{time.Millisecond},
+		q:         1,
+		want:      1_000,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newLatencyHistogram()
+			for _, d := range tc.latencies {
+				h.add(d)
+			}
+
+			assert.InDelta(t, tc.want, h.quantile(tc.q), 1e-6)
+		})
+	}
+}
+
+// repeatLatency returns a slice of n latencies equal to d.
+func repeatLatency(n int, d time.Duration) (ds []time.Duration) {
+	for range n {
+		ds = append(ds, d)
+	}
+
+	return ds
+}
+
+func TestLatencyHistogram_merge(t *testing.T) {
+	h := newLatencyHistogram()
+
+	// The histograms of another length are accepted.
+	h.merge([]uint64{1, 2})
+	h.merge(make([]uint64, numLatencyBuckets+2))
+	h.merge(append(make([]uint64, numLatencyBuckets), 3))
+
+	assert.Equal(t, uint64(1), h[0])
+	assert.Equal(t, uint64(2), h[1])
+	assert.Equal(t, uint64(3), h[numLatencyBuckets-1])
+	assert.Equal(t, uint64(6), h.total())
+}
+
+func TestConvertHistogramsToSlice(t *testing.T) {
+	m := map[string]latencyHistogram{}
+	for name, n := range map[string]uint64{"b": 1, "a": 1, "d": 2, "c": 1} {
+		h := newLatencyHistogram()
+		h[0] = n
+		m[name] = h
+	}
+
+	got := convertHistogramsToSlice(m, 3)
+	require.Len(t, got, 3)
+
+	assert.Equal(t, "d", got[0].Name)
+	assert.Equal(t, "a", got[1].Name)
+	assert.Equal(t, "b", got[2].Name)
+}
+
+func TestUnit_latencyRoundTrip(t *testing.T) {
+	u := newUnit(0)
+	for _, d := range []time.Duration{10 * time.Millisecond, 40 * time.Millisecond} {
+		u.add(&Entry{
+			Domain:       "example.com",
+			Client:       "127.0.0.1",
+			Upstream:     "1.2.3.4",
+			Result:       RNotFiltered,
+			UpstreamTime: d,
+		})
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, gob.NewEncoder(buf).Encode(u.serialize()))
+
+	udb := &unitDB{}
+	require.NoError(t, gob.NewDecoder(buf).Decode(udb))
+
+	got := newUnit(0)
+	got.deserialize(udb)
+	assert.Equal(t, u.upstreamsLatency, got.upstreamsLatency)
+
+	ups, overall := upstreamsLatency([]*unitDB{udb, {
+		// A unit stored by a previous version.
+		UpstreamsResponses: []countPair{{"1.2.3.4", 5}},
+	}})
+	require.Len(t, ups, 1)
+
+	assert.Equal(t, "1.2.3.4", ups[0].Upstream)
+	assert.Equal(t, uint64(2), ups[0].Count)
+	assert.Equal(t, 0.01, ups[0].P50)
+	assert.Equal(t, overall, &ups[0].LatencyPercentiles)
+}
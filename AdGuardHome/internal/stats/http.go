@@ -3,13 +3,18 @@
 package stats
 
 import (
+	"cmp"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/timeutil"
 )
@@ -32,12 +37,33 @@ type StatsResp struct {
 	TopUpstreamsResponses []topAddrs      `json:"top_upstreams_responses"`
 	TopUpstreamsAvgTime   []topAddrsFloat `json:"top_upstreams_avg_time"`
 
+	// UpstreamsLatency are the latency percentiles of the upstreams with the
+	// most responses.
+	UpstreamsLatency []*UpstreamLatency `json:"upstreams_latency"`
+
+	// UpstreamsLatencyOverall are the latency percentiles of all upstreams.
+	UpstreamsLatencyOverall *LatencyPercentiles `json:"upstreams_latency_overall"`
+
 	DNSQueries []uint64 `json:"dns_queries"`
 
 	BlockedFiltering     []uint64 `json:"blocked_filtering"`
 	ReplacedSafebrowsing []uint64 `json:"replaced_safebrowsing"`
 	ReplacedParental     []uint64 `json:"replaced_parental"`
 
+	// QTypes are the numbers of requests for each query type.
+	QTypes []topAddrs `json:"query_types"`
+
+	// RCodes are the numbers of responses with each response code.
+	RCodes []topAddrs `json:"response_codes"`
+
+	// QTypesSeries are the numbers of requests per time unit for each of the
+	// most frequent query types.
+	QTypesSeries map[string][]uint64 `json:"query_types_series"`
+
+	// RCodesSeries are the numbers of responses per time unit for each of the
+	// most frequent response codes.
+	RCodesSeries map[string][]uint64 `json:"response_codes_series"`
+
 	NumDNSQueries           uint64 `json:"num_dns_queries"`
 	NumBlockedFiltering     uint64 `json:"num_blocked_filtering"`
 	NumReplacedSafebrowsing uint64 `json:"num_replaced_safebrowsing"`
@@ -220,6 +246,136 @@ func (s *StatsCtx) handleStatsReset(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleClientStats is the handler for the GET /control/stats/clients/{id} HTTP
+// API.  id is either the client's ID or the name of a persistent client.
+func (s *StatsCtx) handleClientStats(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "client id is empty")
+
+		return
+	}
+
+	ids := []string{id}
+	if s.clientIDs != nil {
+		if found := s.clientIDs(id); len(found) > 0 {
+			ids = found
+		}
+	}
+
+	var (
+		resp *ClientStatsResp
+		ok   bool
+	)
+	func() {
+		s.confMu.RLock()
+		defer s.confMu.RUnlock()
+
+		resp, ok = s.clientStats(ids)
+	}()
+
+	if !ok {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "couldn't get statistics data")
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// defaultReportLimit is the default number of the top groups in a report
+// requested via HTTP API.
+const defaultReportLimit = 10
+
+// reportParams are the parameters of the GET /control/stats/report HTTP API.
+type reportParams struct {
+	// start is the beginning of the time range.
+	start time.Time
+
+	// end is the end of the time range.
+	end time.Time
+
+	// group is the property to group the requests by.
+	group ReportGroup
+
+	// format is the format of the response.
+	format string
+
+	// limit is the number of the top groups.
+	limit uint
+}
+
+// parseReportParams parses the parameters of the report request from q.  The
+// time range defaults to the last day before now.
+func parseReportParams(q url.Values, now time.Time) (p *reportParams, err error) {
+	p = &reportParams{
+		end:    now,
+		group:  ReportGroup(q.Get("group_by")),
+		format: cmp.Or(q.Get("format"), ReportFormatJSON),
+		limit:  defaultReportLimit,
+	}
+
+	if v := q.Get("end"); v != "" {
+		p.end, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("end: %w", err)
+		}
+	}
+
+	p.start = p.end.Add(-timeutil.Day)
+	if v := q.Get("start"); v != "" {
+		p.start, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("start: %w", err)
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		var limit uint64
+		limit, err = strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("limit: %w", err)
+		}
+
+		p.limit = uint(limit)
+	}
+
+	if p.format != ReportFormatJSON && p.format != ReportFormatCSV {
+		return nil, fmt.Errorf("format: unsupported format %q", p.format)
+	}
+
+	return p, nil
+}
+
+// handleStatsReport is the handler for the GET /control/stats/report HTTP API.
+func (s *StatsCtx) handleStatsReport(w http.ResponseWriter, r *http.Request) {
+	p, err := parseReportParams(r.URL.Query(), time.Now())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "parsing params: %s", err)
+
+		return
+	}
+
+	report, err := s.Report(p.start, p.end, p.group, p.limit)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusUnprocessableEntity, "building report: %s", err)
+
+		return
+	}
+
+	if p.format == ReportFormatJSON {
+		aghhttp.WriteJSONResponseOK(w, r, report)
+
+		return
+	}
+
+	w.Header().Set(httphdr.ContentType, "text/csv")
+	err = writeReportsCSV(w, []*Report{report})
+	if err != nil {
+		log.Debug("stats: writing report: %s", err)
+	}
+}
+
 // initWeb registers the handlers for web endpoints of statistics module.
 func (s *StatsCtx) initWeb() {
 	if s.httpRegister == nil {
@@ -227,6 +383,8 @@ func (s *StatsCtx) initWeb() {
 	}
 
 	s.httpRegister(http.MethodGet, "/control/stats", s.handleStats)
+	s.httpRegister(http.MethodGet, "/control/stats/report", s.handleStatsReport)
+	s.httpRegister(http.MethodGet, "/control/stats/clients/{id}", s.handleClientStats)
 	s.httpRegister(http.MethodPost, "/control/stats_reset", s.handleStatsReset)
 	s.httpRegister(http.MethodGet, "/control/stats/config", s.handleGetStatsConfig)
 	s.httpRegister(http.MethodPut, "/control/stats/config/update", s.handlePutStatsConfig)
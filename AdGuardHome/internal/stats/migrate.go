@@ -0,0 +1,123 @@
+package stats
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// schemaVersion is the current version of the database schema.  The
+	// databases without the version are considered to be of version 1.
+	schemaVersion uint32 = 2
+
+	// unknownCodeName is the name of the counter of the requests with the
+	// unknown query type and response code stored by the previous versions.
+	unknownCodeName = "unknown"
+)
+
+var (
+	// schemaBucket is the name of the bucket with the metadata of the
+	// database.  It must not be mistaken for a unit.
+	schemaBucket = []byte("schema")
+
+	// schemaVersionKey is the key of the schema version in schemaBucket.
+	schemaVersionKey = []byte("version")
+)
+
+// loadSchemaVersion returns the schema version of the database.
+func loadSchemaVersion(tx *bbolt.Tx) (vers uint32) {
+	bkt := tx.Bucket(schemaBucket)
+	if bkt == nil {
+		return 1
+	}
+
+	v := bkt.Get(schemaVersionKey)
+	if len(v) != 4 {
+		return 1
+	}
+
+	return binary.BigEndian.Uint32(v)
+}
+
+// migrateDB migrates the database to the current schema version.  changed is
+// true if tx has been modified and should be committed.
+func migrateDB(tx *bbolt.Tx) (changed bool, err error) {
+	vers := loadSchemaVersion(tx)
+	if vers >= schemaVersion {
+		if vers > schemaVersion {
+			// The database is written by a newer version, which keeps the
+			// units compatible, so just leave it as is.
+			log.Info("stats: database schema version %d is newer than %d", vers, schemaVersion)
+		}
+
+		return false, nil
+	}
+
+	log.Info("stats: migrating database from schema version %d to %d", vers, schemaVersion)
+
+	err = migrateToV2(tx)
+	if err != nil {
+		return false, fmt.Errorf("migrating to version 2: %w", err)
+	}
+
+	bkt, err := tx.CreateBucketIfNotExists(schemaBucket)
+	if err != nil {
+		return false, fmt.Errorf("creating schema bucket: %w", err)
+	}
+
+	v := binary.BigEndian.AppendUint32(nil, schemaVersion)
+	err = bkt.Put(schemaVersionKey, v)
+	if err != nil {
+		return false, fmt.Errorf("putting schema version: %w", err)
+	}
+
+	return true, nil
+}
+
+// migrateToV2 adds the counters of the query types and response codes to the
+// units stored by the previous versions.  Since the actual values are unknown,
+// all requests of a unit are counted as [unknownCodeName], so that the counters
+// still sum up to the total number of requests.
+func migrateToV2(tx *bbolt.Tx) (err error) {
+	// Don't modify the buckets while iterating over them.
+	var ids []uint32
+	err = tx.ForEach(func(name []byte, _ *bbolt.Bucket) (_ error) {
+		if id, ok := unitNameToID(name); ok {
+			ids = append(ids, id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("listing units: %w", err)
+	}
+
+	var errs []error
+	migrated := 0
+	for _, id := range ids {
+		udb := loadUnitFromDB(tx, id)
+		if udb == nil || udb.QTypes != nil || udb.NTotal == 0 {
+			continue
+		}
+
+		udb.QTypes = []countPair{{Name: unknownCodeName, Count: udb.NTotal}}
+		udb.RCodes = []countPair{{Name: unknownCodeName, Count: udb.NTotal}}
+
+		err = udb.flushUnitToDB(tx, id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unit %d: %w", id, err))
+
+			continue
+		}
+
+		migrated++
+	}
+
+	log.Debug("stats: migrated %d units", migrated)
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,93 @@
+package stats
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestMigrateDB(t *testing.T) {
+	const (
+		oldID   uint32 = 100
+		emptyID uint32 = 101
+		curID   uint32 = 102
+	)
+
+	filename := filepath.Join(t.TempDir(), "stats.db")
+
+	db, err := bbolt.Open(filename, 0o644, nil)
+	require.NoError(t, err)
+
+	// Write the units as the previous versions do.
+	err = db.Update(func(tx *bbolt.Tx) (uErr error) {
+		uErr = (&unitDB{
+			NResult: []uint64{0, 2, 1, 0, 0, 0},
+			Domains: []countPair{{Name: "example.org", Count: 2}},
+			NTotal:  3,
+		}).flushUnitToDB(tx, oldID)
+		if uErr != nil {
+			return uErr
+		}
+
+		return (&unitDB{NResult: make([]uint64, resultLast)}).flushUnitToDB(tx, emptyID)
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	s, err := New(Config{
+		ShouldCountClient: func([]string) bool { return true },
+		UnitID:            func() (id uint32) { return curID },
+		Filename:          filename,
+		Limit:             timeutil.Day,
+		Enabled:           true,
+	})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, s.Close)
+
+	db = s.db.Load()
+	require.NotNil(t, db)
+
+	err = db.View(func(tx *bbolt.Tx) (_ error) {
+		assert.Equal(t, schemaVersion, loadSchemaVersion(tx))
+
+		udb := loadUnitFromDB(tx, oldID)
+		require.NotNil(t, udb)
+
+		want := []countPair{{Name: unknownCodeName, Count: 3}}
+		assert.Equal(t, want, udb.QTypes)
+		assert.Equal(t, want, udb.RCodes)
+		assert.Equal(t, []countPair{{Name: "example.org", Count: 2}}, udb.Domains)
+
+		udb = loadUnitFromDB(tx, emptyID)
+		require.NotNil(t, udb)
+
+		assert.Nil(t, udb.QTypes)
+		assert.Nil(t, udb.RCodes)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The migrated database isn't migrated again.
+	err = db.Update(func(tx *bbolt.Tx) (uErr error) {
+		changed, uErr := migrateDB(tx)
+		assert.False(t, changed)
+
+		return uErr
+	})
+	require.NoError(t, err)
+
+	// The schema bucket isn't mistaken for an old unit.
+	err = db.Update(func(tx *bbolt.Tx) (_ error) {
+		deleteOldUnits(tx, curID)
+		assert.NotNil(t, tx.Bucket(schemaBucket))
+
+		return nil
+	})
+	require.NoError(t, err)
+}
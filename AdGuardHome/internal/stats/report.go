@@ -0,0 +1,296 @@
+package stats
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// ReportGroup is the property by which the requests are grouped in a report.
+type ReportGroup string
+
+// Supported ReportGroup values.
+const (
+	ReportGroupClient   ReportGroup = "client"
+	ReportGroupDomain   ReportGroup = "domain"
+	ReportGroupQType    ReportGroup = "qtype"
+	ReportGroupRCode    ReportGroup = "rcode"
+	ReportGroupReason   ReportGroup = "reason"
+	ReportGroupUpstream ReportGroup = "upstream"
+)
+
+// Validate returns an error if g is not a supported report group.
+func (g ReportGroup) Validate() (err error) {
+	switch g {
+	case
+		ReportGroupClient,
+		ReportGroupDomain,
+		ReportGroupQType,
+		ReportGroupRCode,
+		ReportGroupReason,
+		ReportGroupUpstream:
+		return nil
+	default:
+		return fmt.Errorf("unsupported group %q", g)
+	}
+}
+
+// Supported report formats.
+const (
+	ReportFormatCSV  = "csv"
+	ReportFormatJSON = "json"
+)
+
+const (
+	// maxReportDuration is the maximum duration of the time range of a report.
+	maxReportDuration = 365 * timeutil.Day
+
+	// maxReportLimit is the maximum number of the top entries in a report.
+	maxReportLimit = maxDomains
+)
+
+// resultNames are the names of the results used in the reports grouped by
+// [ReportGroupReason].
+var resultNames = [resultLast]string{
+	RNotFiltered:  "not_filtered",
+	RFiltered:     "filtered",
+	RSafeBrowsing: "safe_browsing",
+	RSafeSearch:   "safe_search",
+	RParental:     "parental",
+}
+
+// ReportItem is a single group of requests in a report.
+type ReportItem struct {
+	// Name is the value of the grouped property, for example the domain name.
+	Name string `json:"name"`
+
+	// Count is the number of requests in the group.
+	Count uint64 `json:"count"`
+}
+
+// Report is the top groups of requests within a time range.
+type Report struct {
+	// Start is the beginning of the time range, rounded down to the hour.
+	Start time.Time `json:"start"`
+
+	// End is the end of the time range, rounded up to the hour.
+	End time.Time `json:"end"`
+
+	// GroupBy is the property by which the requests are grouped.
+	GroupBy ReportGroup `json:"group_by"`
+
+	// Top are the groups with the most requests sorted by the number of
+	// requests in descending order.
+	Top []*ReportItem `json:"top"`
+
+	// Total is the number of requests in all groups, including the ones not
+	// in Top.  Since each unit only keeps its top domains and clients, it may
+	// be less than NumDNSQueries.
+	Total uint64 `json:"total"`
+
+	// NumDNSQueries is the total number of requests within the time range.
+	NumDNSQueries uint64 `json:"num_dns_queries"`
+
+	// NumBlocked is the total number of requests blocked or replaced within
+	// the time range.
+	NumBlocked uint64 `json:"num_blocked"`
+}
+
+// unitIDTime returns the start time of the unit with the specified id.  id
+// must be generated by [newUnitID].
+func unitIDTime(id uint32) (t time.Time) {
+	return time.Unix(int64(id)*int64(time.Hour/time.Second), 0)
+}
+
+// timeUnitID returns the id of the unit containing t as generated by
+// [newUnitID].
+func timeUnitID(t time.Time) (id uint32) {
+	return uint32(t.Unix() / int64(time.Hour/time.Second))
+}
+
+// Report returns the report of the requests within the time range from start
+// to end, rounded to the whole hours, grouped by group, with at most limit top
+// groups.
+func (s *StatsCtx) Report(start, end time.Time, group ReportGroup, limit uint) (r *Report, err error) {
+	err = group.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("group_by: %w", err)
+	}
+
+	switch {
+	case !end.After(start):
+		return nil, fmt.Errorf("end %s is not after start %s", end, start)
+	case end.Sub(start) > maxReportDuration:
+		return nil, fmt.Errorf("time range is longer than %s", maxReportDuration)
+	case limit == 0 || limit > maxReportLimit:
+		return nil, fmt.Errorf("limit: out of range [1, %d]: %d", maxReportLimit, limit)
+	}
+
+	firstID, lastID := timeUnitID(start), timeUnitID(end.Add(-time.Nanosecond))
+	units, err := s.loadUnitsRange(firstID, lastID)
+	if err != nil {
+		return nil, fmt.Errorf("loading units: %w", err)
+	}
+
+	s.confMu.RLock()
+	defer s.confMu.RUnlock()
+
+	r = &Report{
+		Start:   unitIDTime(firstID),
+		End:     unitIDTime(lastID + 1),
+		GroupBy: group,
+	}
+
+	counts := map[string]uint64{}
+	for _, u := range units {
+		r.NumDNSQueries += u.NTotal
+		for res := RFiltered; res < resultLast && int(res) < len(u.NResult); res++ {
+			r.NumBlocked += u.NResult[res]
+		}
+
+		for _, cp := range s.reportPairs(u, group) {
+			counts[cp.Name] += cp.Count
+		}
+	}
+
+	for _, c := range counts {
+		r.Total += c
+	}
+
+	r.Top = topReportItems(counts, int(limit))
+
+	return r, nil
+}
+
+// reportPairs returns the counts of the requests in u grouped by group.
+// s.confMu is expected to be locked.
+func (s *StatsCtx) reportPairs(u *unitDB, group ReportGroup) (pairs []countPair) {
+	switch group {
+	case ReportGroupClient:
+		return topClientPairs(s)(u)
+	case ReportGroupDomain:
+		for _, domains := range [][]countPair{u.Domains, u.BlockedDomains} {
+			for _, cp := range domains {
+				if !s.ignored.Has(cp.Name) {
+					pairs = append(pairs, cp)
+				}
+			}
+		}
+
+		return pairs
+	case ReportGroupQType:
+		return u.QTypes
+	case ReportGroupRCode:
+		return u.RCodes
+	case ReportGroupReason:
+		for res, n := range u.NResult {
+			if n != 0 && res > 0 && res < int(resultLast) {
+				pairs = append(pairs, countPair{Name: resultNames[res], Count: n})
+			}
+		}
+
+		return pairs
+	case ReportGroupUpstream:
+		return u.UpstreamsResponses
+	default:
+		// Shouldn't happen, since the group is validated.
+		panic(fmt.Errorf("unsupported report group %q", group))
+	}
+}
+
+// topReportItems returns at most limit items with the highest counts from m
+// sorted by count in descending order and then by name.
+func topReportItems(m map[string]uint64, limit int) (items []*ReportItem) {
+	items = make([]*ReportItem, 0, len(m))
+	for name, c := range m {
+		items = append(items, &ReportItem{Name: name, Count: c})
+	}
+
+	slices.SortFunc(items, func(a, b *ReportItem) (res int) {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Name, b.Name))
+	})
+
+	return items[:min(limit, len(items))]
+}
+
+// loadUnitsRange returns the units with ids from firstID to lastID, inclusive,
+// including the current one.  The missing units are skipped.
+func (s *StatsCtx) loadUnitsRange(firstID, lastID uint32) (units []*unitDB, err error) {
+	db := s.db.Load()
+	if db == nil {
+		return nil, errors.Error("database is closed")
+	}
+
+	// Use writable transaction to ensure any ongoing writable transaction is
+	// taken into account.
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, fmt.Errorf("opening transaction: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, finishTxn(tx, false)) }()
+
+	s.currMu.RLock()
+	defer s.currMu.RUnlock()
+
+	cur := s.curr
+	for id := firstID; id <= lastID; id++ {
+		var u *unitDB
+		if cur != nil && cur.id == id {
+			u = cur.serialize()
+		} else {
+			u = loadUnitFromDB(tx, id)
+		}
+
+		if u != nil {
+			units = append(units, u)
+		}
+	}
+
+	return units, nil
+}
+
+// writeReportsJSON writes reports to w as a JSON object.
+func writeReportsJSON(w io.Writer, start, end time.Time, reports []*Report) (err error) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(struct {
+		Start   time.Time `json:"start"`
+		End     time.Time `json:"end"`
+		Reports []*Report `json:"reports"`
+	}{
+		Start:   start,
+		End:     end,
+		Reports: reports,
+	})
+}
+
+// writeReportsCSV writes the top groups of reports to w as CSV with a header.
+func writeReportsCSV(w io.Writer, reports []*Report) (err error) {
+	cw := csv.NewWriter(w)
+	err = cw.Write([]string{"group_by", "name", "count"})
+	if err != nil {
+		return fmt.Errorf("writing header: %w", err)
+	}
+
+	for _, r := range reports {
+		for _, it := range r.Top {
+			err = cw.Write([]string{string(r.GroupBy), it.Name, strconv.FormatUint(it.Count, 10)})
+			if err != nil {
+				return fmt.Errorf("writing record: %w", err)
+			}
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
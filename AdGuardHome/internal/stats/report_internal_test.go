@@ -0,0 +1,429 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testReportStart is the start of the first unit of the report tests, which is
+// on Monday.
+var testReportStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestReportStats returns the statistics with the units for the report
+// tests:
+//
+//   - 2024-01-01 00:00, flushed:  three requests from two clients, one of them
+//     blocked;
+//   - 2024-01-01 01:00, flushed:  one request;
+//   - 2024-01-02 01:00, current:  one request replaced by the parental control.
+func newTestReportStats(t *testing.T, reports *ReportsConfig) (s *StatsCtx) {
+	t.Helper()
+
+	firstID := timeUnitID(testReportStart)
+
+	var curID atomic.Uint32
+	curID.Store(firstID)
+
+	s, err := New(Config{
+		ShouldCountClient: func([]string) bool { return true },
+		UnitID:            curID.Load,
+		Filename:          filepath.Join(t.TempDir(), "stats.db"),
+		Limit:             30 * timeutil.Day,
+		Enabled:           true,
+		Reports:           reports,
+	})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, s.Close)
+
+	units := []struct {
+		entries []*Entry
+		id      uint32
+	}{{
+		entries: []*Entry{{
+			Client:   "192.0.2.1",
+			Domain:   "example.org",
+			Upstream: "tls://upstream-1",
+			Result:   RNotFiltered,
+			QType:    dns.TypeA,
+			RCode:    dns.RcodeSuccess,
+		}, {
+			Client: "192.0.2.1",
+			Domain: "blocked.example",
+			Result: RFiltered,
+			QType:  dns.TypeA,
+			RCode:  dns.RcodeSuccess,
+		}, {
+			Client:   "192.0.2.2",
+			Domain:   "example.org",
+			Upstream: "tls://upstream-1",
+			Result:   RNotFiltered,
+			QType:    dns.TypeAAAA,
+			RCode:    dns.RcodeSuccess,
+		}},
+		id: firstID,
+	}, {
+		entries: []*Entry{{
+			Client:   "192.0.2.2",
+			Domain:   "example.com",
+			Upstream: "tls://upstream-2",
+			Result:   RNotFiltered,
+			QType:    dns.TypeHTTPS,
+			RCode:    dns.RcodeNameError,
+		}},
+		id: firstID + 1,
+	}, {
+		entries: []*Entry{{
+			Client: "192.0.2.3",
+			Domain: "example.net",
+			Result: RParental,
+		}},
+		id: firstID + 25,
+	}}
+
+	for _, u := range units {
+		curID.Store(u.id)
+		cont, _ := s.flush()
+		require.True(t, cont)
+
+		for _, e := range u.entries {
+			s.Update(e)
+		}
+	}
+
+	return s
+}
+
+func TestStatsCtx_Report(t *testing.T) {
+	s := newTestReportStats(t, nil)
+
+	firstDay := testReportStart.Add(2 * time.Hour)
+
+	testCases := []struct {
+		end         time.Time
+		start       time.Time
+		name        string
+		group       ReportGroup
+		wantTop     []*ReportItem
+		limit       uint
+		wantTotal   uint64
+		wantQueries uint64
+		wantBlocked uint64
+	}{{
+		end:   firstDay,
+		start: testReportStart,
+		name:  "domain",
+		group: ReportGroupDomain,
+		wantTop: []*ReportItem{
+			{Name: "example.org", Count: 2},
+			{Name: "blocked.example", Count: 1},
+			{Name: "example.com", Count: 1},
+		},
+		limit:       10,
+		wantTotal:   4,
+		wantQueries: 4,
+		wantBlocked: 1,
+	}, {
+		end:   firstDay,
+		start: testReportStart,
+		name:  "domain_limit",
+		group: ReportGroupDomain,
+		wantTop: []*ReportItem{
+			{Name: "example.org", Count: 2},
+		},
+		limit:       1,
+		wantTotal:   4,
+		wantQueries: 4,
+		wantBlocked: 1,
+	}, {
+		end:   firstDay,
+		start: testReportStart,
+		name:  "client",
+		group: ReportGroupClient,
+		wantTop: []*ReportItem{
+			{Name: "192.0.2.1", Count: 2},
+			{Name: "192.0.2.2", Count: 2},
+		},
+		limit:       10,
+		wantTotal:   4,
+		wantQueries: 4,
+		wantBlocked: 1,
+	}, {
+		end:   firstDay,
+		start: testReportStart,
+		name:  "qtype",
+		group: ReportGroupQType,
+		wantTop: []*ReportItem{
+			{Name: "A", Count: 2},
+			{Name: "AAAA", Count: 1},
+			{Name: "HTTPS", Count: 1},
+		},
+		limit:       10,
+		wantTotal:   4,
+		wantQueries: 4,
+		wantBlocked: 1,
+	}, {
+		end:   firstDay,
+		start: testReportStart,
+		name:  "rcode",
+		group: ReportGroupRCode,
+		wantTop: []*ReportItem{
+			{Name: "NOERROR", Count: 3},
+			{Name: "NXDOMAIN", Count: 1},
+		},
+		limit:       10,
+		wantTotal:   4,
+		wantQueries: 4,
+		wantBlocked: 1,
+	}, {
+		end:   firstDay,
+		start: testReportStart,
+		name:  "reason",
+		group: ReportGroupReason,
+		wantTop: []*ReportItem{
+			{Name: "not_filtered", Count: 3},
+			{Name: "filtered", Count: 1},
+		},
+		limit:       10,
+		wantTotal:   4,
+		wantQueries: 4,
+		wantBlocked: 1,
+	}, {
+		end:   firstDay,
+		start: testReportStart,
+		name:  "upstream",
+		group: ReportGroupUpstream,
+		wantTop: []*ReportItem{
+			{Name: "tls://upstream-1", Count: 2},
+			{Name: "tls://upstream-2", Count: 1},
+		},
+		limit:       10,
+		wantTotal:   3,
+		wantQueries: 4,
+		wantBlocked: 1,
+	}, {
+		// The range is rounded to the whole hours and includes the current
+		// unit.
+		end:   testReportStart.Add(25*time.Hour + time.Minute),
+		start: testReportStart.Add(time.Hour + 30*time.Minute),
+		name:  "rounded_with_current",
+		group: ReportGroupDomain,
+		wantTop: []*ReportItem{
+			{Name: "example.com", Count: 1},
+			{Name: "example.net", Count: 1},
+		},
+		limit:       10,
+		wantTotal:   2,
+		wantQueries: 2,
+		wantBlocked: 1,
+	}, {
+		end:         testReportStart,
+		start:       testReportStart.Add(-timeutil.Day),
+		name:        "empty",
+		group:       ReportGroupDomain,
+		wantTop:     []*ReportItem{},
+		limit:       10,
+		wantTotal:   0,
+		wantQueries: 0,
+		wantBlocked: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := s.Report(tc.start, tc.end, tc.group, tc.limit)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantTop, r.Top)
+			assert.Equal(t, tc.wantTotal, r.Total)
+			assert.Equal(t, tc.wantQueries, r.NumDNSQueries)
+			assert.Equal(t, tc.wantBlocked, r.NumBlocked)
+			assert.Equal(t, tc.group, r.GroupBy)
+		})
+	}
+}
+
+func TestStatsCtx_Report_errors(t *testing.T) {
+	s := newTestReportStats(t, nil)
+
+	end := testReportStart.Add(timeutil.Day)
+
+	testCases := []struct {
+		end        time.Time
+		start      time.Time
+		name       string
+		group      ReportGroup
+		wantErrMsg string
+		limit      uint
+	}{{
+		end:        end,
+		start:      testReportStart,
+		name:       "unknown_group",
+		group:      "bad",
+		wantErrMsg: `group_by: unsupported group "bad"`,
+		limit:      10,
+	}, {
+		end:   testReportStart,
+		start: end,
+		name:  "reversed",
+		group: ReportGroupDomain,
+		wantErrMsg: "end 2024-01-01 00:00:00 +0000 UTC is not after " +
+			"start 2024-01-02 00:00:00 +0000 UTC",
+		limit: 10,
+	}, {
+		end:        end,
+		start:      end.Add(-2 * maxReportDuration),
+		name:       "too_long",
+		group:      ReportGroupDomain,
+		wantErrMsg: "time range is longer than 8760h0m0s",
+		limit:      10,
+	}, {
+		end:        end,
+		start:      testReportStart,
+		name:       "zero_limit",
+		group:      ReportGroupDomain,
+		wantErrMsg: "limit: out of range [1, 100]: 0",
+		limit:      0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.Report(tc.start, tc.end, tc.group, tc.limit)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestStatsCtx_writeDueReports(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestReportStats(t, &ReportsConfig{
+		Dir:     dir,
+		Formats: []string{ReportFormatJSON, ReportFormatCSV},
+		GroupBy: []ReportGroup{ReportGroupDomain, ReportGroupReason},
+		Limit:   10,
+		Enabled: true,
+		Daily:   true,
+		Weekly:  true,
+	})
+
+	// Tuesday.
+	now := testReportStart.Add(timeutil.Day + 10*time.Hour)
+	s.writeDueReports(now)
+
+	data, err := os.ReadFile(filepath.Join(dir, "daily-2024-01-01.csv"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "group_by,name,count\n"+
+		"domain,example.org,2\n"+
+		"domain,blocked.example,1\n"+
+		"domain,example.com,1\n"+
+		"reason,not_filtered,3\n"+
+		"reason,filtered,1\n", string(data))
+
+	data, err = os.ReadFile(filepath.Join(dir, "daily-2024-01-01.json"))
+	require.NoError(t, err)
+
+	var daily struct {
+		Start   time.Time `json:"start"`
+		End     time.Time `json:"end"`
+		Reports []*Report `json:"reports"`
+	}
+	require.NoError(t, json.Unmarshal(data, &daily))
+
+	assert.True(t, daily.Start.Equal(testReportStart))
+	assert.True(t, daily.End.Equal(testReportStart.Add(timeutil.Day)))
+	require.Len(t, daily.Reports, 2)
+	assert.Equal(t, uint64(4), daily.Reports[0].NumDNSQueries)
+
+	// The previous week has no data.
+	weeklyPath := filepath.Join(dir, "weekly-2023-12-25.json")
+	data, err = os.ReadFile(weeklyPath)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(data), `"num_dns_queries": 0`)
+
+	// The existing reports aren't rewritten.
+	require.NoError(t, os.WriteFile(weeklyPath, []byte("{}"), 0o644))
+	s.writeDueReports(now)
+
+	data, err = os.ReadFile(weeklyPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, "{}", string(data))
+}
+
+func TestParseReportParams(t *testing.T) {
+	now := testReportStart
+
+	testCases := []struct {
+		want       *reportParams
+		query      url.Values
+		name       string
+		wantErrMsg string
+	}{{
+		want: &reportParams{
+			start:  now.Add(-timeutil.Day),
+			end:    now,
+			group:  ReportGroupClient,
+			format: ReportFormatJSON,
+			limit:  defaultReportLimit,
+		},
+		query:      url.Values{"group_by": {"client"}},
+		name:       "defaults",
+		wantErrMsg: "",
+	}, {
+		want: &reportParams{
+			start:  now.Add(-timeutil.Day * 7),
+			end:    now.Add(-timeutil.Day),
+			group:  ReportGroupDomain,
+			format: ReportFormatCSV,
+			limit:  5,
+		},
+		query: url.Values{
+			"group_by": {"domain"},
+			"start":    {"2023-12-25T00:00:00Z"},
+			"end":      {"2023-12-31T00:00:00Z"},
+			"format":   {"csv"},
+			"limit":    {"5"},
+		},
+		name:       "all",
+		wantErrMsg: "",
+	}, {
+		want:       nil,
+		query:      url.Values{"format": {"xml"}},
+		name:       "bad_format",
+		wantErrMsg: `format: unsupported format "xml"`,
+	}, {
+		want:       nil,
+		query:      url.Values{"limit": {"-1"}},
+		name:       "bad_limit",
+		wantErrMsg: `limit: strconv.ParseUint: parsing "-1": invalid syntax`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := parseReportParams(tc.query, now)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.want == nil {
+				return
+			}
+
+			require.NotNil(t, p)
+
+			assert.True(t, tc.want.start.Equal(p.start))
+			assert.True(t, tc.want.end.Equal(p.end))
+			assert.Equal(t, tc.want.group, p.group)
+			assert.Equal(t, tc.want.format, p.format)
+			assert.Equal(t, tc.want.limit, p.limit)
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// ReportsConfig is the configuration of the scheduled reports written to disk.
+type ReportsConfig struct {
+	// Dir is the directory the reports are written to.  If empty, the
+	// directory of the statistics database is used.
+	Dir string `yaml:"dir_path"`
+
+	// Formats are the formats of the written reports, see the ReportFormat
+	// constants.
+	Formats []string `yaml:"formats"`
+
+	// GroupBy are the groups included into each report.
+	GroupBy []ReportGroup `yaml:"group_by"`
+
+	// Limit is the number of the top groups in each report.
+	Limit uint `yaml:"limit"`
+
+	// Enabled defines if the scheduled reports are written.
+	Enabled bool `yaml:"enabled"`
+
+	// Daily defines if the reports for each past day are written.
+	Daily bool `yaml:"daily"`
+
+	// Weekly defines if the reports for each past week, starting on Monday,
+	// are written.
+	Weekly bool `yaml:"weekly"`
+}
+
+// Validate returns an error if c is enabled and invalid.
+func (c *ReportsConfig) Validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	var errs []error
+	if len(c.Formats) == 0 {
+		errs = append(errs, errors.Error("formats: empty"))
+	}
+
+	for i, f := range c.Formats {
+		if f != ReportFormatCSV && f != ReportFormatJSON {
+			errs = append(errs, fmt.Errorf("formats: at index %d: unsupported format %q", i, f))
+		}
+	}
+
+	if len(c.GroupBy) == 0 {
+		errs = append(errs, errors.Error("group_by: empty"))
+	}
+
+	for i, g := range c.GroupBy {
+		if gErr := g.Validate(); gErr != nil {
+			errs = append(errs, fmt.Errorf("group_by: at index %d: %w", i, gErr))
+		}
+	}
+
+	if c.Limit == 0 || c.Limit > maxReportLimit {
+		errs = append(errs, fmt.Errorf("limit: out of range [1, %d]: %d", maxReportLimit, c.Limit))
+	}
+
+	return errors.Join(errs...)
+}
+
+// reportCheckIvl is the interval of checking if there are reports to write.
+const reportCheckIvl = 10 * time.Minute
+
+// reportPeriod is a past period of time a scheduled report is written for.
+type reportPeriod struct {
+	// start is the beginning of the period.
+	start time.Time
+
+	// end is the end of the period.
+	end time.Time
+
+	// name is the base name of the report files of the period.
+	name string
+}
+
+// reportPeriods returns the last finished periods before now according to
+// conf.
+func reportPeriods(conf *ReportsConfig, now time.Time) (periods []*reportPeriod) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if conf.Daily {
+		start := today.AddDate(0, 0, -1)
+		periods = append(periods, &reportPeriod{
+			start: start,
+			end:   today,
+			name:  "daily-" + start.Format(time.DateOnly),
+		})
+	}
+
+	if conf.Weekly {
+		// Weeks start on Monday.
+		sinceMonday := (int(today.Weekday()) + 6) % 7
+		end := today.AddDate(0, 0, -sinceMonday)
+		start := end.AddDate(0, 0, -7)
+		periods = append(periods, &reportPeriod{
+			start: start,
+			end:   end,
+			name:  "weekly-" + start.Format(time.DateOnly),
+		})
+	}
+
+	return periods
+}
+
+// periodicReports writes the scheduled reports until s is closed.
+func (s *StatsCtx) periodicReports() {
+	defer log.OnPanic("stats: scheduled reports")
+
+	ticker := time.NewTicker(reportCheckIvl)
+	defer ticker.Stop()
+
+	for {
+		s.writeDueReports(time.Now())
+
+		select {
+		case <-ticker.C:
+			// Go on.
+		case <-s.done:
+			log.Debug("stats: scheduled reports finished")
+
+			return
+		}
+	}
+}
+
+// writeDueReports writes the reports of the last finished periods before now
+// which haven't been written yet.
+func (s *StatsCtx) writeDueReports(now time.Time) {
+	conf := s.reports
+	for _, p := range reportPeriods(conf, now) {
+		var missing []string
+		for _, f := range conf.Formats {
+			path := filepath.Join(conf.Dir, p.name+"."+f)
+			if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+				missing = append(missing, f)
+			}
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		err := s.writeReports(p, missing)
+		if err != nil {
+			log.Error("stats: writing report %s: %s", p.name, err)
+		}
+	}
+}
+
+// writeReports writes the reports for the period p in formats.
+func (s *StatsCtx) writeReports(p *reportPeriod, formats []string) (err error) {
+	conf := s.reports
+
+	reports := make([]*Report, 0, len(conf.GroupBy))
+	for _, g := range conf.GroupBy {
+		var r *Report
+		r, err = s.Report(p.start, p.end, g, conf.Limit)
+		if err != nil {
+			return fmt.Errorf("group %q: %w", g, err)
+		}
+
+		reports = append(reports, r)
+	}
+
+	err = os.MkdirAll(conf.Dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	var errs []error
+	for _, f := range formats {
+		buf := &bytes.Buffer{}
+		if f == ReportFormatCSV {
+			err = writeReportsCSV(buf, reports)
+		} else {
+			err = writeReportsJSON(buf, p.start, p.end, reports)
+		}
+
+		if err == nil {
+			err = writeReportFile(filepath.Join(conf.Dir, p.name+"."+f), buf.Bytes())
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("format %q: %w", f, err))
+
+			continue
+		}
+
+		log.Debug("stats: wrote report %s.%s", p.name, f)
+	}
+
+	return errors.Join(errs...)
+}
+
+// writeReportFile atomically writes data to the file at path.
+func writeReportFile(path string, data []byte) (err error) {
+	pf, err := aghrenameio.NewPendingFile(path, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, pf) }()
+
+	_, err = pf.Write(data)
+
+	return err
+}
+
+// cloneReportsConfig returns a deep copy of c.
+func cloneReportsConfig(c *ReportsConfig) (clone *ReportsConfig) {
+	if c == nil {
+		return nil
+	}
+
+	clone = &ReportsConfig{}
+	*clone = *c
+	clone.Formats = slices.Clone(c.Formats)
+	clone.GroupBy = slices.Clone(c.GroupBy)
+
+	return clone
+}
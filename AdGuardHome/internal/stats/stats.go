@@ -3,10 +3,12 @@
 package stats
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/netip"
 	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -54,6 +56,11 @@ type Config struct {
 	// ShouldCountClient returns client's ignore setting.
 	ShouldCountClient func([]string) bool
 
+	// ClientIDs returns all IDs of the client identified by id, for example
+	// the name of a persistent client.  If nil or if it returns no IDs, id
+	// itself is used.
+	ClientIDs func(id string) (ids []string)
+
 	// HTTPRegister is the function that registers handlers for the stats
 	// endpoints.
 	HTTPRegister aghhttp.RegisterFunc
@@ -65,6 +72,10 @@ type Config struct {
 	// Filename is the name of the database file.
 	Filename string
 
+	// Reports is the configuration of the scheduled reports.  If nil or
+	// disabled, no reports are written.
+	Reports *ReportsConfig
+
 	// Limit is an upper limit for collecting statistics.
 	Limit time.Duration
 
@@ -115,6 +126,13 @@ type StatsCtx struct {
 	// interface.
 	configModified func()
 
+	// reports is the configuration of the scheduled reports.  It's nil if
+	// those are disabled.
+	reports *ReportsConfig
+
+	// done is closed when s is closed.
+	done chan struct{}
+
 	// confMu protects ignored, limit, and enabled.
 	confMu *sync.RWMutex
 
@@ -125,6 +143,10 @@ type StatsCtx struct {
 	// shouldCountClient returns client's ignore setting.
 	shouldCountClient func([]string) bool
 
+	// clientIDs returns all IDs of the client identified by id.  It may be
+	// nil.
+	clientIDs func(id string) (ids []string)
+
 	// filename is the name of database file.
 	filename string
 
@@ -149,15 +171,22 @@ func New(conf Config) (s *StatsCtx, err error) {
 		return nil, errors.Error("should count client is unspecified")
 	}
 
+	err = conf.Reports.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("reports: %w", err)
+	}
+
 	s = &StatsCtx{
 		currMu:         &sync.RWMutex{},
 		httpRegister:   conf.HTTPRegister,
 		configModified: conf.ConfigModified,
 		filename:       conf.Filename,
+		done:           make(chan struct{}),
 
 		confMu:            &sync.RWMutex{},
 		ignored:           conf.Ignored,
 		shouldCountClient: conf.ShouldCountClient,
+		clientIDs:         conf.ClientIDs,
 		limit:             conf.Limit,
 		enabled:           conf.Enabled,
 	}
@@ -166,6 +195,13 @@ func New(conf Config) (s *StatsCtx, err error) {
 		s.unitIDGen = conf.UnitID
 	}
 
+	if conf.Reports != nil && conf.Reports.Enabled {
+		s.reports = cloneReportsConfig(conf.Reports)
+		if s.reports.Dir == "" {
+			s.reports.Dir = filepath.Dir(conf.Filename)
+		}
+	}
+
 	// TODO(e.burkov):  Move the code below to the Start method.
 
 	err = s.openDB()
@@ -181,10 +217,15 @@ func New(conf Config) (s *StatsCtx, err error) {
 		return nil, fmt.Errorf("stats: opening a transaction: %w", err)
 	}
 
+	migrated, err := migrateDB(tx)
+	if err != nil {
+		return nil, errors.WithDeferred(fmt.Errorf("migrating database: %w", err), finishTxn(tx, false))
+	}
+
 	deleted := deleteOldUnits(tx, id-uint32(s.limit.Hours())-1)
 	udb = loadUnitFromDB(tx, id)
 
-	err = finishTxn(tx, deleted > 0)
+	err = finishTxn(tx, migrated || deleted > 0)
 	if err != nil {
 		log.Error("stats: %s", err)
 	}
@@ -224,6 +265,10 @@ func (s *StatsCtx) Start() {
 	s.initWeb()
 
 	go s.periodicFlush()
+
+	if s.reports != nil {
+		go s.periodicReports()
+	}
 }
 
 // Close implements the [io.Closer] interface for *StatsCtx.
@@ -234,6 +279,9 @@ func (s *StatsCtx) Close() (err error) {
 	if db == nil {
 		return nil
 	}
+
+	close(s.done)
+
 	defer func() {
 		cerr := db.Close()
 		if cerr == nil {
@@ -341,6 +389,10 @@ func deleteOldUnits(tx *bbolt.Tx, firstID uint32) (deleted int) {
 	const errStop errors.Error = "stop iteration"
 
 	walk := func(name []byte, _ *bbolt.Bucket) (err error) {
+		if bytes.Equal(name, schemaBucket) {
+			return nil
+		}
+
 		nameID, ok := unitNameToID(name)
 		if ok && nameID >= firstID {
 			return errStop
@@ -82,6 +82,8 @@ func TestStats(t *testing.T) {
 			ProcessingTime: time.Microsecond * 123456,
 			Upstream:       respUpstream,
 			UpstreamTime:   time.Microsecond * 222222,
+			QType:          dns.TypeA,
+			RCode:          dns.RcodeSuccess,
 		}, {
 			Domain:         reqDomain,
 			Client:         cliIPStr,
@@ -89,6 +91,8 @@ func TestStats(t *testing.T) {
 			ProcessingTime: time.Microsecond * 123456,
 			Upstream:       respUpstream,
 			UpstreamTime:   time.Microsecond * 222222,
+			QType:          dns.TypeA,
+			RCode:          dns.RcodeNameError,
 		}}
 
 		wantData := &stats.StatsResp{
@@ -98,6 +102,21 @@ func TestStats(t *testing.T) {
 			TopBlocked:            []map[string]uint64{0: {reqDomain: 1}},
 			TopUpstreamsResponses: []map[string]uint64{0: {respUpstream: 2}},
 			TopUpstreamsAvgTime:   []map[string]float64{0: {respUpstream: 0.222222}},
+			UpstreamsLatency: []*stats.UpstreamLatency{{
+				LatencyPercentiles: stats.LatencyPercentiles{
+					Count: 2,
+					P50:   0.25,
+					P95:   0.295,
+					P99:   0.299,
+				},
+				Upstream: respUpstream,
+			}},
+			UpstreamsLatencyOverall: &stats.LatencyPercentiles{
+				Count: 2,
+				P50:   0.25,
+				P95:   0.295,
+				P99:   0.299,
+			},
 			DNSQueries: []uint64{
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
@@ -114,6 +133,19 @@ func TestStats(t *testing.T) {
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 			},
+			QTypes: []map[string]uint64{0: {"A": 2}},
+			RCodes: []map[string]uint64{0: {"NOERROR": 1}, 1: {"NXDOMAIN": 1}},
+			QTypesSeries: map[string][]uint64{"A": {
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
+			}},
+			RCodesSeries: map[string][]uint64{"NOERROR": {
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+			}, "NXDOMAIN": {
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+			}},
 			NumDNSQueries:           2,
 			NumBlockedFiltering:     1,
 			NumReplacedSafebrowsing: 0,
@@ -146,16 +178,22 @@ func TestStats(t *testing.T) {
 
 		_24zeroes := [24]uint64{}
 		emptyData := &stats.StatsResp{
-			TimeUnits:             "hours",
-			TopQueried:            []map[string]uint64{},
-			TopClients:            []map[string]uint64{},
-			TopBlocked:            []map[string]uint64{},
-			TopUpstreamsResponses: []map[string]uint64{},
-			TopUpstreamsAvgTime:   []map[string]float64{},
-			DNSQueries:            _24zeroes[:],
-			BlockedFiltering:      _24zeroes[:],
-			ReplacedSafebrowsing:  _24zeroes[:],
-			ReplacedParental:      _24zeroes[:],
+			TimeUnits:               "hours",
+			TopQueried:              []map[string]uint64{},
+			TopClients:              []map[string]uint64{},
+			TopBlocked:              []map[string]uint64{},
+			TopUpstreamsResponses:   []map[string]uint64{},
+			TopUpstreamsAvgTime:     []map[string]float64{},
+			UpstreamsLatency:        []*stats.UpstreamLatency{},
+			UpstreamsLatencyOverall: &stats.LatencyPercentiles{},
+			DNSQueries:              _24zeroes[:],
+			BlockedFiltering:        _24zeroes[:],
+			ReplacedSafebrowsing:    _24zeroes[:],
+			ReplacedParental:        _24zeroes[:],
+			QTypes:                  []map[string]uint64{},
+			RCodes:                  []map[string]uint64{},
+			QTypesSeries:            map[string][]uint64{},
+			RCodesSeries:            map[string][]uint64{},
 		}
 
 		req = httptest.NewRequest(http.MethodGet, "/control/stats", nil)
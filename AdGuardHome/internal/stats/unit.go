@@ -2,6 +2,7 @@ package stats
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/binary"
 	"encoding/gob"
 	"fmt"
@@ -24,6 +25,10 @@ const (
 
 	// maxUpstreams is the max number of top upstreams to return.
 	maxUpstreams = 100
+
+	// maxCodes is the max number of query types and response codes stored in
+	// a unit.
+	maxCodes = 100
 )
 
 // UnitIDGenFunc is the signature of a function that generates a unique ID for
@@ -74,6 +79,14 @@ type Entry struct {
 
 	// UpstreamTime is the duration of the successful request to the upstream.
 	UpstreamTime time.Duration
+
+	// QType is the type of the question.  Zero means that the type is
+	// unknown.
+	QType uint16
+
+	// RCode is the response code of the response.  It's ignored when QType is
+	// zero.
+	RCode int
 }
 
 // validate returns an error if entry is not valid.
@@ -111,6 +124,20 @@ type unit struct {
 	// microseconds to each upstream.
 	upstreamsTimeSum map[string]uint64
 
+	// upstreamsLatency stores the histogram of durations of successful queries
+	// to each upstream.
+	upstreamsLatency map[string]latencyHistogram
+
+	// qtypes stores the number of requests for each query type.
+	qtypes map[string]uint64
+
+	// rcodes stores the number of responses with each response code.
+	rcodes map[string]uint64
+
+	// clientTops stores the top domains requested by each client.  It tracks
+	// at most maxTrackedClients clients.
+	clientTops map[string]*clientTops
+
 	// nResult stores the number of requests grouped by it's result.
 	nResult []uint64
 
@@ -137,6 +164,10 @@ func newUnit(id uint32) (u *unit) {
 		clients:            map[string]uint64{},
 		upstreamsResponses: map[string]uint64{},
 		upstreamsTimeSum:   map[string]uint64{},
+		upstreamsLatency:   map[string]latencyHistogram{},
+		qtypes:             map[string]uint64{},
+		rcodes:             map[string]uint64{},
+		clientTops:         map[string]*clientTops{},
 		nResult:            make([]uint64, resultLast),
 		id:                 id,
 	}
@@ -173,6 +204,22 @@ type unitDB struct {
 	// responses from each upstream.
 	UpstreamsTimeSum []countPair
 
+	// UpstreamsLatency is the histogram of processing times of responses from
+	// each upstream.  It is nil in the units stored by the previous versions.
+	UpstreamsLatency []histogramPair
+
+	// ClientTops are the top domains requested by each of the clients with the
+	// most requests.  It is nil in the units stored by the previous versions.
+	ClientTops []*clientTopsDB
+
+	// QTypes is the number of requests for each query type.  It is nil in
+	// the units stored by the previous versions, see [migrateDB].
+	QTypes []countPair
+
+	// RCodes is the number of responses with each response code.  It is nil
+	// in the units stored by the previous versions, see [migrateDB].
+	RCodes []countPair
+
 	// NTotal is the total number of requests.
 	NTotal uint64
 
@@ -183,9 +230,7 @@ type unitDB struct {
 
 // newUnitID is the default UnitIDGenFunc that generates the unique id hourly.
 func newUnitID() (id uint32) {
-	const secsInHour = int64(time.Hour / time.Second)
-
-	return uint32(time.Now().Unix() / secsInHour)
+	return timeUnitID(time.Now())
 }
 
 func finishTxn(tx *bbolt.Tx, commit bool) (err error) {
@@ -240,7 +285,9 @@ func convertMapToSlice(m map[string]uint64, max int) (s []countPair) {
 		s = append(s, countPair{Name: k, Count: v})
 	}
 
-	slices.SortFunc(s, countPair.compareCount)
+	slices.SortFunc(s, func(a, b countPair) (res int) {
+		return cmp.Or(a.compareCount(b), cmp.Compare(a.Name, b.Name))
+	})
 	if max > len(s) {
 		max = len(s)
 	}
@@ -273,6 +320,10 @@ func (u *unit) serialize() (udb *unitDB) {
 		Clients:            convertMapToSlice(u.clients, maxClients),
 		UpstreamsResponses: convertMapToSlice(u.upstreamsResponses, maxUpstreams),
 		UpstreamsTimeSum:   convertMapToSlice(u.upstreamsTimeSum, maxUpstreams),
+		UpstreamsLatency:   convertHistogramsToSlice(u.upstreamsLatency, maxUpstreams),
+		ClientTops:         serializeClientTops(u.clientTops),
+		QTypes:             convertMapToSlice(u.qtypes, maxCodes),
+		RCodes:             convertMapToSlice(u.rcodes, maxCodes),
 		TimeAvg:            timeAvg,
 	}
 }
@@ -314,6 +365,10 @@ func (u *unit) deserialize(udb *unitDB) {
 	u.clients = convertSliceToMap(udb.Clients)
 	u.upstreamsResponses = convertSliceToMap(udb.UpstreamsResponses)
 	u.upstreamsTimeSum = convertSliceToMap(udb.UpstreamsTimeSum)
+	u.upstreamsLatency = convertHistogramSliceToMap(udb.UpstreamsLatency)
+	u.clientTops = deserializeClientTops(udb.ClientTops)
+	u.qtypes = convertSliceToMap(udb.QTypes)
+	u.rcodes = convertSliceToMap(udb.RCodes)
 	u.timeSum = uint64(udb.TimeAvg) * udb.NTotal
 }
 
@@ -327,6 +382,22 @@ func (u *unit) add(e *Entry) {
 	}
 
 	u.clients[e.Client]++
+
+	ct := u.clientTops[e.Client]
+	if ct == nil && len(u.clientTops) < maxTrackedClients {
+		ct = newClientTops()
+		u.clientTops[e.Client] = ct
+	}
+
+	if ct != nil {
+		ct.add(e)
+	}
+
+	if e.QType != 0 {
+		u.qtypes[qtypeName(e.QType)]++
+		u.rcodes[rcodeName(e.RCode)]++
+	}
+
 	pt := uint64(e.ProcessingTime.Microseconds())
 	u.timeSum += pt
 	u.nTotal++
@@ -335,6 +406,14 @@ func (u *unit) add(e *Entry) {
 		u.upstreamsResponses[e.Upstream]++
 		ut := uint64(e.UpstreamTime.Microseconds())
 		u.upstreamsTimeSum[e.Upstream] += ut
+
+		h := u.upstreamsLatency[e.Upstream]
+		if h == nil {
+			h = newLatencyHistogram()
+			u.upstreamsLatency[e.Upstream] = h
+		}
+
+		h.add(e.UpstreamTime)
 	}
 }
 
@@ -413,11 +492,17 @@ func (s *StatsCtx) getData(limit uint32) (resp *StatsResp, ok bool) {
 		return &StatsResp{
 			TimeUnits: "days",
 
-			TopBlocked:            []topAddrs{},
-			TopClients:            []topAddrs{},
-			TopQueried:            []topAddrs{},
-			TopUpstreamsResponses: []topAddrs{},
-			TopUpstreamsAvgTime:   []topAddrsFloat{},
+			TopBlocked:              []topAddrs{},
+			TopClients:              []topAddrs{},
+			TopQueried:              []topAddrs{},
+			TopUpstreamsResponses:   []topAddrs{},
+			TopUpstreamsAvgTime:     []topAddrsFloat{},
+			UpstreamsLatency:        []*UpstreamLatency{},
+			UpstreamsLatencyOverall: &LatencyPercentiles{},
+			QTypes:                  []topAddrs{},
+			RCodes:                  []topAddrs{},
+			QTypesSeries:            map[string][]uint64{},
+			RCodesSeries:            map[string][]uint64{},
 
 			BlockedFiltering:     []uint64{},
 			DNSQueries:           []uint64{},
@@ -437,13 +522,18 @@ func (s *StatsCtx) getData(limit uint32) (resp *StatsResp, ok bool) {
 // dataFromUnits collects and returns the statistics data.
 func (s *StatsCtx) dataFromUnits(units []*unitDB, curID uint32) (resp *StatsResp) {
 	topUpstreamsResponses, topUpstreamsAvgTime := topUpstreamsPairs(units)
+	upsLatency, latency := upstreamsLatency(units)
 
 	resp = &StatsResp{
-		TopQueried:            topsCollector(units, maxDomains, s.ignored, func(u *unitDB) (pairs []countPair) { return u.Domains }),
-		TopBlocked:            topsCollector(units, maxDomains, s.ignored, func(u *unitDB) (pairs []countPair) { return u.BlockedDomains }),
-		TopUpstreamsResponses: topUpstreamsResponses,
-		TopUpstreamsAvgTime:   topUpstreamsAvgTime,
-		TopClients:            topsCollector(units, maxClients, nil, topClientPairs(s)),
+		TopQueried:              topsCollector(units, maxDomains, s.ignored, func(u *unitDB) (pairs []countPair) { return u.Domains }),
+		TopBlocked:              topsCollector(units, maxDomains, s.ignored, func(u *unitDB) (pairs []countPair) { return u.BlockedDomains }),
+		TopUpstreamsResponses:   topUpstreamsResponses,
+		TopUpstreamsAvgTime:     topUpstreamsAvgTime,
+		UpstreamsLatency:        upsLatency,
+		UpstreamsLatencyOverall: latency,
+		TopClients:              topsCollector(units, maxClients, nil, topClientPairs(s)),
+		QTypes:                  convertTopSlice(convertMapToSlice(sumPairs(units, qtypePairs), maxCodes)),
+		RCodes:                  convertTopSlice(convertMapToSlice(sumPairs(units, rcodePairs), maxCodes)),
 	}
 
 	s.fillCollectedStats(resp, units, curID)
@@ -500,6 +590,9 @@ func (s *StatsCtx) fillCollectedStats(data *StatsResp, units []*unitDB, curID ui
 		return
 	}
 
+	data.QTypesSeries = codesSeries(units, data.QTypes, qtypePairs, size, 1)
+	data.RCodesSeries = codesSeries(units, data.RCodes, rcodePairs, size, 1)
+
 	for i, u := range units {
 		data.DNSQueries[i] += u.NTotal
 		data.BlockedFiltering[i] += u.NResult[RFiltered]
@@ -525,6 +618,9 @@ func (s *StatsCtx) fillCollectedStatsDaily(
 	hours := countHours(curHour, days)
 	units = units[len(units)-hours:]
 
+	data.QTypesSeries = codesSeries(units, data.QTypes, qtypePairs, days, 24)
+	data.RCodesSeries = codesSeries(units, data.RCodes, rcodePairs, days, 24)
+
 	for i, u := range units {
 		day := i / 24
 
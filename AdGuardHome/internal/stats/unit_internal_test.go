@@ -24,6 +24,10 @@ func TestUnit_Deserialize(t *testing.T) {
 			timeSum:            0,
 			upstreamsResponses: map[string]uint64{},
 			upstreamsTimeSum:   map[string]uint64{},
+			upstreamsLatency:   map[string]latencyHistogram{},
+			qtypes:             map[string]uint64{},
+			rcodes:             map[string]uint64{},
+			clientTops:         map[string]*clientTops{},
 		},
 		db: &unitDB{
 			NResult:            []uint64{0, 0, 0, 0, 0, 0},
@@ -57,6 +61,15 @@ func TestUnit_Deserialize(t *testing.T) {
 			upstreamsTimeSum: map[string]uint64{
 				"1.2.3.4": 246912,
 			},
+			upstreamsLatency: map[string]latencyHistogram{},
+			qtypes: map[string]uint64{
+				"A":    1,
+				"AAAA": 1,
+			},
+			rcodes: map[string]uint64{
+				"NOERROR": 2,
+			},
+			clientTops: map[string]*clientTops{},
 		},
 		db: &unitDB{
 			NResult: []uint64{0, 1, 1, 0, 0, 0},
@@ -77,6 +90,14 @@ func TestUnit_Deserialize(t *testing.T) {
 			UpstreamsTimeSum: []countPair{{
 				"1.2.3.4", 246912,
 			}},
+			QTypes: []countPair{{
+				"A", 1,
+			}, {
+				"AAAA", 1,
+			}},
+			RCodes: []countPair{{
+				"NOERROR", 2,
+			}},
 		},
 	}}
 